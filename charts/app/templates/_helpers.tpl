@@ -59,4 +59,78 @@ Create the name of the service account to use
 {{- else }}
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
-{{- end }} 
\ No newline at end of file
+{{- end }} 
+
+{{/*
+RBAC rules of the controller in the namespaces it watches, granted by the ClusterRole or by a Role per
+watched namespace
+*/}}
+{{- define "app.rules" -}}
+# JaegerNginxProxy CRD permissions
+- apiGroups: ["jaeger-nginx-proxy.platform-engineer.stream"]
+  resources: ["jaegernginxproxies"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+- apiGroups: ["jaeger-nginx-proxy.platform-engineer.stream"]
+  resources: ["jaegernginxproxies/status"]
+  verbs: ["get", "update", "patch"]
+- apiGroups: ["jaeger-nginx-proxy.platform-engineer.stream"]
+  resources: ["jaegernginxproxies/finalizers"]
+  verbs: ["update"]
+
+# Deployment permissions
+- apiGroups: ["apps"]
+  resources: ["deployments"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+
+# ConfigMap permissions
+- apiGroups: [""]
+  resources: ["configmaps"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+
+# Service and ServiceAccount permissions
+- apiGroups: [""]
+  resources: ["services", "serviceaccounts"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+
+# Ingress and Gateway API route permissions for spec.ingress
+- apiGroups: ["networking.k8s.io"]
+  resources: ["ingresses"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+- apiGroups: ["gateway.networking.k8s.io"]
+  resources: ["httproutes", "grpcroutes"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+
+# NetworkPolicy permissions for spec.networkPolicy
+- apiGroups: ["networking.k8s.io"]
+  resources: ["networkpolicies"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+
+# ServiceMonitor permissions for spec.metrics.serviceMonitor, used when the Prometheus Operator is installed
+- apiGroups: ["monitoring.coreos.com"]
+  resources: ["servicemonitors"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+
+# Pod permissions for status updates
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["get", "list", "watch"]
+
+# Role and RoleBinding permissions for the hot reload sidecar
+- apiGroups: ["rbac.authorization.k8s.io"]
+  resources: ["roles", "rolebindings"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+
+# Event permissions
+- apiGroups: [""]
+  resources: ["events"]
+  verbs: ["create", "patch"] 
+{{- end }}
+
+{{/*
+RBAC rules for the leader election lease in the release namespace
+*/}}
+{{- define "app.leaseRules" -}}
+- apiGroups: ["coordination.k8s.io"]
+  resources: ["leases"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+{{- end }}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/ctrl"
+	"github.com/dolv/k8s-controller-tutorial/pkg/exporter"
+)
+
+var (
+	exporterListen       string
+	exporterStatusURL    string
+	exporterSyslogListen string
+)
+
+var exporterCmd = &cobra.Command{
+	Use:   "exporter",
+	Short: "Serve nginx metrics to Prometheus (sidecar of proxies with spec.metrics.enabled)",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		e := exporter.New(exporterStatusURL)
+		conn, err := net.ListenPacket("udp", exporterSyslogListen)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to listen for the metrics log on %s", exporterSyslogListen)
+			os.Exit(1)
+		}
+		go func() {
+			if err := e.ServeSyslog(ctx, conn); err != nil {
+				log.Error().Err(err).Msg("Metrics log listener exited with error")
+				stop()
+			}
+		}()
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", e.Handler())
+		server := &http.Server{Addr: exporterListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = server.Shutdown(shutdownCtx)
+		}()
+
+		log.Info().Msgf("Serving metrics of %s on %s", exporterStatusURL, exporterListen)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("Exporter exited with error")
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exporterCmd)
+	exporterCmd.Flags().StringVar(&exporterListen, "listen", fmt.Sprintf(":%d", ctrl.DefaultMetricsPort), "Address serving /metrics")
+	exporterCmd.Flags().StringVar(&exporterStatusURL, "status-url", fmt.Sprintf("http://127.0.0.1:%d%s", ctrl.DefaultMetricsStatusPort, ctrl.StubStatusPath), "URL of the nginx stub_status location")
+	exporterCmd.Flags().StringVar(&exporterSyslogListen, "syslog-listen", fmt.Sprintf("127.0.0.1:%d", ctrl.MetricsSyslogPort), "UDP address receiving the metrics log from nginx")
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/health"
+)
+
+// Exit codes of the server command
+const (
+	exitOK = 0
+	// exitError means a component failed to start or stopped with an error
+	exitError = 1
+	// exitShutdownTimeout means a component did not stop within --shutdown-timeout
+	exitShutdownTimeout = 2
+)
+
+// serverComponent is a long-running part of the server
+type serverComponent struct {
+	name string
+	// run blocks until the component stops. Returning before stop was called fails the server.
+	run func() error
+	// stop makes run return, draining in-flight work until ctx is done
+	stop func(ctx context.Context) error
+}
+
+// lifecycle runs the server components until a shutdown signal or the first failure and then stops them
+// one after another, in the order they were added
+type lifecycle struct {
+	timeout    time.Duration
+	components []serverComponent
+	stopping   atomic.Bool
+}
+
+func (l *lifecycle) add(name string, run func() error, stop func(ctx context.Context) error) {
+	l.components = append(l.components, serverComponent{name: name, run: run, stop: stop})
+}
+
+// Check is a readiness check that fails once the shutdown started, so no new requests are routed to
+// the server while it drains the in-flight ones
+func (l *lifecycle) Check(context.Context) health.Result {
+	if l.stopping.Load() {
+		return health.Failing("shutting down")
+	}
+	return health.OK("running")
+}
+
+// run starts the components and blocks until ctx is cancelled or a component fails, then stops them and
+// returns the exit code
+func (l *lifecycle) run(ctx context.Context) int {
+	errs := make(chan error, len(l.components))
+	done := make([]chan struct{}, len(l.components))
+	for i, c := range l.components {
+		done[i] = make(chan struct{})
+		go func(c serverComponent, done chan<- struct{}) {
+			defer close(done)
+			err := c.run()
+			if err == nil && !l.stopping.Load() {
+				err = errors.New("stopped unexpectedly")
+			}
+			if err != nil {
+				errs <- fmt.Errorf("%s: %w", c.name, err)
+			}
+		}(c, done[i])
+	}
+
+	code := exitOK
+	select {
+	case <-ctx.Done():
+		log.Info().Msg("Shutdown signal received, stopping the server")
+	case err := <-errs:
+		log.Error().Err(err).Msg("Server component failed, stopping the server")
+		code = exitError
+	}
+	l.stopping.Store(true)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+	for i, c := range l.components {
+		log.Info().Msgf("Stopping %s", c.name)
+		if err := c.stop(shutdownCtx); err != nil {
+			log.Error().Err(err).Msgf("Failed to stop %s", c.name)
+			code = max(code, exitError)
+		}
+		select {
+		case <-done[i]:
+		case <-shutdownCtx.Done():
+			log.Error().Msgf("%s did not stop within the shutdown timeout of %s", c.name, l.timeout)
+			return exitShutdownTimeout
+		}
+	}
+
+	// Failures while stopping, e.g. a manager runnable that returned an error
+	for {
+		select {
+		case err := <-errs:
+			log.Error().Err(err).Msg("Server component failed during shutdown")
+			code = max(code, exitError)
+		default:
+			log.Info().Msg("Server stopped")
+			return code
+		}
+	}
+}
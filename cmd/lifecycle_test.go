@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/health"
+)
+
+// blockingComponent runs until it is stopped and records the order of the stops
+type blockingComponent struct {
+	name    string
+	stopped chan struct{}
+	order   *[]string
+	mu      *sync.Mutex
+}
+
+func addBlockingComponent(l *lifecycle, name string, order *[]string, mu *sync.Mutex) {
+	c := &blockingComponent{name: name, stopped: make(chan struct{}), order: order, mu: mu}
+	l.add(name, func() error {
+		<-c.stopped
+		return nil
+	}, func(context.Context) error {
+		c.mu.Lock()
+		*c.order = append(*c.order, c.name)
+		c.mu.Unlock()
+		close(c.stopped)
+		return nil
+	})
+}
+
+func TestLifecycleSignal(t *testing.T) {
+	var order []string
+	var mu sync.Mutex
+	l := &lifecycle{timeout: time.Second}
+	addBlockingComponent(l, "http-server", &order, &mu)
+	addBlockingComponent(l, "informer", &order, &mu)
+	addBlockingComponent(l, "manager", &order, &mu)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Equal(t, health.OK("running"), l.Check(ctx))
+	cancel()
+	assert.Equal(t, exitOK, l.run(ctx))
+	assert.Equal(t, []string{"http-server", "informer", "manager"}, order)
+	assert.Equal(t, health.Failing("shutting down"), l.Check(ctx))
+}
+
+func TestLifecycleComponentFailure(t *testing.T) {
+	var order []string
+	var mu sync.Mutex
+	l := &lifecycle{timeout: time.Second}
+	addBlockingComponent(l, "http-server", &order, &mu)
+	l.add("manager", func() error {
+		return errors.New("lost the leader lease")
+	}, func(context.Context) error { return nil })
+
+	assert.Equal(t, exitError, l.run(context.Background()))
+	assert.Equal(t, []string{"http-server"}, order, "the other components are stopped")
+}
+
+func TestLifecycleUnexpectedStop(t *testing.T) {
+	l := &lifecycle{timeout: time.Second}
+	l.add("informer", func() error { return nil }, func(context.Context) error { return nil })
+	assert.Equal(t, exitError, l.run(context.Background()))
+}
+
+func TestLifecycleShutdownTimeout(t *testing.T) {
+	l := &lifecycle{timeout: 50 * time.Millisecond}
+	release := make(chan struct{})
+	defer close(release)
+	l.add("mcp-server", func() error {
+		<-release
+		return nil
+	}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, exitShutdownTimeout, l.run(ctx))
+}
@@ -7,9 +7,13 @@ import (
 	"strings"
 
 	"github.com/dolv/k8s-controller-tutorial/pkg/api"
+	"github.com/dolv/k8s-controller-tutorial/pkg/tracing"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -24,6 +28,7 @@ func NewMCPServer(serverName, version string) *server.MCPServer {
 		server.WithToolCapabilities(true),
 		server.WithLogging(),
 		server.WithRecovery(),
+		server.WithToolHandlerMiddleware(traceToolCall),
 	)
 
 	// Track tool names for list_tools
@@ -70,6 +75,19 @@ func NewMCPServer(serverName, version string) *server.MCPServer {
 	return s
 }
 
+// traceToolCall records every tool call as span, tool errors and error results mark the span as failed
+func traceToolCall(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx, span := tracing.Start(ctx, "tools/call "+req.Params.Name, trace.WithAttributes(attribute.String("mcp.tool.name", req.Params.Name)))
+		result, err := next(ctx, req)
+		if err == nil && result != nil && result.IsError {
+			span.SetStatus(codes.Error, "tool returned an error result")
+		}
+		tracing.End(span, err)
+		return result, err
+	}
+}
+
 // listJaegerNginxProxiesHandler handles the list_jaegernginxproxies MCP tool
 // Lists all JaegerNginxProxy resources in the configured namespace
 func listJaegerNginxProxiesHandler(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -130,6 +148,9 @@ func createJaegerNginxProxyHandler(ctx context.Context, req mcp.CallToolRequest)
 					if v, ok := portMap["path"].(string); ok {
 						port.Path = v
 					}
+					if v, ok := portMap["protocol"].(string); ok {
+						port.Protocol = v
+					}
 					ports = append(ports, port)
 				}
 			}
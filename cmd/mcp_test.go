@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"encoding/hex"
 	"testing"
 	"time"
 
@@ -9,7 +10,12 @@ import (
 	jaegerv1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
 	"github.com/dolv/k8s-controller-tutorial/pkg/ctrl"
 	"github.com/dolv/k8s-controller-tutorial/pkg/testutil"
+	"github.com/dolv/k8s-controller-tutorial/pkg/tracing"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -21,7 +27,7 @@ func setupTestAPIWithManager(t *testing.T) (*api.JaegerNginxProxyAPI, client.Cli
 	// Register the JaegerNginxProxy CRD scheme
 	require.NoError(t, jaegerv1alpha0.AddToScheme(mgr.GetScheme()))
 
-	require.NoError(t, ctrl.AddJaegerNginxProxyController(mgr))
+	require.NoError(t, ctrl.AddJaegerNginxProxyController(mgr, ctrl.ControllerOptions{}))
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -116,3 +122,27 @@ func TestMCP_ListJaegerNginxProxiesHandler(t *testing.T) {
 	require.Contains(t, names, "mcp-proxy1")
 	require.Contains(t, names, "mcp-proxy2")
 }
+
+func TestTraceToolCall(t *testing.T) {
+	ctx := context.Background()
+	receiver := testutil.StartOTLPReceiver(t)
+	shutdown, err := tracing.Setup(ctx, tracing.Options{Endpoint: receiver.URL, Sampler: tracing.SamplerAlwaysOn})
+	require.NoError(t, err)
+
+	var handlerSpan trace.SpanContext
+	handler := traceToolCall(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		return mcp.NewToolResultError("JaegerNginxProxy not found"), nil
+	})
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "list_jaegernginxproxies"
+	result, err := handler(ctx, req)
+	require.NoError(t, err)
+	assert.True(t, result.IsError)
+	require.NoError(t, shutdown(ctx))
+
+	span := receiver.WaitForSpan(t, "tools/call list_jaegernginxproxies")
+	assert.Equal(t, handlerSpan.SpanID().String(), hex.EncodeToString(span.SpanId), "the tool runs within the span")
+	assert.Equal(t, "list_jaegernginxproxies", testutil.SpanAttribute(span, "mcp.tool.name"))
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, span.Status.Code)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/ctrl"
+	"github.com/dolv/k8s-controller-tutorial/pkg/reloader"
+)
+
+var (
+	reloaderConfigPath string
+	reloaderProcDir    string
+	reloaderInterval   time.Duration
+	reloaderStatusName string
+	reloaderTimeout    time.Duration
+)
+
+var reloaderCmd = &cobra.Command{
+	Use:   "reloader",
+	Short: "Reload nginx when the mounted proxy config changes (sidecar of proxies with reloadStrategy hotReload)",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		r := &reloader.Reloader{
+			ConfigPath:    reloaderConfigPath,
+			ProcDir:       reloaderProcDir,
+			Interval:      reloaderInterval,
+			Validate:      ctrl.ValidateNginxConfig,
+			ReloadTimeout: reloaderTimeout,
+		}
+
+		podName, podNamespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+		if podName != "" && podNamespace != "" && reloaderStatusName != "" {
+			clientset, err := getServerKubeClient("", true)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to create Kubernetes client")
+				os.Exit(1)
+			}
+			r.Report = func(ctx context.Context, hash string) error {
+				return reportLoadedConfig(ctx, clientset, podNamespace, reloaderStatusName, podName, hash)
+			}
+		} else {
+			log.Warn().Msg("POD_NAME, POD_NAMESPACE or --status-configmap not set: loaded config will not be reported")
+		}
+
+		log.Info().Msgf("Watching %s every %s", reloaderConfigPath, reloaderInterval)
+		if err := r.Run(ctx); err != nil {
+			log.Error().Err(err).Msg("Reloader exited with error")
+			os.Exit(1)
+		}
+	},
+}
+
+// reportLoadedConfig records the config hash pod loaded in the status ConfigMap of its proxy for the controller
+func reportLoadedConfig(ctx context.Context, clientset kubernetes.Interface, namespace, statusName, pod, hash string) error {
+	patch, err := ctrl.LoadedConfigReport(pod, hash, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = clientset.CoreV1().ConfigMaps(namespace).Patch(ctx, statusName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(reloaderCmd)
+	reloaderCmd.Flags().StringVar(&reloaderConfigPath, "config", ctrl.ConfigMountPath+"/"+ctrl.ConfigFileName, "Path of the nginx config to watch")
+	reloaderCmd.Flags().StringVar(&reloaderProcDir, "proc", "/proc", "Directory used to look up the nginx master process")
+	reloaderCmd.Flags().StringVar(&reloaderStatusName, "status-configmap", "", "ConfigMap in the pod namespace the loaded config is reported to")
+	reloaderCmd.Flags().DurationVar(&reloaderInterval, "interval", 5*time.Second, "How often the config is checked for changes")
+	reloaderCmd.Flags().DurationVar(&reloaderTimeout, "reload-timeout", reloader.DefaultReloadTimeout, "How long nginx gets to start workers with a new config before the reload counts as failed")
+}
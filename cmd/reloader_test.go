@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func TestReloaderCommandDefined(t *testing.T) {
+	assert.Equal(t, "reloader", reloaderCmd.Use)
+	assert.Equal(t, "/etc/nginx/conf.d/proxy.conf", reloaderCmd.Flags().Lookup("config").DefValue)
+}
+
+func TestReportLoadedConfig(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "proxy-reloader", Namespace: "default"},
+		Data:       map[string]string{"proxy-old": "{}"},
+	})
+
+	require.NoError(t, reportLoadedConfig(context.Background(), clientset, "default", "proxy-reloader", "proxy-abc", "0123456789abcdef"))
+
+	cm, err := clientset.CoreV1().ConfigMaps("default").Get(context.Background(), "proxy-reloader", metav1.GetOptions{})
+	require.NoError(t, err)
+	var report JaegerNginxProxyV1alpha0.PodConfigStatus
+	require.NoError(t, json.Unmarshal([]byte(cm.Data["proxy-abc"]), &report))
+	assert.Equal(t, "0123456789abcdef", report.ConfigHash)
+	assert.NotNil(t, report.LoadedAt)
+	assert.Equal(t, "{}", cm.Data["proxy-old"], "reports of other pods are kept")
+}
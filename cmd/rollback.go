@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	cfgPkg "github.com/dolv/k8s-controller-tutorial/internal/config"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/api"
+	jaegernginxproxyv1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+var rollbackToRevision int64
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <name>",
+	Short: "Roll a JaegerNginxProxy back to a config revision in the provided namespace",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		kubeconfig, err := cfgPkg.GetKubeConfig(kubeconfigPath)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to build kubeconfig rest object")
+			os.Exit(1)
+		}
+		scheme := runtime.NewScheme()
+		if err := jaegernginxproxyv1alpha0.AddToScheme(scheme); err != nil {
+			log.Error().Err(err).Msg("Failed to add JaegerNginxProxy scheme")
+			os.Exit(1)
+		}
+		k8sClient, err := client.New(kubeconfig, client.Options{Scheme: scheme})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to create Kubernetes client")
+			os.Exit(1)
+		}
+
+		log.Debug().Msgf("Rolling back JaegerNginxProxy '%s' in namespace '%s' to revision %d", name, namespace, rollbackToRevision)
+		if _, err := api.RequestRollback(context.Background(), k8sClient, namespace, name, rollbackToRevision); err != nil {
+			log.Error().Err(err).Msg("Failed to request rollback")
+			os.Exit(1)
+		}
+		if rollbackToRevision == 0 {
+			fmt.Printf("JaegerNginxProxy '%s' in namespace '%s' is rolling back to the previous revision\n", name, namespace)
+			return
+		}
+		fmt.Printf("JaegerNginxProxy '%s' in namespace '%s' is rolling back to revision %d\n", name, namespace, rollbackToRevision)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().Int64Var(&rollbackToRevision, "to-revision", 0, "Config revision to roll back to, 0 for the previous revision")
+}
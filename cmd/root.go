@@ -12,6 +12,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/dolv/k8s-controller-tutorial/internal/config"
+	"github.com/dolv/k8s-controller-tutorial/pkg/tracing"
 )
 
 var (
@@ -111,8 +112,11 @@ func Execute() {
 
 func init() {
 	viper.SetEnvPrefix("K8SCTRL")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 	viper.SetDefault("log-level", "info")
+	// Loggers derived from the global one keep the hook, configureLogger must not add it again
+	log.Logger = log.Logger.Hook(tracing.LogHook{})
 
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Set log level: trace, debug, info, warn, error")
 	rootCmd.PersistentFlags().StringVarP(&namespace, "namespace", "n", "default", "Kubernetes namespace to use")
@@ -2,41 +2,79 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	cfgPkg "github.com/dolv/k8s-controller-tutorial/internal/config"
 	"github.com/dolv/k8s-controller-tutorial/pkg/api"
 	jaegernginxproxyv1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
 	"github.com/dolv/k8s-controller-tutorial/pkg/ctrl"
+	"github.com/dolv/k8s-controller-tutorial/pkg/health"
 	"github.com/dolv/k8s-controller-tutorial/pkg/informer"
+	"github.com/dolv/k8s-controller-tutorial/pkg/tracing"
+	"github.com/dolv/k8s-controller-tutorial/pkg/webhook"
 	"github.com/google/uuid"
 	mcpserver "github.com/mark3labs/mcp-go/server"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
 	"github.com/valyala/fasthttprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	ctrlruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrlruntimelog "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	ctrlwebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
 var (
 	serverPort                    int
 	serverMetricsPort             int
+	serverHealthPort              int
+	serverDeploymentSelector      string
+	serverDeploymentMetadataOnly  bool
+	serverDeploymentEventHistory  int
+	serverCollectorNamespaces     []string
+	serverShutdownTimeout         time.Duration
 	serverKubeconfig              string
 	serverInCluster               bool
 	serverEnableLeaderElection    bool
 	serverLeaderElectionNamespace string
+	serverEnableWebhooks          bool
+	serverWebhookCertDir          string
 	serverEnableMCP               bool
 	serverMCPPort                 int
+	serverReloaderImage           string
+	serverEventDedupWindow        time.Duration
+	serverNginxTest               string
+	serverConfigLint              bool
+	serverConfigLintDisable       []string
+	serverAllowedDirectives       []string
+	serverOTLPEndpoint            string
+	serverTraceSampler            string
+	serverTraceSamplerArg         float64
+	serverTraceResourceAttributes map[string]string
 )
 
 const (
@@ -44,15 +82,48 @@ const (
 	loggerKey    = "logger"
 )
 
+// requestHeaderCarrier lets the OpenTelemetry propagators read the trace context of a request
+type requestHeaderCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (c requestHeaderCarrier) Get(key string) string { return string(c.header.Peek(key)) }
+
+func (c requestHeaderCarrier) Set(key, value string) { c.header.Set(key, value) }
+
+func (c requestHeaderCarrier) Keys() []string {
+	var keys []string
+	c.header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// loggingMiddleware logs and traces every request. Handlers find a logger carrying the request and trace
+// IDs under loggerKey.
 func loggingMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 		start := time.Now()
 		requestID := uuid.New().String()
+		spanCtx := otel.GetTextMapPropagator().Extract(context.Background(), requestHeaderCarrier{&ctx.Request.Header})
+		spanCtx, span := tracing.Start(spanCtx, "HTTP "+string(ctx.Method()),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(string(ctx.Method())),
+				semconv.URLPath(string(ctx.Path())),
+				attribute.String("request_id", requestID),
+			))
 		ctx.SetUserValue(requestIDKey, requestID)
+		ctx.SetUserValue(loggerKey, log.With().Str("request_id", requestID).Ctx(spanCtx).Logger())
 		ctx.Response.Header.Set("X-Request-ID", requestID)
 		next(ctx)
 		duration := time.Since(start)
-		log.Debug().
+		span.SetAttributes(semconv.HTTPResponseStatusCode(ctx.Response.StatusCode()))
+		if ctx.Response.StatusCode() >= fasthttp.StatusInternalServerError {
+			span.SetStatus(codes.Error, fasthttp.StatusMessage(ctx.Response.StatusCode()))
+		}
+		span.End()
+		log.Debug().Ctx(spanCtx).
 			Str("method", string(ctx.Method())).
 			Str("path", string(ctx.Path())).
 			Str("remote_ip", ctx.RemoteIP().String()).
@@ -63,6 +134,27 @@ func loggingMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	}
 }
 
+// addHealthChecks registers the probes of the manager, the deployment informer, the webhook server, the
+// MCP server and the REST API server
+func addHealthChecks(registry *health.Registry, mgr manager.Manager, mcpServer, httpServer *health.Component) {
+	registry.AddLivenessCheck("ping", health.Ping)
+	registry.AddLivenessCheck("http-server", httpServer.Alive)
+	registry.AddLivenessCheck("mcp-server", mcpServer.Alive)
+
+	registry.AddReadinessCheck("manager-cache", health.CacheSynced(mgr.GetCache()))
+	registry.AddReadinessCheck("informer", health.Synced(informer.HasSynced))
+	registry.AddReadinessCheck("leader-election", health.LeaderElection(serverEnableLeaderElection, mgr.Elected()))
+	if serverEnableWebhooks {
+		registry.AddReadinessCheck("webhook-server", health.Checker(mgr.GetWebhookServer().StartedChecker()))
+	} else {
+		registry.AddReadinessCheck("webhook-server", func(context.Context) health.Result {
+			return health.Disabled("--enable-webhooks is not set")
+		})
+	}
+	registry.AddReadinessCheck("http-server", httpServer.Check)
+	registry.AddReadinessCheck("mcp-server", mcpServer.Check)
+}
+
 func getServerKubeClient(kubeconfigPath string, inCluster bool) (*kubernetes.Clientset, error) {
 	var config *rest.Config
 	var err error
@@ -85,186 +177,354 @@ var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Start a FastHTTP server",
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Trace().Msg("This is a top-level trace log from serverCmd.Run")
-		log.Trace().Msg("Getting clientset instance")
-		clientset, err := getServerKubeClient(serverKubeconfig, serverInCluster)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to create Kubernetes client")
-			os.Exit(1)
-		}
-		ctx := context.Background()
+		os.Exit(runServer(cmd.Context()))
+	},
+}
 
-		log.Trace().Msg("Starting Informer")
-		// Use namespaces parameter if provided, otherwise fall back to namespace
-		namespaceToWatch := namespace
-		if namespaces != "" {
-			namespaceToWatch = namespaces
+// runServer runs the controller, the REST API, the MCP server and the probes until SIGTERM or SIGINT, or
+// until one of them fails, and returns the exit code
+func runServer(parent context.Context) int {
+	log.Trace().Msg("This is a top-level trace log from serverCmd.Run")
+	log.Trace().Msg("Getting clientset instance")
+	clientset, err := getServerKubeClient(serverKubeconfig, serverInCluster)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create Kubernetes client")
+		return exitError
+	}
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// A second signal kills the server right away
+		<-ctx.Done()
+		stop()
+	}()
+
+	// Flags win over the config file and K8SCTRL_ environment variables
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Options{
+		Endpoint:           viper.GetString("otlp-endpoint"),
+		Sampler:            viper.GetString("trace-sampler"),
+		SamplerArg:         viper.GetFloat64("trace-sampler-arg"),
+		ServiceVersion:     appVersion,
+		ResourceAttributes: viper.GetStringMapString("trace-resource-attributes"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set up tracing")
+		return exitError
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to flush traces")
 		}
-		go informer.StartDeploymentInformer(ctx, clientset, namespaceToWatch)
+	}()
 
-		// Start controller-runtime manager and controller
-		log.Trace().Msg("Starting Controller-runtime manager")
+	// Start controller-runtime manager and controller
+	log.Trace().Msg("Starting Controller-runtime manager")
 
-		// Use the same kubeconfig as the server client
-		var mgrConfig *rest.Config
-		if serverInCluster {
-			mgrConfig, err = rest.InClusterConfig()
-		} else {
-			mgrConfig, err = cfgPkg.GetKubeConfig(serverKubeconfig)
-		}
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to get kubeconfig for controller-runtime manager")
-			os.Exit(1)
-		}
+	// Use the same kubeconfig as the server client
+	var mgrConfig *rest.Config
+	if serverInCluster {
+		mgrConfig, err = rest.InClusterConfig()
+	} else {
+		mgrConfig, err = cfgPkg.GetKubeConfig(serverKubeconfig)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get kubeconfig for controller-runtime manager")
+		return exitError
+	}
 
-		mgr, err := ctrlruntime.NewManager(mgrConfig, manager.Options{
-			LeaderElection:          serverEnableLeaderElection,
-			LeaderElectionID:        "jaeger-nginx-proxy-controller-leader-election",
-			LeaderElectionNamespace: serverLeaderElectionNamespace,
-			Metrics:                 server.Options{BindAddress: fmt.Sprintf(":%d", serverMetricsPort)},
-		},
-		)
+	if _, err := labels.Parse(serverDeploymentSelector); err != nil {
+		log.Error().Err(err).Msg("Invalid --deployment-selector")
+		return exitError
+	}
+	informerOpts := []informer.Option{
+		informer.WithLabelSelector(serverDeploymentSelector),
+		informer.WithEventHistory(serverDeploymentEventHistory),
+	}
+	if serverDeploymentMetadataOnly {
+		metadataClient, err := metadata.NewForConfig(mgrConfig)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to create controller-runtime manager")
-			os.Exit(1)
+			log.Error().Err(err).Msg("Failed to create metadata client for the deployment informer")
+			return exitError
 		}
+		informerOpts = append(informerOpts, informer.WithMetadataOnly(metadataClient))
+	}
 
-		// Register the JaegerNginxProxy CRD scheme
-		if err := jaegernginxproxyv1alpha0.AddToScheme(mgr.GetScheme()); err != nil {
-			log.Error().Err(err).Msg("Failed to add JaegerNginxProxy scheme")
-			os.Exit(1)
+	// The manager and the Deployment informer cache only the namespaces of --namespaces, all when it is
+	// empty. --namespace is the default of the other commands and does not scope the server. Namespaced Roles are enough and
+	// missing permissions fail the start with the full list instead of a cache that never syncs.
+	watchNamespaces := cfgPkg.ParseNamespaces(namespaces)
+	if err := ctrl.CheckPermissions(ctx, clientset.AuthorizationV1().SelfSubjectAccessReviews(), watchNamespaces, serverCollectorNamespaces); err != nil {
+		log.Error().Err(err).Strs("namespaces", watchNamespaces).Msg("The controller lacks permissions for the watched namespaces")
+		return exitError
+	}
+	cacheOptions := cache.Options{DefaultWatchErrorHandler: ctrl.WatchErrorHandler}
+	if len(watchNamespaces) > 0 {
+		cacheOptions.DefaultNamespaces = make(map[string]cache.Config, len(watchNamespaces))
+		for _, ns := range watchNamespaces {
+			cacheOptions.DefaultNamespaces[ns] = cache.Config{}
 		}
+		log.Info().Strs("namespaces", watchNamespaces).Msg("Controller watches namespaces")
+	} else {
+		log.Info().Msg("Controller watches all namespaces")
+	}
+
+	mgrOptions := manager.Options{
+		Cache:                   cacheOptions,
+		LeaderElection:          serverEnableLeaderElection,
+		LeaderElectionID:        "jaeger-nginx-proxy-controller-leader-election",
+		LeaderElectionNamespace: serverLeaderElectionNamespace,
+		// The stopped manager hands the lease over right away instead of letting it expire
+		LeaderElectionReleaseOnCancel: true,
+		GracefulShutdownTimeout:       &serverShutdownTimeout,
+		Metrics:                       server.Options{BindAddress: fmt.Sprintf(":%d", serverMetricsPort)},
+	}
+	if serverEnableWebhooks {
+		mgrOptions.WebhookServer = ctrlwebhook.NewServer(ctrlwebhook.Options{CertDir: serverWebhookCertDir})
+	}
+	mgr, err := ctrlruntime.NewManager(mgrConfig, mgrOptions)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create controller-runtime manager")
+		return exitError
+	}
 
-		// Add the JaegerNginxProxy controller
-		if err := ctrl.AddJaegerNginxProxyController(mgr); err != nil {
-			log.Error().Err(err).Msg("Failed to add JaegerNginxProxy controller")
-			os.Exit(1)
+	// Register the JaegerNginxProxy CRD scheme
+	if err := jaegernginxproxyv1alpha0.AddToScheme(mgr.GetScheme()); err != nil {
+		log.Error().Err(err).Msg("Failed to add JaegerNginxProxy scheme")
+		return exitError
+	}
+
+	validators, err := ctrl.NewConfigValidators(ctrl.ValidationOptions{
+		NginxTest:         serverNginxTest,
+		Lint:              serverConfigLint,
+		LintDisabledRules: serverConfigLintDisable,
+		AllowedDirectives: serverAllowedDirectives,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set up nginx config validators")
+		return exitError
+	}
+
+	// Add the JaegerNginxProxy controller
+	if err := ctrl.AddJaegerNginxProxyController(mgr, ctrl.ControllerOptions{
+		ReloaderImage:    serverReloaderImage,
+		Validators:       validators,
+		EventDedupWindow: serverEventDedupWindow,
+	}); err != nil {
+		log.Error().Err(err).Msg("Failed to add JaegerNginxProxy controller")
+		return exitError
+	}
+
+	if serverEnableWebhooks {
+		err := ctrlruntime.NewWebhookManagedBy(mgr).
+			For(&jaegernginxproxyv1alpha0.JaegerNginxProxy{}).
+			WithValidator(&webhook.JaegerNginxProxyValidator{Client: mgr.GetClient(), APIReader: mgr.GetAPIReader(), ConfigValidators: validators}).
+			Complete()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to register JaegerNginxProxy webhook")
+			return exitError
 		}
+	}
 
-		// Webhook registration is handled automatically by kubebuilder markers
+	serverLifecycle := &lifecycle{timeout: serverShutdownTimeout}
 
-		go func() {
-			log.Info().Msg("Starting controller-runtime manager...")
-			if err := mgr.Start(cmd.Context()); err != nil {
-				log.Error().Err(err).Msg("Manager exited with error")
-				os.Exit(1)
-			}
-		}()
-
-		// Set up controller-runtime logging
-		ctrlruntimelog.SetLogger(zap.New(zap.UseDevMode(true)))
-
-		// --- API ROUTER SETUP ---
-		// Import here to avoid import cycle in code edit
-		jaegerapi := requireJaegerNginxProxyAPI(mgr.GetClient(), namespace)
-		router := requireFasthttprouter()
-		// JaegerNginxProxy API endpoints
-		router.GET("/api/jaegernginxproxies", adaptHandler(jaegerapi.ListJaegerNginxProxies))
-		router.GET("/api/jaegernginxproxies/:name", adaptHandler(jaegerapi.GetJaegerNginxProxy))
-		router.POST("/api/jaegernginxproxies", adaptHandler(jaegerapi.CreateJaegerNginxProxy))
-		router.PUT("/api/jaegernginxproxies/:name", adaptHandler(jaegerapi.UpdateJaegerNginxProxy))
-		router.PATCH("/api/jaegernginxproxies/:name", adaptHandler(jaegerapi.PatchJaegerNginxProxy))
-		router.DELETE("/api/jaegernginxproxies/:name", adaptHandler(jaegerapi.DeleteJaegerNginxProxy))
-		// Swagger documentation endpoints
-		router.GET("/docs/swagger.json", adaptHandler(serveSwaggerJSON))
-		router.GET("/swagger", adaptHandler(serveSwaggerUI))
-		router.GET("/swagger/", adaptHandler(serveSwaggerUI))
-		// --- END API ROUTER SETUP ---
-
-		log.Trace().Msg("Getting handler instance")
-		handler := func(ctx *fasthttp.RequestCtx) {
-			logger, ok := ctx.UserValue(loggerKey).(zerolog.Logger)
-			if !ok {
-				logger = log.Logger
-			}
-			logger.Trace().Msg("Handler entered")
-
-			// Check for /deployments endpoint first (before router)
-			if string(ctx.Path()) == "/deployments" {
-				logger.Info().Msg("Deployments request received")
-				ctx.Response.Header.Set("Content-Type", "application/json")
-
-				// Check if user wants to see deployments with namespace info
-				queryArgs := ctx.QueryArgs()
-				if queryArgs.Has("with-namespace") {
-					deployments := informer.GetDeploymentNamesWithNamespace()
-					logger.Info().Msgf("Deployments with namespace: %v", deployments)
-					ctx.SetStatusCode(200)
-					ctx.Write([]byte("["))
-					for i, deployment := range deployments {
-						ctx.WriteString("{\"name\":\"")
-						ctx.WriteString(deployment["name"])
-						ctx.WriteString("\",\"namespace\":\"")
-						ctx.WriteString(deployment["namespace"])
-						ctx.WriteString("\"}")
-						if i < len(deployments)-1 {
-							ctx.WriteString(",")
-						}
+	// Probes are served on their own port, so they answer while the API is busy and before the
+	// manager has started
+	healthRegistry := &health.Registry{}
+	mcpHealth, httpHealth := &health.Component{}, &health.Component{}
+	addHealthChecks(healthRegistry, mgr, mcpHealth, httpHealth)
+	healthRegistry.AddReadinessCheck("lifecycle", serverLifecycle.Check)
+
+	// Set up controller-runtime logging
+	ctrlruntimelog.SetLogger(zap.New(zap.UseDevMode(true)))
+
+	// --- API ROUTER SETUP ---
+	// Import here to avoid import cycle in code edit
+	jaegerapi := requireJaegerNginxProxyAPI(mgr.GetClient(), namespace)
+	router := requireFasthttprouter()
+	// JaegerNginxProxy API endpoints
+	router.GET("/api/jaegernginxproxies", adaptHandler(jaegerapi.ListJaegerNginxProxies))
+	router.GET("/api/jaegernginxproxies/:name", adaptHandler(jaegerapi.GetJaegerNginxProxy))
+	router.POST("/api/jaegernginxproxies", adaptHandler(jaegerapi.CreateJaegerNginxProxy))
+	router.PUT("/api/jaegernginxproxies/:name", adaptHandler(jaegerapi.UpdateJaegerNginxProxy))
+	router.PATCH("/api/jaegernginxproxies/:name", adaptHandler(jaegerapi.PatchJaegerNginxProxy))
+	router.DELETE("/api/jaegernginxproxies/:name", adaptHandler(jaegerapi.DeleteJaegerNginxProxy))
+	router.POST("/api/jaegernginxproxies/:name/rollback", adaptHandler(jaegerapi.RollbackJaegerNginxProxy))
+	// Swagger documentation endpoints
+	router.GET("/docs/swagger.json", adaptHandler(serveSwaggerJSON))
+	router.GET("/swagger", adaptHandler(serveSwaggerUI))
+	router.GET("/swagger/", adaptHandler(serveSwaggerUI))
+	// Deployment event stream, ended by the HTTP server's shutdown so it does not wait for the clients
+	watchStop := make(chan struct{})
+	router.GET("/deployments/watch", adaptHandler(func(ctx *fasthttp.RequestCtx) {
+		serveDeploymentWatch(ctx, informer.Subscribe, watchStop)
+	}))
+	// Health endpoints, also served on --health-port
+	router.GET("/healthz", adaptHandler(fasthttpadaptor.NewFastHTTPHandler(healthRegistry.LivenessHandler())))
+	router.GET("/readyz", adaptHandler(fasthttpadaptor.NewFastHTTPHandler(healthRegistry.ReadinessHandler())))
+	// --- END API ROUTER SETUP ---
+
+	log.Trace().Msg("Getting handler instance")
+	handler := func(ctx *fasthttp.RequestCtx) {
+		logger, ok := ctx.UserValue(loggerKey).(zerolog.Logger)
+		if !ok {
+			logger = log.Logger
+		}
+		logger.Trace().Msg("Handler entered")
+
+		// Check for /deployments endpoint first (before router)
+		if string(ctx.Path()) == "/deployments" {
+			logger.Info().Msg("Deployments request received")
+			ctx.Response.Header.Set("Content-Type", "application/json")
+
+			// Check if user wants to see deployments with namespace info
+			queryArgs := ctx.QueryArgs()
+			if queryArgs.Has("with-namespace") {
+				deployments := informer.GetDeploymentNamesWithNamespace()
+				logger.Info().Msgf("Deployments with namespace: %v", deployments)
+				ctx.SetStatusCode(200)
+				ctx.Write([]byte("["))
+				for i, deployment := range deployments {
+					ctx.WriteString("{\"name\":\"")
+					ctx.WriteString(deployment["name"])
+					ctx.WriteString("\",\"namespace\":\"")
+					ctx.WriteString(deployment["namespace"])
+					ctx.WriteString("\"}")
+					if i < len(deployments)-1 {
+						ctx.WriteString(",")
 					}
-					ctx.Write([]byte("]"))
-				} else {
-					deployments := informer.GetDeploymentNames()
-					logger.Info().Msgf("Deployments: %v", deployments)
-					ctx.SetStatusCode(200)
-					ctx.Write([]byte("["))
-					for i, name := range deployments {
-						ctx.WriteString("\"")
-						ctx.WriteString(name)
-						ctx.WriteString("\"")
-						if i < len(deployments)-1 {
-							ctx.WriteString(",")
-						}
+				}
+				ctx.Write([]byte("]"))
+			} else {
+				deployments := informer.GetDeploymentNames()
+				logger.Info().Msgf("Deployments: %v", deployments)
+				ctx.SetStatusCode(200)
+				ctx.Write([]byte("["))
+				for i, name := range deployments {
+					ctx.WriteString("\"")
+					ctx.WriteString(name)
+					ctx.WriteString("\"")
+					if i < len(deployments)-1 {
+						ctx.WriteString(",")
 					}
-					ctx.Write([]byte("]"))
 				}
-				return
+				ctx.Write([]byte("]"))
 			}
+			return
+		}
 
-			// API router takes precedence for all other routes
-			if router != nil {
-				router.Handler(ctx)
-				if ctx.Response.StatusCode() != 0 {
-					return
-				}
+		// API router takes precedence for all other routes
+		if router != nil {
+			router.Handler(ctx)
+			if ctx.Response.StatusCode() != 0 {
+				return
 			}
+		}
 
-			// Default handler for unmatched routes
-			logger.Info().Msg("Default request received")
-			fmt.Fprintf(ctx, "Hello from FastHTTP! Your request ID: %s", ctx.UserValue(requestIDKey))
-			logger.Trace().Msg("Handler exiting")
+		// Default handler for unmatched routes
+		logger.Info().Msg("Default request received")
+		fmt.Fprintf(ctx, "Hello from FastHTTP! Your request ID: %s", ctx.UserValue(requestIDKey))
+		logger.Trace().Msg("Handler exiting")
+	}
+	log.Trace().Msg("Adding loggingMiddleware to handler instance")
+	wrappedHandler := loggingMiddleware(handler)
+
+	// Listeners are opened up front, a port in use fails the start instead of a running server.
+	// Components are stopped in the order they are added: the HTTP and MCP servers drain their
+	// requests first, then the informer and the manager stop, which releases the leader lease, and the
+	// probes are served until the end.
+	addr := fmt.Sprintf(":%d", serverPort)
+	log.Info().Msgf("Starting FastHTTP server on %s", addr)
+	ln, err := net.Listen("tcp4", addr)
+	if err != nil {
+		log.Error().Err(err).Msg("Error starting FastHTTP server")
+		return exitError
+	}
+	httpServer := &fasthttp.Server{Handler: wrappedHandler}
+	httpHealth.Ready("listening on " + addr)
+	serverLifecycle.add("http-server", func() error {
+		if err := httpServer.Serve(ln); err != nil {
+			httpHealth.Fail(err)
+			return err
+		}
+		return nil
+	}, func(ctx context.Context) error {
+		close(watchStop)
+		return httpServer.ShutdownWithContext(ctx)
+	})
+
+	if serverEnableMCP {
+		log.Trace().Msg("MCP server is enabled.")
+		mcpServer := NewMCPServer("K8s Controller MCP", appVersion)
+
+		// Set the global API instance for MCP handlers
+		api.JaegerNginxProxyAPIInst = &api.JaegerNginxProxyAPI{
+			K8sClient: mgr.GetClient(),
+			Namespace: namespace,
 		}
-		log.Trace().Msg("Adding loggingMiddleware to handler instance")
-		wrappedHandler := loggingMiddleware(handler)
-
-		if serverEnableMCP {
-			log.Trace().Msg("MCP server is enabled.")
-			go func() {
-				mcpServer := NewMCPServer("K8s Controller MCP", appVersion)
-
-				// Set the global API instance for MCP handlers
-				api.JaegerNginxProxyAPIInst = &api.JaegerNginxProxyAPI{
-					K8sClient: mgr.GetClient(),
-					Namespace: namespace,
-				}
 
-				sseServer := mcpserver.NewSSEServer(mcpServer,
-					mcpserver.WithBaseURL(fmt.Sprintf("http://:%d", serverMCPPort)),
-				)
-				log.Info().Msgf("Starting MCP server in SSE mode on port %d", serverMCPPort)
-				if err := sseServer.Start(fmt.Sprintf(":%d", serverMCPPort)); err != nil {
-					log.Fatal().Err(err).Msg("MCP SSE server error")
-				}
-			}()
-			log.Info().Msgf("MCP server ready on port %d", serverMCPPort)
+		mcpHTTPServer := &http.Server{}
+		sseServer := mcpserver.NewSSEServer(mcpServer,
+			mcpserver.WithBaseURL(fmt.Sprintf("http://:%d", serverMCPPort)),
+			mcpserver.WithHTTPServer(mcpHTTPServer),
+		)
+		mcpHTTPServer.Handler = sseServer
+		log.Info().Msgf("Starting MCP server in SSE mode on port %d", serverMCPPort)
+		mcpListener, err := net.Listen("tcp", fmt.Sprintf(":%d", serverMCPPort))
+		if err != nil {
+			log.Error().Err(err).Msg("MCP SSE server error")
+			return exitError
 		}
+		mcpHealth.Ready(fmt.Sprintf("listening on port %d", serverMCPPort))
+		log.Info().Msgf("MCP server ready on port %d", serverMCPPort)
+		serverLifecycle.add("mcp-server", func() error {
+			if err := mcpHTTPServer.Serve(mcpListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				mcpHealth.Fail(err)
+				return err
+			}
+			return nil
+		}, sseServer.Shutdown)
+	} else {
+		mcpHealth.Disable("--enable-mcp is not set")
+	}
 
-		addr := fmt.Sprintf(":%d", serverPort)
-		log.Info().Msgf("Starting FastHTTP server on %s", addr)
-		if err := fasthttp.ListenAndServe(addr, wrappedHandler); err != nil {
-			log.Error().Err(err).Msg("Error starting FastHTTP server")
-			os.Exit(1)
+	informerCtx, stopInformer := context.WithCancel(context.Background())
+	defer stopInformer()
+	serverLifecycle.add("informer", func() error {
+		informer.StartDeploymentInformer(informerCtx, clientset, namespaces, informerOpts...)
+		return nil
+	}, func(context.Context) error {
+		stopInformer()
+		return nil
+	})
+
+	mgrCtx, stopManager := context.WithCancel(context.Background())
+	defer stopManager()
+	serverLifecycle.add("manager", func() error {
+		log.Info().Msg("Starting controller-runtime manager...")
+		return mgr.Start(mgrCtx)
+	}, func(context.Context) error {
+		stopManager()
+		return nil
+	})
+
+	healthAddr := fmt.Sprintf(":%d", serverHealthPort)
+	healthListener, err := net.Listen("tcp", healthAddr)
+	if err != nil {
+		log.Error().Err(err).Msg("Health probe server error")
+		return exitError
+	}
+	healthServer := &http.Server{Handler: healthRegistry.Mux()}
+	log.Info().Msgf("Serving /healthz and /readyz on %s", healthAddr)
+	serverLifecycle.add("health-server", func() error {
+		if err := healthServer.Serve(healthListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
 		}
-	},
+		return nil
+	}, healthServer.Shutdown)
+
+	return serverLifecycle.run(ctx)
 }
 
 // --- Helper functions for API router wiring ---
@@ -374,7 +634,10 @@ func serveMCPMessage(ctx *fasthttp.RequestCtx) {
 //   PUT    /api/jaegernginxproxies/:name   - Update a JaegerNginxProxy (full update)
 //   PATCH  /api/jaegernginxproxies/:name   - Patch a JaegerNginxProxy (partial update)
 //   DELETE /api/jaegernginxproxies/:name   - Delete a JaegerNginxProxy
+//   POST   /api/jaegernginxproxies/:name/rollback - Roll a JaegerNginxProxy back to a config revision
 //   GET    /deployments                    - List deployment names from informer cache
+//   GET    /healthz                        - Liveness of the server components as JSON (also on --health-port)
+//   GET    /readyz                         - Readiness of the server components as JSON (also on --health-port)
 //   GET    /docs/swagger.json              - Get Swagger JSON specification
 //   GET    /swagger                        - Get Swagger UI
 //   GET    /sse                            - MCP SSE stream (port 9090)
@@ -384,10 +647,31 @@ func init() {
 	rootCmd.AddCommand(serverCmd)
 	serverCmd.Flags().IntVar(&serverPort, "port", 8080, "Port to run the server on")
 	serverCmd.Flags().IntVar(&serverMetricsPort, "metrics-port", 8081, "Port for controller manager metrics")
+	serverCmd.Flags().IntVar(&serverHealthPort, "health-port", 8082, "Port serving the /healthz and /readyz probes")
+	serverCmd.Flags().DurationVar(&serverShutdownTimeout, "shutdown-timeout", 30*time.Second, "Time to drain in-flight requests and stop the controllers after SIGTERM, shorter than the pod's terminationGracePeriodSeconds")
 	serverCmd.Flags().BoolVar(&serverEnableLeaderElection, "enable-leader-election", true, "Enable leader election for controller manager")
 	serverCmd.Flags().StringVar(&serverLeaderElectionNamespace, "leader-election-namespace", "default", "Namespace for leader election")
 	serverCmd.Flags().StringVar(&serverKubeconfig, "kubeconfig", "", "Path to the kubeconfig file")
 	serverCmd.Flags().BoolVar(&serverInCluster, "in-cluster", false, "Use in-cluster Kubernetes config")
+	serverCmd.Flags().StringVar(&serverDeploymentSelector, "deployment-selector", "", "Label selector of the Deployments listed by /deployments, e.g. app.kubernetes.io/managed-by=jaeger-nginx-proxy (default: all)")
+	serverCmd.Flags().BoolVar(&serverDeploymentMetadataOnly, "deployment-metadata-only", false, "Cache only the metadata of Deployments, which is all /deployments lists, instead of stripped Deployments to use less memory")
+	serverCmd.Flags().IntVar(&serverDeploymentEventHistory, "deployment-event-history", informer.DefaultEventHistory, "Number of Deployment events kept to resume /deployments/watch streams from a resourceVersion, 0 disables resuming")
+	serverCmd.Flags().StringSliceVar(&serverCollectorNamespaces, "collector-namespaces", nil, "Namespaces of collector Services outside --namespaces that spec.networkPolicy reads, the start fails without get services there")
+	serverCmd.Flags().BoolVar(&serverEnableWebhooks, "enable-webhooks", false, "Serve the JaegerNginxProxy validating webhook, requires a serving certificate in --webhook-cert-dir")
+	serverCmd.Flags().StringVar(&serverWebhookCertDir, "webhook-cert-dir", "", "Directory with tls.crt and tls.key of the webhook server (default: <temp dir>/k8s-webhook-server/serving-certs)")
 	serverCmd.Flags().BoolVar(&serverEnableMCP, "enable-mcp", false, "Enable MCP server")
 	serverCmd.Flags().IntVar(&serverMCPPort, "mcp-port", 9090, "Port for MCP server")
+	serverCmd.Flags().StringVar(&serverNginxTest, "nginx-test", ctrl.NginxTestWarn, "Validate generated configs with nginx -t: off, warn (report failures as warnings) or strict (reject failing configs, requires nginx)")
+	serverCmd.Flags().BoolVar(&serverConfigLint, "config-lint", false, "Lint generated configs and report findings as warnings")
+	serverCmd.Flags().StringSliceVar(&serverConfigLintDisable, "config-lint-disable", nil, "Lint rules to skip: "+strings.Join(ctrl.LintRules, ", "))
+	serverCmd.Flags().StringSliceVar(&serverAllowedDirectives, "allowed-directives", nil, "nginx directives config templates and snippets may use (default: a built-in list without file, module and scripting directives)")
+	serverCmd.Flags().StringVar(&serverOTLPEndpoint, "otlp-endpoint", "", "OTLP/HTTP endpoint receiving traces, e.g. http://jaeger-collector:4318 (default: tracing disabled)")
+	serverCmd.Flags().StringVar(&serverTraceSampler, "trace-sampler", tracing.SamplerParentBasedAlwaysOn, "Trace sampler: "+strings.Join(tracing.Samplers, ", "))
+	serverCmd.Flags().Float64Var(&serverTraceSamplerArg, "trace-sampler-arg", 1, "Sampling ratio of the traceidratio samplers")
+	serverCmd.Flags().StringToStringVar(&serverTraceResourceAttributes, "trace-resource-attributes", nil, "Resource attributes added to every span, e.g. deployment.environment=prod")
+	for _, name := range []string{"otlp-endpoint", "trace-sampler", "trace-sampler-arg", "trace-resource-attributes"} {
+		_ = viper.BindPFlag(name, serverCmd.Flags().Lookup(name))
+	}
+	serverCmd.Flags().StringVar(&serverReloaderImage, "reloader-image", "ghcr.io/dolv/k8s-controller-tutorial/app:"+appVersion, "Image of the config reloader sidecar added to proxies with reloadStrategy hotReload")
+	serverCmd.Flags().DurationVar(&serverEventDedupWindow, "event-dedup-window", ctrl.DefaultEventDedupWindow, "How long an identical event about a failed create or update of a proxy resource is suppressed")
 }
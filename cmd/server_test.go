@@ -1,12 +1,29 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"testing"
+	"time"
 
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttprouter"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"k8s.io/client-go/rest"
+	ctrlruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/health"
+	"github.com/dolv/k8s-controller-tutorial/pkg/testutil"
+	"github.com/dolv/k8s-controller-tutorial/pkg/tracing"
 )
 
 func TestServerCommandDefined(t *testing.T) {
@@ -84,3 +101,69 @@ func TestAdaptHandlerWithoutParameters(t *testing.T) {
 	// Verify no parameter was captured (as expected)
 	assert.Equal(t, "", capturedName, "No parameter should be captured when params are empty")
 }
+
+func TestLoggingMiddlewareTracing(t *testing.T) {
+	ctx := context.Background()
+	receiver := testutil.StartOTLPReceiver(t)
+	shutdown, err := tracing.Setup(ctx, tracing.Options{Endpoint: receiver.URL, Sampler: tracing.SamplerAlwaysOn})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	global := log.Logger
+	log.Logger = zerolog.New(&buf).Hook(tracing.LogHook{})
+	defer func() { log.Logger = global }()
+
+	handler := loggingMiddleware(func(ctx *fasthttp.RequestCtx) {
+		logger, ok := ctx.UserValue(loggerKey).(zerolog.Logger)
+		require.True(t, ok, "handlers find the request logger")
+		logger.Info().Msg("handled")
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+	})
+	req := &fasthttp.RequestCtx{}
+	req.Request.Header.SetMethod(fasthttp.MethodGet)
+	req.Request.SetRequestURI("/api/jaegernginxproxies")
+	req.Request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler(req)
+	require.NoError(t, shutdown(ctx))
+
+	span := receiver.WaitForSpan(t, "HTTP GET")
+	requestID := string(req.Response.Header.Peek("X-Request-ID"))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(span.TraceId), "the trace of the caller is continued")
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(span.ParentSpanId))
+	assert.Equal(t, requestID, testutil.SpanAttribute(span, "request_id"))
+	assert.Equal(t, "/api/jaegernginxproxies", testutil.SpanAttribute(span, "url.path"))
+	assert.Equal(t, "500", testutil.SpanAttribute(span, "http.response.status_code"))
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, span.Status.Code)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(bytes.SplitN(buf.Bytes(), []byte("\n"), 2)[0], &entry))
+	assert.Equal(t, "handled", entry["message"])
+	assert.Equal(t, requestID, entry["request_id"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", entry[tracing.TraceIDField])
+	assert.Equal(t, hex.EncodeToString(span.SpanId), entry[tracing.SpanIDField])
+}
+
+func TestAddHealthChecks(t *testing.T) {
+	// The manager is not started, nothing contacts the API server
+	mgr, err := ctrlruntime.NewManager(&rest.Config{Host: "https://127.0.0.1:1"}, manager.Options{
+		Metrics: server.Options{BindAddress: "0"},
+	})
+	require.NoError(t, err)
+	registry := &health.Registry{Timeout: 100 * time.Millisecond}
+	mcpHealth, httpHealth := &health.Component{}, &health.Component{}
+	mcpHealth.Disable("--enable-mcp is not set")
+	addHealthChecks(registry, mgr, mcpHealth, httpHealth)
+
+	liveness := registry.Liveness(context.Background())
+	assert.Equal(t, health.StatusOK, liveness.Status)
+	assert.Equal(t, health.OK("starting"), liveness.Components["http-server"])
+
+	readiness := registry.Readiness(context.Background())
+	assert.Equal(t, health.StatusFailing, readiness.Status)
+	assert.Equal(t, health.StatusFailing, readiness.Components["manager-cache"].Status)
+	assert.Equal(t, health.Failing("cache not synced"), readiness.Components["informer"])
+	assert.Equal(t, health.OK("standby, waiting for the leader lease"), readiness.Components["leader-election"])
+	assert.Equal(t, health.Disabled("--enable-webhooks is not set"), readiness.Components["webhook-server"])
+	assert.Equal(t, health.Disabled("--enable-mcp is not set"), readiness.Components["mcp-server"])
+	assert.Equal(t, health.Failing("starting"), readiness.Components["http-server"])
+}
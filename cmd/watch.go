@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/informer"
+)
+
+// watchBookmarkInterval is how often idle watch streams get a Bookmark event, so proxies keep them
+// open and disconnected clients are noticed
+var watchBookmarkInterval = 15 * time.Second
+
+// watchEventError is the type of the last event of a stream the server ended, e.g. for a slow client
+const watchEventError = "Error"
+
+// serveDeploymentWatch streams the Deployment events of subscribe, informer.Subscribe in the server,
+// until the client disconnects, its subscription ends or stop is closed. Clients that accept
+// text/event-stream or ask for format=sse get Server-Sent Events, others newline-delimited JSON. The
+// namespace and labelSelector query parameters filter the events, resourceVersion (or an SSE
+// Last-Event-ID) resumes a stream after the event with that resourceVersion.
+func serveDeploymentWatch(ctx *fasthttp.RequestCtx, subscribe func(informer.SubscribeOptions) (*informer.Subscription, error), stop <-chan struct{}) {
+	args := ctx.QueryArgs()
+	opts := informer.SubscribeOptions{
+		Namespace:       string(args.Peek("namespace")),
+		ResourceVersion: string(args.Peek("resourceVersion")),
+	}
+	if opts.ResourceVersion == "" {
+		opts.ResourceVersion = string(ctx.Request.Header.Peek("Last-Event-ID"))
+	}
+	if selector := string(args.Peek("labelSelector")); selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			writeWatchError(ctx, fasthttp.StatusBadRequest, err)
+			return
+		}
+		opts.LabelSelector = parsed
+	}
+	sse := string(args.Peek("format")) == "sse" ||
+		(!args.Has("format") && strings.Contains(string(ctx.Request.Header.Peek("Accept")), "text/event-stream"))
+
+	subscription, err := subscribe(opts)
+	switch {
+	case errors.Is(err, informer.ErrResourceVersionTooOld):
+		writeWatchError(ctx, fasthttp.StatusGone, err)
+		return
+	case err != nil:
+		writeWatchError(ctx, fasthttp.StatusServiceUnavailable, err)
+		return
+	}
+
+	if sse {
+		ctx.SetContentType("text/event-stream")
+	} else {
+		ctx.SetContentType("application/x-ndjson")
+	}
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	// Stops proxies like nginx from buffering the stream
+	ctx.Response.Header.Set("X-Accel-Buffering", "no")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer subscription.Close()
+		// Sends the headers and the position to resume from right away, also when there are no events
+		subscription.Bookmark()
+		bookmarks := time.NewTicker(watchBookmarkInterval)
+		defer bookmarks.Stop()
+		for {
+			select {
+			case e, ok := <-subscription.Events():
+				if !ok {
+					if err := subscription.Err(); err != nil {
+						log.Warn().Err(err).Msg("Ending deployment watch stream")
+						_ = writeWatchEvent(w, sse, watchEventError, "", map[string]string{"type": watchEventError, "error": err.Error()})
+					}
+					return
+				}
+				if err := writeWatchEvent(w, sse, string(e.Type), e.ResourceVersion, e); err != nil {
+					log.Debug().Err(err).Msg("Deployment watch client disconnected")
+					return
+				}
+			case <-bookmarks.C:
+				subscription.Bookmark()
+			case <-stop:
+				return
+			}
+		}
+	})
+}
+
+// writeWatchEvent writes and flushes one event, as SSE message with the resourceVersion as id or as
+// JSON line
+func writeWatchEvent(w *bufio.Writer, sse bool, eventType, id string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if sse {
+		if id != "" {
+			w.WriteString("id: " + id + "\n")
+		}
+		w.WriteString("event: " + eventType + "\n")
+		w.WriteString("data: ")
+		w.Write(data)
+		w.WriteString("\n\n")
+	} else {
+		w.Write(data)
+		w.WriteString("\n")
+	}
+	return w.Flush()
+}
+
+func writeWatchError(ctx *fasthttp.RequestCtx, status int, err error) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	json.NewEncoder(ctx).Encode(map[string]string{"error": err.Error()})
+}
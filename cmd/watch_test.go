@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/informer"
+)
+
+func watchTestDeployment(namespace, name, resourceVersion string) *appsv1.Deployment {
+	return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace:       namespace,
+		Name:            name,
+		ResourceVersion: resourceVersion,
+		Labels:          map[string]string{"app": name},
+	}}
+}
+
+// startWatchServer serves /deployments/watch for a synced cache of clientset and returns a client for
+// it and the channel stopping the streams
+func startWatchServer(t *testing.T, clientset *fake.Clientset) (*http.Client, chan struct{}) {
+	t.Helper()
+	c, err := informer.NewDeploymentCache(clientset)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+	require.Eventually(t, c.HasSynced, 5*time.Second, 10*time.Millisecond)
+	// The fake clientset does not replay changes made before the informer watches
+	require.Eventually(t, func() bool {
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "watch" {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stop := make(chan struct{})
+	ln := fasthttputil.NewInmemoryListener()
+	server := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		serveDeploymentWatch(ctx, c.Subscribe, stop)
+	}}
+	go func() { _ = server.Serve(ln) }()
+	t.Cleanup(func() {
+		_ = ln.Close()
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) { return ln.Dial() },
+	}}, stop
+}
+
+func TestDeploymentWatchNDJSON(t *testing.T) {
+	clientset := fake.NewClientset(
+		watchTestDeployment("default", "web", "1"),
+		watchTestDeployment("default", "api", "2"),
+		watchTestDeployment("other", "web", "3"),
+	)
+	client, stop := startWatchServer(t, clientset)
+
+	resp, err := client.Get("http://watch/deployments/watch?namespace=default&labelSelector=app%3Dweb")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	lines := bufio.NewScanner(resp.Body)
+	lines.Buffer(nil, 1<<20)
+
+	next := func() informer.DeploymentEvent {
+		t.Helper()
+		require.True(t, lines.Scan(), "stream ended: %v", lines.Err())
+		var e informer.DeploymentEvent
+		require.NoError(t, json.Unmarshal(lines.Bytes(), &e))
+		return e
+	}
+	e := next()
+	assert.Equal(t, informer.EventAdd, e.Type)
+	assert.Equal(t, "default/web@1", e.Namespace+"/"+e.Name+"@"+e.ResourceVersion, "filtered by namespace and labels")
+	assert.Equal(t, informer.DeploymentEvent{Type: informer.EventBookmark, ResourceVersion: "3"}, next(), "the stream starts with the position to resume from")
+
+	_, err = clientset.AppsV1().Deployments("default").Update(context.Background(), watchTestDeployment("default", "web", "4"), metav1.UpdateOptions{})
+	require.NoError(t, err)
+	e = next()
+	assert.Equal(t, informer.EventUpdate, e.Type)
+	assert.Equal(t, "4", e.ResourceVersion)
+
+	close(stop)
+	assert.False(t, lines.Scan(), "closing stop ends the stream")
+}
+
+func TestDeploymentWatchSSE(t *testing.T) {
+	clientset := fake.NewClientset(watchTestDeployment("default", "web", "1"))
+	client, _ := startWatchServer(t, clientset)
+
+	req, err := http.NewRequest(http.MethodGet, "http://watch/deployments/watch", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	// An EventSource reconnecting after the initial Add
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	require.NoError(t, clientset.AppsV1().Deployments("default").Delete(context.Background(), "web", metav1.DeleteOptions{}))
+	reader := bufio.NewReader(resp.Body)
+	next := func() []string {
+		t.Helper()
+		var message []string
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\n" {
+				return message
+			}
+			message = append(message, strings.TrimSuffix(line, "\n"))
+		}
+	}
+	assert.Equal(t, []string{"id: 1", "event: Bookmark", `data: {"type":"Bookmark","resourceVersion":"1"}`}, next(),
+		"the Add before Last-Event-ID is not replayed")
+	message := next()
+	require.Len(t, message, 3)
+	assert.Equal(t, []string{"id: 1", "event: Delete"}, message[:2])
+	assert.True(t, strings.HasPrefix(message[2], `data: {"type":"Delete","namespace":"default","name":"web","resourceVersion":"1"`), message[2])
+}
+
+func TestDeploymentWatchErrors(t *testing.T) {
+	client, _ := startWatchServer(t, fake.NewClientset(watchTestDeployment("default", "web", "1")))
+
+	for _, tc := range []struct {
+		query  string
+		status int
+		error  string
+	}{
+		{"labelSelector=app+in+(web", http.StatusBadRequest, "unable to parse requirement"},
+		{"resourceVersion=0", http.StatusGone, "resource version is too old"},
+	} {
+		resp, err := client.Get("http://watch/deployments/watch?" + tc.query)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, tc.status, resp.StatusCode, tc.query)
+		assert.Contains(t, string(body), tc.error, tc.query)
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	serveDeploymentWatch(ctx, informer.Subscribe, nil)
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode(), "the informer is not started")
+}
@@ -250,6 +250,65 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/jaegernginxproxies/{name}/rollback": {
+            "post": {
+                "description": "Revert a JaegerNginxProxy to the spec of a config revision listed in its status, 0 for the previous revision",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jaegernginxproxies"
+                ],
+                "summary": "Roll back a JaegerNginxProxy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JaegerNginxProxy name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Revision to roll back to",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.RollbackRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/api.JaegerNginxProxyDoc"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
         "/docs/swagger.json": {
             "get": {
                 "description": "Returns the OpenAPI/Swagger JSON specification for the API",
@@ -385,6 +444,171 @@ const docTemplate = `{
                 }
             }
         },
+        "api.RollbackRequest": {
+            "description": "Config revision to roll back to, 0 for the previous revision",
+            "type": "object",
+            "properties": {
+                "revision": {
+                    "type": "integer",
+                    "example": 0
+                }
+            }
+        },
+        "v1alpha0.Access": {
+            "type": "object",
+            "properties": {
+                "allow": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "deny": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "proxyProtocol": {
+                    "description": "ProxyProtocol expects the PROXY protocol on every connection, for LoadBalancer Services whose load\nbalancer sends it. Clients that do not send it, including in-cluster ones, cannot connect.",
+                    "type": "boolean"
+                },
+                "realIPHeader": {
+                    "description": "RealIPHeader carries the client address set by trusted proxies, e.g. X-Forwarded-For, X-Real-IP, or\nproxy_protocol for the address of the PROXY protocol header",
+                    "type": "string",
+                    "default": "X-Forwarded-For"
+                },
+                "realIPRecursive": {
+                    "description": "RealIPRecursive takes the last untrusted address of the header instead of the last address",
+                    "type": "boolean"
+                },
+                "trustedProxies": {
+                    "description": "TrustedProxies are the CIDRs of load balancers and proxies whose client address header is trusted",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "v1alpha0.CORS": {
+            "type": "object",
+            "properties": {
+                "allowCredentials": {
+                    "description": "AllowCredentials allows requests with cookies or authorization, not with the \"*\" origin",
+                    "type": "boolean"
+                },
+                "allowedHeaders": {
+                    "description": "AllowedHeaders are the request headers allowed in preflight responses, Content-Type when empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "allowedMethods": {
+                    "description": "AllowedMethods are the methods allowed in preflight responses, POST and OPTIONS when empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "allowedOrigins": {
+                    "description": "AllowedOrigins are origins such as \"https://app.example.com\", \"https://*.example.com\" for any\nsubdomain, or \"*\" for any origin\n+kubebuilder:validation:MinItems=1",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "exposedHeaders": {
+                    "description": "ExposedHeaders are response headers readable by the browser client",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "maxAge": {
+                    "description": "MaxAge is the number of seconds browsers may cache preflight responses\n+kubebuilder:validation:Minimum=0",
+                    "type": "integer",
+                    "default": 86400
+                },
+                "preflight": {
+                    "description": "Preflight is \"respond\" to answer preflight requests in nginx, or \"forward\" to pass them to the collector\n+kubebuilder:validation:Enum=respond;forward",
+                    "type": "string",
+                    "default": "respond"
+                }
+            }
+        },
+        "v1alpha0.ConfigFinding": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "description": "Field is the spec field the offending config line was generated from, when known",
+                    "type": "string"
+                },
+                "line": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "rule": {
+                    "type": "string"
+                },
+                "severity": {
+                    "description": "Severity is Error for findings rejecting the config, Warning otherwise\n+kubebuilder:validation:Enum=Error;Warning",
+                    "type": "string"
+                },
+                "validator": {
+                    "description": "Validator is the validator reporting the finding: structural, nginx or lint",
+                    "type": "string"
+                }
+            }
+        },
+        "v1alpha0.ConfigRevision": {
+            "type": "object",
+            "properties": {
+                "configHash": {
+                    "type": "string"
+                },
+                "configMapName": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "revision": {
+                    "type": "integer"
+                }
+            }
+        },
+        "v1alpha0.ConfigTemplate": {
+            "type": "object",
+            "properties": {
+                "configMapName": {
+                    "type": "string"
+                },
+                "key": {
+                    "description": "Key of the template in the ConfigMap",
+                    "type": "string",
+                    "default": "proxy.conf.tmpl"
+                }
+            }
+        },
+        "v1alpha0.GatewayRef": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "namespace": {
+                    "type": "string"
+                },
+                "sectionName": {
+                    "type": "string"
+                }
+            }
+        },
         "v1alpha0.Image": {
             "type": "object",
             "properties": {
@@ -392,6 +616,13 @@ const docTemplate = `{
                     "type": "string",
                     "default": "IfNotPresent"
                 },
+                "pullSecrets": {
+                    "description": "PullSecrets are names of docker-registry Secrets in the proxy namespace",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "repository": {
                     "type": "string",
                     "default": "nginx"
@@ -402,22 +633,156 @@ const docTemplate = `{
                 }
             }
         },
+        "v1alpha0.Ingress": {
+            "type": "object",
+            "properties": {
+                "annotations": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "className": {
+                    "type": "string"
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "gateway": {
+                    "description": "Gateway is the parent Gateway the routes attach to when Kind is \"Gateway\"",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1alpha0.GatewayRef"
+                        }
+                    ]
+                },
+                "host": {
+                    "type": "string"
+                },
+                "kind": {
+                    "description": "Kind selects the rendered resource: \"Ingress\" or \"Gateway\"\n+kubebuilder:validation:Enum=Ingress;Gateway",
+                    "type": "string",
+                    "default": "Ingress"
+                },
+                "tlsSecretName": {
+                    "description": "TLSSecretName enables TLS on the Ingress; Gateway listeners terminate TLS themselves",
+                    "type": "string"
+                }
+            }
+        },
         "v1alpha0.JaegerNginxProxySpec": {
             "type": "object",
             "properties": {
+                "access": {
+                    "description": "Access restricts the client addresses allowed to send spans and configures how they are determined",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1alpha0.Access"
+                        }
+                    ]
+                },
+                "commonAnnotations": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "commonLabels": {
+                    "description": "CommonLabels and CommonAnnotations are set on every resource the controller creates, including pods",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "configTemplate": {
+                    "description": "ConfigTemplate replaces the generated nginx config by a Go text/template rendered with the proxy",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1alpha0.ConfigTemplate"
+                        }
+                    ]
+                },
                 "containerPort": {
                     "type": "integer",
                     "default": 8080
                 },
+                "env": {
+                    "description": "Env is added to the nginx container, e.g. for templates rendered by the nginx image entrypoint",
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                },
+                "extraContainers": {
+                    "description": "ExtraContainers run next to nginx in every proxy pod, e.g. a log shipper",
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                },
+                "extraVolumeMounts": {
+                    "description": "ExtraVolumeMounts are added to the nginx container; /etc/nginx/conf.d is reserved for the generated config",
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                },
+                "extraVolumes": {
+                    "description": "ExtraVolumes are added to the proxy pod; the \"contents\" volume is reserved for the generated config",
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                },
                 "image": {
                     "$ref": "#/definitions/v1alpha0.Image"
                 },
+                "ingress": {
+                    "$ref": "#/definitions/v1alpha0.Ingress"
+                },
+                "logging": {
+                    "description": "Logging configures the access log of the proxy pods",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1alpha0.Logging"
+                        }
+                    ]
+                },
+                "metrics": {
+                    "description": "Metrics adds a Prometheus exporter sidecar to the proxy pods",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1alpha0.Metrics"
+                        }
+                    ]
+                },
+                "networkPolicy": {
+                    "$ref": "#/definitions/v1alpha0.NetworkPolicy"
+                },
+                "podAnnotations": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "podLabels": {
+                    "description": "PodLabels and PodAnnotations are only set on the proxy pods",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
                 "ports": {
                     "type": "array",
                     "items": {
                         "$ref": "#/definitions/v1alpha0.Port"
                     }
                 },
+                "reloadStrategy": {
+                    "description": "ReloadStrategy controls how config changes reach running pods. With \"none\" nginx picks them up on the\nnext pod restart, \"hotReload\" adds a sidecar that reloads nginx in place.\n+kubebuilder:validation:Enum=none;hotReload",
+                    "type": "string",
+                    "default": "none"
+                },
                 "replicaCount": {
                     "type": "integer",
                     "default": 1
@@ -425,9 +790,25 @@ const docTemplate = `{
                 "resources": {
                     "$ref": "#/definitions/v1alpha0.Resources"
                 },
+                "revisionHistoryLimit": {
+                    "description": "RevisionHistoryLimit is the number of config revisions retained for rollback, including the current one\n+kubebuilder:validation:Minimum=1",
+                    "type": "integer",
+                    "default": 10
+                },
                 "service": {
                     "$ref": "#/definitions/v1alpha0.Service"
                 },
+                "serviceAccount": {
+                    "$ref": "#/definitions/v1alpha0.ServiceAccount"
+                },
+                "snippets": {
+                    "description": "Snippets are inserted into the generated nginx config",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1alpha0.Snippets"
+                        }
+                    ]
+                },
                 "upstream": {
                     "$ref": "#/definitions/v1alpha0.Upstream"
                 }
@@ -436,18 +817,191 @@ const docTemplate = `{
         "v1alpha0.JaegerNginxProxyStatus": {
             "type": "object",
             "properties": {
+                "conditions": {
+                    "description": "Conditions are the latest observations of the proxy state, e.g. ConfigValid\n+listType=map\n+listMapKey=type",
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                },
+                "configFindings": {
+                    "description": "ConfigFindings are the results of the config validators for the current spec",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/v1alpha0.ConfigFinding"
+                    }
+                },
+                "configHash": {
+                    "description": "ConfigHash identifies the generated nginx config currently stored in the ConfigMap",
+                    "type": "string"
+                },
+                "currentRevision": {
+                    "description": "CurrentRevision is the config revision the proxy is serving",
+                    "type": "integer"
+                },
                 "message": {
                     "type": "string"
                 },
+                "pods": {
+                    "description": "Pods reports which config every proxy pod has loaded when spec.reloadStrategy is hotReload",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/v1alpha0.PodConfigStatus"
+                    }
+                },
                 "ready": {
                     "description": "Add your custom status fields here",
                     "type": "boolean"
+                },
+                "revisions": {
+                    "description": "Revisions lists the retained config revisions, newest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/v1alpha0.ConfigRevision"
+                    }
+                },
+                "url": {
+                    "description": "URL is the externally reachable address when spec.ingress is enabled",
+                    "type": "string"
+                }
+            }
+        },
+        "v1alpha0.Logging": {
+            "type": "object",
+            "properties": {
+                "fields": {
+                    "description": "Fields are the fields of the json format, or fields appended to the custom format. The combined\nformat has fixed fields.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "format": {
+                    "description": "Format is \"custom\", the key=value text format, \"json\" or \"combined\", the nginx default format\n+kubebuilder:validation:Enum=custom;json;combined",
+                    "type": "string",
+                    "default": "custom"
+                },
+                "successSamplePercent": {
+                    "description": "SuccessSamplePercent is the share of successful requests that is logged, failed requests are always logged\n+kubebuilder:validation:Minimum=0\n+kubebuilder:validation:Maximum=100",
+                    "type": "integer",
+                    "default": 100
+                },
+                "tenantHeader": {
+                    "description": "TenantHeader is the request header logged as the tenant field",
+                    "type": "string"
+                }
+            }
+        },
+        "v1alpha0.Metrics": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "port": {
+                    "description": "Port serves /metrics, it is exposed by the \u003cname\u003e-metrics Service\n+kubebuilder:validation:Minimum=1\n+kubebuilder:validation:Maximum=65535",
+                    "type": "integer",
+                    "default": 9113
+                },
+                "serviceMonitor": {
+                    "description": "ServiceMonitor is created for the Prometheus Operator when its CRD is installed",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1alpha0.ServiceMonitor"
+                        }
+                    ]
+                },
+                "statusPort": {
+                    "description": "StatusPort is the internal port of the stub_status location, it only listens on localhost\n+kubebuilder:validation:Minimum=1\n+kubebuilder:validation:Maximum=65535",
+                    "type": "integer",
+                    "default": 18080
+                }
+            }
+        },
+        "v1alpha0.NetworkPolicy": {
+            "type": "object",
+            "properties": {
+                "collectorCIDRs": {
+                    "description": "CollectorCIDRs allows egress to these CIDRs instead of resolving Upstream.CollectorHost as a Service",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "from": {
+                    "description": "From lists the peers allowed to reach ContainerPort; an empty list allows all sources",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/v1alpha0.NetworkPolicyPeer"
+                    }
+                }
+            }
+        },
+        "v1alpha0.NetworkPolicyPeer": {
+            "type": "object",
+            "properties": {
+                "namespaceSelector": {
+                    "type": "object"
+                },
+                "podSelector": {
+                    "type": "object"
+                }
+            }
+        },
+        "v1alpha0.PodConfigStatus": {
+            "type": "object",
+            "properties": {
+                "configHash": {
+                    "type": "string"
+                },
+                "loadedAt": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "name": {
+                    "type": "string"
                 }
             }
         },
         "v1alpha0.Port": {
             "type": "object",
             "properties": {
+                "accessLog": {
+                    "description": "AccessLog set to false turns off the access log of the port",
+                    "type": "boolean",
+                    "default": true
+                },
+                "allow": {
+                    "description": "Allow replaces spec.access.allow for this port, Deny is checked in addition to spec.access.deny",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "cors": {
+                    "description": "CORS allows browser clients of other origins to post spans, http ports only",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1alpha0.CORS"
+                        }
+                    ]
+                },
+                "deny": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "hideResponseHeaders": {
+                    "description": "HideResponseHeaders are collector response headers not passed to the client",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "name": {
                     "type": "string"
                 },
@@ -456,6 +1010,24 @@ const docTemplate = `{
                 },
                 "port": {
                     "type": "integer"
+                },
+                "protocol": {
+                    "description": "Protocol is either \"http\" or \"grpc\"; ports named \"grpc\" default to grpc\n+kubebuilder:validation:Enum=http;grpc",
+                    "type": "string"
+                },
+                "removeHeaders": {
+                    "description": "RemoveHeaders are request headers not passed to the collector",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "setHeaders": {
+                    "description": "SetHeaders are request headers set on requests to the collector, e.g. tenant or auth headers.\nValues may reference nginx variables such as $remote_addr.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
                 }
             }
         },
@@ -489,6 +1061,64 @@ const docTemplate = `{
                 }
             }
         },
+        "v1alpha0.ServiceAccount": {
+            "type": "object",
+            "properties": {
+                "annotations": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "automountServiceAccountToken": {
+                    "description": "AutomountServiceAccountToken defaults to false, nginx never talks to the API server",
+                    "type": "boolean"
+                },
+                "create": {
+                    "description": "Create makes the controller create and own a ServiceAccount named after the proxy",
+                    "type": "boolean"
+                },
+                "name": {
+                    "description": "Name of an existing ServiceAccount to use when Create is false",
+                    "type": "string"
+                }
+            }
+        },
+        "v1alpha0.ServiceMonitor": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "interval": {
+                    "description": "Interval is the scrape interval, the Prometheus default when empty",
+                    "type": "string"
+                },
+                "labels": {
+                    "description": "Labels are added to the ServiceMonitor, e.g. the labels selected by the Prometheus resource",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "v1alpha0.Snippets": {
+            "type": "object",
+            "properties": {
+                "location": {
+                    "description": "Location maps port names to snippets inserted into the location block of the port",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "server": {
+                    "description": "Server is inserted into the server block",
+                    "type": "string"
+                }
+            }
+        },
         "v1alpha0.Upstream": {
             "type": "object",
             "properties": {
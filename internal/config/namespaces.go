@@ -0,0 +1,27 @@
+package config
+
+import (
+	"strings"
+)
+
+// AllNamespaces selects every namespace in --namespaces
+const AllNamespaces = "all"
+
+// ParseNamespaces parses a --namespaces value: a comma-separated list of namespaces, or "" or "all" for
+// every namespace, which is returned as nil. Blanks and duplicates are dropped.
+func ParseNamespaces(value string) []string {
+	var namespaces []string
+	seen := map[string]bool{}
+	for _, ns := range strings.Split(value, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == AllNamespaces {
+			return nil
+		}
+		if ns == "" || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces
+}
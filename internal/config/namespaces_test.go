@@ -0,0 +1,23 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNamespaces(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{value: "", want: nil},
+		{value: "all", want: nil},
+		{value: "default", want: []string{"default"}},
+		{value: " tenant-a, tenant-b ,,tenant-a", want: []string{"tenant-a", "tenant-b"}},
+		{value: "tenant-a,all", want: nil},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ParseNamespaces(tt.value), tt.value)
+	}
+}
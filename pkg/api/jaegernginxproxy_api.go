@@ -3,12 +3,16 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/valyala/fasthttp"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	jaegerv1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+	ctrl "github.com/dolv/k8s-controller-tutorial/pkg/ctrl"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -237,6 +241,12 @@ func (api *JaegerNginxProxyAPI) applyPartialSpecUpdate(existing *jaegerv1alpha0.
 			if pullPolicy, ok := imageData["pullPolicy"].(string); ok {
 				existing.Spec.Image.PullPolicy = pullPolicy
 			}
+			if pullSecrets, ok := imageData["pullSecrets"]; ok {
+				existing.Spec.Image.PullSecrets = nil
+				if err := remarshal(pullSecrets, &existing.Spec.Image.PullSecrets); err != nil {
+					return fmt.Errorf("invalid image.pullSecrets: %w", err)
+				}
+			}
 		}
 
 		// Update upstream
@@ -290,6 +300,26 @@ func (api *JaegerNginxProxyAPI) applyPartialSpecUpdate(existing *jaegerv1alpha0.
 					if path, ok := portData["path"].(string); ok {
 						port.Path = path
 					}
+					if protocol, ok := portData["protocol"].(string); ok {
+						port.Protocol = protocol
+					}
+					// Header, CORS, access and logging settings are structured, decode them as a whole
+					headerFields := map[string]interface{}{
+						"setHeaders":          &port.SetHeaders,
+						"removeHeaders":       &port.RemoveHeaders,
+						"hideResponseHeaders": &port.HideResponseHeaders,
+						"cors":                &port.CORS,
+						"allow":               &port.Allow,
+						"deny":                &port.Deny,
+						"accessLog":           &port.AccessLog,
+					}
+					for key, target := range headerFields {
+						if data, ok := portData[key]; ok {
+							if err := remarshal(data, target); err != nil {
+								return fmt.Errorf("invalid ports %s: %w", key, err)
+							}
+						}
+					}
 					newPorts = append(newPorts, port)
 				}
 			}
@@ -297,11 +327,245 @@ func (api *JaegerNginxProxyAPI) applyPartialSpecUpdate(existing *jaegerv1alpha0.
 				existing.Spec.Ports = newPorts
 			}
 		}
+
+		// Update ingress (replace entire object, null disables it)
+		if ingressData, ok := specData["ingress"]; ok {
+			if ingressData == nil {
+				existing.Spec.Ingress = nil
+			} else {
+				ingress := &jaegerv1alpha0.Ingress{}
+				if err := remarshal(ingressData, ingress); err != nil {
+					return fmt.Errorf("invalid ingress: %w", err)
+				}
+				existing.Spec.Ingress = ingress
+			}
+		}
+
+		// Update network policy (replace entire object, null disables it)
+		if networkPolicyData, ok := specData["networkPolicy"]; ok {
+			if networkPolicyData == nil {
+				existing.Spec.NetworkPolicy = nil
+			} else {
+				networkPolicy := &jaegerv1alpha0.NetworkPolicy{}
+				if err := remarshal(networkPolicyData, networkPolicy); err != nil {
+					return fmt.Errorf("invalid networkPolicy: %w", err)
+				}
+				existing.Spec.NetworkPolicy = networkPolicy
+			}
+		}
+
+		// Update service account (replace entire object, null reverts to the namespace default)
+		if serviceAccountData, ok := specData["serviceAccount"]; ok {
+			if serviceAccountData == nil {
+				existing.Spec.ServiceAccount = nil
+			} else {
+				serviceAccount := &jaegerv1alpha0.ServiceAccount{}
+				if err := remarshal(serviceAccountData, serviceAccount); err != nil {
+					return fmt.Errorf("invalid serviceAccount: %w", err)
+				}
+				existing.Spec.ServiceAccount = serviceAccount
+			}
+		}
+
+		// Update labels and annotations (replace entire maps)
+		for key, target := range map[string]*map[string]string{
+			"commonLabels":      &existing.Spec.CommonLabels,
+			"commonAnnotations": &existing.Spec.CommonAnnotations,
+			"podLabels":         &existing.Spec.PodLabels,
+			"podAnnotations":    &existing.Spec.PodAnnotations,
+		} {
+			if data, ok := specData[key]; ok {
+				*target = nil
+				if err := remarshal(data, target); err != nil {
+					return fmt.Errorf("invalid %s: %w", key, err)
+				}
+			}
+		}
+
+		if reloadStrategy, ok := specData["reloadStrategy"].(string); ok {
+			existing.Spec.ReloadStrategy = reloadStrategy
+		}
+
+		if limit, ok := specData["revisionHistoryLimit"].(float64); ok {
+			revisionHistoryLimit := int32(limit)
+			existing.Spec.RevisionHistoryLimit = &revisionHistoryLimit
+		}
+
+		// Update pod extras (replace entire lists)
+		if data, ok := specData["env"]; ok {
+			existing.Spec.Env = nil
+			if err := remarshal(data, &existing.Spec.Env); err != nil {
+				return fmt.Errorf("invalid env: %w", err)
+			}
+		}
+		if data, ok := specData["extraContainers"]; ok {
+			existing.Spec.ExtraContainers = nil
+			if err := remarshal(data, &existing.Spec.ExtraContainers); err != nil {
+				return fmt.Errorf("invalid extraContainers: %w", err)
+			}
+		}
+		if data, ok := specData["extraVolumes"]; ok {
+			existing.Spec.ExtraVolumes = nil
+			if err := remarshal(data, &existing.Spec.ExtraVolumes); err != nil {
+				return fmt.Errorf("invalid extraVolumes: %w", err)
+			}
+		}
+		if data, ok := specData["extraVolumeMounts"]; ok {
+			existing.Spec.ExtraVolumeMounts = nil
+			if err := remarshal(data, &existing.Spec.ExtraVolumeMounts); err != nil {
+				return fmt.Errorf("invalid extraVolumeMounts: %w", err)
+			}
+		}
+
+		// Update access control (replace entire object, null removes it)
+		if data, ok := specData["access"]; ok {
+			existing.Spec.Access = nil
+			if err := remarshal(data, &existing.Spec.Access); err != nil {
+				return fmt.Errorf("invalid access: %w", err)
+			}
+		}
+
+		// Update logging (replace entire object, null removes it)
+		if data, ok := specData["logging"]; ok {
+			existing.Spec.Logging = nil
+			if err := remarshal(data, &existing.Spec.Logging); err != nil {
+				return fmt.Errorf("invalid logging: %w", err)
+			}
+		}
+
+		// Update metrics (replace entire object, null removes it)
+		if data, ok := specData["metrics"]; ok {
+			existing.Spec.Metrics = nil
+			if err := remarshal(data, &existing.Spec.Metrics); err != nil {
+				return fmt.Errorf("invalid metrics: %w", err)
+			}
+		}
+
+		// Update config template and snippets (replace entirely, null removes them)
+		if data, ok := specData["configTemplate"]; ok {
+			existing.Spec.ConfigTemplate = nil
+			if err := remarshal(data, &existing.Spec.ConfigTemplate); err != nil {
+				return fmt.Errorf("invalid configTemplate: %w", err)
+			}
+		}
+		if data, ok := specData["snippets"]; ok {
+			existing.Spec.Snippets = nil
+			if err := remarshal(data, &existing.Spec.Snippets); err != nil {
+				return fmt.Errorf("invalid snippets: %w", err)
+			}
+		}
 	}
 
 	return nil
 }
 
+// remarshal converts a generic JSON value decoded from a patch body into a typed struct
+func remarshal(in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// RollbackRequest selects the config revision to roll back to
+// @Description Config revision to roll back to, 0 for the previous revision
+type RollbackRequest struct {
+	Revision int64 `json:"revision" example:"0"`
+}
+
+// RollbackJaegerNginxProxy godoc
+// @Summary Roll back a JaegerNginxProxy
+// @Description Revert a JaegerNginxProxy to the spec of a config revision listed in its status, 0 for the previous revision
+// @Tags jaegernginxproxies
+// @Accept json
+// @Produce json
+// @Param name path string true "JaegerNginxProxy name"
+// @Param body body RollbackRequest true "Revision to roll back to"
+// @Success 202 {object} JaegerNginxProxyDoc
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/jaegernginxproxies/{name}/rollback [post]
+func (api *JaegerNginxProxyAPI) RollbackJaegerNginxProxy(ctx *fasthttp.RequestCtx) {
+	nameVal := ctx.UserValue("name")
+	if nameVal == nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error":"missing name parameter"}`)
+		return
+	}
+	name := nameVal.(string)
+
+	var req RollbackRequest
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"invalid JSON: %v"}`, err))
+		return
+	}
+
+	obj, err := RequestRollback(context.Background(), api.K8sClient, api.Namespace, name, req.Revision)
+	if err != nil {
+		switch {
+		case apierrors.IsNotFound(err), errors.Is(err, ErrRevisionNotFound):
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+		case errors.Is(err, ErrInvalidRevision):
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		default:
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		}
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"%v"}`, err))
+		return
+	}
+
+	// The controller performs the rollback asynchronously
+	ctx.SetStatusCode(fasthttp.StatusAccepted)
+	ctx.SetContentType("application/json")
+	json.NewEncoder(ctx).Encode(obj)
+}
+
+var (
+	ErrInvalidRevision  = errors.New("revision must be 0 or greater")
+	ErrRevisionNotFound = errors.New("config revision not found")
+)
+
+// RequestRollback asks the controller to roll the JaegerNginxProxy back to revision, 0 meaning the
+// previous one, by setting the rollback annotation. Revisions are checked against the status so that
+// typos fail right away instead of only producing a Warning event.
+func RequestRollback(ctx context.Context, c client.Client, namespace, name string, revision int64) (*jaegerv1alpha0.JaegerNginxProxy, error) {
+	if revision < 0 {
+		return nil, ErrInvalidRevision
+	}
+
+	obj := &jaegerv1alpha0.JaegerNginxProxy{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, obj); err != nil {
+		return nil, err
+	}
+
+	known := revision == 0 && len(obj.Status.Revisions) > 1
+	for _, r := range obj.Status.Revisions {
+		if r.Revision == revision {
+			known = true
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("%w: %d", ErrRevisionNotFound, revision)
+	}
+
+	patch := client.MergeFrom(obj.DeepCopy())
+	obj.SetAnnotations(mergeAnnotation(obj.GetAnnotations(), ctrl.RollbackAnnotation, strconv.FormatInt(revision, 10)))
+	if err := c.Patch(ctx, obj, patch); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func mergeAnnotation(annotations map[string]string, key, value string) map[string]string {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	return annotations
+}
+
 // DeleteJaegerNginxProxy godoc
 // @Summary Delete a JaegerNginxProxy
 // @Description Delete a JaegerNginxProxy by name
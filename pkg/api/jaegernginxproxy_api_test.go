@@ -20,7 +20,9 @@ import (
 	"github.com/valyala/fasthttprouter"
 	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
@@ -53,7 +55,7 @@ func adaptHandler(h func(ctx *fasthttp.RequestCtx)) fasthttprouter.Handle {
 func setupTestAPIWithManager(t *testing.T) (*JaegerNginxProxyAPI, client.Client, func()) {
 	mgr, k8sClient, _, cleanup := testutil.StartTestManager(t)
 
-	require.NoError(t, myctrl.AddJaegerNginxProxyController(mgr))
+	require.NoError(t, myctrl.AddJaegerNginxProxyController(mgr, myctrl.ControllerOptions{}))
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -310,3 +312,41 @@ func TestJaegerNginxProxyAPI_PatchPartialUpdate(t *testing.T) {
 	assert.Equal(t, "100m", existing.Spec.Resources.Requests.CPU)     // Should remain unchanged
 	assert.Equal(t, "128Mi", existing.Spec.Resources.Requests.Memory) // Should remain unchanged
 }
+
+func TestRequestRollback(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, jaegerv1alpha0.AddToScheme(s))
+	proxy := &jaegerv1alpha0.JaegerNginxProxy{
+		ObjectMeta: metav1.ObjectMeta{Name: "rollback-proxy", Namespace: "default"},
+		Status: jaegerv1alpha0.JaegerNginxProxyStatus{
+			CurrentRevision: 2,
+			Revisions:       []jaegerv1alpha0.ConfigRevision{{Revision: 2}, {Revision: 1}},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(s).WithObjects(proxy).Build()
+	ctx := context.Background()
+
+	_, err := RequestRollback(ctx, c, "default", "rollback-proxy", -1)
+	assert.ErrorIs(t, err, ErrInvalidRevision)
+	_, err = RequestRollback(ctx, c, "default", "rollback-proxy", 5)
+	assert.ErrorIs(t, err, ErrRevisionNotFound)
+
+	_, err = RequestRollback(ctx, c, "default", "rollback-proxy", 1)
+	require.NoError(t, err)
+	var current jaegerv1alpha0.JaegerNginxProxy
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(proxy), &current))
+	assert.Equal(t, "1", current.Annotations[myctrl.RollbackAnnotation])
+
+	rctx := &fasthttp.RequestCtx{}
+	rctx.SetUserValue("name", "rollback-proxy")
+	rctx.Request.SetBodyString(`{"revision":0}`)
+	api := &JaegerNginxProxyAPI{K8sClient: c, Namespace: "default"}
+	api.RollbackJaegerNginxProxy(rctx)
+	assert.Equal(t, fasthttp.StatusAccepted, rctx.Response.StatusCode())
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(proxy), &current))
+	assert.Equal(t, "0", current.Annotations[myctrl.RollbackAnnotation])
+
+	rctx.SetUserValue("name", "missing")
+	api.RollbackJaegerNginxProxy(rctx)
+	assert.Equal(t, fasthttp.StatusNotFound, rctx.Response.StatusCode())
+}
@@ -1,6 +1,7 @@
 package v1alpha0
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -8,9 +9,54 @@ type JaegerNginxProxyStatus struct {
 	// Add your custom status fields here
 	Ready   bool   `json:"ready"`
 	Message string `json:"message,omitempty"`
+	// URL is the externally reachable address when spec.ingress is enabled
+	URL string `json:"url,omitempty"`
+	// ConfigHash identifies the generated nginx config currently stored in the ConfigMap
+	ConfigHash string `json:"configHash,omitempty"`
+	// Pods reports which config every proxy pod has loaded when spec.reloadStrategy is hotReload
+	Pods []PodConfigStatus `json:"pods,omitempty"`
+	// CurrentRevision is the config revision the proxy is serving
+	CurrentRevision int64 `json:"currentRevision,omitempty"`
+	// Revisions lists the retained config revisions, newest first
+	Revisions []ConfigRevision `json:"revisions,omitempty"`
+	// ConfigFindings are the results of the config validators for the current spec
+	ConfigFindings []ConfigFinding `json:"configFindings,omitempty"`
+	// Conditions are the latest observations of the proxy state, e.g. ConfigValid
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" swaggertype:"array,object"`
 	// You can add more fields as needed
 }
 
+// PodConfigStatus is the config hash reported by the reloader sidecar of a proxy pod
+type PodConfigStatus struct {
+	Name       string       `json:"name"`
+	ConfigHash string       `json:"configHash,omitempty"`
+	LoadedAt   *metav1.Time `json:"loadedAt,omitempty" swaggertype:"string" format:"date-time"`
+}
+
+// ConfigRevision describes an immutable ConfigMap holding a rendered nginx config and the spec it was rendered from
+type ConfigRevision struct {
+	Revision      int64        `json:"revision"`
+	ConfigMapName string       `json:"configMapName"`
+	ConfigHash    string       `json:"configHash"`
+	CreatedAt     *metav1.Time `json:"createdAt,omitempty" swaggertype:"string" format:"date-time"`
+}
+
+// ConfigFinding is a single result of a config validator, e.g. a failed lint rule
+type ConfigFinding struct {
+	// Validator is the validator reporting the finding: structural, nginx or lint
+	Validator string `json:"validator"`
+	Rule      string `json:"rule"`
+	// Severity is Error for findings rejecting the config, Warning otherwise
+	// +kubebuilder:validation:Enum=Error;Warning
+	Severity string `json:"severity"`
+	// Field is the spec field the offending config line was generated from, when known
+	Field   string `json:"field,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 type JaegerNginxProxy struct {
@@ -23,13 +69,65 @@ type JaegerNginxProxy struct {
 
 // JaegerNginxProxySpec defines the desired state of JaegerNginxProxy
 type JaegerNginxProxySpec struct {
-	ReplicaCount  int       `json:"replicaCount" default:"1"`
-	Upstream      Upstream  `json:"upstream"`
-	ContainerPort int       `json:"containerPort" default:"8080"`
-	Image         Image     `json:"image"`
-	Ports         []Port    `json:"ports"`
-	Service       Service   `json:"service"`
-	Resources     Resources `json:"resources"`
+	ReplicaCount   int             `json:"replicaCount" default:"1"`
+	Upstream       Upstream        `json:"upstream"`
+	ContainerPort  int             `json:"containerPort" default:"8080"`
+	Image          Image           `json:"image"`
+	Ports          []Port          `json:"ports"`
+	Service        Service         `json:"service"`
+	Resources      Resources       `json:"resources"`
+	Ingress        *Ingress        `json:"ingress,omitempty"`
+	NetworkPolicy  *NetworkPolicy  `json:"networkPolicy,omitempty"`
+	ServiceAccount *ServiceAccount `json:"serviceAccount,omitempty"`
+	// Access restricts the client addresses allowed to send spans and configures how they are determined
+	Access *Access `json:"access,omitempty"`
+	// Logging configures the access log of the proxy pods
+	Logging *Logging `json:"logging,omitempty"`
+	// Metrics adds a Prometheus exporter sidecar to the proxy pods
+	Metrics *Metrics `json:"metrics,omitempty"`
+	// CommonLabels and CommonAnnotations are set on every resource the controller creates, including pods
+	CommonLabels      map[string]string `json:"commonLabels,omitempty"`
+	CommonAnnotations map[string]string `json:"commonAnnotations,omitempty"`
+	// PodLabels and PodAnnotations are only set on the proxy pods
+	PodLabels      map[string]string `json:"podLabels,omitempty"`
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+	// Env is added to the nginx container, e.g. for templates rendered by the nginx image entrypoint
+	Env []corev1.EnvVar `json:"env,omitempty" swaggertype:"array,object"`
+	// ExtraContainers run next to nginx in every proxy pod, e.g. a log shipper
+	ExtraContainers []corev1.Container `json:"extraContainers,omitempty" swaggertype:"array,object"`
+	// ExtraVolumes are added to the proxy pod; the "contents" volume is reserved for the generated config
+	ExtraVolumes []corev1.Volume `json:"extraVolumes,omitempty" swaggertype:"array,object"`
+	// ExtraVolumeMounts are added to the nginx container; /etc/nginx/conf.d is reserved for the generated config
+	ExtraVolumeMounts []corev1.VolumeMount `json:"extraVolumeMounts,omitempty" swaggertype:"array,object"`
+	// ReloadStrategy controls how config changes reach running pods. With "none" nginx picks them up on the
+	// next pod restart, "hotReload" adds a sidecar that reloads nginx in place.
+	// +kubebuilder:validation:Enum=none;hotReload
+	ReloadStrategy string `json:"reloadStrategy,omitempty" default:"none"`
+	// RevisionHistoryLimit is the number of config revisions retained for rollback, including the current one
+	// +kubebuilder:validation:Minimum=1
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty" default:"10"`
+	// ConfigTemplate replaces the generated nginx config by a Go text/template rendered with the proxy
+	ConfigTemplate *ConfigTemplate `json:"configTemplate,omitempty"`
+	// Snippets are inserted into the generated nginx config
+	Snippets *Snippets `json:"snippets,omitempty"`
+}
+
+// ConfigTemplate references a Go text/template in a ConfigMap of the proxy namespace. The template is
+// rendered with .Name, .Namespace, .Spec and .Generated, the config the controller would generate.
+// The ConfigMap is not versioned, rolling back re-renders its current content.
+type ConfigTemplate struct {
+	ConfigMapName string `json:"configMapName"`
+	// Key of the template in the ConfigMap
+	Key string `json:"key,omitempty" default:"proxy.conf.tmpl"`
+}
+
+// Snippets add raw nginx directives to the generated config. Only directives allowed by the controller
+// can be used and snippets cannot close the block they are inserted into.
+type Snippets struct {
+	// Server is inserted into the server block
+	Server string `json:"server,omitempty"`
+	// Location maps port names to snippets inserted into the location block of the port
+	Location map[string]string `json:"location,omitempty"`
 }
 
 type Upstream struct {
@@ -40,12 +138,146 @@ type Port struct {
 	Name string `json:"name"`
 	Port int    `json:"port"`
 	Path string `json:"path"`
+	// Protocol is either "http" or "grpc"; ports named "grpc" default to grpc
+	// +kubebuilder:validation:Enum=http;grpc
+	Protocol string `json:"protocol,omitempty"`
+	// SetHeaders are request headers set on requests to the collector, e.g. tenant or auth headers.
+	// Values may reference nginx variables such as $remote_addr.
+	SetHeaders map[string]string `json:"setHeaders,omitempty"`
+	// RemoveHeaders are request headers not passed to the collector
+	RemoveHeaders []string `json:"removeHeaders,omitempty"`
+	// HideResponseHeaders are collector response headers not passed to the client
+	HideResponseHeaders []string `json:"hideResponseHeaders,omitempty"`
+	// CORS allows browser clients of other origins to post spans, http ports only
+	CORS *CORS `json:"cors,omitempty"`
+	// Allow replaces spec.access.allow for this port, Deny is checked in addition to spec.access.deny
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	// AccessLog set to false turns off the access log of the port
+	AccessLog *bool `json:"accessLog,omitempty" default:"true"`
+}
+
+// Logging selects the access log format of the proxy pods, written to stdout
+type Logging struct {
+	// Format is "custom", the key=value text format, "json" or "combined", the nginx default format
+	// +kubebuilder:validation:Enum=custom;json;combined
+	Format string `json:"format,omitempty" default:"custom"`
+	// Fields are the fields of the json format, or fields appended to the custom format. The combined
+	// format has fixed fields.
+	Fields []string `json:"fields,omitempty"`
+	// TenantHeader is the request header logged as the tenant field
+	TenantHeader string `json:"tenantHeader,omitempty"`
+	// SuccessSamplePercent is the share of successful requests that is logged, failed requests are always logged
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	SuccessSamplePercent *int32 `json:"successSamplePercent,omitempty" default:"100"`
+}
+
+// Metrics exposes nginx stub_status and per-port request counters parsed from the access log as
+// Prometheus metrics. The exporter is a sidecar running the controller image.
+type Metrics struct {
+	Enabled bool `json:"enabled"`
+	// Port serves /metrics, it is exposed by the <name>-metrics Service
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int `json:"port,omitempty" default:"9113"`
+	// StatusPort is the internal port of the stub_status location, it only listens on localhost
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	StatusPort int `json:"statusPort,omitempty" default:"18080"`
+	// ServiceMonitor is created for the Prometheus Operator when its CRD is installed
+	ServiceMonitor *ServiceMonitor `json:"serviceMonitor,omitempty"`
+}
+
+type ServiceMonitor struct {
+	Enabled bool `json:"enabled"`
+	// Interval is the scrape interval, the Prometheus default when empty
+	Interval string `json:"interval,omitempty"`
+	// Labels are added to the ServiceMonitor, e.g. the labels selected by the Prometheus resource
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Access lists the client addresses and CIDRs allowed to reach the ports. Denied addresses are checked
+// first, when an allow list is set every other client is denied. The health check is not restricted.
+type Access struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	// TrustedProxies are the CIDRs of load balancers and proxies whose client address header is trusted
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+	// RealIPHeader carries the client address set by trusted proxies, e.g. X-Forwarded-For, X-Real-IP, or
+	// proxy_protocol for the address of the PROXY protocol header
+	RealIPHeader string `json:"realIPHeader,omitempty" default:"X-Forwarded-For"`
+	// RealIPRecursive takes the last untrusted address of the header instead of the last address
+	RealIPRecursive bool `json:"realIPRecursive,omitempty"`
+	// ProxyProtocol expects the PROXY protocol on every connection, for LoadBalancer Services whose load
+	// balancer sends it. Clients that do not send it, including in-cluster ones, cannot connect.
+	ProxyProtocol bool `json:"proxyProtocol,omitempty"`
+}
+
+// CORS configures the cross-origin resource sharing headers of a port
+type CORS struct {
+	// AllowedOrigins are origins such as "https://app.example.com", "https://*.example.com" for any
+	// subdomain, or "*" for any origin
+	// +kubebuilder:validation:MinItems=1
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// AllowedMethods are the methods allowed in preflight responses, POST and OPTIONS when empty
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+	// AllowedHeaders are the request headers allowed in preflight responses, Content-Type when empty
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	// ExposedHeaders are response headers readable by the browser client
+	ExposedHeaders []string `json:"exposedHeaders,omitempty"`
+	// AllowCredentials allows requests with cookies or authorization, not with the "*" origin
+	AllowCredentials bool `json:"allowCredentials,omitempty"`
+	// MaxAge is the number of seconds browsers may cache preflight responses
+	// +kubebuilder:validation:Minimum=0
+	MaxAge *int32 `json:"maxAge,omitempty" default:"86400"`
+	// Preflight is "respond" to answer preflight requests in nginx, or "forward" to pass them to the collector
+	// +kubebuilder:validation:Enum=respond;forward
+	Preflight string `json:"preflight,omitempty" default:"respond"`
 }
 
 type Service struct {
 	Type string `json:"type"`
 }
 
+// Ingress exposes the proxy outside of the cluster either through a
+// networking.k8s.io/v1 Ingress or through Gateway API routes
+type Ingress struct {
+	Enabled bool `json:"enabled"`
+	// Kind selects the rendered resource: "Ingress" or "Gateway"
+	// +kubebuilder:validation:Enum=Ingress;Gateway
+	Kind string `json:"kind,omitempty" default:"Ingress"`
+	Host string `json:"host"`
+	// TLSSecretName enables TLS on the Ingress; Gateway listeners terminate TLS themselves
+	TLSSecretName string            `json:"tlsSecretName,omitempty"`
+	ClassName     string            `json:"className,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	// Gateway is the parent Gateway the routes attach to when Kind is "Gateway"
+	Gateway *GatewayRef `json:"gateway,omitempty"`
+}
+
+type GatewayRef struct {
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace,omitempty"`
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// NetworkPolicy restricts who can send spans to the proxy and where the proxy can connect to.
+// Egress is limited to the collector and DNS.
+type NetworkPolicy struct {
+	Enabled bool `json:"enabled"`
+	// From lists the peers allowed to reach ContainerPort; an empty list allows all sources
+	From []NetworkPolicyPeer `json:"from,omitempty"`
+	// CollectorCIDRs allows egress to these CIDRs instead of resolving Upstream.CollectorHost as a Service
+	CollectorCIDRs []string `json:"collectorCIDRs,omitempty"`
+}
+
+// NetworkPolicyPeer selects pods by namespace and/or pod labels, both must match when set
+type NetworkPolicyPeer struct {
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty" swaggertype:"object"`
+	PodSelector       *metav1.LabelSelector `json:"podSelector,omitempty" swaggertype:"object"`
+}
+
 type Resources struct {
 	Limits   Resource `json:"limits"`
 	Requests Resource `json:"requests"`
@@ -60,6 +292,19 @@ type Image struct {
 	Repository string `json:"repository" default:"nginx"`
 	Tag        string `json:"tag" default:"1.28.0"`
 	PullPolicy string `json:"pullPolicy" default:"IfNotPresent"`
+	// PullSecrets are names of docker-registry Secrets in the proxy namespace
+	PullSecrets []string `json:"pullSecrets,omitempty"`
+}
+
+// ServiceAccount configures the identity of the proxy pods
+type ServiceAccount struct {
+	// Create makes the controller create and own a ServiceAccount named after the proxy
+	Create bool `json:"create,omitempty"`
+	// Name of an existing ServiceAccount to use when Create is false
+	Name        string            `json:"name,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// AutomountServiceAccountToken defaults to false, nginx never talks to the API server
+	AutomountServiceAccountToken *bool `json:"automountServiceAccountToken,omitempty"`
 }
 
 // +kubebuilder:object:root=true
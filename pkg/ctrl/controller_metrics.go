@@ -0,0 +1,247 @@
+package ctrl
+
+import (
+	context "context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+// Causes of the child resource updates counted by jaeger_nginx_proxy_resource_updates_total
+const (
+	UpdateCauseCreated          = "created"
+	UpdateCauseNewRevision      = "new_revision"
+	UpdateCausePromotedRevision = "promoted_revision"
+	UpdateCauseLiveConfig       = "live_config"
+	UpdateCauseMetadata         = "metadata"
+	UpdateCauseReplicas         = "replicas"
+	UpdateCauseImage            = "image"
+	UpdateCauseContainers       = "containers"
+	UpdateCauseVolumes          = "volumes"
+	UpdateCausePodSpec          = "pod_spec"
+
+	// Reasons of config failures that are not validator findings
+	ConfigErrorReasonTemplate   = "template"
+	ConfigErrorReasonGeneration = "generation"
+
+	proxyCollectTimeout = 10 * time.Second
+)
+
+var (
+	configErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "jaeger_nginx_proxy_config_errors_total",
+		Help: "Failures to generate or validate the nginx config of a JaegerNginxProxy by reason, the rule of the first failed finding.",
+	}, []string{"reason"})
+
+	resourceUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "jaeger_nginx_proxy_resource_updates_total",
+		Help: "ConfigMap and Deployment writes of the JaegerNginxProxy controller by cause. An update with several causes counts once for each.",
+	}, []string{"resource", "cause"})
+
+	readyDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "jaeger_nginx_proxy_ready_duration_seconds",
+		Help:    "Time from a JaegerNginxProxy generation change until the proxy reports Ready.",
+		Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800},
+	})
+
+	proxyReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "jaeger_nginx_proxy_replicas",
+		Help: "Desired and available replicas of the Deployment of every JaegerNginxProxy.",
+	}, []string{"namespace", "name", "state"})
+
+	proxies = &proxyCollector{
+		desc: prometheus.NewDesc("jaeger_nginx_proxy_proxies",
+			"JaegerNginxProxies by namespace and readiness.",
+			[]string{"namespace", "ready"}, nil),
+	}
+)
+
+func init() {
+	metrics.Registry.MustRegister(configErrors, resourceUpdates, readyDuration, proxyReplicas, proxies)
+}
+
+// recordUpdate counts a successful write of a child resource once for each distinct cause
+func recordUpdate(resource string, causes ...string) {
+	seen := map[string]bool{}
+	for _, cause := range causes {
+		if !seen[cause] {
+			seen[cause] = true
+			resourceUpdates.WithLabelValues(resource, cause).Inc()
+		}
+	}
+}
+
+// recordConfigError counts a config that could not be generated, configErr is never nil
+func recordConfigError(findings []JaegerNginxProxyV1alpha0.ConfigFinding, configErr error) {
+	reason := ConfigErrorReasonGeneration
+	for _, finding := range findings {
+		if finding.Severity == SeverityError {
+			reason = finding.Rule
+			break
+		}
+	}
+	var cfgErr *ConfigError
+	if len(findings) == 0 && errors.As(configErr, &cfgErr) && strings.HasPrefix(cfgErr.Field, configTemplateField) {
+		reason = ConfigErrorReasonTemplate
+	}
+	configErrors.WithLabelValues(reason).Inc()
+}
+
+// recordReplicas exports the replica counts of the proxy Deployment, found is false when it does not exist
+func recordReplicas(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, desired, available int32, found bool) {
+	if !found {
+		forgetReplicas(types.NamespacedName{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace})
+		return
+	}
+	proxyReplicas.WithLabelValues(nginxProxy.Namespace, nginxProxy.Name, "desired").Set(float64(desired))
+	proxyReplicas.WithLabelValues(nginxProxy.Namespace, nginxProxy.Name, "available").Set(float64(available))
+}
+
+func forgetReplicas(key types.NamespacedName) {
+	proxyReplicas.DeleteLabelValues(key.Namespace, key.Name, "desired")
+	proxyReplicas.DeleteLabelValues(key.Namespace, key.Name, "available")
+}
+
+// proxyCollector counts the proxies of the informer cache on every scrape, so that the numbers never
+// drift from the cluster. It collects nothing until a reader is set.
+type proxyCollector struct {
+	desc *prometheus.Desc
+
+	mu     sync.RWMutex
+	reader client.Reader
+}
+
+func (c *proxyCollector) setReader(reader client.Reader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reader = reader
+}
+
+func (c *proxyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *proxyCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	reader := c.reader
+	c.mu.RUnlock()
+	if reader == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), proxyCollectTimeout)
+	defer cancel()
+	var list JaegerNginxProxyV1alpha0.JaegerNginxProxyList
+	if err := reader.List(ctx, &list); err != nil {
+		log.Error().Err(err).Msg("Failed to list JaegerNginxProxies for metrics")
+		return
+	}
+
+	// Both readiness values are reported for every namespace, absent series break alerts on ratios
+	counts := map[string][2]int{}
+	for _, proxy := range list.Items {
+		count := counts[proxy.Namespace]
+		if proxy.Status.Ready {
+			count[1]++
+		} else {
+			count[0]++
+		}
+		counts[proxy.Namespace] = count
+	}
+	namespaces := make([]string, 0, len(counts))
+	for namespace := range counts {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		for ready, count := range counts[namespace] {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), namespace, strconv.FormatBool(ready == 1))
+		}
+	}
+}
+
+type pendingRollout struct {
+	uid        types.UID
+	generation int64
+	since      time.Time
+}
+
+// rolloutTracker measures the time from a generation change until the proxy is Ready. Generations the
+// controller already processed before it started are not measured, their start time is unknown. The zero
+// value is ready to use.
+type rolloutTracker struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	pending map[types.NamespacedName]pendingRollout
+}
+
+// start remembers when the controller first saw the generation of the proxy. Reconciles of a generation
+// carry ConfigValid with that observed generation, see setConfigCondition, so it must be called before.
+func (t *rolloutTracker) start(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+	condition := apimeta.FindStatusCondition(nginxProxy.Status.Conditions, ConditionConfigValid)
+	if condition != nil && condition.ObservedGeneration == nginxProxy.Generation {
+		return
+	}
+	key := types.NamespacedName{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending == nil {
+		t.pending = map[types.NamespacedName]pendingRollout{}
+	}
+	if pending, ok := t.pending[key]; ok && pending.uid == nginxProxy.UID && pending.generation == nginxProxy.Generation {
+		return
+	}
+	since := time.Now()
+	if t.now != nil {
+		since = t.now()
+	}
+	// A new proxy changed when it was created, not when its first reconcile ran
+	if condition == nil && !nginxProxy.CreationTimestamp.IsZero() {
+		since = nginxProxy.CreationTimestamp.Time
+	}
+	t.pending[key] = pendingRollout{uid: nginxProxy.UID, generation: nginxProxy.Generation, since: since}
+}
+
+// finish observes the rollout duration once the generation it started with is Ready
+func (t *rolloutTracker) finish(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+	if !nginxProxy.Status.Ready {
+		return
+	}
+	key := types.NamespacedName{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending, ok := t.pending[key]
+	if !ok || pending.uid != nginxProxy.UID || pending.generation != nginxProxy.Generation {
+		return
+	}
+	now := time.Now()
+	if t.now != nil {
+		now = t.now()
+	}
+	readyDuration.Observe(now.Sub(pending.since).Seconds())
+	delete(t.pending, key)
+}
+
+// forget drops the rollout of a deleted proxy
+func (t *rolloutTracker) forget(key types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pending, key)
+}
@@ -0,0 +1,193 @@
+package ctrl
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func readyDurationCount(t *testing.T) uint64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, readyDuration.Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestRecordConfigError(t *testing.T) {
+	tests := []struct {
+		name     string
+		findings []JaegerNginxProxyV1alpha0.ConfigFinding
+		err      error
+		reason   string
+	}{
+		{
+			name: "first failed finding",
+			findings: []JaegerNginxProxyV1alpha0.ConfigFinding{
+				{Rule: "nginx-t", Severity: "warning"},
+				{Rule: "directive-not-allowed", Severity: SeverityError},
+				{Rule: "syntax", Severity: SeverityError},
+			},
+			err:    errors.New("invalid"),
+			reason: "directive-not-allowed",
+		},
+		{
+			name:   "missing template",
+			err:    &ConfigError{Field: configTemplateField + ".configMapName", Msg: "not found"},
+			reason: ConfigErrorReasonTemplate,
+		},
+		{
+			name:   "other",
+			err:    errors.New("marshal"),
+			reason: ConfigErrorReasonGeneration,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(configErrors.WithLabelValues(tt.reason))
+			recordConfigError(tt.findings, tt.err)
+			assert.Equal(t, before+1, testutil.ToFloat64(configErrors.WithLabelValues(tt.reason)))
+		})
+	}
+}
+
+func TestProxyCollector(t *testing.T) {
+	c := &proxyCollector{desc: proxies.desc}
+	assert.Equal(t, 0, testutil.CollectAndCount(c), "nothing is collected without a reader")
+
+	proxy := func(namespace, name string, ready bool) client.Object {
+		p := newTestProxy()
+		p.Namespace = namespace
+		p.Name = name
+		p.Status.Ready = ready
+		return p
+	}
+	c.setReader(fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(
+		proxy("tracing", "a", true),
+		proxy("tracing", "b", false),
+		proxy("tracing", "c", true),
+		proxy("default", "d", true),
+	).Build())
+
+	expected := `
+# HELP jaeger_nginx_proxy_proxies JaegerNginxProxies by namespace and readiness.
+# TYPE jaeger_nginx_proxy_proxies gauge
+jaeger_nginx_proxy_proxies{namespace="default",ready="false"} 0
+jaeger_nginx_proxy_proxies{namespace="default",ready="true"} 1
+jaeger_nginx_proxy_proxies{namespace="tracing",ready="false"} 1
+jaeger_nginx_proxy_proxies{namespace="tracing",ready="true"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected)))
+
+	// The collector is part of the controller-runtime registry
+	assert.NoError(t, prometheus.NewPedanticRegistry().Register(c))
+}
+
+func TestRolloutTracker(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	tracker := &rolloutTracker{now: func() time.Time { return now }}
+	nginxProxy := newTestProxy()
+	nginxProxy.UID = "uid-1"
+	nginxProxy.Generation = 1
+	nginxProxy.CreationTimestamp = metav1.NewTime(now.Add(-5 * time.Second))
+
+	// A new proxy is measured from its creation
+	before := readyDurationCount(t)
+	tracker.start(nginxProxy)
+	now = now.Add(10 * time.Second)
+	tracker.finish(nginxProxy)
+	assert.Equal(t, before, readyDurationCount(t), "not ready yet")
+	nginxProxy.Status.Ready = true
+	tracker.finish(nginxProxy)
+	assert.Equal(t, before+1, readyDurationCount(t))
+	tracker.finish(nginxProxy)
+	assert.Equal(t, before+1, readyDurationCount(t), "a generation is observed once")
+
+	// A generation that was already processed, e.g. before a restart of the controller, is not measured
+	meta.SetStatusCondition(&nginxProxy.Status.Conditions, metav1.Condition{Type: ConditionConfigValid, Status: metav1.ConditionTrue, Reason: ReasonConfigValid, ObservedGeneration: 1})
+	tracker.start(nginxProxy)
+	tracker.finish(nginxProxy)
+	assert.Equal(t, before+1, readyDurationCount(t))
+
+	// A spec change is measured from the first reconcile of its generation
+	nginxProxy.Generation = 2
+	nginxProxy.Status.Ready = false
+	tracker.start(nginxProxy)
+	assert.Equal(t, now, tracker.pending[client.ObjectKeyFromObject(nginxProxy)].since)
+	now = now.Add(time.Minute)
+	tracker.start(nginxProxy)
+	assert.Equal(t, now.Add(-time.Minute), tracker.pending[client.ObjectKeyFromObject(nginxProxy)].since, "later reconciles keep the start")
+	nginxProxy.Status.Ready = true
+	tracker.finish(nginxProxy)
+	assert.Equal(t, before+2, readyDurationCount(t))
+	assert.Empty(t, tracker.pending)
+}
+
+func TestReconcileControllerMetrics(t *testing.T) {
+	ctx := context.Background()
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{Name: "proxy"}
+	r, _ := newRevisionTestReconciler(t, nginxProxy)
+	key := client.ObjectKeyFromObject(nginxProxy)
+
+	created := testutil.ToFloat64(resourceUpdates.WithLabelValues("Deployment", UpdateCauseCreated))
+	newRevisions := testutil.ToFloat64(resourceUpdates.WithLabelValues("ConfigMap", UpdateCauseNewRevision))
+	reconcileProxy(t, r, key, nil)
+	assert.Equal(t, created+1, testutil.ToFloat64(resourceUpdates.WithLabelValues("Deployment", UpdateCauseCreated)))
+	assert.Equal(t, newRevisions+1, testutil.ToFloat64(resourceUpdates.WithLabelValues("ConfigMap", UpdateCauseNewRevision)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(proxyReplicas.WithLabelValues(key.Namespace, key.Name, "desired")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(proxyReplicas.WithLabelValues(key.Namespace, key.Name, "available")))
+
+	// A steady state writes nothing
+	total := func() float64 {
+		var sum float64
+		for _, cause := range []string{UpdateCauseMetadata, UpdateCauseReplicas, UpdateCauseImage, UpdateCauseContainers, UpdateCauseVolumes, UpdateCausePodSpec} {
+			sum += testutil.ToFloat64(resourceUpdates.WithLabelValues("Deployment", cause))
+		}
+		return sum
+	}
+	updates := total()
+	reconcileProxy(t, r, key, nil)
+	assert.Equal(t, updates, total())
+
+	replicas := testutil.ToFloat64(resourceUpdates.WithLabelValues("Deployment", UpdateCauseReplicas))
+	// The fake client does not bump the generation on spec changes like the API server
+	reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+		p.Spec.ReplicaCount = 3
+		p.Generation++
+	})
+	assert.Equal(t, replicas+1, testutil.ToFloat64(resourceUpdates.WithLabelValues("Deployment", UpdateCauseReplicas)))
+	assert.Equal(t, updates+1, total(), "scaling only changes the replicas")
+	assert.Equal(t, 3.0, testutil.ToFloat64(proxyReplicas.WithLabelValues(key.Namespace, key.Name, "desired")))
+
+	var dep appsv1.Deployment
+	require.NoError(t, r.Get(ctx, key, &dep))
+	dep.Status.AvailableReplicas = 3
+	require.NoError(t, r.Status().Update(ctx, &dep))
+	observed := readyDurationCount(t)
+	reconcileProxy(t, r, key, nil)
+	assert.Equal(t, 3.0, testutil.ToFloat64(proxyReplicas.WithLabelValues(key.Namespace, key.Name, "available")))
+	assert.Equal(t, observed+1, readyDurationCount(t), "the rollout of the scaled generation is measured")
+
+	// Deleted proxies are no longer reported
+	require.NoError(t, r.Delete(ctx, nginxProxy))
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	assert.False(t, proxyReplicas.DeleteLabelValues(key.Namespace, key.Name, "desired"))
+	assert.False(t, proxyReplicas.DeleteLabelValues(key.Namespace, key.Name, "available"))
+}
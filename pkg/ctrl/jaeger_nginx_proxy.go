@@ -1,57 +1,127 @@
 package ctrl
 
 import (
-	"bytes"
 	context "context"
+	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+	"github.com/dolv/k8s-controller-tutorial/pkg/tracing"
+)
+
+const (
+	// NginxContainerName, ConfigVolumeName and ConfigMountPath are managed by the controller and
+	// cannot be used by spec.extraContainers, spec.extraVolumes or spec.extraVolumeMounts
+	NginxContainerName = "nginx"
+	ConfigVolumeName   = "contents"
+	ConfigMountPath    = "/etc/nginx/conf.d"
 )
 
 type JaegerNginxProxyReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	// APIReader reads objects outside the watched namespaces, like collector Services, uncached. The
+	// Client is used when it is nil.
+	APIReader client.Reader
+	Scheme    *runtime.Scheme
+	// ReloaderImage is the image of the hot reload and metrics exporter sidecars, normally the controller
+	// image itself
+	ReloaderImage string
+	// Recorder records events on the proxy, failure events are deduplicated by events
+	Recorder record.EventRecorder
+	// Validators check every generated config, DefaultConfigValidators when empty
+	Validators ConfigValidators
+
+	events   eventDeduplicator
+	rollouts rolloutTracker
+}
+
+// apiReader returns the APIReader, or the Client when it is not set
+func (r *JaegerNginxProxyReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// ControllerOptions configures the JaegerNginxProxy controller
+type ControllerOptions struct {
+	ReloaderImage string
+	Validators    ConfigValidators
+	// EventDedupWindow is how long an identical failure event is suppressed, DefaultEventDedupWindow when 0
+	EventDedupWindow time.Duration
 }
 
 func GenerateNginxConfig(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) string {
-	var config strings.Builder
+	config, _ := renderNginxConfig(nginxProxy)
+	return config
+}
+
+// renderNginxConfig generates the nginx config together with the spec field each line was generated from
+func renderNginxConfig(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (string, []string) {
+	config := &configBuilder{}
 
 	// Log format
-	config.WriteString("log_format custom_format '$remote_addr - $remote_user [$time_local] '\n")
-	config.WriteString("                             '\"$request\" \"args=$args\" \"q=$query_string\" '\n")
-	config.WriteString("                             '\"url=$uri\" \"status=$status\" '\n")
-	config.WriteString("                             '\"bytes=$body_bytes_sent\" \"ref=$http_referer\" '\n")
-	config.WriteString("                             '\"agent=$http_user_agent\" \"$http_x_forwarded_for\" ';\n\n")
+	config.writeLogFormat(nginxProxy)
+	config.writeLogSampling(nginxProxy)
+	config.writeMetricsLogFormat(nginxProxy)
 
 	// Upstream blocks
-	for _, port := range nginxProxy.Spec.Ports {
-		config.WriteString(fmt.Sprintf("upstream jaeger-collector-%s {\n", port.Name))
-		config.WriteString(fmt.Sprintf("  server %s:%d;\n", nginxProxy.Spec.Upstream.CollectorHost, port.Port))
-		config.WriteString("}\n\n")
+	for i, port := range nginxProxy.Spec.Ports {
+		portPath := fmt.Sprintf("spec.ports[%d]", i)
+		config.Writef(portPath+".name", "upstream jaeger-collector-%s {\n", port.Name)
+		config.Writef("spec.upstream.collectorHost", "  server %s:%d;\n", nginxProxy.Spec.Upstream.CollectorHost, port.Port)
+		config.Writef(portPath+".name", "}\n\n")
 	}
+	config.writeCORSMaps(nginxProxy)
 
 	// Server block
 	config.WriteString("server {\n")
-	config.WriteString(fmt.Sprintf("  listen %d default_server;\n\n", nginxProxy.Spec.ContainerPort))
+	// gRPC needs HTTP/2, which older nginx versions only enable through the listen parameter
+	version := proxyNginxVersion(nginxProxy)
+	listen := fmt.Sprintf("  listen %d default_server", nginxProxy.Spec.ContainerPort)
+	if proxyProtocol(nginxProxy) {
+		listen += " proxy_protocol"
+	}
+	switch {
+	case !hasGRPCPorts(nginxProxy):
+		config.Writef("spec.containerPort", "%s;\n\n", listen)
+	case version.AtLeast(nginxHTTP2DirectiveVersion):
+		config.Writef("spec.containerPort", "%s;\n", listen)
+		config.Writef("spec.image.tag", "  http2 on;\n\n")
+	default:
+		config.Writef("spec.containerPort", "%s http2;\n\n", listen)
+	}
+
+	config.writeRealIP(nginxProxy, "  ")
 
-	config.WriteString("  access_log /dev/stdout custom_format;\n")
+	config.writeAccessLog(nginxProxy, "  ")
+	config.writeMetricsAccessLog(nginxProxy, "  ")
 	config.WriteString("  error_log  /dev/stderr;\n\n")
 
 	config.WriteString("  proxy_connect_timeout 600;\n")
@@ -65,16 +135,36 @@ func GenerateNginxConfig(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy)
 	config.WriteString("        return 200;\n")
 	config.WriteString("  }\n\n")
 
+	snippets := nginxProxy.Spec.Snippets
+	if snippets == nil {
+		snippets = &JaegerNginxProxyV1alpha0.Snippets{}
+	}
+	if strings.TrimSpace(snippets.Server) != "" {
+		config.writeSnippet(serverSnippetField, snippets.Server, "  ")
+		config.WriteString("\n")
+	}
+
 	// Location blocks
-	for _, port := range nginxProxy.Spec.Ports {
-		config.WriteString(fmt.Sprintf("  location %s {\n", port.Path))
-		config.WriteString(fmt.Sprintf("     proxy_pass http://jaeger-collector-%s;\n", port.Name))
-		config.WriteString("  }\n\n")
+	for i, port := range nginxProxy.Spec.Ports {
+		portPath := fmt.Sprintf("spec.ports[%d]", i)
+		config.Writef(portPath+".path", "  location %s {\n", port.Path)
+		if PortProtocol(port) == PortProtocolGRPC {
+			config.Writef(portPath+".protocol", "     grpc_pass grpc://jaeger-collector-%s;\n", port.Name)
+		} else {
+			config.Writef(portPath+".name", "     proxy_pass http://jaeger-collector-%s;\n", port.Name)
+		}
+		config.writePortAccess(i, port, nginxProxy.Spec.Access, "     ")
+		config.writePortAccessLog(i, port, "     ")
+		config.writePortMetrics(nginxProxy, i, port, "     ")
+		config.writePortHeaders(i, port, "     ")
+		config.writeSnippet(locationSnippetField(port.Name), snippets.Location[port.Name], "     ")
+		config.Writef(portPath+".path", "  }\n\n")
 	}
 
 	config.WriteString("}\n")
+	config.writeStatusServer(nginxProxy)
 
-	return config.String()
+	return config.String(), config.sources
 }
 
 // ValidateNginxConfig performs basic validation of the nginx configuration
@@ -82,8 +172,10 @@ func ValidateNginxConfig(config string) error {
 	// Basic syntax validation - check for common issues
 	lines := strings.Split(config, "\n")
 
-	// Check for balanced braces
+	// Check for balanced braces. The likely culprit of an imbalance is the first line opening more than
+	// one block, or the first line closing a block next to other content.
 	braceCount := 0
+	openSuspect, closeSuspect := 0, 0
 	for i, line := range lines {
 		line = strings.TrimSpace(line)
 
@@ -93,31 +185,36 @@ func ValidateNginxConfig(config string) error {
 		}
 
 		// Count braces
-		braceCount += strings.Count(line, "{")
-		braceCount -= strings.Count(line, "}")
-
-		// Check for unmatched braces
-		if braceCount < 0 {
-			return fmt.Errorf("unmatched closing brace on line %d: %s", i+1, line)
+		opened := strings.Count(line, "{") - strings.Count(line, "}")
+		braceCount += opened
+		if opened > 1 && openSuspect == 0 {
+			openSuspect = i + 1
 		}
-
-		// Check for common syntax errors
-		if strings.Contains(line, "server") && !strings.Contains(line, "{") && !strings.Contains(line, ";") {
-			return fmt.Errorf("invalid server directive on line %d: %s", i+1, line)
+		if opened < 0 && line != "}" && closeSuspect == 0 {
+			closeSuspect = i + 1
 		}
 
-		if strings.Contains(line, "location") && !strings.Contains(line, "{") && !strings.Contains(line, ";") {
-			return fmt.Errorf("invalid location directive on line %d: %s", i+1, line)
+		// Check for unmatched braces
+		if braceCount < 0 {
+			if closeSuspect == 0 {
+				closeSuspect = i + 1
+			}
+			return &ConfigError{Line: closeSuspect, Msg: fmt.Sprintf("unmatched closing brace on line %d: %s", i+1, line)}
 		}
 
-		if strings.Contains(line, "upstream") && !strings.Contains(line, "{") && !strings.Contains(line, ";") {
-			return fmt.Errorf("invalid upstream directive on line %d: %s", i+1, line)
+		// Check for common syntax errors. Only the directive name counts, log formats mention
+		// variables such as $upstream_addr on continuation lines.
+		if directive := strings.Fields(line)[0]; !strings.Contains(line, "{") && !strings.Contains(line, ";") {
+			switch directive {
+			case "server", "location", "upstream":
+				return &ConfigError{Line: i + 1, Msg: fmt.Sprintf("invalid %s directive on line %d: %s", directive, i+1, line)}
+			}
 		}
 	}
 
 	// Check for balanced braces at the end
 	if braceCount != 0 {
-		return fmt.Errorf("unmatched opening braces: %d unclosed", braceCount)
+		return &ConfigError{Line: openSuspect, Msg: fmt.Sprintf("unmatched opening braces: %d unclosed", braceCount)}
 	}
 
 	// Check for required directives
@@ -129,113 +226,63 @@ func ValidateNginxConfig(config string) error {
 		return fmt.Errorf("missing listen directive")
 	}
 
-	// Try to validate with nginx -t if available (optional)
-	if err := validateWithNginx(config); err != nil {
-		log.Warn().Err(err).Msg("nginx validation failed, but continuing with basic validation")
-		// Don't fail the reconciliation for nginx validation errors
-		// as nginx might not be available in the controller environment
-	}
-
 	return nil
 }
 
-// validateWithNginx attempts to validate the config using nginx -t
-func validateWithNginx(config string) error {
-	// Check if nginx is available
-	if _, err := exec.LookPath("nginx"); err != nil {
-		log.Warn().Msg("nginx binary not found in PATH: skipping nginx -t config validation. Please install nginx to enable full validation.")
-		return nil // Don't treat as fatal error
-	}
-
-	// Create a temporary file with the config
-	tmpFile, err := os.CreateTemp("", "nginx-config-*.conf")
+// buildConfigMap builds the immutable revision ConfigMap holding the rendered config and a snapshot of
+// the spec it was rendered from, so that the proxy can be rolled back to it later. The findings of the
+// validators are returned even when the config is rejected.
+func buildConfigMap(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, tmpl string, validators ConfigValidators) (*corev1.ConfigMap, []JaegerNginxProxyV1alpha0.ConfigFinding, error) {
+	// Validate the nginx configuration before creating the ConfigMap
+	config, findings, err := validators.validateProxyConfig(ctx, nginxProxy, tmpl)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
-	}
-	defer os.Remove(tmpFile.Name())
-
-	// Create a complete nginx config that wraps the generated config in proper blocks
-	// This avoids permission issues with system log directories and network connectivity issues
-	wrapperConfig := fmt.Sprintf(`
-# Suppress default error log behavior
-error_log /dev/null;
-
-http {
-    # Use stderr for error logs to avoid permission issues
-    error_log /dev/stderr;
-    
-    # Include the generated config
-    %s
-}
-
-events {
-    worker_connections 1024;
-}
-`, config)
-
-	// Write wrapper config to temp file
-	if _, err := tmpFile.WriteString(wrapperConfig); err != nil {
-		return fmt.Errorf("failed to write config to temp file: %w", err)
-	}
-	tmpFile.Close()
-
-	// Run nginx -t to validate with suppressed error output
-	cmd := exec.Command("nginx", "-t", "-c", tmpFile.Name(), "-e", "/dev/null")
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		// Check if the error is just about host not found (which is expected in validation)
-		if strings.Contains(stderr.String(), "host not found in upstream") {
-			log.Debug().Msg("nginx validation passed (upstream host not found is expected during validation)")
-			return nil
-		}
-		return fmt.Errorf("nginx validation failed: %s", stderr.String())
+		return nil, findings, fmt.Errorf("nginx configuration validation failed: %w", err)
 	}
 
-	return nil
-}
-
-func buildConfigMap(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (*corev1.ConfigMap, error) {
-	config := GenerateNginxConfig(nginxProxy)
-
-	// Validate the nginx configuration before creating the ConfigMap
-	if err := ValidateNginxConfig(config); err != nil {
-		return nil, fmt.Errorf("nginx configuration validation failed: %w", err)
+	// Scaling is not part of a revision
+	spec := nginxProxy.Spec.DeepCopy()
+	spec.ReplicaCount = 0
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return nil, findings, err
 	}
 
+	immutable := true
+	objectMeta := childObjectMeta(nginxProxy, nil)
+	objectMeta.Name = revisionName(nginxProxy, ConfigHash(config+"\n"+string(specJSON)))
 	return &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      nginxProxy.Name,
-			Namespace: nginxProxy.Namespace,
-		},
+		ObjectMeta: objectMeta,
+		Immutable:  &immutable,
 		Data: map[string]string{
-			"proxy.conf": config,
+			ConfigFileName: config,
+			SpecFileName:   string(specJSON),
 		},
-	}, nil
+	}, findings, nil
 }
 
 func buildDeployment(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *appsv1.Deployment {
 	replicas := int32(nginxProxy.Spec.ReplicaCount)
 	image := nginxProxy.Spec.Image.Repository + ":" + nginxProxy.Spec.Image.Tag
-	return &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      nginxProxy.Name,
-			Namespace: nginxProxy.Namespace,
-		},
+	return withPodTemplateTracking(&appsv1.Deployment{
+		ObjectMeta: childObjectMeta(nginxProxy, nil),
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{"app": nginxProxy.Name},
+				MatchLabels: selectorLabels(nginxProxy),
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{"app": nginxProxy.Name},
+					Labels:      podLabels(nginxProxy),
+					Annotations: podAnnotations(nginxProxy),
 				},
 				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{{
-						Name:  "nginx",
-						Image: image,
+					ServiceAccountName:           serviceAccountName(nginxProxy),
+					AutomountServiceAccountToken: automountServiceAccountToken(nginxProxy),
+					ImagePullSecrets:             imagePullSecrets(nginxProxy),
+					Containers: append([]corev1.Container{{
+						Name:            NginxContainerName,
+						Image:           image,
+						ImagePullPolicy: corev1.PullPolicy(nginxProxy.Spec.Image.PullPolicy),
 						Resources: corev1.ResourceRequirements{
 							Limits: corev1.ResourceList{
 								corev1.ResourceCPU:    resource.MustParse(nginxProxy.Spec.Resources.Limits.CPU),
@@ -246,13 +293,14 @@ func buildDeployment(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *app
 								corev1.ResourceMemory: resource.MustParse(nginxProxy.Spec.Resources.Requests.Memory),
 							},
 						},
-						VolumeMounts: []corev1.VolumeMount{{
-							Name:      "contents",
-							MountPath: "/etc/nginx/conf.d",
-						}},
-					}},
-					Volumes: []corev1.Volume{{
-						Name: "contents",
+						Env: nginxProxy.Spec.Env,
+						VolumeMounts: append([]corev1.VolumeMount{{
+							Name:      ConfigVolumeName,
+							MountPath: ConfigMountPath,
+						}}, nginxProxy.Spec.ExtraVolumeMounts...),
+					}}, nginxProxy.Spec.ExtraContainers...),
+					Volumes: append([]corev1.Volume{{
+						Name: ConfigVolumeName,
 						VolumeSource: corev1.VolumeSource{
 							ConfigMap: &corev1.ConfigMapVolumeSource{
 								LocalObjectReference: corev1.LocalObjectReference{
@@ -260,20 +308,100 @@ func buildDeployment(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *app
 								},
 							},
 						},
-					}},
+					}}, nginxProxy.Spec.ExtraVolumes...),
 				},
 			},
 		},
+	})
+}
+
+func buildService(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *corev1.Service {
+	serviceType := corev1.ServiceTypeClusterIP
+	if nginxProxy.Spec.Service.Type != "" {
+		serviceType = corev1.ServiceType(nginxProxy.Spec.Service.Type)
+	}
+	return &corev1.Service{
+		ObjectMeta: childObjectMeta(nginxProxy, nil),
+		Spec: corev1.ServiceSpec{
+			Type:     serviceType,
+			Selector: selectorLabels(nginxProxy),
+			Ports: []corev1.ServicePort{{
+				Name:       "http",
+				Port:       int32(nginxProxy.Spec.ContainerPort),
+				TargetPort: intstr.FromInt(nginxProxy.Spec.ContainerPort),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+		},
 	}
 }
 
+// reconcileService makes sure the Service fronting the proxy pods exists and matches the spec
+func (r *JaegerNginxProxyReconciler) reconcileService(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (bool, error) {
+	svc := buildService(nginxProxy)
+	if err := ctrl.SetControllerReference(nginxProxy, svc, r.Scheme); err != nil {
+		return false, err
+	}
+
+	var existingSvc corev1.Service
+	if err := r.Get(ctx, client.ObjectKeyFromObject(svc), &existingSvc); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		log.Info().Msgf("Creating Service for JaegerNginxProxy: %s %s", svc.Name, svc.Namespace)
+		return false, r.createChild(ctx, nginxProxy, svc)
+	}
+
+	metadataChanged := convergeMetadata(&existingSvc, svc)
+	if !metadataChanged &&
+		existingSvc.Spec.Type == svc.Spec.Type &&
+		reflect.DeepEqual(existingSvc.Spec.Selector, svc.Spec.Selector) &&
+		len(existingSvc.Spec.Ports) == 1 &&
+		existingSvc.Spec.Ports[0].Port == svc.Spec.Ports[0].Port &&
+		existingSvc.Spec.Ports[0].TargetPort == svc.Spec.Ports[0].TargetPort {
+		log.Debug().Msgf("Service is up to date: %s %s", svc.Name, svc.Namespace)
+		return false, nil
+	}
+
+	log.Info().Msgf("Service changed, updating: %s %s", svc.Name, svc.Namespace)
+	// Keep already allocated node ports so that external load balancers keep working
+	for i := range svc.Spec.Ports {
+		for _, existingPort := range existingSvc.Spec.Ports {
+			if existingPort.Name == svc.Spec.Ports[i].Name && svc.Spec.Type != corev1.ServiceTypeClusterIP {
+				svc.Spec.Ports[i].NodePort = existingPort.NodePort
+			}
+		}
+	}
+	existingSvc.Spec.Type = svc.Spec.Type
+	existingSvc.Spec.Selector = svc.Spec.Selector
+	existingSvc.Spec.Ports = svc.Spec.Ports
+	return r.updateChild(ctx, nginxProxy, &existingSvc)
+}
+
+// listDerivative reports whether existing matches desired apart from fields defaulted by the API server.
+// Unlike equality.Semantic.DeepDerivative an empty desired list only matches an empty existing list.
+func listDerivative[T any](desired, existing []T) bool {
+	return len(desired) == len(existing) && equality.Semantic.DeepDerivative(desired, existing)
+}
+
 func (r *JaegerNginxProxyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := tracing.Start(ctx, "Reconcile JaegerNginxProxy", trace.WithAttributes(
+		attribute.String("k8s.namespace.name", req.Namespace),
+		attribute.String("k8s.jaegernginxproxy.name", req.Name),
+	))
+	result, err := r.reconcile(ctx, req)
+	span.SetAttributes(attribute.Bool("requeue", result.Requeue || result.RequeueAfter > 0))
+	tracing.End(span, err)
+	return result, err
+}
+
+func (r *JaegerNginxProxyReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.With().Ctx(ctx).Logger()
 	var page JaegerNginxProxyV1alpha0.JaegerNginxProxy
 	err := r.Get(ctx, req.NamespacedName, &page)
 	if err != nil {
 		if client.IgnoreNotFound(err) == nil {
 			// JaegerNginxProxy deleted: clean up resources
-			log.Info().Msgf("JaegerNginxProxy deleted: %s %s", req.Name, req.Namespace)
+			logger.Info().Msgf("JaegerNginxProxy deleted: %s %s", req.Name, req.Namespace)
 			var cm corev1.ConfigMap
 			cm.Name = req.Name
 			cm.Namespace = req.Namespace
@@ -282,64 +410,103 @@ func (r *JaegerNginxProxyReconciler) Reconcile(ctx context.Context, req ctrl.Req
 			dep.Name = req.Name
 			dep.Namespace = req.Namespace
 			_ = r.Delete(ctx, &dep)
+			// The other children carry controller references and are garbage collected
+			r.rollouts.forget(req.NamespacedName)
+			forgetReplicas(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
+	r.rollouts.start(&page)
 
-	// 1. Ensure ConfigMap exists and is up to date
-	cm, err := buildConfigMap(&page)
-	if err != nil {
-		log.Error().Err(err).Msgf("Failed to build ConfigMap for JaegerNginxProxy: %s %s", page.Name, page.Namespace)
+	if rolledBack, err := r.rollback(ctx, &page); rolledBack || err != nil {
+		if errors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
 		return ctrl.Result{}, err
 	}
-	if err := ctrl.SetControllerReference(&page, cm, r.Scheme); err != nil {
+
+	// 1. Ensure the rendered config is stored as the current revision. An invalid config never replaces
+	// the last valid one.
+	revisions, err := r.listRevisions(ctx, &page)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	log.Info().Msgf("Reconciling ConfigMap for JaegerNginxProxy: %s %s", cm.Name, cm.Namespace)
-	var existingCM corev1.ConfigMap
-
-	if err := r.Get(ctx, req.NamespacedName, &existingCM); err != nil {
-		if !errors.IsNotFound(err) {
-			return ctrl.Result{}, err
-		}
-
-		log.Info().Msgf("Creating ConfigMap for JaegerNginxProxy: %s %s", cm.Name, cm.Namespace)
-		if err := r.Create(ctx, cm); err != nil {
-			log.Error().Err(err).Msgf("Failed to create ConfigMap: %s %s", cm.Name, cm.Namespace)
-			return ctrl.Result{}, err
+	// The template is not part of the spec, a revision snapshots the config rendered from it
+	tmpl, configErr := FetchConfigTemplate(ctx, r.Client, &page)
+	if configErr != nil && !isConfigError(configErr) {
+		return ctrl.Result{}, configErr
+	}
+	var cm *corev1.ConfigMap
+	var findings []JaegerNginxProxyV1alpha0.ConfigFinding
+	if configErr == nil {
+		cm, findings, configErr = buildConfigMap(ctx, &page, tmpl, r.Validators)
+	}
+	page.Status.ConfigFindings = findings
+	r.setConfigCondition(&page, configErr)
+	if configErr != nil {
+		recordConfigError(findings, configErr)
+		logger.Error().Err(configErr).Msgf("Failed to build ConfigMap for JaegerNginxProxy, keeping the last valid config: %s %s", page.Name, page.Namespace)
+		if len(revisions) == 0 {
+			// Nothing valid to serve yet, pods would only fail to start
+			page.Status.Ready = false
+			page.Status.Message = "No valid nginx config has been generated yet"
+			return r.updateStatus(ctx, &page)
 		}
-		log.Info().Msgf("Successfully created ConfigMap: %s %s", cm.Name, cm.Namespace)
 	} else {
-		// Check if ConfigMap data needs to be updated
-		if !reflect.DeepEqual(existingCM.Data, cm.Data) {
-			log.Info().Msgf("ConfigMap data changed, updating: %s %s", cm.Name, cm.Namespace)
-			log.Debug().Interface("old_data", existingCM.Data).Interface("new_data", cm.Data).Msg("ConfigMap data comparison")
-
-			existingCM.Data = cm.Data
-			if err := r.Update(ctx, &existingCM); err != nil {
-				if errors.IsConflict(err) {
-					log.Info().Msgf("ConfigMap update conflict, requeuing: %s %s", cm.Name, cm.Namespace)
-					// Requeue to try again with the latest version
-					return ctrl.Result{Requeue: true}, nil
-				}
-				log.Error().Err(err).Msgf("Failed to update ConfigMap: %s %s", cm.Name, cm.Namespace)
-				return ctrl.Result{}, err
+		logger.Info().Msgf("Reconciling ConfigMap for JaegerNginxProxy: %s %s", cm.Name, cm.Namespace)
+		revisions, err = r.reconcileRevision(ctx, &page, cm, revisions)
+		if err != nil {
+			if errors.IsConflict(err) {
+				logger.Info().Msgf("ConfigMap update conflict, requeuing: %s %s", cm.Name, cm.Namespace)
+				return ctrl.Result{Requeue: true}, nil
 			}
-			log.Info().Msgf("Successfully updated ConfigMap: %s %s", cm.Name, cm.Namespace)
-		} else {
-			log.Debug().Msgf("ConfigMap is up to date: %s %s", cm.Name, cm.Namespace)
+			logger.Error().Err(err).Msgf("Failed to reconcile ConfigMap: %s %s", cm.Name, cm.Namespace)
+			return ctrl.Result{}, err
 		}
 	}
+	current := revisions[len(revisions)-1].DeepCopy()
+	servedConfig := current.Data[ConfigFileName]
+
+	if requeue, err := r.reconcileLiveConfigMap(ctx, &page, current); err != nil {
+		logger.Error().Err(err).Msgf("Failed to reconcile live ConfigMap for JaegerNginxProxy: %s %s", page.Name, page.Namespace)
+		return ctrl.Result{}, err
+	} else if requeue {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// 2. Ensure ServiceAccount exists before the pods referencing it
+	if requeue, err := r.reconcileServiceAccount(ctx, &page); err != nil {
+		logger.Error().Err(err).Msgf("Failed to reconcile ServiceAccount for JaegerNginxProxy: %s %s", page.Name, page.Namespace)
+		return ctrl.Result{}, err
+	} else if requeue {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	if requeue, err := r.reconcileReloaderRBAC(ctx, &page); err != nil {
+		logger.Error().Err(err).Msgf("Failed to reconcile reloader RBAC for JaegerNginxProxy: %s %s", page.Name, page.Namespace)
+		return ctrl.Result{}, err
+	} else if requeue {
+		return ctrl.Result{Requeue: true}, nil
+	}
 
-	// 2. Ensure Deployment exists and is up to date
+	// 3. Ensure Deployment exists and is up to date
 	dep := buildDeployment(&page)
+	if hotReloadEnabled(&page) {
+		dep = withReloader(dep, r.ReloaderImage, reloaderName(&page))
+	} else {
+		// Every config change rolls out new pods mounting the new revision
+		dep = withConfigMap(dep, current.Name)
+	}
+	if metricsEnabled(&page) {
+		dep = withExporter(dep, r.ReloaderImage, page.Spec.Metrics)
+	}
 	if err := ctrl.SetControllerReference(&page, dep, r.Scheme); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	log.Info().Msgf("Reconciling Deployment for JaegerNginxProxy: %s %s", dep.Name, dep.Namespace)
+	logger.Info().Msgf("Reconciling Deployment for JaegerNginxProxy: %s %s", dep.Name, dep.Namespace)
 	var existingDep appsv1.Deployment
 
 	if err := r.Get(ctx, req.NamespacedName, &existingDep); err != nil {
@@ -347,43 +514,163 @@ func (r *JaegerNginxProxyReconciler) Reconcile(ctx context.Context, req ctrl.Req
 			return ctrl.Result{}, err
 		}
 
-		if err := r.Create(ctx, dep); err != nil {
+		if err := r.createChild(ctx, &page, dep); err != nil {
 			return ctrl.Result{}, err
 		}
+		recordUpdate("Deployment", UpdateCauseCreated)
 	} else {
-		updated := false
+		// Pod template metadata first, it reads the previously managed keys from the Deployment annotations
+		var causes []string
+		if podTemplateChanged := convergePodTemplateMetadata(&existingDep, dep); convergeMetadata(&existingDep, dep) || podTemplateChanged {
+			causes = append(causes, UpdateCauseMetadata)
+		}
 
 		if *existingDep.Spec.Replicas != *dep.Spec.Replicas {
 			existingDep.Spec.Replicas = dep.Spec.Replicas
-			updated = true
+			causes = append(causes, UpdateCauseReplicas)
 		}
 
 		if existingDep.Spec.Template.Spec.Containers[0].Image != dep.Spec.Template.Spec.Containers[0].Image {
 			existingDep.Spec.Template.Spec.Containers[0].Image = dep.Spec.Template.Spec.Containers[0].Image
-			updated = true
+			causes = append(causes, UpdateCauseImage)
+		}
+
+		if dep.Spec.Template.Spec.Containers[0].ImagePullPolicy != "" &&
+			existingDep.Spec.Template.Spec.Containers[0].ImagePullPolicy != dep.Spec.Template.Spec.Containers[0].ImagePullPolicy {
+			existingDep.Spec.Template.Spec.Containers[0].ImagePullPolicy = dep.Spec.Template.Spec.Containers[0].ImagePullPolicy
+			causes = append(causes, UpdateCauseImage)
+		}
+
+		if !reflect.DeepEqual(existingDep.Spec.Template.Spec.ImagePullSecrets, dep.Spec.Template.Spec.ImagePullSecrets) {
+			existingDep.Spec.Template.Spec.ImagePullSecrets = dep.Spec.Template.Spec.ImagePullSecrets
+			causes = append(causes, UpdateCauseImage)
+		}
+
+		// An empty name is defaulted to "default" by the API server
+		desiredSA := dep.Spec.Template.Spec.ServiceAccountName
+		if desiredSA == "" {
+			desiredSA = "default"
+		}
+		if existingDep.Spec.Template.Spec.ServiceAccountName != desiredSA {
+			existingDep.Spec.Template.Spec.ServiceAccountName = dep.Spec.Template.Spec.ServiceAccountName
+			existingDep.Spec.Template.Spec.DeprecatedServiceAccount = ""
+			causes = append(causes, UpdateCausePodSpec)
+		}
+
+		if !listDerivative(dep.Spec.Template.Spec.Containers[0].Env, existingDep.Spec.Template.Spec.Containers[0].Env) {
+			existingDep.Spec.Template.Spec.Containers[0].Env = dep.Spec.Template.Spec.Containers[0].Env
+			causes = append(causes, UpdateCauseContainers)
+		}
+
+		if !listDerivative(dep.Spec.Template.Spec.Containers[0].VolumeMounts, existingDep.Spec.Template.Spec.Containers[0].VolumeMounts) {
+			existingDep.Spec.Template.Spec.Containers[0].VolumeMounts = dep.Spec.Template.Spec.Containers[0].VolumeMounts
+			causes = append(causes, UpdateCauseContainers)
+		}
+
+		// Extra containers follow the nginx container
+		if !listDerivative(dep.Spec.Template.Spec.Containers[1:], existingDep.Spec.Template.Spec.Containers[1:]) {
+			existingDep.Spec.Template.Spec.Containers = append(existingDep.Spec.Template.Spec.Containers[:1], dep.Spec.Template.Spec.Containers[1:]...)
+			causes = append(causes, UpdateCauseContainers)
+		}
+
+		if !listDerivative(dep.Spec.Template.Spec.Volumes, existingDep.Spec.Template.Spec.Volumes) {
+			existingDep.Spec.Template.Spec.Volumes = dep.Spec.Template.Spec.Volumes
+			causes = append(causes, UpdateCauseVolumes)
 		}
 
-		if updated {
-			if err := r.Update(ctx, &existingDep); err != nil {
-				if errors.IsConflict(err) {
-					// Requeue to try again with the latest version
-					return ctrl.Result{Requeue: true}, nil
-				}
+		if !reflect.DeepEqual(existingDep.Spec.Template.Spec.ShareProcessNamespace, dep.Spec.Template.Spec.ShareProcessNamespace) {
+			existingDep.Spec.Template.Spec.ShareProcessNamespace = dep.Spec.Template.Spec.ShareProcessNamespace
+			causes = append(causes, UpdateCausePodSpec)
+		}
+
+		if !reflect.DeepEqual(existingDep.Spec.Template.Spec.AutomountServiceAccountToken, dep.Spec.Template.Spec.AutomountServiceAccountToken) {
+			existingDep.Spec.Template.Spec.AutomountServiceAccountToken = dep.Spec.Template.Spec.AutomountServiceAccountToken
+			causes = append(causes, UpdateCausePodSpec)
+		}
+
+		if len(causes) > 0 {
+			if requeue, err := r.updateChild(ctx, &page, &existingDep); err != nil {
 				return ctrl.Result{}, err
+			} else if requeue {
+				// Requeue to try again with the latest version
+				return ctrl.Result{Requeue: true}, nil
 			}
+			recordUpdate("Deployment", causes...)
 		}
 	}
 
+	// Old revisions and the live ConfigMap are only pruned once the Deployment no longer references them
+	if revisions, err = r.pruneRevisions(ctx, &page, revisions, current.Name); err != nil {
+		logger.Error().Err(err).Msgf("Failed to prune ConfigMap revisions for JaegerNginxProxy: %s %s", page.Name, page.Namespace)
+		return ctrl.Result{}, err
+	}
+	if err := r.pruneLiveConfigMap(ctx, &page); err != nil {
+		logger.Error().Err(err).Msgf("Failed to prune live ConfigMap for JaegerNginxProxy: %s %s", page.Name, page.Namespace)
+		return ctrl.Result{}, err
+	}
+	page.Status.CurrentRevision = revisionNumber(current)
+	page.Status.Revisions = revisionStatus(revisions)
+
+	// 4. Ensure Service exists and is up to date
+	if requeue, err := r.reconcileService(ctx, &page); err != nil {
+		logger.Error().Err(err).Msgf("Failed to reconcile Service for JaegerNginxProxy: %s %s", page.Name, page.Namespace)
+		return ctrl.Result{}, err
+	} else if requeue {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// 5. Ensure Ingress or Gateway API routes match spec.ingress
+	if requeue, err := r.reconcileIngress(ctx, &page); err != nil {
+		logger.Error().Err(err).Msgf("Failed to reconcile ingress for JaegerNginxProxy: %s %s", page.Name, page.Namespace)
+		return ctrl.Result{}, err
+	} else if requeue {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	page.Status.URL = ingressURL(&page)
+
+	// 6. Ensure NetworkPolicy matches spec.networkPolicy
+	if requeue, err := r.reconcileNetworkPolicy(ctx, &page); err != nil {
+		logger.Error().Err(err).Msgf("Failed to reconcile NetworkPolicy for JaegerNginxProxy: %s %s", page.Name, page.Namespace)
+		return ctrl.Result{}, err
+	} else if requeue {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// 7. Ensure the metrics Service and ServiceMonitor match spec.metrics
+	if requeue, err := r.reconcileMetrics(ctx, &page); err != nil {
+		logger.Error().Err(err).Msgf("Failed to reconcile metrics for JaegerNginxProxy: %s %s", page.Name, page.Namespace)
+		return ctrl.Result{}, err
+	} else if requeue {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// 8. Report which config the pods have loaded
+	page.Status.ConfigHash = ConfigHash(servedConfig)
+	page.Status.Pods = nil
+	reloadPending := false
+	if hotReloadEnabled(&page) {
+		pods, allLoaded, err := r.podConfigStatus(ctx, &page, page.Status.ConfigHash)
+		if err != nil {
+			logger.Error().Err(err).Msgf("Failed to collect pod config status for JaegerNginxProxy: %s %s", page.Name, page.Namespace)
+			return ctrl.Result{}, err
+		}
+		page.Status.Pods = pods
+		reloadPending = !allLoaded
+	}
+
 	// Improved status logic: check Deployment status
+	wasReady := page.Status.Ready
 	var depToCheck appsv1.Deployment
 	if err := r.Get(ctx, req.NamespacedName, &depToCheck); err != nil {
 		page.Status.Ready = false
 		page.Status.Message = "Deployment not found"
+		recordReplicas(&page, 0, 0, false)
 	} else {
 		desired := int32(1)
 		if depToCheck.Spec.Replicas != nil {
 			desired = *depToCheck.Spec.Replicas
 		}
+		recordReplicas(&page, desired, depToCheck.Status.AvailableReplicas, true)
 
 		// Handle different scenarios for readiness
 		if desired == 0 {
@@ -406,28 +693,78 @@ func (r *JaegerNginxProxyReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		}
 	}
 
-	log.Info().Bool("ready", page.Status.Ready).Str("message", page.Status.Message).Msg("Setting CR status")
+	if configErr != nil {
+		page.Status.Message = fmt.Sprintf("%s, serving the last valid nginx config", page.Status.Message)
+	}
+	if reloadPending {
+		page.Status.Message = fmt.Sprintf("%s, waiting for pods to load config %s", page.Status.Message, page.Status.ConfigHash)
+	}
+
+	r.readinessEvent(&page, wasReady)
+	r.rollouts.finish(&page)
 
-	if err := r.Status().Update(ctx, &page); err != nil {
+	result, err := r.updateStatus(ctx, &page)
+	// Pods are not watched, poll until every reloader reported the current config
+	if err == nil && !result.Requeue && reloadPending {
+		return ctrl.Result{RequeueAfter: reloadPollInterval}, nil
+	}
+	return result, err
+}
+
+func (r *JaegerNginxProxyReconciler) updateStatus(ctx context.Context, page *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (_ ctrl.Result, err error) {
+	ctx, span := tracing.Start(ctx, "UpdateStatus", trace.WithAttributes(attribute.Bool("ready", page.Status.Ready)))
+	defer func() { tracing.End(span, err) }()
+	logger := log.With().Ctx(ctx).Logger()
+	logger.Info().Bool("ready", page.Status.Ready).Str("message", page.Status.Message).Msg("Setting CR status")
+
+	if err := r.Status().Update(ctx, page); err != nil {
 		if errors.IsConflict(err) {
 			// Requeue if there's a conflict
 			return ctrl.Result{Requeue: true}, nil
 		}
-		log.Error().Err(err).Msg("Failed to update status")
+		logger.Error().Err(err).Msg("Failed to update status")
 		return ctrl.Result{}, err
 	}
-	log.Info().Msg("Successfully updated CR status")
-
+	logger.Info().Msg("Successfully updated CR status")
 	return ctrl.Result{}, nil
 }
 
-func AddJaegerNginxProxyController(mgr manager.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+// optionalOwnedKinds are the child kinds of CRDs that may not be installed
+var optionalOwnedKinds = []schema.GroupVersionKind{httpRouteGVK, grpcRouteGVK, serviceMonitorGVK}
+
+func AddJaegerNginxProxyController(mgr manager.Manager, opts ControllerOptions) error {
+	r := &JaegerNginxProxyReconciler{
+		Client:        mgr.GetClient(),
+		APIReader:     mgr.GetAPIReader(),
+		Scheme:        mgr.GetScheme(),
+		ReloaderImage: opts.ReloaderImage,
+		Recorder:      mgr.GetEventRecorderFor(ControllerName),
+		Validators:    opts.Validators,
+		events:        eventDeduplicator{window: opts.EventDedupWindow},
+	}
+	proxies.setReader(mgr.GetClient())
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&JaegerNginxProxyV1alpha0.JaegerNginxProxy{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.ConfigMap{}).
-		Complete(&JaegerNginxProxyReconciler{
-			Client: mgr.GetClient(),
-			Scheme: mgr.GetScheme(),
-		})
+		Owns(&corev1.Service{}).
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&networkingv1.Ingress{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&rbacv1.Role{}).
+		Owns(&rbacv1.RoleBinding{}).
+		// Config templates are referenced, not owned
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.proxiesForConfigTemplate))
+	// Kinds of optional CRDs are watched when the cluster serves them at start, a watch on a missing
+	// kind would fail the manager
+	for _, gvk := range optionalOwnedKinds {
+		if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+			log.Info().Msgf("Not watching %s, the cluster does not serve it", gvk.Kind)
+			continue
+		}
+		owned := &unstructured.Unstructured{}
+		owned.SetGroupVersionKind(gvk)
+		builder = builder.Owns(owned)
+	}
+	return builder.Complete(r)
 }
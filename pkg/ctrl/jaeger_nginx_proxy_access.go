@@ -0,0 +1,81 @@
+package ctrl
+
+import (
+	"fmt"
+	"net"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+const (
+	DefaultRealIPHeader = "X-Forwarded-For"
+	// RealIPHeaderProxyProtocol takes the client address from the PROXY protocol header
+	RealIPHeaderProxyProtocol = "proxy_protocol"
+)
+
+// ValidateAccessAddress returns an error when address is neither an IP address nor a CIDR
+func ValidateAccessAddress(address string) error {
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(address); err != nil {
+		return fmt.Errorf("must be an IP address or CIDR")
+	}
+	return nil
+}
+
+// proxyProtocol reports whether the listener expects the PROXY protocol
+func proxyProtocol(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) bool {
+	return nginxProxy.Spec.Access != nil && nginxProxy.Spec.Access.ProxyProtocol
+}
+
+// writeRealIP replaces the client address by the one reported by trusted proxies, so that allow and deny
+// lists and the access log see the client instead of the load balancer
+func (b *configBuilder) writeRealIP(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, indent string) {
+	access := nginxProxy.Spec.Access
+	if access == nil || len(access.TrustedProxies) == 0 {
+		return
+	}
+	for i, cidr := range access.TrustedProxies {
+		b.Writef(fmt.Sprintf("spec.access.trustedProxies[%d]", i), "%sset_real_ip_from %s;\n", indent, cidr)
+	}
+	header := access.RealIPHeader
+	if header == "" {
+		header = DefaultRealIPHeader
+		if access.ProxyProtocol {
+			header = RealIPHeaderProxyProtocol
+		}
+	}
+	b.Writef("spec.access.realIPHeader", "%sreal_ip_header %s;\n", indent, header)
+	if access.RealIPRecursive {
+		b.Writef("spec.access.realIPRecursive", "%sreal_ip_recursive on;\n", indent)
+	}
+	b.WriteString("\n")
+}
+
+// writePortAccess writes the allow and deny directives of the location block of port i. nginx uses the
+// first matching rule, so denied addresses come first and a trailing deny all closes an allow list.
+func (b *configBuilder) writePortAccess(i int, port JaegerNginxProxyV1alpha0.Port, access *JaegerNginxProxyV1alpha0.Access, indent string) {
+	if access == nil {
+		access = &JaegerNginxProxyV1alpha0.Access{}
+	}
+	portPath := fmt.Sprintf("spec.ports[%d]", i)
+
+	for j, address := range port.Deny {
+		b.Writef(fmt.Sprintf("%s.deny[%d]", portPath, j), "%sdeny %s;\n", indent, address)
+	}
+	for j, address := range access.Deny {
+		b.Writef(fmt.Sprintf("spec.access.deny[%d]", j), "%sdeny %s;\n", indent, address)
+	}
+
+	allow, allowPath := port.Allow, portPath+".allow"
+	if len(allow) == 0 {
+		allow, allowPath = access.Allow, "spec.access.allow"
+	}
+	for j, address := range allow {
+		b.Writef(fmt.Sprintf("%s[%d]", allowPath, j), "%sallow %s;\n", indent, address)
+	}
+	if len(allow) > 0 {
+		b.Writef(allowPath, "%sdeny all;\n", indent)
+	}
+}
@@ -0,0 +1,80 @@
+package ctrl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func TestRenderNginxConfigAccess(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Access = &JaegerNginxProxyV1alpha0.Access{
+		Allow:          []string{"10.0.0.0/8"},
+		Deny:           []string{"10.0.13.0/24"},
+		TrustedProxies: []string{"192.168.0.0/16"},
+	}
+	nginxProxy.Spec.Ports[1].Allow = []string{"10.1.2.3"}
+	nginxProxy.Spec.Ports[1].Deny = []string{"10.1.2.4"}
+
+	config, sources := renderNginxConfig(nginxProxy)
+	assert.Contains(t, config, "  set_real_ip_from 192.168.0.0/16;\n  real_ip_header X-Forwarded-For;\n\n")
+	assert.Contains(t, config, "     proxy_pass http://jaeger-collector-http;\n"+
+		"     deny 10.0.13.0/24;\n"+
+		"     allow 10.0.0.0/8;\n"+
+		"     deny all;\n")
+	assert.Contains(t, config, "     grpc_pass grpc://jaeger-collector-grpc;\n"+
+		"     deny 10.1.2.4;\n"+
+		"     deny 10.0.13.0/24;\n"+
+		"     allow 10.1.2.3;\n"+
+		"     deny all;\n")
+	assert.Contains(t, config, "  location /healthz {\n        access_log off;\n        return 200;\n  }\n", "the health check is not restricted")
+
+	lines := strings.Split(config, "\n")
+	for i, line := range lines[:len(sources)] {
+		switch strings.TrimSpace(line) {
+		case "allow 10.1.2.3;":
+			assert.Equal(t, "spec.ports[1].allow[0]", sources[i])
+		case "deny 10.1.2.4;":
+			assert.Equal(t, "spec.ports[1].deny[0]", sources[i])
+		case "set_real_ip_from 192.168.0.0/16;":
+			assert.Equal(t, "spec.access.trustedProxies[0]", sources[i])
+		}
+	}
+
+	_, findings, err := DefaultConfigValidators().ValidateProxyConfig(nginxProxy, "")
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+}
+
+func TestRenderNginxConfigProxyProtocol(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Access = &JaegerNginxProxyV1alpha0.Access{
+		TrustedProxies: []string{"10.0.0.0/8"},
+		ProxyProtocol:  true,
+	}
+	config := GenerateNginxConfig(nginxProxy)
+	assert.Contains(t, config, "  listen 8080 default_server proxy_protocol;\n  http2 on;\n")
+	assert.Contains(t, config, "  real_ip_header proxy_protocol;\n")
+	assert.NotContains(t, config, "allow")
+
+	nginxProxy.Spec.Image.Tag = "1.21"
+	nginxProxy.Spec.Ports = nginxProxy.Spec.Ports[1:]
+	nginxProxy.Spec.Access.RealIPHeader = "X-Real-IP"
+	nginxProxy.Spec.Access.RealIPRecursive = true
+	config = GenerateNginxConfig(nginxProxy)
+	assert.Contains(t, config, "  listen 8080 default_server proxy_protocol http2;\n")
+	assert.Contains(t, config, "  real_ip_header X-Real-IP;\n  real_ip_recursive on;\n")
+}
+
+func TestValidateAccessAddress(t *testing.T) {
+	for _, address := range []string{"10.0.0.1", "10.0.0.0/8", "fd00::/8", "::1"} {
+		assert.NoError(t, ValidateAccessAddress(address), address)
+	}
+	for _, address := range []string{"", "all", "10.0.0.0/33", "10.0.0.1; include x", "example.com"} {
+		assert.Error(t, ValidateAccessAddress(address), address)
+	}
+}
@@ -0,0 +1,96 @@
+package ctrl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+const (
+	// ConditionConfigValid reports whether the spec renders to a valid nginx config
+	ConditionConfigValid = "ConfigValid"
+
+	ReasonConfigValid   = "Valid"
+	ReasonConfigInvalid = "ValidationFailed"
+)
+
+// ConfigError is a validation error located at a line of the generated nginx config
+type ConfigError struct {
+	// Line is the 1-based line most likely causing the error, 0 when it cannot be attributed to a line
+	Line int
+	// Field is the spec field causing the error when known without looking at the line, e.g. spec.image.tag
+	Field string
+	Msg   string
+}
+
+func (e *ConfigError) Error() string {
+	return e.Msg
+}
+
+// isConfigError reports whether err is caused by the config of the proxy rather than by the cluster
+func isConfigError(err error) bool {
+	var configErr *ConfigError
+	return errors.As(err, &configErr)
+}
+
+// configBuilder builds the nginx config and remembers the spec field every line was generated from
+type configBuilder struct {
+	strings.Builder
+	sources []string
+}
+
+// WriteString appends static text that does not depend on the spec
+func (b *configBuilder) WriteString(s string) (int, error) {
+	return b.write("", s)
+}
+
+// Writef appends formatted text generated from the spec field fieldPath
+func (b *configBuilder) Writef(fieldPath, format string, args ...interface{}) {
+	_, _ = b.write(fieldPath, fmt.Sprintf(format, args...))
+}
+
+func (b *configBuilder) write(fieldPath, s string) (int, error) {
+	for i := 0; i < strings.Count(s, "\n"); i++ {
+		b.sources = append(b.sources, fieldPath)
+	}
+	return b.Builder.WriteString(s)
+}
+
+// setConfigCondition records the ConfigValid condition and emits a Warning event whenever the config
+// turns invalid or fails for a different reason
+func (r *JaegerNginxProxyReconciler) setConfigCondition(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, configErr error) {
+	condition := metav1.Condition{
+		Type:               ConditionConfigValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             ReasonConfigValid,
+		Message:            "nginx config is valid",
+		ObservedGeneration: nginxProxy.Generation,
+	}
+	if configErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonConfigInvalid
+		condition.Message = fmt.Sprintf("%s: %v", ConfigErrorField(configErr), configErr)
+	}
+
+	changed := meta.SetStatusCondition(&nginxProxy.Status.Conditions, condition)
+	if configErr != nil && changed {
+		r.event(nginxProxy, corev1.EventTypeWarning, ReasonInvalidConfig, condition.Message+", keeping the last valid config")
+	}
+}
+
+// ConfigErrorField returns the spec field path responsible for a config validation error, "spec" when
+// the error cannot be attributed to a single field. Lines are attributed by ValidateProxyConfig against
+// the config that failed, which may have been rendered from a template.
+func ConfigErrorField(err error) string {
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Field == "" {
+		return "spec"
+	}
+	return configErr.Field
+}
@@ -0,0 +1,153 @@
+package ctrl
+
+import (
+	context "context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func TestRenderNginxConfigSources(t *testing.T) {
+	nginxProxy := newTestProxy()
+	config, sources := renderNginxConfig(nginxProxy)
+
+	assert.Equal(t, GenerateNginxConfig(nginxProxy), config)
+	assert.Len(t, sources, strings.Count(config, "\n"))
+
+	lines := strings.Split(config, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "  location /api/traces"):
+			assert.Equal(t, "spec.ports[0].path", sources[i])
+		case strings.Contains(line, "server jaeger-collector"):
+			assert.Equal(t, "spec.upstream.collectorHost", sources[i])
+		case strings.Contains(line, "listen"):
+			assert.Equal(t, "spec.containerPort", sources[i])
+		case strings.HasPrefix(line, "server {"):
+			assert.Empty(t, sources[i])
+		}
+	}
+}
+
+func TestConfigErrorField(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Ports[1].Path = "/broken {"
+
+	_, _, err := ConfigValidators{}.ValidateProxyConfig(nginxProxy, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unmatched opening braces")
+	assert.Equal(t, "spec.ports[1].path", ConfigErrorField(err))
+
+	nginxProxy.Spec.Ports[1].Path = "/broken"
+	nginxProxy.Spec.Upstream.CollectorHost = "collector }"
+	_, _, err = ConfigValidators{}.ValidateProxyConfig(nginxProxy, "")
+	require.Error(t, err)
+	assert.Equal(t, "spec.upstream.collectorHost", ConfigErrorField(err))
+
+	// Lines of a rendered template are attributed to the template, not to the field the line of the
+	// generated config would come from
+	nginxProxy.Spec.Upstream.CollectorHost = "jaeger-collector"
+	generated := GenerateNginxConfig(nginxProxy)
+	_, _, err = ConfigValidators{}.ValidateProxyConfig(nginxProxy, "# custom\n{{ .Generated }}}\n")
+	require.Error(t, err)
+	var configErr *ConfigError
+	require.ErrorAs(t, err, &configErr)
+	assert.Equal(t, strings.Count(generated, "\n")+2, configErr.Line, "the line of the rendered template")
+	assert.Equal(t, configTemplateField, ConfigErrorField(err))
+
+	assert.Equal(t, "spec", ConfigErrorField(assert.AnError))
+	assert.Equal(t, "spec", ConfigErrorField(&ConfigError{Line: 1, Msg: "no field"}))
+}
+
+func TestReconcileKeepsLastValidConfig(t *testing.T) {
+	s := newTestScheme(t)
+	nginxProxy := newTestProxy()
+	c := fake.NewClientBuilder().WithScheme(s).
+		WithObjects(nginxProxy).
+		WithStatusSubresource(&JaegerNginxProxyV1alpha0.JaegerNginxProxy{}).
+		Build()
+	recorder := record.NewFakeRecorder(100)
+	r := &JaegerNginxProxyReconciler{Client: c, Scheme: s, Recorder: recorder}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(nginxProxy)
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+
+	var current JaegerNginxProxyV1alpha0.JaegerNginxProxy
+	require.NoError(t, c.Get(ctx, key, &current))
+	require.Len(t, current.Status.Revisions, 1)
+	cmKey := client.ObjectKey{Name: current.Status.Revisions[0].ConfigMapName, Namespace: key.Namespace}
+
+	var cm corev1.ConfigMap
+	require.NoError(t, c.Get(ctx, cmKey, &cm))
+	validConfig := cm.Data[ConfigFileName]
+
+	assert.True(t, meta.IsStatusConditionTrue(current.Status.Conditions, ConditionConfigValid))
+	assert.Equal(t, ConfigHash(validConfig), current.Status.ConfigHash)
+
+	drainEvents(recorder)
+	current.Spec.Ports[0].Path = "/api/traces {"
+	require.NoError(t, c.Update(ctx, &current))
+
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	require.NoError(t, err, "an invalid config is reported in status instead of retried")
+
+	require.NoError(t, c.Get(ctx, key, &current))
+	require.Len(t, current.Status.Revisions, 1, "an invalid config never becomes a revision")
+	assert.Equal(t, int64(1), current.Status.CurrentRevision)
+	require.NoError(t, c.Get(ctx, cmKey, &cm))
+	assert.Equal(t, validConfig, cm.Data[ConfigFileName], "the last valid config is kept")
+	condition := meta.FindStatusCondition(current.Status.Conditions, ConditionConfigValid)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, ReasonConfigInvalid, condition.Reason)
+	assert.Contains(t, condition.Message, "spec.ports[0].path")
+	assert.Equal(t, ConfigHash(validConfig), current.Status.ConfigHash)
+	assert.Contains(t, current.Status.Message, "serving the last valid nginx config")
+
+	events := eventsWithReason(drainEvents(recorder), ReasonInvalidConfig)
+	require.Len(t, events, 1)
+	assert.Contains(t, events[0], "Warning InvalidConfig spec.ports[0].path")
+
+	// The same failure is not reported twice
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	assert.Empty(t, eventsWithReason(drainEvents(recorder), ReasonInvalidConfig))
+}
+
+func TestReconcileInvalidConfigWithoutPreviousConfig(t *testing.T) {
+	s := newTestScheme(t)
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Ports[0].Path = "/api/traces {"
+	c := fake.NewClientBuilder().WithScheme(s).
+		WithObjects(nginxProxy).
+		WithStatusSubresource(&JaegerNginxProxyV1alpha0.JaegerNginxProxy{}).
+		Build()
+	r := &JaegerNginxProxyReconciler{Client: c, Scheme: s}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(nginxProxy)
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+
+	var cms corev1.ConfigMapList
+	require.NoError(t, c.List(ctx, &cms))
+	assert.Empty(t, cms.Items)
+
+	var current JaegerNginxProxyV1alpha0.JaegerNginxProxy
+	require.NoError(t, c.Get(ctx, key, &current))
+	assert.False(t, current.Status.Ready)
+	assert.True(t, meta.IsStatusConditionFalse(current.Status.Conditions, ConditionConfigValid))
+}
@@ -0,0 +1,185 @@
+package ctrl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+	"github.com/dolv/k8s-controller-tutorial/pkg/tracing"
+)
+
+// Reasons of the events recorded on JaegerNginxProxy resources
+const (
+	ReasonCreated        = "Created"
+	ReasonUpdated        = "Updated"
+	ReasonCreateFailed   = "FailedCreate"
+	ReasonUpdateFailed   = "FailedUpdate"
+	ReasonUpdateConflict = "UpdateConflict"
+	ReasonInvalidConfig  = "InvalidConfig"
+	ReasonReady          = "Ready"
+	ReasonNotReady       = "NotReady"
+	ReasonRolledBack     = "RolledBack"
+	ReasonRollbackFailed = "RollbackFailed"
+	ReasonResourceExists = "ResourceExists"
+
+	// DefaultEventDedupWindow is how long an identical failure event is suppressed
+	DefaultEventDedupWindow = 10 * time.Minute
+)
+
+type eventKey struct {
+	uid        types.UID
+	generation int64
+	eventType  string
+	reason     string
+	message    string
+}
+
+// eventDeduplicator suppresses failure events that were already recorded for the same generation of a
+// proxy within the window, so that reconciles retrying the same failure do not flood the event stream.
+// The zero value uses DefaultEventDedupWindow.
+type eventDeduplicator struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	seen map[eventKey]time.Time
+}
+
+// allow reports whether the event should be recorded and remembers it when it should
+func (d *eventDeduplicator) allow(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, eventType, reason, message string) bool {
+	window := d.window
+	if window == 0 {
+		window = DefaultEventDedupWindow
+	}
+	now := time.Now()
+	if d.now != nil {
+		now = d.now()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen == nil {
+		d.seen = map[eventKey]time.Time{}
+	}
+	// Expired entries are dropped on the way, the map only holds events of the last window
+	for key, recorded := range d.seen {
+		if now.Sub(recorded) >= window {
+			delete(d.seen, key)
+		}
+	}
+
+	key := eventKey{uid: nginxProxy.UID, generation: nginxProxy.Generation, eventType: eventType, reason: reason, message: message}
+	if _, ok := d.seen[key]; ok {
+		return false
+	}
+	d.seen[key] = now
+	return true
+}
+
+// event records an event on the proxy when a recorder is configured
+func (r *JaegerNginxProxyReconciler) event(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(nginxProxy, eventType, reason, message)
+}
+
+func (r *JaegerNginxProxyReconciler) eventf(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, eventType, reason, format string, args ...interface{}) {
+	r.event(nginxProxy, eventType, reason, fmt.Sprintf(format, args...))
+}
+
+// failureEventf records an event about a failed write of a child resource. The reconcile is retried
+// until the failure is resolved, so the same event is only recorded once per dedup window.
+func (r *JaegerNginxProxyReconciler) failureEventf(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, eventType, reason, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if r.Recorder == nil || !r.events.allow(nginxProxy, eventType, reason, message) {
+		return
+	}
+	r.Recorder.Event(nginxProxy, eventType, reason, message)
+}
+
+// kind returns the kind of a child resource, "" when the scheme does not know it
+func (r *JaegerNginxProxyReconciler) kind(obj client.Object) string {
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return ""
+	}
+	return gvk.Kind
+}
+
+// describe names a child resource in event messages, e.g. "Service my-proxy"
+func (r *JaegerNginxProxyReconciler) describe(obj client.Object) string {
+	if kind := r.kind(obj); kind != "" {
+		return kind + " " + obj.GetName()
+	}
+	return obj.GetName()
+}
+
+// childSpan traces a write of a child resource
+func (r *JaegerNginxProxyReconciler) childSpan(ctx context.Context, operation string, obj client.Object) (context.Context, trace.Span) {
+	return tracing.Start(ctx, operation+" "+r.kind(obj), trace.WithAttributes(
+		attribute.String("k8s.resource.kind", r.kind(obj)),
+		attribute.String("k8s.resource.name", obj.GetName()),
+	))
+}
+
+// createChild creates a child resource of the proxy and records the outcome as event
+func (r *JaegerNginxProxyReconciler) createChild(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, obj client.Object) (err error) {
+	ctx, span := r.childSpan(ctx, "Create", obj)
+	defer func() { tracing.End(span, err) }()
+	if err := r.Create(ctx, obj); err != nil {
+		r.failureEventf(nginxProxy, corev1.EventTypeWarning, ReasonCreateFailed, "Failed to create %s: %v", r.describe(obj), err)
+		return err
+	}
+	r.eventf(nginxProxy, corev1.EventTypeNormal, ReasonCreated, "Created %s", r.describe(obj))
+	return nil
+}
+
+// updateChild updates a child resource of the proxy and records the outcome as event. It returns true
+// when the update conflicted with another writer and has to be retried with the latest version.
+func (r *JaegerNginxProxyReconciler) updateChild(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, obj client.Object) (_ bool, err error) {
+	ctx, span := r.childSpan(ctx, "Update", obj)
+	defer func() { tracing.End(span, err) }()
+	if err := r.Update(ctx, obj); err != nil {
+		if errors.IsConflict(err) {
+			span.SetAttributes(attribute.Bool("conflict", true))
+			r.failureEventf(nginxProxy, corev1.EventTypeNormal, ReasonUpdateConflict, "%s was modified concurrently, retrying", r.describe(obj))
+			return true, nil
+		}
+		r.failureEventf(nginxProxy, corev1.EventTypeWarning, ReasonUpdateFailed, "Failed to update %s: %v", r.describe(obj), err)
+		return false, err
+	}
+	r.eventf(nginxProxy, corev1.EventTypeNormal, ReasonUpdated, "Updated %s", r.describe(obj))
+	return false, nil
+}
+
+// notControlledError records that obj already exists without being controlled by the proxy and returns
+// the matching error. Objects the controller did not create are never adopted or overwritten.
+func (r *JaegerNginxProxyReconciler) notControlledError(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, obj client.Object) error {
+	err := fmt.Errorf("%s already exists and is not controlled by JaegerNginxProxy %s", r.describe(obj), nginxProxy.Name)
+	r.failureEventf(nginxProxy, corev1.EventTypeWarning, ReasonResourceExists, "%v", err)
+	return err
+}
+
+// readinessEvent records the transition of status.ready. Every transition is recorded, also when the
+// proxy flaps back to a state it reported before.
+func (r *JaegerNginxProxyReconciler) readinessEvent(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, wasReady bool) {
+	switch {
+	case nginxProxy.Status.Ready && !wasReady:
+		r.event(nginxProxy, corev1.EventTypeNormal, ReasonReady, nginxProxy.Status.Message)
+	case !nginxProxy.Status.Ready && wasReady:
+		r.event(nginxProxy, corev1.EventTypeWarning, ReasonNotReady, nginxProxy.Status.Message)
+	}
+}
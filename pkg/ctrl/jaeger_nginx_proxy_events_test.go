@@ -0,0 +1,132 @@
+package ctrl
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+// drainEvents returns the events recorded so far
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// eventsWithReason returns the events of the given reason
+func eventsWithReason(events []string, reason string) []string {
+	var matching []string
+	for _, event := range events {
+		if _, rest, _ := strings.Cut(event, " "); strings.HasPrefix(rest, reason+" ") {
+			matching = append(matching, event)
+		}
+	}
+	return matching
+}
+
+func TestEventDeduplicator(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := &eventDeduplicator{window: time.Minute, now: func() time.Time { return now }}
+	nginxProxy := newTestProxy()
+	nginxProxy.UID = "uid-1"
+	nginxProxy.Generation = 1
+
+	assert.True(t, d.allow(nginxProxy, corev1.EventTypeWarning, ReasonUpdateFailed, "boom"))
+	assert.False(t, d.allow(nginxProxy, corev1.EventTypeWarning, ReasonUpdateFailed, "boom"))
+	assert.True(t, d.allow(nginxProxy, corev1.EventTypeWarning, ReasonUpdateFailed, "other"))
+
+	// A new generation is a new change worth reporting
+	nginxProxy.Generation = 2
+	assert.True(t, d.allow(nginxProxy, corev1.EventTypeWarning, ReasonUpdateFailed, "boom"))
+
+	now = now.Add(time.Minute)
+	assert.True(t, d.allow(nginxProxy, corev1.EventTypeWarning, ReasonUpdateFailed, "boom"), "the window expired")
+	assert.Len(t, d.seen, 1, "expired events are forgotten")
+}
+
+func TestReadinessEventsFlapping(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &JaegerNginxProxyReconciler{Recorder: recorder}
+	nginxProxy := newTestProxy()
+	nginxProxy.UID = "uid-1"
+	nginxProxy.Generation = 1
+
+	// Every transition of the same generation is reported, none is swallowed as a duplicate
+	for _, ready := range []bool{true, false, true, false} {
+		wasReady := nginxProxy.Status.Ready
+		nginxProxy.Status.Ready = ready
+		nginxProxy.Status.Message = "pods changed"
+		r.readinessEvent(nginxProxy, wasReady)
+	}
+	assert.Equal(t, []string{
+		"Normal Ready pods changed",
+		"Warning NotReady pods changed",
+		"Normal Ready pods changed",
+		"Warning NotReady pods changed",
+	}, drainEvents(recorder))
+
+	// Repeated failures of the same write are still reported once
+	for i := 0; i < 3; i++ {
+		r.failureEventf(nginxProxy, corev1.EventTypeWarning, ReasonUpdateFailed, "Failed to update %s: %v", "Deployment test-proxy", "boom")
+	}
+	assert.Equal(t, []string{"Warning FailedUpdate Failed to update Deployment test-proxy: boom"}, drainEvents(recorder))
+}
+
+func TestReconcileEvents(t *testing.T) {
+	ctx := context.Background()
+	nginxProxy := newTestProxy()
+	// The fake client does not default the ServiceAccount of the pod template like the API server
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{Name: "proxy"}
+	r, recorder := newRevisionTestReconciler(t, nginxProxy)
+	key := client.ObjectKeyFromObject(nginxProxy)
+
+	reconcileProxy(t, r, key, nil)
+	events := drainEvents(recorder)
+	assert.Contains(t, events, "Normal Created Created Deployment test-proxy")
+	assert.Contains(t, events, "Normal Created Created Service test-proxy")
+
+	// Steady state reconciles are silent
+	reconcileProxy(t, r, key, nil)
+	assert.Empty(t, drainEvents(recorder))
+
+	// The fake client does not bump the generation on spec changes like the API server
+	reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+		p.Spec.ReplicaCount = 2
+		p.Generation++
+	})
+	assert.Contains(t, drainEvents(recorder), "Normal Updated Updated Deployment test-proxy")
+
+	// Readiness is reported on transitions only
+	var dep appsv1.Deployment
+	require.NoError(t, r.Get(ctx, key, &dep))
+	dep.Status.AvailableReplicas = 2
+	require.NoError(t, r.Status().Update(ctx, &dep))
+	reconcileProxy(t, r, key, nil)
+	assert.Equal(t, []string{"Normal Ready All 2 pods are running"}, drainEvents(recorder))
+	reconcileProxy(t, r, key, nil)
+	assert.Empty(t, drainEvents(recorder))
+
+	require.NoError(t, r.Get(ctx, key, &dep))
+	dep.Status.AvailableReplicas = 1
+	require.NoError(t, r.Status().Update(ctx, &dep))
+	reconcileProxy(t, r, key, nil)
+	events = drainEvents(recorder)
+	require.Len(t, events, 1)
+	assert.Contains(t, events[0], "Warning NotReady Available replicas: 1/2")
+}
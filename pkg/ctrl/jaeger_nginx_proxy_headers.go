@@ -0,0 +1,160 @@
+package ctrl
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+const (
+	CORSPreflightRespond = "respond"
+	CORSPreflightForward = "forward"
+
+	DefaultCORSMaxAge = 86400
+)
+
+var (
+	// DefaultCORSMethods and DefaultCORSHeaders are what OTLP/HTTP and Jaeger browser exporters send
+	DefaultCORSMethods = []string{"POST", "OPTIONS"}
+	DefaultCORSHeaders = []string{"Content-Type"}
+
+	// headerName matches an HTTP header name, a token as defined by RFC 9110
+	headerName = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+	// corsOrigin matches a serialized origin, optionally with a "*." wildcard for subdomains
+	corsOrigin = regexp.MustCompile(`^(https?)://(\*\.)?([A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*)(:[0-9]{1,5})?$`)
+)
+
+// IsHeaderName reports whether name is a valid HTTP header name
+func IsHeaderName(name string) bool {
+	return headerName.MatchString(name)
+}
+
+// ValidateCORSOrigin returns an error when origin is neither "*" nor an origin without path, e.g.
+// "https://app.example.com" or "https://*.example.com"
+func ValidateCORSOrigin(origin string) error {
+	if origin == "*" || corsOrigin.MatchString(origin) {
+		return nil
+	}
+	return fmt.Errorf("must be \"*\" or scheme://host[:port] with scheme http or https and no path, e.g. https://app.example.com or https://*.example.com")
+}
+
+// nginxQuote quotes s as nginx string, keeping variables such as $remote_addr
+func nginxQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// corsOriginVariable is the variable holding the allowed origin of a request to port i, empty when the
+// origin is not allowed. Port names may not be valid variable names, the index is.
+func corsOriginVariable(i int) string {
+	return fmt.Sprintf("$jaeger_cors_origin_%d", i)
+}
+
+// corsOriginPattern returns the map key matching origin
+func corsOriginPattern(origin string) string {
+	match := corsOrigin.FindStringSubmatch(origin)
+	if match == nil || match[2] == "" {
+		return nginxQuote(origin)
+	}
+	// Regular expressions are not escaped, a validated origin contains no quotes
+	return `"~^` + regexp.QuoteMeta(match[1]+"://") + `[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.` +
+		regexp.QuoteMeta(match[3]+match[4]) + `$"`
+}
+
+// writeCORSMaps maps the Origin request header to the allowed origin of every port with CORS. Maps
+// are only valid in the http context, so they are written next to the upstream blocks.
+func (b *configBuilder) writeCORSMaps(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+	for i, port := range nginxProxy.Spec.Ports {
+		cors := port.CORS
+		if cors == nil || slices.Contains(cors.AllowedOrigins, "*") {
+			continue
+		}
+		corsPath := fmt.Sprintf("spec.ports[%d].cors", i)
+		b.Writef(corsPath, "map $http_origin %s {\n", corsOriginVariable(i))
+		b.Writef(corsPath, "  default \"\";\n")
+		for j, origin := range cors.AllowedOrigins {
+			b.Writef(fmt.Sprintf("%s.allowedOrigins[%d]", corsPath, j), "  %s $http_origin;\n", corsOriginPattern(origin))
+		}
+		b.Writef(corsPath, "}\n\n")
+	}
+}
+
+// writePortHeaders writes the header manipulation and CORS directives of the location block of port i
+func (b *configBuilder) writePortHeaders(i int, port JaegerNginxProxyV1alpha0.Port, indent string) {
+	portPath := fmt.Sprintf("spec.ports[%d]", i)
+	prefix := "proxy"
+	if PortProtocol(port) == PortProtocolGRPC {
+		prefix = "grpc"
+	}
+
+	// Map iteration order is random, sorting keeps the config and its hash stable
+	names := make([]string, 0, len(port.SetHeaders))
+	for name := range port.SetHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.Writef(fmt.Sprintf("%s.setHeaders[%s]", portPath, name), "%s%s_set_header %s %s;\n", indent, prefix, name, nginxQuote(port.SetHeaders[name]))
+	}
+	// nginx does not pass headers set to an empty string
+	for j, name := range port.RemoveHeaders {
+		b.Writef(fmt.Sprintf("%s.removeHeaders[%d]", portPath, j), "%s%s_set_header %s \"\";\n", indent, prefix, name)
+	}
+	for j, name := range port.HideResponseHeaders {
+		b.Writef(fmt.Sprintf("%s.hideResponseHeaders[%d]", portPath, j), "%s%s_hide_header %s;\n", indent, prefix, name)
+	}
+
+	if port.CORS != nil {
+		b.writeCORS(i, port.CORS, indent)
+	}
+}
+
+// writeCORS adds the CORS response headers. add_header directives of a block replace inherited ones,
+// so the preflight response repeats the headers of the location.
+func (b *configBuilder) writeCORS(i int, cors *JaegerNginxProxyV1alpha0.CORS, indent string) {
+	corsPath := fmt.Sprintf("spec.ports[%d].cors", i)
+	origin := corsOriginVariable(i)
+	if slices.Contains(cors.AllowedOrigins, "*") {
+		origin = `"*"`
+	}
+
+	responseHeaders := func(indent string) {
+		b.Writef(corsPath+".allowedOrigins", "%sadd_header Access-Control-Allow-Origin %s always;\n", indent, origin)
+		if origin != `"*"` {
+			b.Writef(corsPath+".allowedOrigins", "%sadd_header Vary Origin always;\n", indent)
+		}
+		if cors.AllowCredentials {
+			b.Writef(corsPath+".allowCredentials", "%sadd_header Access-Control-Allow-Credentials \"true\" always;\n", indent)
+		}
+	}
+
+	responseHeaders(indent)
+	if len(cors.ExposedHeaders) > 0 {
+		b.Writef(corsPath+".exposedHeaders", "%sadd_header Access-Control-Expose-Headers %s always;\n", indent, nginxQuote(strings.Join(cors.ExposedHeaders, ", ")))
+	}
+	if cors.Preflight == CORSPreflightForward {
+		return
+	}
+
+	methods, headers := cors.AllowedMethods, cors.AllowedHeaders
+	if len(methods) == 0 {
+		methods = DefaultCORSMethods
+	}
+	if len(headers) == 0 {
+		headers = DefaultCORSHeaders
+	}
+	maxAge := int32(DefaultCORSMaxAge)
+	if cors.MaxAge != nil {
+		maxAge = *cors.MaxAge
+	}
+	b.Writef(corsPath+".preflight", "%sif ($request_method = OPTIONS) {\n", indent)
+	responseHeaders(indent + "  ")
+	b.Writef(corsPath+".allowedMethods", "%s  add_header Access-Control-Allow-Methods %s always;\n", indent, nginxQuote(strings.Join(methods, ", ")))
+	b.Writef(corsPath+".allowedHeaders", "%s  add_header Access-Control-Allow-Headers %s always;\n", indent, nginxQuote(strings.Join(headers, ", ")))
+	b.Writef(corsPath+".maxAge", "%s  add_header Access-Control-Max-Age %d always;\n", indent, maxAge)
+	b.Writef(corsPath+".preflight", "%s  return 204;\n", indent)
+	b.Writef(corsPath+".preflight", "%s}\n", indent)
+}
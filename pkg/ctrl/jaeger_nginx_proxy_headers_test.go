@@ -0,0 +1,102 @@
+package ctrl
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func TestRenderNginxConfigHeaders(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Ports[0].SetHeaders = map[string]string{"X-Scope-OrgID": "tenant-a", "Host": "$host"}
+	nginxProxy.Spec.Ports[0].RemoveHeaders = []string{"Cookie"}
+	nginxProxy.Spec.Ports[0].HideResponseHeaders = []string{"Server"}
+	nginxProxy.Spec.Ports[1].SetHeaders = map[string]string{"Authorization": `Bearer "token"`}
+
+	config, sources := renderNginxConfig(nginxProxy)
+	assert.Contains(t, config, "     proxy_pass http://jaeger-collector-http;\n"+
+		"     proxy_set_header Host \"$host\";\n"+
+		"     proxy_set_header X-Scope-OrgID \"tenant-a\";\n"+
+		"     proxy_set_header Cookie \"\";\n"+
+		"     proxy_hide_header Server;\n")
+	assert.Contains(t, config, "     grpc_set_header Authorization \"Bearer \\\"token\\\"\";\n")
+
+	lines := strings.Split(config, "\n")
+	for i, line := range lines[:len(sources)] {
+		switch strings.TrimSpace(line) {
+		case `proxy_set_header X-Scope-OrgID "tenant-a";`:
+			assert.Equal(t, "spec.ports[0].setHeaders[X-Scope-OrgID]", sources[i])
+		case `proxy_set_header Cookie "";`:
+			assert.Equal(t, "spec.ports[0].removeHeaders[0]", sources[i])
+		case "proxy_hide_header Server;":
+			assert.Equal(t, "spec.ports[0].hideResponseHeaders[0]", sources[i])
+		}
+	}
+
+	validators := ConfigValidators{StructuralValidator{}, &DirectiveAllowlist{Allowed: DefaultAllowedDirectives}, &LintValidator{}}
+	_, findings, err := validators.ValidateProxyConfig(nginxProxy, "")
+	require.NoError(t, err)
+	assert.Empty(t, findings, "the Host header is set by setHeaders")
+}
+
+func TestRenderNginxConfigCORS(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Ports[0].CORS = &JaegerNginxProxyV1alpha0.CORS{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org:8443"},
+		ExposedHeaders:   []string{"X-Trace-Id"},
+		AllowCredentials: true,
+	}
+
+	config, _ := renderNginxConfig(nginxProxy)
+	assert.Contains(t, config, "map $http_origin $jaeger_cors_origin_0 {\n  default \"\";\n"+
+		"  \"https://app.example.com\" $http_origin;\n")
+	assert.Contains(t, config, `"~^https://[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.example\.org:8443$" $http_origin;`)
+	assert.Contains(t, config, "     add_header Access-Control-Allow-Origin $jaeger_cors_origin_0 always;\n"+
+		"     add_header Vary Origin always;\n"+
+		"     add_header Access-Control-Allow-Credentials \"true\" always;\n"+
+		"     add_header Access-Control-Expose-Headers \"X-Trace-Id\" always;\n"+
+		"     if ($request_method = OPTIONS) {\n")
+	assert.Contains(t, config, "       add_header Access-Control-Allow-Methods \"POST, OPTIONS\" always;\n"+
+		"       add_header Access-Control-Allow-Headers \"Content-Type\" always;\n"+
+		"       add_header Access-Control-Max-Age 86400 always;\n"+
+		"       return 204;\n     }\n")
+
+	_, findings, err := DefaultConfigValidators().ValidateProxyConfig(nginxProxy, "")
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+
+	maxAge := int32(600)
+	nginxProxy.Spec.Ports[0].CORS = &JaegerNginxProxyV1alpha0.CORS{AllowedOrigins: []string{"*"}, MaxAge: &maxAge}
+	config, _ = renderNginxConfig(nginxProxy)
+	assert.NotContains(t, config, "map $http_origin")
+	assert.NotContains(t, config, "Vary")
+	assert.Contains(t, config, "add_header Access-Control-Allow-Origin \"*\" always;")
+	assert.Contains(t, config, "add_header Access-Control-Max-Age 600 always;")
+
+	nginxProxy.Spec.Ports[0].CORS.Preflight = CORSPreflightForward
+	config, _ = renderNginxConfig(nginxProxy)
+	assert.Contains(t, config, "add_header Access-Control-Allow-Origin \"*\" always;")
+	assert.NotContains(t, config, "$request_method = OPTIONS")
+}
+
+func TestCORSOrigin(t *testing.T) {
+	for _, origin := range []string{"*", "https://app.example.com", "http://localhost:16686", "https://*.example.com"} {
+		assert.NoError(t, ValidateCORSOrigin(origin), origin)
+	}
+	for _, origin := range []string{"", "app.example.com", "https://app.example.com/", "ftp://example.com", "https://*", "https://a.*.example.com", `https://x.com" ; include`} {
+		assert.Error(t, ValidateCORSOrigin(origin), origin)
+	}
+
+	pattern := corsOriginPattern("https://*.example.com")
+	re := regexp.MustCompile(strings.Trim(pattern, `"`)[1:])
+	assert.True(t, re.MatchString("https://app.example.com"))
+	assert.True(t, re.MatchString("https://a.b.example.com"))
+	assert.False(t, re.MatchString("https://example.com"))
+	assert.False(t, re.MatchString("https://app.example.com.evil.io"))
+	assert.False(t, re.MatchString("http://app.example.com"))
+}
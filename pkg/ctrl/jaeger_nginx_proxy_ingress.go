@@ -0,0 +1,295 @@
+package ctrl
+
+import (
+	context "context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+const (
+	IngressKindIngress = "Ingress"
+	IngressKindGateway = "Gateway"
+
+	PortProtocolHTTP = "http"
+	PortProtocolGRPC = "grpc"
+)
+
+var (
+	httpRouteGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+	grpcRouteGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "GRPCRoute"}
+)
+
+// PortProtocol returns the effective protocol of a port
+func PortProtocol(port JaegerNginxProxyV1alpha0.Port) string {
+	if port.Protocol != "" {
+		return port.Protocol
+	}
+	if port.Name == PortProtocolGRPC {
+		return PortProtocolGRPC
+	}
+	return PortProtocolHTTP
+}
+
+// ingressKind returns the effective kind of spec.ingress, or "" when the proxy is not exposed
+func ingressKind(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) string {
+	if nginxProxy.Spec.Ingress == nil || !nginxProxy.Spec.Ingress.Enabled {
+		return ""
+	}
+	if nginxProxy.Spec.Ingress.Kind == "" {
+		return IngressKindIngress
+	}
+	return nginxProxy.Spec.Ingress.Kind
+}
+
+// ingressURL returns the URL clients outside the cluster should use, or "" when the proxy is not exposed
+func ingressURL(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) string {
+	if ingressKind(nginxProxy) == "" {
+		return ""
+	}
+	scheme := "http"
+	if nginxProxy.Spec.Ingress.TLSSecretName != "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, nginxProxy.Spec.Ingress.Host)
+}
+
+func buildIngress(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *networkingv1.Ingress {
+	spec := nginxProxy.Spec.Ingress
+	pathType := networkingv1.PathTypePrefix
+
+	var paths []networkingv1.HTTPIngressPath
+	for _, port := range nginxProxy.Spec.Ports {
+		paths = append(paths, networkingv1.HTTPIngressPath{
+			Path:     port.Path,
+			PathType: &pathType,
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: nginxProxy.Name,
+					Port: networkingv1.ServiceBackendPort{Name: "http"},
+				},
+			},
+		})
+	}
+
+	ing := &networkingv1.Ingress{
+		ObjectMeta: childObjectMeta(nginxProxy, spec.Annotations),
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: spec.Host,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+				},
+			}},
+		},
+	}
+	if spec.ClassName != "" {
+		className := spec.ClassName
+		ing.Spec.IngressClassName = &className
+	}
+	if spec.TLSSecretName != "" {
+		ing.Spec.TLS = []networkingv1.IngressTLS{{
+			Hosts:      []string{spec.Host},
+			SecretName: spec.TLSSecretName,
+		}}
+	}
+	return ing
+}
+
+// buildRoute renders the Gateway API route of the given kind for all ports with a matching protocol.
+// It returns nil when no port uses the protocol served by that route kind.
+func buildRoute(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	spec := nginxProxy.Spec.Ingress
+	backendRefs := []interface{}{map[string]interface{}{
+		"name": nginxProxy.Name,
+		"port": int64(nginxProxy.Spec.ContainerPort),
+	}}
+
+	var rules []interface{}
+	for _, port := range nginxProxy.Spec.Ports {
+		var match map[string]interface{}
+		switch {
+		case gvk == grpcRouteGVK && PortProtocol(port) == PortProtocolGRPC:
+			service, method, _ := strings.Cut(strings.Trim(port.Path, "/"), "/")
+			grpcMethod := map[string]interface{}{"service": service}
+			if method != "" {
+				grpcMethod["method"] = method
+			}
+			match = map[string]interface{}{"method": grpcMethod}
+		case gvk == httpRouteGVK && PortProtocol(port) == PortProtocolHTTP:
+			match = map[string]interface{}{
+				"path": map[string]interface{}{"type": "PathPrefix", "value": port.Path},
+			}
+		default:
+			continue
+		}
+		rules = append(rules, map[string]interface{}{
+			"matches":     []interface{}{match},
+			"backendRefs": backendRefs,
+		})
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	parentRef := map[string]interface{}{}
+	if spec.Gateway != nil {
+		parentRef["name"] = spec.Gateway.Name
+		if spec.Gateway.Namespace != "" {
+			parentRef["namespace"] = spec.Gateway.Namespace
+		}
+		if spec.Gateway.SectionName != "" {
+			parentRef["sectionName"] = spec.Gateway.SectionName
+		}
+	}
+
+	meta := childObjectMeta(nginxProxy, spec.Annotations)
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(gvk)
+	route.SetName(meta.Name)
+	route.SetNamespace(meta.Namespace)
+	route.SetLabels(meta.Labels)
+	route.SetAnnotations(meta.Annotations)
+	route.Object["spec"] = map[string]interface{}{
+		"parentRefs": []interface{}{parentRef},
+		"hostnames":  []interface{}{spec.Host},
+		"rules":      rules,
+	}
+	return route
+}
+
+// reconcileIngress converges the Ingress or Gateway API routes with spec.ingress and removes
+// whichever of them is no longer wanted
+func (r *JaegerNginxProxyReconciler) reconcileIngress(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (bool, error) {
+	kind := ingressKind(nginxProxy)
+
+	if kind == IngressKindIngress {
+		if requeue, err := r.reconcileIngressObject(ctx, nginxProxy); err != nil || requeue {
+			return requeue, err
+		}
+	} else if err := r.deleteIfControlled(ctx, nginxProxy, &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace}}); err != nil {
+		return false, err
+	}
+
+	for _, gvk := range []schema.GroupVersionKind{httpRouteGVK, grpcRouteGVK} {
+		var desired *unstructured.Unstructured
+		if kind == IngressKindGateway {
+			desired = buildRoute(nginxProxy, gvk)
+		}
+		if desired == nil {
+			existing := &unstructured.Unstructured{}
+			existing.SetGroupVersionKind(gvk)
+			existing.SetName(nginxProxy.Name)
+			existing.SetNamespace(nginxProxy.Namespace)
+			if err := r.deleteIfControlled(ctx, nginxProxy, existing); err != nil && !meta.IsNoMatchError(err) {
+				return false, err
+			}
+			continue
+		}
+		if requeue, err := r.reconcileRoute(ctx, nginxProxy, desired); err != nil || requeue {
+			return requeue, err
+		}
+	}
+	return false, nil
+}
+
+func (r *JaegerNginxProxyReconciler) reconcileIngressObject(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (bool, error) {
+	ing := buildIngress(nginxProxy)
+	if err := ctrl.SetControllerReference(nginxProxy, ing, r.Scheme); err != nil {
+		return false, err
+	}
+
+	var existing networkingv1.Ingress
+	if err := r.Get(ctx, client.ObjectKeyFromObject(ing), &existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		log.Info().Msgf("Creating Ingress for JaegerNginxProxy: %s %s", ing.Name, ing.Namespace)
+		return false, r.createChild(ctx, nginxProxy, ing)
+	}
+	if !metav1.IsControlledBy(&existing, nginxProxy) {
+		return false, r.notControlledError(nginxProxy, &existing)
+	}
+
+	metadataChanged := convergeMetadata(&existing, ing)
+	if !metadataChanged && equality.Semantic.DeepDerivative(ing.Spec, existing.Spec) {
+		log.Debug().Msgf("Ingress is up to date: %s %s", ing.Name, ing.Namespace)
+		return false, nil
+	}
+
+	log.Info().Msgf("Ingress changed, updating: %s %s", ing.Name, ing.Namespace)
+	existing.Spec = ing.Spec
+	return r.updateChild(ctx, nginxProxy, &existing)
+}
+
+func (r *JaegerNginxProxyReconciler) reconcileRoute(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, route *unstructured.Unstructured) (bool, error) {
+	gvk := route.GroupVersionKind()
+	if err := ctrl.SetControllerReference(nginxProxy, route, r.Scheme); err != nil {
+		return false, err
+	}
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(gvk)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(route), existing); err != nil {
+		if meta.IsNoMatchError(err) {
+			return false, fmt.Errorf("%s is not served by the cluster, install the Gateway API CRDs: %w", gvk.Kind, err)
+		}
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		log.Info().Msgf("Creating %s for JaegerNginxProxy: %s %s", gvk.Kind, route.GetName(), route.GetNamespace())
+		return false, r.createChild(ctx, nginxProxy, route)
+	}
+	if !metav1.IsControlledBy(existing, nginxProxy) {
+		return false, r.notControlledError(nginxProxy, existing)
+	}
+
+	// The API server fills in defaults (group, kind, weight, ...), so only compare what we render
+	metadataChanged := convergeMetadata(existing, route)
+	if !metadataChanged && equality.Semantic.DeepDerivative(route.Object["spec"], existing.Object["spec"]) {
+		log.Debug().Msgf("%s is up to date: %s %s", gvk.Kind, route.GetName(), route.GetNamespace())
+		return false, nil
+	}
+
+	log.Info().Msgf("%s changed, updating: %s %s", gvk.Kind, route.GetName(), route.GetNamespace())
+	existing.Object["spec"] = route.Object["spec"]
+	return r.updateChild(ctx, nginxProxy, existing)
+}
+
+// deleteIfControlled deletes obj when it exists and is controlled by nginxProxy. Objects with the proxy's
+// name the controller did not create are never touched.
+func (r *JaegerNginxProxyReconciler) deleteIfControlled(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, obj client.Object) error {
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	gvk, _ := apiutil.GVKForObject(obj, r.Scheme)
+	if !metav1.IsControlledBy(obj, nginxProxy) {
+		log.Debug().Msgf("Not deleting %s %s %s, it is not controlled by JaegerNginxProxy", gvk.Kind, obj.GetName(), obj.GetNamespace())
+		return nil
+	}
+	log.Info().Msgf("Deleting %s no longer wanted by JaegerNginxProxy: %s %s", gvk.Kind, obj.GetName(), obj.GetNamespace())
+	return r.deleteIfExists(ctx, obj)
+}
+
+// deleteIfExists deletes obj and treats a missing object as success
+func (r *JaegerNginxProxyReconciler) deleteIfExists(ctx context.Context, obj client.Object) error {
+	if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
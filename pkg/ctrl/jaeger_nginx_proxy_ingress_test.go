@@ -0,0 +1,157 @@
+package ctrl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func TestPortProtocol(t *testing.T) {
+	assert.Equal(t, PortProtocolHTTP, PortProtocol(JaegerNginxProxyV1alpha0.Port{Name: "http"}))
+	assert.Equal(t, PortProtocolGRPC, PortProtocol(JaegerNginxProxyV1alpha0.Port{Name: "grpc"}))
+	assert.Equal(t, PortProtocolGRPC, PortProtocol(JaegerNginxProxyV1alpha0.Port{Name: "otlp", Protocol: "grpc"}))
+	assert.Equal(t, PortProtocolHTTP, PortProtocol(JaegerNginxProxyV1alpha0.Port{Name: "grpc", Protocol: "http"}))
+}
+
+func TestIngressURL(t *testing.T) {
+	nginxProxy := newTestProxy()
+	assert.Equal(t, "", ingressURL(nginxProxy), "no URL without spec.ingress")
+
+	nginxProxy.Spec.Ingress = &JaegerNginxProxyV1alpha0.Ingress{Enabled: false, Host: "traces.example.com"}
+	assert.Equal(t, "", ingressURL(nginxProxy), "no URL when ingress is disabled")
+
+	nginxProxy.Spec.Ingress.Enabled = true
+	assert.Equal(t, "http://traces.example.com", ingressURL(nginxProxy))
+
+	nginxProxy.Spec.Ingress.TLSSecretName = "traces-tls"
+	assert.Equal(t, "https://traces.example.com", ingressURL(nginxProxy))
+}
+
+func TestBuildIngress(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Ingress = &JaegerNginxProxyV1alpha0.Ingress{
+		Enabled:       true,
+		Host:          "traces.example.com",
+		TLSSecretName: "traces-tls",
+		ClassName:     "nginx",
+		Annotations:   map[string]string{"nginx.ingress.kubernetes.io/proxy-body-size": "100m"},
+	}
+
+	ing := buildIngress(nginxProxy)
+
+	assert.Equal(t, "test-proxy", ing.Name)
+	assert.Equal(t, "100m", ing.Annotations["nginx.ingress.kubernetes.io/proxy-body-size"])
+	require.NotNil(t, ing.Spec.IngressClassName)
+	assert.Equal(t, "nginx", *ing.Spec.IngressClassName)
+	require.Len(t, ing.Spec.TLS, 1)
+	assert.Equal(t, []string{"traces.example.com"}, ing.Spec.TLS[0].Hosts)
+	assert.Equal(t, "traces-tls", ing.Spec.TLS[0].SecretName)
+
+	require.Len(t, ing.Spec.Rules, 1)
+	assert.Equal(t, "traces.example.com", ing.Spec.Rules[0].Host)
+	paths := ing.Spec.Rules[0].HTTP.Paths
+	require.Len(t, paths, 2)
+	assert.Equal(t, "/api/traces", paths[0].Path)
+	assert.Equal(t, networkingv1.PathTypePrefix, *paths[0].PathType)
+	assert.Equal(t, "test-proxy", paths[0].Backend.Service.Name)
+	assert.Equal(t, "/jaeger.api.v2.CollectorService/PostSpans", paths[1].Path)
+}
+
+func TestBuildRoute(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Ingress = &JaegerNginxProxyV1alpha0.Ingress{
+		Enabled: true,
+		Kind:    IngressKindGateway,
+		Host:    "traces.example.com",
+		Gateway: &JaegerNginxProxyV1alpha0.GatewayRef{Name: "public", Namespace: "gateways", SectionName: "https"},
+	}
+
+	httpRoute := buildRoute(nginxProxy, httpRouteGVK)
+	require.NotNil(t, httpRoute)
+	assert.Equal(t, "HTTPRoute", httpRoute.GetKind())
+	parentRefs, _, _ := unstructured.NestedSlice(httpRoute.Object, "spec", "parentRefs")
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "public", "namespace": "gateways", "sectionName": "https"}}, parentRefs)
+	rules, _, _ := unstructured.NestedSlice(httpRoute.Object, "spec", "rules")
+	require.Len(t, rules, 1, "only the http port is routed by the HTTPRoute")
+	path, _, _ := unstructured.NestedMap(rules[0].(map[string]interface{})["matches"].([]interface{})[0].(map[string]interface{}), "path")
+	assert.Equal(t, "/api/traces", path["value"])
+
+	grpcRoute := buildRoute(nginxProxy, grpcRouteGVK)
+	require.NotNil(t, grpcRoute)
+	rules, _, _ = unstructured.NestedSlice(grpcRoute.Object, "spec", "rules")
+	require.Len(t, rules, 1, "only the grpc port is routed by the GRPCRoute")
+	method, _, _ := unstructured.NestedStringMap(rules[0].(map[string]interface{})["matches"].([]interface{})[0].(map[string]interface{}), "method")
+	assert.Equal(t, map[string]string{"service": "jaeger.api.v2.CollectorService", "method": "PostSpans"}, method)
+
+	nginxProxy.Spec.Ports = nginxProxy.Spec.Ports[:1]
+	assert.Nil(t, buildRoute(nginxProxy, grpcRouteGVK), "no GRPCRoute without grpc ports")
+}
+
+func TestReconcileIngressDeletesOnlyOwnedObjects(t *testing.T) {
+	ctx := context.Background()
+	nginxProxy := newTestProxy()
+	userIngress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace}}
+	userRoute := &unstructured.Unstructured{}
+	userRoute.SetGroupVersionKind(httpRouteGVK)
+	userRoute.SetName(nginxProxy.Name)
+	userRoute.SetNamespace(nginxProxy.Namespace)
+	r := newMetricsTestReconciler(t, nginxProxy, userIngress, userRoute)
+	key := client.ObjectKeyFromObject(nginxProxy)
+
+	// Same-named objects the user created survive a proxy without spec.ingress
+	reconcileProxy(t, r, key, nil)
+	require.NoError(t, r.Get(ctx, key, &networkingv1.Ingress{}))
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	require.NoError(t, r.Get(ctx, key, route))
+
+	// Routes the controller created are removed once spec.ingress is disabled
+	require.NoError(t, r.Delete(ctx, route))
+	reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+		p.Spec.Ingress = &JaegerNginxProxyV1alpha0.Ingress{Enabled: true, Kind: IngressKindGateway, Host: "traces.example.com"}
+	})
+	require.NoError(t, r.Get(ctx, key, route))
+	assert.True(t, metav1.IsControlledBy(route, nginxProxy))
+	reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) { p.Spec.Ingress.Enabled = false })
+	assert.True(t, errors.IsNotFound(r.Get(ctx, key, route)))
+	require.NoError(t, r.Get(ctx, key, &networkingv1.Ingress{}), "the user's Ingress is kept")
+}
+
+func TestReconcileIngressRefusesUncontrolledObjects(t *testing.T) {
+	ctx := context.Background()
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Ingress = &JaegerNginxProxyV1alpha0.Ingress{Enabled: true, Host: "traces.example.com"}
+	userIngress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace}}
+	userRoute := &unstructured.Unstructured{}
+	userRoute.SetGroupVersionKind(httpRouteGVK)
+	userRoute.SetName(nginxProxy.Name)
+	userRoute.SetNamespace(nginxProxy.Namespace)
+	r := newMetricsTestReconciler(t, nginxProxy, userIngress, userRoute)
+	recorder := r.Recorder.(*record.FakeRecorder)
+
+	_, err := r.reconcileIngressObject(ctx, nginxProxy)
+	assert.ErrorContains(t, err, "Ingress test-proxy already exists and is not controlled by JaegerNginxProxy test-proxy")
+	var ingress networkingv1.Ingress
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(userIngress), &ingress))
+	assert.Empty(t, ingress.Spec.Rules, "the user's Ingress is not overwritten")
+
+	nginxProxy.Spec.Ingress.Kind = IngressKindGateway
+	_, err = r.reconcileRoute(ctx, nginxProxy, buildRoute(nginxProxy, httpRouteGVK))
+	assert.ErrorContains(t, err, "HTTPRoute test-proxy already exists")
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(userRoute), route))
+	assert.Nil(t, route.Object["spec"], "the user's HTTPRoute is not overwritten")
+
+	assert.Len(t, eventsWithReason(drainEvents(recorder), ReasonResourceExists), 2)
+}
@@ -0,0 +1,172 @@
+package ctrl
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+const (
+	AppNameLabel   = "app.kubernetes.io/name"
+	InstanceLabel  = "app.kubernetes.io/instance"
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	VersionLabel   = "app.kubernetes.io/version"
+
+	AppName        = "jaeger-nginx-proxy"
+	ControllerName = "jaeger-nginx-proxy-controller"
+
+	// The managed-* annotations remember which keys the controller set, so that keys removed from the
+	// spec can be pruned while keys added by other controllers are left alone
+	managedLabelsAnnotation         = "jaeger-nginx-proxy.platform-engineer.stream/managed-labels"
+	managedAnnotationsAnnotation    = "jaeger-nginx-proxy.platform-engineer.stream/managed-annotations"
+	managedPodLabelsAnnotation      = "jaeger-nginx-proxy.platform-engineer.stream/managed-pod-labels"
+	managedPodAnnotationsAnnotation = "jaeger-nginx-proxy.platform-engineer.stream/managed-pod-annotations"
+)
+
+// selectorLabels are the immutable labels used by the Deployment selector, Service and NetworkPolicy
+func selectorLabels(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) map[string]string {
+	return map[string]string{"app": nginxProxy.Name}
+}
+
+// standardLabels returns the selector labels plus the recommended app.kubernetes.io labels
+func standardLabels(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) map[string]string {
+	labels := selectorLabels(nginxProxy)
+	labels[AppNameLabel] = AppName
+	labels[InstanceLabel] = nginxProxy.Name
+	labels[ManagedByLabel] = ControllerName
+	if version := labelValue(nginxProxy.Spec.Image.Tag); version != "" {
+		labels[VersionLabel] = version
+	}
+	return labels
+}
+
+// labelValue shortens an image tag into a valid label value, or returns "" when that is not possible
+func labelValue(value string) string {
+	if len(value) > validation.LabelValueMaxLength {
+		value = value[:validation.LabelValueMaxLength]
+	}
+	value = strings.TrimRight(value, "-_.")
+	if len(validation.IsValidLabelValue(value)) > 0 {
+		return ""
+	}
+	return value
+}
+
+// objectLabels returns the labels of every child resource; standard labels win over common labels
+func objectLabels(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) map[string]string {
+	return mergeMaps(nginxProxy.Spec.CommonLabels, standardLabels(nginxProxy))
+}
+
+// podLabels returns the labels of the proxy pod template
+func podLabels(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) map[string]string {
+	return mergeMaps(nginxProxy.Spec.CommonLabels, nginxProxy.Spec.PodLabels, standardLabels(nginxProxy))
+}
+
+// podAnnotations returns the annotations of the proxy pod template
+func podAnnotations(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) map[string]string {
+	return mergeMaps(nginxProxy.Spec.CommonAnnotations, nginxProxy.Spec.PodAnnotations)
+}
+
+// childObjectMeta returns the metadata of a child resource named after the proxy. extraAnnotations
+// (e.g. spec.ingress.annotations) take precedence over spec.commonAnnotations.
+func childObjectMeta(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, extraAnnotations map[string]string) metav1.ObjectMeta {
+	labels := objectLabels(nginxProxy)
+	annotations := mergeMaps(nginxProxy.Spec.CommonAnnotations, extraAnnotations)
+	annotations = mergeMaps(annotations, map[string]string{
+		managedLabelsAnnotation:      managedKeys(labels),
+		managedAnnotationsAnnotation: managedKeys(annotations),
+	})
+	return metav1.ObjectMeta{
+		Name:        nginxProxy.Name,
+		Namespace:   nginxProxy.Namespace,
+		Labels:      labels,
+		Annotations: annotations,
+	}
+}
+
+// convergeMetadata applies the labels and annotations of desired to existing. Keys the controller set
+// before but no longer wants are removed, keys set by anybody else are kept. It returns true when
+// existing was modified.
+func convergeMetadata(existing, desired metav1.Object) bool {
+	previous := existing.GetAnnotations()
+	labels, labelsChanged := mergeManaged(existing.GetLabels(), desired.GetLabels(), previous[managedLabelsAnnotation])
+	annotations, annotationsChanged := mergeManaged(previous, desired.GetAnnotations(), previous[managedAnnotationsAnnotation])
+	existing.SetLabels(labels)
+	existing.SetAnnotations(annotations)
+	return labelsChanged || annotationsChanged
+}
+
+// convergePodTemplateMetadata is convergeMetadata for the pod template of a Deployment. The managed keys
+// are remembered on the Deployment itself so they do not end up on every pod, and annotations added by
+// e.g. "kubectl rollout restart" survive.
+func convergePodTemplateMetadata(existing, desired *appsv1.Deployment) bool {
+	previous := existing.GetAnnotations()
+	labels, labelsChanged := mergeManaged(existing.Spec.Template.Labels, desired.Spec.Template.Labels, previous[managedPodLabelsAnnotation])
+	annotations, annotationsChanged := mergeManaged(existing.Spec.Template.Annotations, desired.Spec.Template.Annotations, previous[managedPodAnnotationsAnnotation])
+	existing.Spec.Template.Labels = labels
+	existing.Spec.Template.Annotations = annotations
+	return labelsChanged || annotationsChanged
+}
+
+// withPodTemplateTracking records the managed pod template keys on the Deployment
+func withPodTemplateTracking(dep *appsv1.Deployment) *appsv1.Deployment {
+	dep.Annotations = mergeMaps(dep.Annotations, map[string]string{
+		managedPodLabelsAnnotation:      managedKeys(dep.Spec.Template.Labels),
+		managedPodAnnotationsAnnotation: managedKeys(dep.Spec.Template.Annotations),
+	})
+	return dep
+}
+
+// mergeManaged returns current with desired applied and previously managed keys that are no longer
+// desired removed, plus whether the result differs from current
+func mergeManaged(current, desired map[string]string, previouslyManaged string) (map[string]string, bool) {
+	result := make(map[string]string, len(current)+len(desired))
+	for k, v := range current {
+		result[k] = v
+	}
+	for _, k := range strings.Split(previouslyManaged, ",") {
+		if _, ok := desired[k]; k != "" && !ok {
+			delete(result, k)
+		}
+	}
+	for k, v := range desired {
+		result[k] = v
+	}
+	if len(result) == 0 {
+		return nil, len(current) != 0
+	}
+	return result, !reflect.DeepEqual(current, result)
+}
+
+// mergeMaps merges maps left to right, later maps win
+func mergeMaps(maps ...map[string]string) map[string]string {
+	result := map[string]string{}
+	for _, m := range maps {
+		for k, v := range m {
+			result[k] = v
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+// managedKeys returns the sorted, comma separated keys of m without the tracking annotations themselves
+func managedKeys(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		if strings.HasPrefix(k, "jaeger-nginx-proxy.platform-engineer.stream/managed-") {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,88 @@
+package ctrl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestChildObjectMetaLabels(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.CommonLabels = map[string]string{"team": "observability", "app": "ignored"}
+	nginxProxy.Spec.CommonAnnotations = map[string]string{"owner": "tracing@example.com"}
+
+	meta := childObjectMeta(nginxProxy, map[string]string{"owner": "override"})
+
+	assert.Equal(t, "observability", meta.Labels["team"])
+	assert.Equal(t, "test-proxy", meta.Labels["app"], "standard labels win over common labels")
+	assert.Equal(t, AppName, meta.Labels[AppNameLabel])
+	assert.Equal(t, "test-proxy", meta.Labels[InstanceLabel])
+	assert.Equal(t, ControllerName, meta.Labels[ManagedByLabel])
+	assert.Equal(t, "1.28.0", meta.Labels[VersionLabel])
+	assert.Equal(t, "override", meta.Annotations["owner"], "resource specific annotations win over common annotations")
+	assert.Equal(t, "owner", meta.Annotations[managedAnnotationsAnnotation])
+}
+
+func TestLabelValue(t *testing.T) {
+	assert.Equal(t, "1.28.0-alpine", labelValue("1.28.0-alpine"))
+	assert.Equal(t, "", labelValue("1.28@sha256"))
+	assert.Len(t, labelValue(strings.Repeat("a", 100)), 63)
+}
+
+func TestBuildDeploymentPodMetadata(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.CommonLabels = map[string]string{"team": "observability"}
+	nginxProxy.Spec.PodLabels = map[string]string{"sidecar.istio.io/inject": "false"}
+	nginxProxy.Spec.PodAnnotations = map[string]string{"prometheus.io/scrape": "true"}
+
+	dep := buildDeployment(nginxProxy)
+
+	assert.Equal(t, map[string]string{"app": "test-proxy"}, dep.Spec.Selector.MatchLabels, "selector stays immutable")
+	assert.Equal(t, "observability", dep.Labels["team"])
+	assert.Empty(t, dep.Labels["sidecar.istio.io/inject"], "pod labels are not set on the Deployment")
+	assert.Equal(t, "observability", dep.Spec.Template.Labels["team"])
+	assert.Equal(t, "false", dep.Spec.Template.Labels["sidecar.istio.io/inject"])
+	assert.Equal(t, "true", dep.Spec.Template.Annotations["prometheus.io/scrape"])
+	assert.Equal(t, "prometheus.io/scrape", dep.Annotations[managedPodAnnotationsAnnotation])
+	assert.NotContains(t, dep.Spec.Template.Annotations, managedPodAnnotationsAnnotation)
+}
+
+func TestConvergeMetadata(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.CommonLabels = map[string]string{"team": "observability", "cost-center": "42"}
+	existing := &corev1.ConfigMap{ObjectMeta: childObjectMeta(nginxProxy, nil)}
+
+	// Another controller adds its own label and annotation
+	existing.Labels["other-controller/owned"] = "yes"
+	existing.Annotations["other-controller/note"] = "keep me"
+
+	assert.False(t, convergeMetadata(existing, &corev1.ConfigMap{ObjectMeta: childObjectMeta(nginxProxy, nil)}), "steady state is a no-op")
+
+	// cost-center is removed from the spec, team changes
+	nginxProxy.Spec.CommonLabels = map[string]string{"team": "platform"}
+	assert.True(t, convergeMetadata(existing, &corev1.ConfigMap{ObjectMeta: childObjectMeta(nginxProxy, nil)}))
+
+	assert.Equal(t, "platform", existing.Labels["team"])
+	assert.NotContains(t, existing.Labels, "cost-center", "labels the controller set before are pruned")
+	assert.Equal(t, "yes", existing.Labels["other-controller/owned"], "foreign labels are kept")
+	assert.Equal(t, "keep me", existing.Annotations["other-controller/note"], "foreign annotations are kept")
+}
+
+func TestConvergePodTemplateMetadata(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.PodAnnotations = map[string]string{"prometheus.io/scrape": "true"}
+	existing := buildDeployment(nginxProxy)
+	existing.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = "2025-01-01T00:00:00Z"
+
+	nginxProxy.Spec.PodAnnotations = nil
+	desired := buildDeployment(nginxProxy)
+	assert.True(t, convergePodTemplateMetadata(existing, desired))
+	convergeMetadata(existing, desired)
+
+	assert.NotContains(t, existing.Spec.Template.Annotations, "prometheus.io/scrape")
+	assert.Equal(t, "2025-01-01T00:00:00Z", existing.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"])
+	assert.Equal(t, "", existing.Annotations[managedPodAnnotationsAnnotation])
+	assert.False(t, convergePodTemplateMetadata(existing, desired), "steady state is a no-op")
+}
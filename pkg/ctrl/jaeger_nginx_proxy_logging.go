@@ -0,0 +1,170 @@
+package ctrl
+
+import (
+	"fmt"
+	"strings"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+const (
+	LogFormatCustom   = "custom"
+	LogFormatJSON     = "json"
+	LogFormatCombined = "combined"
+
+	// LogFieldTenant logs the request header named by spec.logging.tenantHeader
+	LogFieldTenant = "tenant"
+
+	customLogFormat = "custom_format"
+	jsonLogFormat   = "json_format"
+)
+
+// LogFields are the access log fields that can be selected, in the order they are logged
+var LogFields = []string{
+	"time", "remote_addr", "request_method", "uri", "args", "status", "bytes_sent", "request_length",
+	"request_time", "upstream_addr", "upstream_status", "upstream_response_time", "referer", "user_agent",
+	"forwarded_for", "request_id", LogFieldTenant,
+}
+
+// DefaultJSONLogFields are logged by the json format when no fields are selected, the fields of the
+// custom format
+var DefaultJSONLogFields = []string{
+	"time", "remote_addr", "request_method", "uri", "args", "status", "bytes_sent", "referer",
+	"user_agent", "forwarded_for",
+}
+
+var logFieldVariables = map[string]string{
+	"time":                   "$time_iso8601",
+	"remote_addr":            "$remote_addr",
+	"request_method":         "$request_method",
+	"uri":                    "$uri",
+	"args":                   "$args",
+	"status":                 "$status",
+	"bytes_sent":             "$body_bytes_sent",
+	"request_length":         "$request_length",
+	"request_time":           "$request_time",
+	"upstream_addr":          "$upstream_addr",
+	"upstream_status":        "$upstream_status",
+	"upstream_response_time": "$upstream_response_time",
+	"referer":                "$http_referer",
+	"user_agent":             "$http_user_agent",
+	"forwarded_for":          "$http_x_forwarded_for",
+	"request_id":             "$request_id",
+}
+
+// numericLogFields are always numbers and logged unquoted by the json format. Upstream fields are lists
+// when several upstreams were tried and "-" when none was.
+var numericLogFields = map[string]bool{
+	"status": true, "bytes_sent": true, "request_length": true, "request_time": true,
+}
+
+// logFieldVariable returns the nginx variable of a log field
+func logFieldVariable(logging *JaegerNginxProxyV1alpha0.Logging, name string) string {
+	if name == LogFieldTenant {
+		return "$http_" + strings.ReplaceAll(strings.ToLower(logging.TenantHeader), "-", "_")
+	}
+	return logFieldVariables[name]
+}
+
+func proxyLogging(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *JaegerNginxProxyV1alpha0.Logging {
+	if nginxProxy.Spec.Logging == nil {
+		return &JaegerNginxProxyV1alpha0.Logging{}
+	}
+	return nginxProxy.Spec.Logging
+}
+
+// writeLogFormat defines the log format selected by spec.logging, combined is built into nginx
+func (b *configBuilder) writeLogFormat(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+	logging := proxyLogging(nginxProxy)
+	switch logging.Format {
+	case LogFormatCombined:
+		return
+	case LogFormatJSON:
+		fields := logging.Fields
+		if len(fields) == 0 {
+			fields = DefaultJSONLogFields
+		}
+		b.Writef("spec.logging.format", "log_format %s escape=json '{'\n", jsonLogFormat)
+		for i, name := range fields {
+			value := fmt.Sprintf(`"%s"`, logFieldVariable(logging, name))
+			if numericLogFields[name] {
+				value = logFieldVariable(logging, name)
+			}
+			separator := ","
+			if i == len(fields)-1 {
+				separator = ""
+			}
+			b.Writef(fmt.Sprintf("spec.logging.fields[%d]", i), "                             '\"%s\":%s%s'\n", name, value, separator)
+		}
+		b.Writef("spec.logging.format", "                             '}';\n\n")
+		return
+	}
+
+	// The custom format predates spec.logging, selected fields are appended to it
+	b.WriteString("log_format custom_format '$remote_addr - $remote_user [$time_local] '\n")
+	b.WriteString("                             '\"$request\" \"args=$args\" \"q=$query_string\" '\n")
+	b.WriteString("                             '\"url=$uri\" \"status=$status\" '\n")
+	b.WriteString("                             '\"bytes=$body_bytes_sent\" \"ref=$http_referer\" '\n")
+	if len(logging.Fields) == 0 {
+		b.WriteString("                             '\"agent=$http_user_agent\" \"$http_x_forwarded_for\" ';\n\n")
+		return
+	}
+	b.WriteString("                             '\"agent=$http_user_agent\" \"$http_x_forwarded_for\" '\n")
+	for i, name := range logging.Fields {
+		end := "\n"
+		if i == len(logging.Fields)-1 {
+			end = ";\n\n"
+		}
+		b.Writef(fmt.Sprintf("spec.logging.fields[%d]", i), "                             '\"%s=%s\" '%s", name, logFieldVariable(logging, name), end)
+	}
+}
+
+// writeLogSampling logs every failed request but only the sampled share of successful ones. Requests
+// are sampled by their random request id. Keys are quoted so that they are not taken for directives.
+func (b *configBuilder) writeLogSampling(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+	percent := proxyLogging(nginxProxy).SuccessSamplePercent
+	if percent == nil || *percent >= 100 {
+		return
+	}
+	sampled := "0"
+	if *percent > 0 {
+		sampled = "$jaeger_log_sample"
+		b.Writef("spec.logging.successSamplePercent", "split_clients $request_id $jaeger_log_sample {\n")
+		b.Writef("spec.logging.successSamplePercent", "  \"%d%%\" 1;\n", *percent)
+		b.Writef("spec.logging.successSamplePercent", "  \"*\" 0;\n")
+		b.Writef("spec.logging.successSamplePercent", "}\n\n")
+	}
+	b.Writef("spec.logging.successSamplePercent", "map $status $jaeger_log {\n")
+	b.Writef("spec.logging.successSamplePercent", "  \"~^[23]\" %s;\n", sampled)
+	b.Writef("spec.logging.successSamplePercent", "  default 1;\n")
+	b.Writef("spec.logging.successSamplePercent", "}\n\n")
+}
+
+// writeAccessLog writes the access_log directive of the server block
+func (b *configBuilder) writeAccessLog(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, indent string) {
+	logging := proxyLogging(nginxProxy)
+	if logging.Format == "" && logging.SuccessSamplePercent == nil {
+		b.WriteString(indent + "access_log /dev/stdout custom_format;\n")
+		return
+	}
+
+	format := customLogFormat
+	switch logging.Format {
+	case LogFormatJSON:
+		format = jsonLogFormat
+	case LogFormatCombined:
+		format = LogFormatCombined
+	}
+	condition := ""
+	if percent := logging.SuccessSamplePercent; percent != nil && *percent < 100 {
+		condition = " if=$jaeger_log"
+	}
+	b.Writef("spec.logging.format", "%saccess_log /dev/stdout %s%s;\n", indent, format, condition)
+}
+
+// writePortAccessLog turns off the access log of a port
+func (b *configBuilder) writePortAccessLog(i int, port JaegerNginxProxyV1alpha0.Port, indent string) {
+	if port.AccessLog != nil && !*port.AccessLog {
+		b.Writef(fmt.Sprintf("spec.ports[%d].accessLog", i), "%saccess_log off;\n", indent)
+	}
+}
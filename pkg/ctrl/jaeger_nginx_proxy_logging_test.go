@@ -0,0 +1,80 @@
+package ctrl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func TestRenderNginxConfigLogging(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Logging = &JaegerNginxProxyV1alpha0.Logging{
+		Format:       LogFormatJSON,
+		Fields:       []string{"time", "status", "upstream_addr", "upstream_response_time", "request_length", LogFieldTenant},
+		TenantHeader: "X-Scope-OrgID",
+	}
+
+	config, sources := renderNginxConfig(nginxProxy)
+	assert.True(t, strings.HasPrefix(config, "log_format json_format escape=json '{'\n"+
+		"                             '\"time\":\"$time_iso8601\",'\n"+
+		"                             '\"status\":$status,'\n"+
+		"                             '\"upstream_addr\":\"$upstream_addr\",'\n"+
+		"                             '\"upstream_response_time\":\"$upstream_response_time\",'\n"+
+		"                             '\"request_length\":$request_length,'\n"+
+		"                             '\"tenant\":\"$http_x_scope_orgid\"'\n"+
+		"                             '}';\n"), config)
+	assert.Contains(t, config, "  access_log /dev/stdout json_format;\n")
+	assert.NotContains(t, config, "custom_format")
+	assert.Equal(t, "spec.logging.fields[5]", sources[6])
+
+	_, findings, err := DefaultConfigValidators().ValidateProxyConfig(nginxProxy, "")
+	require.NoError(t, err, "upstream fields are not upstream blocks")
+	assert.Empty(t, findings)
+
+	nginxProxy.Spec.Logging = &JaegerNginxProxyV1alpha0.Logging{Fields: []string{"upstream_addr", "request_time"}}
+	config = GenerateNginxConfig(nginxProxy)
+	assert.Contains(t, config, "'\"agent=$http_user_agent\" \"$http_x_forwarded_for\" '\n"+
+		"                             '\"upstream_addr=$upstream_addr\" '\n"+
+		"                             '\"request_time=$request_time\" ';\n")
+	assert.Contains(t, config, "  access_log /dev/stdout custom_format;\n")
+	_, _, err = DefaultConfigValidators().ValidateProxyConfig(nginxProxy, "")
+	require.NoError(t, err)
+
+	nginxProxy.Spec.Logging = &JaegerNginxProxyV1alpha0.Logging{Format: LogFormatCombined}
+	config = GenerateNginxConfig(nginxProxy)
+	assert.NotContains(t, config, "log_format")
+	assert.Contains(t, config, "  access_log /dev/stdout combined;\n")
+}
+
+func TestRenderNginxConfigLogSampling(t *testing.T) {
+	nginxProxy := newTestProxy()
+	percent := int32(10)
+	nginxProxy.Spec.Logging = &JaegerNginxProxyV1alpha0.Logging{SuccessSamplePercent: &percent}
+	falseValue := false
+	nginxProxy.Spec.Ports[1].AccessLog = &falseValue
+
+	config := GenerateNginxConfig(nginxProxy)
+	assert.Contains(t, config, "split_clients $request_id $jaeger_log_sample {\n  \"10%\" 1;\n  \"*\" 0;\n}\n\n"+
+		"map $status $jaeger_log {\n  \"~^[23]\" $jaeger_log_sample;\n  default 1;\n}\n")
+	assert.Contains(t, config, "  access_log /dev/stdout custom_format if=$jaeger_log;\n")
+	assert.Contains(t, config, "     grpc_pass grpc://jaeger-collector-grpc;\n     access_log off;\n")
+	_, findings, err := DefaultConfigValidators().ValidateProxyConfig(nginxProxy, "")
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+
+	// Templates may use the generated config as a whole
+	_, _, err = DefaultConfigValidators().ValidateProxyConfig(nginxProxy, "{{ .Generated }}")
+	require.NoError(t, err)
+
+	percent = 0
+	config = GenerateNginxConfig(nginxProxy)
+	assert.NotContains(t, config, "split_clients")
+	assert.Contains(t, config, "  \"~^[23]\" 0;\n")
+
+	percent = 100
+	assert.NotContains(t, GenerateNginxConfig(nginxProxy), "jaeger_log")
+}
@@ -0,0 +1,290 @@
+package ctrl
+
+import (
+	context "context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+const (
+	// ExporterContainerName is reserved for the metrics exporter sidecar
+	ExporterContainerName = "exporter"
+	MetricsPortName       = "metrics"
+
+	DefaultMetricsPort       = 9113
+	DefaultMetricsStatusPort = 18080
+	// MetricsSyslogPort is the localhost UDP port nginx sends the metrics log to
+	MetricsSyslogPort = 9514
+	// MetricsSyslogTag marks the metrics log messages sent by nginx
+	MetricsSyslogTag = "jaeger_metrics"
+	// MetricsLogSeparator separates the fields of a metrics log message
+	MetricsLogSeparator = "|"
+	StubStatusPath      = "/stub_status"
+
+	// MetricsComponentLabel tells the metrics Service apart from the proxy Service
+	MetricsComponentLabel = "app.kubernetes.io/component"
+
+	metricsLogFormat = "jaeger_metrics"
+	portVariable     = "$jaeger_port"
+)
+
+// MetricsLogFields are the nginx variables of a metrics log message, in order. Upstream fields are lists
+// when several upstreams were tried and "-" when none was.
+var MetricsLogFields = []string{
+	portVariable, "$status", "$request_time", "$request_length", "$bytes_sent", "$upstream_status", "$upstream_response_time",
+}
+
+var serviceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+
+func metricsEnabled(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) bool {
+	return nginxProxy.Spec.Metrics != nil && nginxProxy.Spec.Metrics.Enabled
+}
+
+func serviceMonitorEnabled(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) bool {
+	return metricsEnabled(nginxProxy) && nginxProxy.Spec.Metrics.ServiceMonitor != nil && nginxProxy.Spec.Metrics.ServiceMonitor.Enabled
+}
+
+// MetricsPorts returns the metrics and stub_status ports with their defaults applied
+func MetricsPorts(metrics *JaegerNginxProxyV1alpha0.Metrics) (port, statusPort int) {
+	port, statusPort = DefaultMetricsPort, DefaultMetricsStatusPort
+	if metrics == nil {
+		return port, statusPort
+	}
+	if metrics.Port != 0 {
+		port = metrics.Port
+	}
+	if metrics.StatusPort != 0 {
+		statusPort = metrics.StatusPort
+	}
+	return port, statusPort
+}
+
+func metricsName(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) string {
+	return nginxProxy.Name + "-metrics"
+}
+
+// metricsLabels select the metrics Service, the proxy Service has no component label
+func metricsLabels(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) map[string]string {
+	return mergeMaps(objectLabels(nginxProxy), map[string]string{MetricsComponentLabel: MetricsPortName})
+}
+
+// metricsAccessLog is the access_log directive sending the metrics log to the exporter
+func metricsAccessLog() string {
+	return fmt.Sprintf("access_log syslog:server=127.0.0.1:%d,tag=%s,nohostname %s;", MetricsSyslogPort, MetricsSyslogTag, metricsLogFormat)
+}
+
+// writeMetricsLogFormat defines the log format of the messages parsed by the exporter
+func (b *configBuilder) writeMetricsLogFormat(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+	if !metricsEnabled(nginxProxy) {
+		return
+	}
+	b.Writef("spec.metrics.enabled", "log_format %s '%s';\n\n", metricsLogFormat, strings.Join(MetricsLogFields, MetricsLogSeparator))
+}
+
+// writeMetricsAccessLog adds the metrics log to the server block. Requests outside the port locations
+// are logged with an empty port.
+func (b *configBuilder) writeMetricsAccessLog(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, indent string) {
+	if !metricsEnabled(nginxProxy) {
+		return
+	}
+	b.Writef("spec.metrics.enabled", "%s%s\n", indent, metricsAccessLog())
+	b.Writef("spec.metrics.enabled", "%sset %s \"\";\n", indent, portVariable)
+}
+
+// writePortMetrics names the port in the metrics log. access_log directives of a location replace the
+// inherited ones, so a port without access log keeps the metrics log.
+func (b *configBuilder) writePortMetrics(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, i int, port JaegerNginxProxyV1alpha0.Port, indent string) {
+	if !metricsEnabled(nginxProxy) {
+		return
+	}
+	b.Writef(fmt.Sprintf("spec.ports[%d].name", i), "%sset %s %s;\n", indent, portVariable, nginxQuote(port.Name))
+	if port.AccessLog != nil && !*port.AccessLog {
+		b.Writef(fmt.Sprintf("spec.ports[%d].accessLog", i), "%s%s\n", indent, metricsAccessLog())
+	}
+}
+
+// writeStatusServer serves stub_status to the exporter, only on localhost
+func (b *configBuilder) writeStatusServer(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+	if !metricsEnabled(nginxProxy) {
+		return
+	}
+	_, statusPort := MetricsPorts(nginxProxy.Spec.Metrics)
+	b.Writef("spec.metrics.enabled", "\nserver {\n")
+	b.Writef("spec.metrics.statusPort", "  listen 127.0.0.1:%d;\n", statusPort)
+	b.Writef("spec.metrics.enabled", "  access_log off;\n\n")
+	b.Writef("spec.metrics.enabled", "  location = %s {\n", StubStatusPath)
+	b.Writef("spec.metrics.enabled", "        stub_status;\n")
+	b.Writef("spec.metrics.enabled", "  }\n")
+	b.Writef("spec.metrics.enabled", "}\n")
+}
+
+// withExporter adds the metrics exporter sidecar to the proxy Deployment
+func withExporter(dep *appsv1.Deployment, image string, metrics *JaegerNginxProxyV1alpha0.Metrics) *appsv1.Deployment {
+	port, statusPort := MetricsPorts(metrics)
+	// The distroless nonroot user, the exporter needs no privileges
+	nonRoot := int64(65532)
+	readOnly := true
+	podSpec := &dep.Spec.Template.Spec
+
+	podSpec.Containers = append(podSpec.Containers, corev1.Container{
+		Name:  ExporterContainerName,
+		Image: image,
+		Args: []string{
+			"exporter",
+			"--listen", fmt.Sprintf(":%d", port),
+			"--status-url", fmt.Sprintf("http://127.0.0.1:%d%s", statusPort, StubStatusPath),
+			"--syslog-listen", fmt.Sprintf("127.0.0.1:%d", MetricsSyslogPort),
+		},
+		Ports: []corev1.ContainerPort{{Name: MetricsPortName, ContainerPort: int32(port), Protocol: corev1.ProtocolTCP}},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:              &nonRoot,
+			ReadOnlyRootFilesystem: &readOnly,
+			Capabilities:           &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		},
+	})
+	return dep
+}
+
+func buildMetricsService(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *corev1.Service {
+	port, _ := MetricsPorts(nginxProxy.Spec.Metrics)
+	meta := childObjectMeta(nginxProxy, nil)
+	meta.Name = metricsName(nginxProxy)
+	meta.Labels = metricsLabels(nginxProxy)
+	meta.Annotations[managedLabelsAnnotation] = managedKeys(meta.Labels)
+	return &corev1.Service{
+		ObjectMeta: meta,
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: selectorLabels(nginxProxy),
+			Ports: []corev1.ServicePort{{
+				Name:       MetricsPortName,
+				Port:       int32(port),
+				TargetPort: intstr.FromString(MetricsPortName),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+		},
+	}
+}
+
+// buildServiceMonitor renders the Prometheus Operator ServiceMonitor scraping the metrics Service
+func buildServiceMonitor(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *unstructured.Unstructured {
+	spec := nginxProxy.Spec.Metrics.ServiceMonitor
+	meta := childObjectMeta(nginxProxy, nil)
+	labels := mergeMaps(spec.Labels, objectLabels(nginxProxy))
+	meta.Annotations[managedLabelsAnnotation] = managedKeys(labels)
+
+	endpoint := map[string]interface{}{"port": MetricsPortName, "path": "/metrics"}
+	if spec.Interval != "" {
+		endpoint["interval"] = spec.Interval
+	}
+	selector := map[string]interface{}{}
+	for key, value := range metricsLabels(nginxProxy) {
+		selector[key] = value
+	}
+
+	monitor := &unstructured.Unstructured{}
+	monitor.SetGroupVersionKind(serviceMonitorGVK)
+	monitor.SetName(metricsName(nginxProxy))
+	monitor.SetNamespace(meta.Namespace)
+	monitor.SetLabels(labels)
+	monitor.SetAnnotations(meta.Annotations)
+	monitor.Object["spec"] = map[string]interface{}{
+		"selector":  map[string]interface{}{"matchLabels": selector},
+		"endpoints": []interface{}{endpoint},
+	}
+	return monitor
+}
+
+// reconcileMetrics converges the metrics Service and ServiceMonitor with spec.metrics and removes them
+// when disabled. Without the Prometheus Operator CRD the ServiceMonitor is skipped.
+func (r *JaegerNginxProxyReconciler) reconcileMetrics(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (bool, error) {
+	if requeue, err := r.reconcileMetricsService(ctx, nginxProxy); err != nil || requeue {
+		return requeue, err
+	}
+
+	if !serviceMonitorEnabled(nginxProxy) {
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(serviceMonitorGVK)
+		existing.SetName(metricsName(nginxProxy))
+		existing.SetNamespace(nginxProxy.Namespace)
+		if err := r.deleteIfControlled(ctx, nginxProxy, existing); err != nil && !meta.IsNoMatchError(err) {
+			return false, err
+		}
+		return false, nil
+	}
+
+	monitor := buildServiceMonitor(nginxProxy)
+	if err := ctrl.SetControllerReference(nginxProxy, monitor, r.Scheme); err != nil {
+		return false, err
+	}
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(serviceMonitorGVK)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(monitor), existing); err != nil {
+		if meta.IsNoMatchError(err) {
+			log.Debug().Msgf("ServiceMonitor is not served by the cluster, skipping: %s %s", monitor.GetName(), monitor.GetNamespace())
+			return false, nil
+		}
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		log.Info().Msgf("Creating ServiceMonitor for JaegerNginxProxy: %s %s", monitor.GetName(), monitor.GetNamespace())
+		return false, r.createChild(ctx, nginxProxy, monitor)
+	}
+
+	metadataChanged := convergeMetadata(existing, monitor)
+	if !metadataChanged && equality.Semantic.DeepDerivative(monitor.Object["spec"], existing.Object["spec"]) {
+		log.Debug().Msgf("ServiceMonitor is up to date: %s %s", monitor.GetName(), monitor.GetNamespace())
+		return false, nil
+	}
+
+	log.Info().Msgf("ServiceMonitor changed, updating: %s %s", monitor.GetName(), monitor.GetNamespace())
+	existing.Object["spec"] = monitor.Object["spec"]
+	return r.updateChild(ctx, nginxProxy, existing)
+}
+
+func (r *JaegerNginxProxyReconciler) reconcileMetricsService(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (bool, error) {
+	if !metricsEnabled(nginxProxy) {
+		return false, r.deleteIfControlled(ctx, nginxProxy, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: metricsName(nginxProxy), Namespace: nginxProxy.Namespace}})
+	}
+
+	svc := buildMetricsService(nginxProxy)
+	if err := ctrl.SetControllerReference(nginxProxy, svc, r.Scheme); err != nil {
+		return false, err
+	}
+	var existing corev1.Service
+	if err := r.Get(ctx, client.ObjectKeyFromObject(svc), &existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		log.Info().Msgf("Creating metrics Service for JaegerNginxProxy: %s %s", svc.Name, svc.Namespace)
+		return false, r.createChild(ctx, nginxProxy, svc)
+	}
+
+	metadataChanged := convergeMetadata(&existing, svc)
+	if !metadataChanged && equality.Semantic.DeepDerivative(svc.Spec, existing.Spec) {
+		log.Debug().Msgf("Metrics Service is up to date: %s %s", svc.Name, svc.Namespace)
+		return false, nil
+	}
+
+	log.Info().Msgf("Metrics Service changed, updating: %s %s", svc.Name, svc.Namespace)
+	existing.Spec.Selector = svc.Spec.Selector
+	existing.Spec.Ports = svc.Spec.Ports
+	return r.updateChild(ctx, nginxProxy, &existing)
+}
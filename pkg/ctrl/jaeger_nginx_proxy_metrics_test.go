@@ -0,0 +1,180 @@
+package ctrl
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func TestRenderNginxConfigMetrics(t *testing.T) {
+	nginxProxy := newTestProxy()
+	config := GenerateNginxConfig(nginxProxy)
+	assert.NotContains(t, config, "stub_status")
+	assert.NotContains(t, config, "$jaeger_port")
+
+	falseValue := false
+	nginxProxy.Spec.Ports[1].AccessLog = &falseValue
+	nginxProxy.Spec.Metrics = &JaegerNginxProxyV1alpha0.Metrics{Enabled: true, StatusPort: 18081}
+	config, sources := renderNginxConfig(nginxProxy)
+	assert.True(t, strings.HasPrefix(config, "log_format custom_format"), config)
+	assert.Contains(t, config, "log_format jaeger_metrics '$jaeger_port|$status|$request_time|$request_length|$bytes_sent|$upstream_status|$upstream_response_time';\n")
+	assert.Contains(t, config, "  access_log /dev/stdout custom_format;\n"+
+		"  access_log syslog:server=127.0.0.1:9514,tag=jaeger_metrics,nohostname jaeger_metrics;\n"+
+		"  set $jaeger_port \"\";\n")
+	assert.Contains(t, config, "     proxy_pass http://jaeger-collector-http;\n     set $jaeger_port \"http\";\n")
+	assert.Contains(t, config, "     access_log off;\n"+
+		"     set $jaeger_port \"grpc\";\n"+
+		"     access_log syslog:server=127.0.0.1:9514,tag=jaeger_metrics,nohostname jaeger_metrics;\n")
+	assert.True(t, strings.HasSuffix(config, "}\n\nserver {\n"+
+		"  listen 127.0.0.1:18081;\n"+
+		"  access_log off;\n\n"+
+		"  location = /stub_status {\n"+
+		"        stub_status;\n"+
+		"  }\n"+
+		"}\n"), config)
+
+	lines := strings.Split(config, "\n")
+	for i, line := range lines[:len(sources)] {
+		if strings.TrimSpace(line) == "listen 127.0.0.1:18081;" {
+			assert.Equal(t, "spec.metrics.statusPort", sources[i])
+		}
+	}
+
+	_, findings, err := DefaultConfigValidators().ValidateProxyConfig(nginxProxy, "")
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+
+	// Templates may use the generated config with the status endpoint as a whole
+	_, findings, err = DefaultConfigValidators().ValidateProxyConfig(nginxProxy, "{{ .Generated }}")
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+}
+
+func TestWithExporter(t *testing.T) {
+	nginxProxy := newTestProxy()
+	dep := withExporter(buildDeployment(nginxProxy), "ghcr.io/dolv/k8s-controller-tutorial/app:1.0.0", &JaegerNginxProxyV1alpha0.Metrics{Enabled: true, Port: 9200})
+
+	containers := dep.Spec.Template.Spec.Containers
+	require.Len(t, containers, 2)
+	exporter := containers[1]
+	assert.Equal(t, ExporterContainerName, exporter.Name)
+	assert.Equal(t, "ghcr.io/dolv/k8s-controller-tutorial/app:1.0.0", exporter.Image)
+	assert.Equal(t, []string{
+		"exporter", "--listen", ":9200",
+		"--status-url", "http://127.0.0.1:18080/stub_status",
+		"--syslog-listen", "127.0.0.1:9514",
+	}, exporter.Args)
+	require.Len(t, exporter.Ports, 1)
+	assert.Equal(t, MetricsPortName, exporter.Ports[0].Name)
+	assert.Equal(t, int32(9200), exporter.Ports[0].ContainerPort)
+	assert.Empty(t, exporter.VolumeMounts, "the exporter reads nothing from disk")
+}
+
+// newMetricsTestReconciler returns a reconciler whose cluster serves the ServiceMonitor and Gateway API
+// CRDs and holds nginxProxy and objs
+func newMetricsTestReconciler(t *testing.T, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, objs ...client.Object) *JaegerNginxProxyReconciler {
+	t.Helper()
+	s := newTestScheme(t)
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for gvk := range s.AllKnownTypes() {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	for _, gvk := range optionalOwnedKinds {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	c := fake.NewClientBuilder().WithScheme(s).WithRESTMapper(mapper).
+		WithObjects(append(objs, nginxProxy)...).
+		WithStatusSubresource(&JaegerNginxProxyV1alpha0.JaegerNginxProxy{}).
+		Build()
+	return &JaegerNginxProxyReconciler{Client: c, Scheme: s, Recorder: record.NewFakeRecorder(100), ReloaderImage: "controller:1.0.0"}
+}
+
+func TestReconcileMetrics(t *testing.T) {
+	ctx := context.Background()
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Metrics = &JaegerNginxProxyV1alpha0.Metrics{
+		Enabled: true,
+		ServiceMonitor: &JaegerNginxProxyV1alpha0.ServiceMonitor{
+			Enabled:  true,
+			Interval: "30s",
+			Labels:   map[string]string{"release": "prometheus"},
+		},
+	}
+	r := newMetricsTestReconciler(t, nginxProxy)
+	key := client.ObjectKeyFromObject(nginxProxy)
+	metricsKey := client.ObjectKey{Name: nginxProxy.Name + "-metrics", Namespace: nginxProxy.Namespace}
+	reconcileProxy(t, r, key, nil)
+
+	var dep appsv1.Deployment
+	require.NoError(t, r.Get(ctx, key, &dep))
+	require.Len(t, dep.Spec.Template.Spec.Containers, 2)
+	assert.Equal(t, ExporterContainerName, dep.Spec.Template.Spec.Containers[1].Name)
+	assert.Equal(t, "controller:1.0.0", dep.Spec.Template.Spec.Containers[1].Image)
+
+	var svc corev1.Service
+	require.NoError(t, r.Get(ctx, metricsKey, &svc))
+	assert.Equal(t, MetricsPortName, svc.Labels[MetricsComponentLabel])
+	assert.Equal(t, selectorLabels(nginxProxy), svc.Spec.Selector)
+	require.Len(t, svc.Spec.Ports, 1)
+	assert.Equal(t, int32(DefaultMetricsPort), svc.Spec.Ports[0].Port)
+
+	monitor := &unstructured.Unstructured{}
+	monitor.SetGroupVersionKind(serviceMonitorGVK)
+	require.NoError(t, r.Get(ctx, metricsKey, monitor))
+	assert.Equal(t, "prometheus", monitor.GetLabels()["release"])
+	matchLabels, _, _ := unstructured.NestedStringMap(monitor.Object, "spec", "selector", "matchLabels")
+	assert.Equal(t, svc.Labels[MetricsComponentLabel], matchLabels[MetricsComponentLabel])
+	endpoints, _, _ := unstructured.NestedSlice(monitor.Object, "spec", "endpoints")
+	assert.Equal(t, []interface{}{map[string]interface{}{"port": MetricsPortName, "path": "/metrics", "interval": "30s"}}, endpoints)
+
+	// Disabling metrics removes the sidecar, the Service and the ServiceMonitor
+	reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) { p.Spec.Metrics.Enabled = false })
+	require.NoError(t, r.Get(ctx, key, &dep))
+	assert.Len(t, dep.Spec.Template.Spec.Containers, 1)
+	assert.True(t, errors.IsNotFound(r.Get(ctx, metricsKey, &corev1.Service{})))
+	assert.True(t, errors.IsNotFound(r.Get(ctx, metricsKey, monitor)))
+}
+
+func TestReconcileMetricsKeepsForeignObjects(t *testing.T) {
+	ctx := context.Background()
+	nginxProxy := newTestProxy()
+	metricsKey := client.ObjectKey{Name: nginxProxy.Name + "-metrics", Namespace: nginxProxy.Namespace}
+	monitor := &unstructured.Unstructured{}
+	monitor.SetGroupVersionKind(serviceMonitorGVK)
+	monitor.SetName(metricsKey.Name)
+	monitor.SetNamespace(metricsKey.Namespace)
+	r := newMetricsTestReconciler(t, nginxProxy, monitor,
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: metricsKey.Name, Namespace: metricsKey.Namespace}})
+
+	reconcileProxy(t, r, client.ObjectKeyFromObject(nginxProxy), nil)
+	assert.NoError(t, r.Get(ctx, metricsKey, &corev1.Service{}), "objects the controller did not create are not deleted")
+	assert.NoError(t, r.Get(ctx, metricsKey, monitor))
+}
+
+func TestReconcileMetricsWithoutServiceMonitorCRD(t *testing.T) {
+	ctx := context.Background()
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Metrics = &JaegerNginxProxyV1alpha0.Metrics{
+		Enabled:        true,
+		ServiceMonitor: &JaegerNginxProxyV1alpha0.ServiceMonitor{Enabled: true},
+	}
+	r, _ := newRevisionTestReconciler(t, nginxProxy)
+	reconcileProxy(t, r, client.ObjectKeyFromObject(nginxProxy), nil)
+
+	var svc corev1.Service
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Name: nginxProxy.Name + "-metrics", Namespace: nginxProxy.Namespace}, &svc))
+}
@@ -0,0 +1,253 @@
+package ctrl
+
+import (
+	context "context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+// ParseCollectorServiceHost splits an in-cluster Service host name such as
+// "jaeger-collector", "jaeger-collector.tracing" or "jaeger-collector.tracing.svc.cluster.local"
+// into the Service name and namespace. ok is false for hosts that are not cluster Services.
+func ParseCollectorServiceHost(host, defaultNamespace string) (name, namespace string, ok bool) {
+	parts := strings.Split(host, ".")
+	switch {
+	case len(parts) == 1:
+		return parts[0], defaultNamespace, parts[0] != ""
+	case len(parts) == 2:
+		return parts[0], parts[1], parts[0] != "" && parts[1] != ""
+	case len(parts) >= 3 && parts[2] == "svc":
+		return parts[0], parts[1], parts[0] != "" && parts[1] != ""
+	}
+	return "", "", false
+}
+
+func networkPolicyEnabled(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) bool {
+	return nginxProxy.Spec.NetworkPolicy != nil && nginxProxy.Spec.NetworkPolicy.Enabled
+}
+
+// collectorEgressRule returns the egress rule allowing traffic to the collector. Explicit CIDRs win,
+// otherwise Upstream.CollectorHost is resolved to the pods behind the collector Service.
+func (r *JaegerNginxProxyReconciler) collectorEgressRule(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (networkingv1.NetworkPolicyEgressRule, error) {
+	tcp := corev1.ProtocolTCP
+	rule := networkingv1.NetworkPolicyEgressRule{}
+
+	if cidrs := nginxProxy.Spec.NetworkPolicy.CollectorCIDRs; len(cidrs) > 0 {
+		for _, cidr := range cidrs {
+			rule.To = append(rule.To, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+		}
+		for _, port := range nginxProxy.Spec.Ports {
+			target := intstr.FromInt(port.Port)
+			rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &target})
+		}
+		return rule, nil
+	}
+
+	host := nginxProxy.Spec.Upstream.CollectorHost
+	name, namespace, ok := ParseCollectorServiceHost(host, nginxProxy.Namespace)
+	if !ok {
+		return rule, fmt.Errorf("collector host %q is not a cluster Service, set spec.networkPolicy.collectorCIDRs", host)
+	}
+
+	// The collector usually runs outside the namespaces the manager cache holds
+	var collector corev1.Service
+	if err := r.apiReader().Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, &collector); err != nil {
+		if errors.IsForbidden(err) {
+			return rule, fmt.Errorf("not allowed to get collector Service %s/%s, grant get services in namespace %s or set spec.networkPolicy.collectorCIDRs: %w", namespace, name, namespace, err)
+		}
+		return rule, fmt.Errorf("failed to resolve collector Service %s/%s: %w", namespace, name, err)
+	}
+	if len(collector.Spec.Selector) == 0 {
+		return rule, fmt.Errorf("collector Service %s/%s has no pod selector, set spec.networkPolicy.collectorCIDRs", namespace, name)
+	}
+
+	rule.To = []networkingv1.NetworkPolicyPeer{{
+		NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{corev1.LabelMetadataName: namespace},
+		},
+		PodSelector: &metav1.LabelSelector{MatchLabels: collector.Spec.Selector},
+	}}
+	// NetworkPolicies are evaluated after the Service DNAT, so allow the collector target ports
+	for _, port := range nginxProxy.Spec.Ports {
+		target := intstr.FromInt(port.Port)
+		for _, svcPort := range collector.Spec.Ports {
+			if int(svcPort.Port) == port.Port && svcPort.TargetPort.String() != "0" && svcPort.TargetPort.String() != "" {
+				target = svcPort.TargetPort
+			}
+		}
+		rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &target})
+	}
+	return rule, nil
+}
+
+// apiServerEgressRule returns the egress rule allowing the reloader sidecar to report to the API server.
+// NetworkPolicies are evaluated after the Service DNAT, so the endpoints of the kubernetes Service are
+// allowed instead of its ClusterIP.
+func (r *JaegerNginxProxyReconciler) apiServerEgressRule(ctx context.Context) (networkingv1.NetworkPolicyEgressRule, error) {
+	rule := networkingv1.NetworkPolicyEgressRule{}
+	var endpointSlices discoveryv1.EndpointSliceList
+	if err := r.apiReader().List(ctx, &endpointSlices, client.InNamespace(metav1.NamespaceDefault),
+		client.MatchingLabels{discoveryv1.LabelServiceName: "kubernetes"}); err != nil {
+		if errors.IsForbidden(err) {
+			return rule, fmt.Errorf("not allowed to list the API server EndpointSlices in namespace default, which reloadStrategy %s needs with spec.networkPolicy: %w", ReloadStrategyHotReload, err)
+		}
+		return rule, fmt.Errorf("failed to list the API server EndpointSlices: %w", err)
+	}
+
+	cidrs := map[string]bool{}
+	ports := map[int32]bool{}
+	for _, slice := range endpointSlices.Items {
+		for _, port := range slice.Ports {
+			if port.Port != nil {
+				ports[*port.Port] = true
+			}
+		}
+		for _, endpoint := range slice.Endpoints {
+			for _, address := range endpoint.Addresses {
+				if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+					cidrs[address+"/32"] = true
+				} else if ip != nil {
+					cidrs[address+"/128"] = true
+				}
+			}
+		}
+	}
+	if len(cidrs) == 0 || len(ports) == 0 {
+		return rule, fmt.Errorf("the kubernetes Service in namespace default has no endpoints")
+	}
+
+	// Sorted, so an unchanged API server does not update the NetworkPolicy
+	for _, cidr := range sortedKeys(cidrs) {
+		rule.To = append(rule.To, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+	}
+	portNumbers := make([]int, 0, len(ports))
+	for port := range ports {
+		portNumbers = append(portNumbers, int(port))
+	}
+	sort.Ints(portNumbers)
+	tcp := corev1.ProtocolTCP
+	for _, port := range portNumbers {
+		target := intstr.FromInt(port)
+		rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &target})
+	}
+	return rule, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// buildNetworkPolicy allows ingress from spec.networkPolicy.from and egress to DNS and the given rules
+func buildNetworkPolicy(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, collectorRule networkingv1.NetworkPolicyEgressRule, extraEgress ...networkingv1.NetworkPolicyEgressRule) *networkingv1.NetworkPolicy {
+	tcp := corev1.ProtocolTCP
+	udp := corev1.ProtocolUDP
+	dnsPort := intstr.FromInt(53)
+	containerPort := intstr.FromInt(nginxProxy.Spec.ContainerPort)
+
+	ingressRule := networkingv1.NetworkPolicyIngressRule{
+		Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &containerPort}},
+	}
+	for _, peer := range nginxProxy.Spec.NetworkPolicy.From {
+		ingressRule.From = append(ingressRule.From, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: peer.NamespaceSelector,
+			PodSelector:       peer.PodSelector,
+		})
+	}
+
+	ingressRules := []networkingv1.NetworkPolicyIngressRule{ingressRule}
+	// The metrics carry no span data, Prometheus may scrape them from anywhere
+	if metricsEnabled(nginxProxy) {
+		metricsPort := intstr.FromString(MetricsPortName)
+		ingressRules = append(ingressRules, networkingv1.NetworkPolicyIngressRule{
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &metricsPort}},
+		})
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: childObjectMeta(nginxProxy, nil),
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: selectorLabels(nginxProxy),
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress:     ingressRules,
+			Egress: append([]networkingv1.NetworkPolicyEgressRule{
+				collectorRule,
+				{
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Protocol: &udp, Port: &dnsPort},
+						{Protocol: &tcp, Port: &dnsPort},
+					},
+				},
+			}, extraEgress...),
+		},
+	}
+}
+
+// reconcileNetworkPolicy converges the NetworkPolicy with spec.networkPolicy and removes it when disabled
+func (r *JaegerNginxProxyReconciler) reconcileNetworkPolicy(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (bool, error) {
+	if !networkPolicyEnabled(nginxProxy) {
+		return false, r.deleteIfControlled(ctx, nginxProxy, &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace}})
+	}
+
+	collectorRule, err := r.collectorEgressRule(ctx, nginxProxy)
+	if err != nil {
+		return false, err
+	}
+	var extraEgress []networkingv1.NetworkPolicyEgressRule
+	if hotReloadEnabled(nginxProxy) {
+		apiServerRule, err := r.apiServerEgressRule(ctx)
+		if err != nil {
+			return false, err
+		}
+		extraEgress = append(extraEgress, apiServerRule)
+	}
+	np := buildNetworkPolicy(nginxProxy, collectorRule, extraEgress...)
+	if err := ctrl.SetControllerReference(nginxProxy, np, r.Scheme); err != nil {
+		return false, err
+	}
+
+	var existing networkingv1.NetworkPolicy
+	if err := r.Get(ctx, client.ObjectKeyFromObject(np), &existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		log.Info().Msgf("Creating NetworkPolicy for JaegerNginxProxy: %s %s", np.Name, np.Namespace)
+		return false, r.createChild(ctx, nginxProxy, np)
+	}
+	if !metav1.IsControlledBy(&existing, nginxProxy) {
+		return false, r.notControlledError(nginxProxy, &existing)
+	}
+
+	metadataChanged := convergeMetadata(&existing, np)
+	if !metadataChanged && equality.Semantic.DeepEqual(existing.Spec, np.Spec) {
+		log.Debug().Msgf("NetworkPolicy is up to date: %s %s", np.Name, np.Namespace)
+		return false, nil
+	}
+
+	log.Info().Msgf("NetworkPolicy changed, updating: %s %s", np.Name, np.Namespace)
+	existing.Spec = np.Spec
+	return r.updateChild(ctx, nginxProxy, &existing)
+}
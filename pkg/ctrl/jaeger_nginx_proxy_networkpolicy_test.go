@@ -0,0 +1,243 @@
+package ctrl
+
+import (
+	context "context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func TestParseCollectorServiceHost(t *testing.T) {
+	tests := []struct {
+		host          string
+		wantName      string
+		wantNamespace string
+		wantOK        bool
+	}{
+		{"jaeger-collector", "jaeger-collector", "default", true},
+		{"jaeger-collector.tracing", "jaeger-collector", "tracing", true},
+		{"jaeger-collector.tracing.svc", "jaeger-collector", "tracing", true},
+		{"jaeger-collector.tracing.svc.cluster.local", "jaeger-collector", "tracing", true},
+		{"collector.example.com", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		name, namespace, ok := ParseCollectorServiceHost(tt.host, "default")
+		assert.Equal(t, tt.wantOK, ok, tt.host)
+		if tt.wantOK {
+			assert.Equal(t, tt.wantName, name, tt.host)
+			assert.Equal(t, tt.wantNamespace, namespace, tt.host)
+		}
+	}
+}
+
+func TestBuildNetworkPolicy(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.NetworkPolicy = &JaegerNginxProxyV1alpha0.NetworkPolicy{
+		Enabled: true,
+		From: []JaegerNginxProxyV1alpha0.NetworkPolicyPeer{{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "checkout"}},
+		}},
+	}
+	collectorPort := intstr.FromInt(14268)
+	collectorRule := networkingv1.NetworkPolicyEgressRule{
+		To:    []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/24"}}},
+		Ports: []networkingv1.NetworkPolicyPort{{Port: &collectorPort}},
+	}
+
+	np := buildNetworkPolicy(nginxProxy, collectorRule)
+
+	assert.Equal(t, "test-proxy", np.Name)
+	assert.Equal(t, map[string]string{"app": "test-proxy"}, np.Spec.PodSelector.MatchLabels)
+	assert.ElementsMatch(t, []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}, np.Spec.PolicyTypes)
+
+	require.Len(t, np.Spec.Ingress, 1)
+	require.Len(t, np.Spec.Ingress[0].Ports, 1)
+	assert.Equal(t, 8080, np.Spec.Ingress[0].Ports[0].Port.IntValue())
+	require.Len(t, np.Spec.Ingress[0].From, 1)
+	assert.Equal(t, "checkout", np.Spec.Ingress[0].From[0].NamespaceSelector.MatchLabels["team"])
+
+	require.Len(t, np.Spec.Egress, 2, "collector and DNS egress")
+	assert.Equal(t, collectorRule, np.Spec.Egress[0])
+	assert.Empty(t, np.Spec.Egress[1].To, "DNS is allowed to any destination")
+	assert.Equal(t, 53, np.Spec.Egress[1].Ports[0].Port.IntValue())
+
+	nginxProxy.Spec.Metrics = &JaegerNginxProxyV1alpha0.Metrics{Enabled: true}
+	np = buildNetworkPolicy(nginxProxy, collectorRule)
+	require.Len(t, np.Spec.Ingress, 2)
+	assert.Empty(t, np.Spec.Ingress[1].From, "metrics may be scraped from anywhere")
+	assert.Equal(t, MetricsPortName, np.Spec.Ingress[1].Ports[0].Port.String())
+}
+
+func TestCollectorEgressRule(t *testing.T) {
+	collector := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "jaeger-collector", Namespace: "tracing"},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app.kubernetes.io/name": "jaeger"},
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 14268, TargetPort: intstr.FromString("http-collector")},
+				{Name: "grpc", Port: 14250, TargetPort: intstr.FromInt(4250)},
+			},
+		},
+	}
+	r := &JaegerNginxProxyReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(collector).Build(),
+		Scheme: scheme.Scheme,
+	}
+
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.NetworkPolicy = &JaegerNginxProxyV1alpha0.NetworkPolicy{Enabled: true}
+
+	rule, err := r.collectorEgressRule(context.Background(), nginxProxy)
+	require.NoError(t, err)
+	require.Len(t, rule.To, 1)
+	assert.Equal(t, "tracing", rule.To[0].NamespaceSelector.MatchLabels[corev1.LabelMetadataName])
+	assert.Equal(t, collector.Spec.Selector, rule.To[0].PodSelector.MatchLabels)
+	require.Len(t, rule.Ports, 2)
+	assert.Equal(t, "http-collector", rule.Ports[0].Port.String(), "named target port is kept")
+	assert.Equal(t, 4250, rule.Ports[1].Port.IntValue())
+
+	nginxProxy.Spec.NetworkPolicy.CollectorCIDRs = []string{"10.1.0.0/16"}
+	rule, err = r.collectorEgressRule(context.Background(), nginxProxy)
+	require.NoError(t, err)
+	require.Len(t, rule.To, 1)
+	assert.Equal(t, "10.1.0.0/16", rule.To[0].IPBlock.CIDR)
+	assert.Equal(t, 14268, rule.Ports[0].Port.IntValue())
+
+	nginxProxy.Spec.NetworkPolicy.CollectorCIDRs = nil
+	nginxProxy.Spec.Upstream.CollectorHost = "missing.tracing.svc.cluster.local"
+	_, err = r.collectorEgressRule(context.Background(), nginxProxy)
+	assert.Error(t, err)
+
+	// The manager cache only holds the watched namespaces, the collector is read from the API server
+	nginxProxy.Spec.Upstream.CollectorHost = "jaeger-collector.tracing.svc.cluster.local"
+	r.Client = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	r.APIReader = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(collector).Build()
+	rule, err = r.collectorEgressRule(context.Background(), nginxProxy)
+	require.NoError(t, err)
+	assert.Equal(t, collector.Spec.Selector, rule.To[0].PodSelector.MatchLabels)
+
+	r.APIReader = interceptor.NewClient(fake.NewClientBuilder().WithScheme(scheme.Scheme).Build(), interceptor.Funcs{
+		Get: func(_ context.Context, _ client.WithWatch, key client.ObjectKey, _ client.Object, _ ...client.GetOption) error {
+			return errors.NewForbidden(corev1.Resource("services"), key.Name, nil)
+		},
+	})
+	_, err = r.collectorEgressRule(context.Background(), nginxProxy)
+	assert.ErrorContains(t, err, "grant get services in namespace tracing")
+}
+
+func TestReconcileNetworkPolicyDeletesOnlyOwned(t *testing.T) {
+	ctx := context.Background()
+	nginxProxy := newTestProxy()
+	s := newTestScheme(t)
+	newPolicy := func() *networkingv1.NetworkPolicy {
+		return &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace}}
+	}
+	owned := newPolicy()
+	require.NoError(t, ctrl.SetControllerReference(nginxProxy, owned, s))
+
+	for _, tc := range []struct {
+		name    string
+		policy  *networkingv1.NetworkPolicy
+		deleted bool
+	}{
+		{"user policy", newPolicy(), false},
+		{"owned policy", owned, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &JaegerNginxProxyReconciler{Client: fake.NewClientBuilder().WithScheme(s).WithObjects(tc.policy).Build(), Scheme: s}
+			_, err := r.reconcileNetworkPolicy(ctx, nginxProxy)
+			require.NoError(t, err)
+			err = r.Get(ctx, client.ObjectKeyFromObject(tc.policy), &networkingv1.NetworkPolicy{})
+			assert.Equal(t, tc.deleted, errors.IsNotFound(err), "err: %v", err)
+		})
+	}
+}
+
+func TestReconcileNetworkPolicyWithHotReload(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheme(t)
+	port := int32(6443)
+	apiServer := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kubernetes",
+			Namespace: metav1.NamespaceDefault,
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "kubernetes"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"172.18.0.3"}}, {Addresses: []string{"172.18.0.2"}}},
+		Ports:       []discoveryv1.EndpointPort{{Port: &port}},
+	}
+
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.ReloadStrategy = ReloadStrategyHotReload
+	nginxProxy.Spec.NetworkPolicy = &JaegerNginxProxyV1alpha0.NetworkPolicy{Enabled: true, CollectorCIDRs: []string{"10.1.0.0/16"}}
+
+	r := &JaegerNginxProxyReconciler{Client: fake.NewClientBuilder().WithScheme(s).Build(), Scheme: s}
+	r.APIReader = fake.NewClientBuilder().WithScheme(s).WithObjects(apiServer).Build()
+	_, err := r.reconcileNetworkPolicy(ctx, nginxProxy)
+	require.NoError(t, err)
+
+	var np networkingv1.NetworkPolicy
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(nginxProxy), &np))
+	require.Len(t, np.Spec.Egress, 3, "collector, DNS and API server egress")
+	apiServerRule := np.Spec.Egress[2]
+	require.Len(t, apiServerRule.To, 2)
+	assert.Equal(t, "172.18.0.2/32", apiServerRule.To[0].IPBlock.CIDR)
+	assert.Equal(t, "172.18.0.3/32", apiServerRule.To[1].IPBlock.CIDR)
+	require.Len(t, apiServerRule.Ports, 1)
+	assert.Equal(t, 6443, apiServerRule.Ports[0].Port.IntValue())
+
+	// Without hot reload nothing but the collector and DNS is reachable
+	nginxProxy.Spec.ReloadStrategy = ""
+	_, err = r.reconcileNetworkPolicy(ctx, nginxProxy)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(nginxProxy), &np))
+	assert.Len(t, np.Spec.Egress, 2)
+
+	nginxProxy.Spec.ReloadStrategy = ReloadStrategyHotReload
+	r.APIReader = interceptor.NewClient(fake.NewClientBuilder().WithScheme(s).Build(), interceptor.Funcs{
+		List: func(_ context.Context, _ client.WithWatch, _ client.ObjectList, _ ...client.ListOption) error {
+			return errors.NewForbidden(discoveryv1.Resource("endpointslices"), "", nil)
+		},
+	})
+	_, err = r.reconcileNetworkPolicy(ctx, nginxProxy)
+	assert.ErrorContains(t, err, "not allowed to list the API server EndpointSlices")
+}
+
+func TestReconcileNetworkPolicyRefusesUncontrolledPolicy(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheme(t)
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.NetworkPolicy = &JaegerNginxProxyV1alpha0.NetworkPolicy{Enabled: true, CollectorCIDRs: []string{"10.1.0.0/16"}}
+	userPolicy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace},
+		Spec:       networkingv1.NetworkPolicySpec{PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &JaegerNginxProxyReconciler{Client: fake.NewClientBuilder().WithScheme(s).WithObjects(userPolicy).Build(), Scheme: s, Recorder: recorder}
+
+	_, err := r.reconcileNetworkPolicy(ctx, nginxProxy)
+	assert.ErrorContains(t, err, "NetworkPolicy test-proxy already exists and is not controlled by JaegerNginxProxy test-proxy")
+
+	var np networkingv1.NetworkPolicy
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(userPolicy), &np))
+	assert.Equal(t, userPolicy.Spec, np.Spec, "the user's NetworkPolicy is not replaced")
+	assert.Empty(t, np.OwnerReferences, "the user's NetworkPolicy is not adopted")
+	assert.Len(t, eventsWithReason(drainEvents(recorder), ReasonResourceExists), 1)
+}
@@ -0,0 +1,309 @@
+package ctrl
+
+import (
+	context "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+const (
+	ReloadStrategyNone      = "none"
+	ReloadStrategyHotReload = "hotReload"
+
+	// ReloaderContainerName and ReloaderTokenVolumeName are reserved for the hot reload sidecar
+	ReloaderContainerName   = "reloader"
+	ReloaderTokenVolumeName = "reloader-token"
+
+	// ConfigFileName is the key of the generated config in the ConfigMap and its file name below ConfigMountPath
+	ConfigFileName = "proxy.conf"
+
+	serviceAccountMountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+	reloadPollInterval      = 10 * time.Second
+	// reloadReportRetention is how long the report of a pod that is not listed is kept, the pod of a
+	// fresh report may not be in the cache yet
+	reloadReportRetention = 10 * time.Minute
+)
+
+// ConfigHash returns the short content hash used to track which config a pod has loaded
+func ConfigHash(config string) string {
+	sum := sha256.Sum256([]byte(config))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func hotReloadEnabled(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) bool {
+	return nginxProxy.Spec.ReloadStrategy == ReloadStrategyHotReload
+}
+
+// reloaderName is the name of the reloader Role, RoleBinding and status ConfigMap. The status ConfigMap
+// holds the config every pod loaded, keyed by pod name.
+func reloaderName(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) string {
+	return nginxProxy.Name + "-reloader"
+}
+
+// LoadedConfigReport returns the status ConfigMap patch recording that pod loaded the config with hash
+func LoadedConfigReport(pod, hash string, loadedAt time.Time) ([]byte, error) {
+	report, err := json.Marshal(JaegerNginxProxyV1alpha0.PodConfigStatus{
+		Name:       pod,
+		ConfigHash: hash,
+		LoadedAt:   &metav1.Time{Time: loadedAt.UTC()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{"data": map[string]string{pod: string(report)}})
+}
+
+// withReloader adds the reloader sidecar to the proxy Deployment. The pod shares its process namespace so
+// the sidecar can send SIGHUP to the nginx master, and only the sidecar gets an API token to report back
+// to the status ConfigMap statusName.
+func withReloader(dep *appsv1.Deployment, image, statusName string) *appsv1.Deployment {
+	shareProcessNamespace := true
+	runAsRoot := int64(0)
+	readOnly := true
+	podSpec := &dep.Spec.Template.Spec
+
+	podSpec.ShareProcessNamespace = &shareProcessNamespace
+	podSpec.Containers = append(podSpec.Containers, corev1.Container{
+		Name:  ReloaderContainerName,
+		Image: image,
+		Args:  []string{"reloader", "--config", ConfigMountPath + "/" + ConfigFileName, "--status-configmap", statusName},
+		Env: []corev1.EnvVar{
+			{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.name"}}},
+			{Name: "POD_NAMESPACE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.namespace"}}},
+		},
+		// nginx runs as root, signalling it requires the same user but no capabilities
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:              &runAsRoot,
+			ReadOnlyRootFilesystem: &readOnly,
+			Capabilities:           &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: ConfigVolumeName, MountPath: ConfigMountPath, ReadOnly: true},
+			{Name: ReloaderTokenVolumeName, MountPath: serviceAccountMountPath, ReadOnly: true},
+		},
+	})
+
+	expiration := int64(3600)
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: ReloaderTokenVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Path: "token", ExpirationSeconds: &expiration}},
+					{ConfigMap: &corev1.ConfigMapProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"},
+						Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+					}},
+					{DownwardAPI: &corev1.DownwardAPIProjection{
+						Items: []corev1.DownwardAPIVolumeFile{{
+							Path:     "namespace",
+							FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.namespace"},
+						}},
+					}},
+				},
+			},
+		},
+	})
+	return dep
+}
+
+// buildReloaderStatus returns the empty status ConfigMap the reloader sidecars report to
+func buildReloaderStatus(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *corev1.ConfigMap {
+	meta := childObjectMeta(nginxProxy, nil)
+	meta.Name = reloaderName(nginxProxy)
+	return &corev1.ConfigMap{ObjectMeta: meta}
+}
+
+// buildReloaderRole allows the reloader sidecar to patch the status ConfigMap of its proxy and nothing else
+func buildReloaderRole(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *rbacv1.Role {
+	meta := childObjectMeta(nginxProxy, nil)
+	meta.Name = reloaderName(nginxProxy)
+	return &rbacv1.Role{
+		ObjectMeta: meta,
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: []string{reloaderName(nginxProxy)},
+			Verbs:         []string{"patch"},
+		}},
+	}
+}
+
+func buildReloaderRoleBinding(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *rbacv1.RoleBinding {
+	meta := childObjectMeta(nginxProxy, nil)
+	meta.Name = reloaderName(nginxProxy)
+	return &rbacv1.RoleBinding{
+		ObjectMeta: meta,
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     reloaderName(nginxProxy),
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      serviceAccountName(nginxProxy),
+			Namespace: nginxProxy.Namespace,
+		}},
+	}
+}
+
+// reconcileReloaderRBAC creates the status ConfigMap of the reloader sidecar and grants the proxy
+// ServiceAccount access to it while hot reload is enabled, and removes both afterwards
+func (r *JaegerNginxProxyReconciler) reconcileReloaderRBAC(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (bool, error) {
+	if !hotReloadEnabled(nginxProxy) {
+		key := metav1.ObjectMeta{Name: reloaderName(nginxProxy), Namespace: nginxProxy.Namespace}
+		if err := r.deleteIfControlled(ctx, nginxProxy, &rbacv1.RoleBinding{ObjectMeta: key}); err != nil {
+			return false, err
+		}
+		if err := r.deleteIfControlled(ctx, nginxProxy, &rbacv1.Role{ObjectMeta: key}); err != nil {
+			return false, err
+		}
+		return false, r.deleteIfControlled(ctx, nginxProxy, &corev1.ConfigMap{ObjectMeta: key})
+	}
+
+	// Never hand the namespace default ServiceAccount write access
+	if serviceAccountName(nginxProxy) == "" {
+		return false, fmt.Errorf("reloadStrategy %s requires spec.serviceAccount", ReloadStrategyHotReload)
+	}
+
+	status := buildReloaderStatus(nginxProxy)
+	if err := ctrl.SetControllerReference(nginxProxy, status, r.Scheme); err != nil {
+		return false, err
+	}
+	var existingStatus corev1.ConfigMap
+	if err := r.Get(ctx, client.ObjectKeyFromObject(status), &existingStatus); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		log.Info().Msgf("Creating reloader status ConfigMap for JaegerNginxProxy: %s %s", status.Name, status.Namespace)
+		if err := r.createChild(ctx, nginxProxy, status); err != nil {
+			return false, err
+		}
+	} else if !metav1.IsControlledBy(&existingStatus, nginxProxy) {
+		return false, fmt.Errorf("ConfigMap %s already exists and is not controlled by JaegerNginxProxy %s", status.Name, nginxProxy.Name)
+	} else if convergeMetadata(&existingStatus, status) {
+		// The data holds the reports of the sidecars and is kept
+		log.Info().Msgf("Reloader status ConfigMap changed, updating: %s %s", status.Name, status.Namespace)
+		if requeue, err := r.updateChild(ctx, nginxProxy, &existingStatus); err != nil || requeue {
+			return requeue, err
+		}
+	}
+
+	role := buildReloaderRole(nginxProxy)
+	if err := ctrl.SetControllerReference(nginxProxy, role, r.Scheme); err != nil {
+		return false, err
+	}
+	var existingRole rbacv1.Role
+	if err := r.Get(ctx, client.ObjectKeyFromObject(role), &existingRole); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		log.Info().Msgf("Creating reloader Role for JaegerNginxProxy: %s %s", role.Name, role.Namespace)
+		if err := r.createChild(ctx, nginxProxy, role); err != nil {
+			return false, err
+		}
+	} else if metadataChanged := convergeMetadata(&existingRole, role); metadataChanged || !reflect.DeepEqual(existingRole.Rules, role.Rules) {
+		log.Info().Msgf("Reloader Role changed, updating: %s %s", role.Name, role.Namespace)
+		existingRole.Rules = role.Rules
+		if requeue, err := r.updateChild(ctx, nginxProxy, &existingRole); err != nil || requeue {
+			return requeue, err
+		}
+	}
+
+	binding := buildReloaderRoleBinding(nginxProxy)
+	if err := ctrl.SetControllerReference(nginxProxy, binding, r.Scheme); err != nil {
+		return false, err
+	}
+	var existingBinding rbacv1.RoleBinding
+	if err := r.Get(ctx, client.ObjectKeyFromObject(binding), &existingBinding); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		log.Info().Msgf("Creating reloader RoleBinding for JaegerNginxProxy: %s %s", binding.Name, binding.Namespace)
+		return false, r.createChild(ctx, nginxProxy, binding)
+	}
+	metadataChanged := convergeMetadata(&existingBinding, binding)
+	if !metadataChanged && reflect.DeepEqual(existingBinding.Subjects, binding.Subjects) {
+		log.Debug().Msgf("Reloader RoleBinding is up to date: %s %s", binding.Name, binding.Namespace)
+		return false, nil
+	}
+	// The role reference is immutable and always the same, only the subjects can change
+	log.Info().Msgf("Reloader RoleBinding changed, updating: %s %s", binding.Name, binding.Namespace)
+	existingBinding.Subjects = binding.Subjects
+	return r.updateChild(ctx, nginxProxy, &existingBinding)
+}
+
+// podConfigStatus collects the config hash every proxy pod reported and whether all of them loaded configHash.
+// Reports of pods that are gone are dropped from the status ConfigMap after reloadReportRetention.
+// Pods are listed from the API server, a list through the manager cache would start a Pod informer for
+// every Pod of the watched namespaces.
+func (r *JaegerNginxProxyReconciler) podConfigStatus(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, configHash string) ([]JaegerNginxProxyV1alpha0.PodConfigStatus, bool, error) {
+	var pods corev1.PodList
+	if err := r.apiReader().List(ctx, &pods, client.InNamespace(nginxProxy.Namespace), client.MatchingLabels(selectorLabels(nginxProxy))); err != nil {
+		return nil, false, err
+	}
+	var reports corev1.ConfigMap
+	if err := r.Get(ctx, client.ObjectKey{Name: reloaderName(nginxProxy), Namespace: nginxProxy.Namespace}, &reports); client.IgnoreNotFound(err) != nil {
+		return nil, false, err
+	}
+
+	var statuses []JaegerNginxProxyV1alpha0.PodConfigStatus
+	allLoaded := true
+	listed := make(map[string]bool, len(pods.Items))
+	for _, pod := range pods.Items {
+		listed[pod.Name] = true
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		status := JaegerNginxProxyV1alpha0.PodConfigStatus{Name: pod.Name}
+		if report, ok := reports.Data[pod.Name]; ok {
+			if err := json.Unmarshal([]byte(report), &status); err != nil {
+				log.Warn().Err(err).Msgf("Ignoring invalid reloader report of pod %s %s", pod.Name, pod.Namespace)
+			}
+			status.Name = pod.Name
+		}
+		if status.ConfigHash != configHash {
+			allLoaded = false
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	stale := map[string]interface{}{}
+	for pod, report := range reports.Data {
+		var status JaegerNginxProxyV1alpha0.PodConfigStatus
+		if !listed[pod] && (json.Unmarshal([]byte(report), &status) != nil || status.LoadedAt == nil || time.Since(status.LoadedAt.Time) > reloadReportRetention) {
+			stale[pod] = nil
+		}
+	}
+	if len(stale) > 0 {
+		patch, err := json.Marshal(map[string]interface{}{"data": stale})
+		if err != nil {
+			return nil, false, err
+		}
+		log.Debug().Msgf("Dropping %d reloader reports of gone pods: %s %s", len(stale), reports.Name, reports.Namespace)
+		if err := r.Patch(ctx, &reports, client.RawPatch(types.MergePatchType, patch)); err != nil {
+			return nil, false, err
+		}
+	}
+	return statuses, allLoaded, nil
+}
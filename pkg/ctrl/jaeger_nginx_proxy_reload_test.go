@@ -0,0 +1,197 @@
+package ctrl
+
+import (
+	context "context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	s := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(s))
+	require.NoError(t, JaegerNginxProxyV1alpha0.AddToScheme(s))
+	return s
+}
+
+func TestConfigHash(t *testing.T) {
+	assert.Len(t, ConfigHash("server {}"), 16)
+	assert.Equal(t, ConfigHash("server {}"), ConfigHash("server {}"))
+	assert.NotEqual(t, ConfigHash("server {}"), ConfigHash("server { }"))
+}
+
+func TestWithReloader(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.ReloadStrategy = ReloadStrategyHotReload
+
+	dep := withReloader(buildDeployment(nginxProxy), "ghcr.io/dolv/k8s-controller-tutorial/app:1.0.0", "test-proxy-reloader")
+	podSpec := dep.Spec.Template.Spec
+
+	require.NotNil(t, podSpec.ShareProcessNamespace)
+	assert.True(t, *podSpec.ShareProcessNamespace)
+	assert.False(t, *podSpec.AutomountServiceAccountToken, "nginx itself still gets no API token")
+
+	require.Len(t, podSpec.Containers, 2)
+	reloader := podSpec.Containers[1]
+	assert.Equal(t, ReloaderContainerName, reloader.Name)
+	assert.Equal(t, "ghcr.io/dolv/k8s-controller-tutorial/app:1.0.0", reloader.Image)
+	assert.Equal(t, []string{"reloader", "--config", "/etc/nginx/conf.d/proxy.conf", "--status-configmap", "test-proxy-reloader"}, reloader.Args)
+	assert.Equal(t, ConfigVolumeName, reloader.VolumeMounts[0].Name)
+	assert.Equal(t, serviceAccountMountPath, reloader.VolumeMounts[1].MountPath)
+
+	require.Len(t, podSpec.Volumes, 2)
+	assert.Equal(t, ReloaderTokenVolumeName, podSpec.Volumes[1].Name)
+	assert.NotNil(t, podSpec.Volumes[1].Projected.Sources[0].ServiceAccountToken)
+}
+
+func TestReconcileReloaderRBAC(t *testing.T) {
+	s := newTestScheme(t)
+	r := &JaegerNginxProxyReconciler{
+		Client: fake.NewClientBuilder().WithScheme(s).Build(),
+		Scheme: s,
+	}
+	ctx := context.Background()
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.ReloadStrategy = ReloadStrategyHotReload
+
+	_, err := r.reconcileReloaderRBAC(ctx, nginxProxy)
+	assert.Error(t, err, "the namespace default ServiceAccount is never granted access")
+
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{Create: true}
+	_, err = r.reconcileReloaderRBAC(ctx, nginxProxy)
+	require.NoError(t, err)
+
+	key := client.ObjectKey{Name: "test-proxy-reloader", Namespace: "default"}
+	var role rbacv1.Role
+	require.NoError(t, r.Get(ctx, key, &role))
+	assert.Equal(t, []rbacv1.PolicyRule{{
+		APIGroups:     []string{""},
+		Resources:     []string{"configmaps"},
+		ResourceNames: []string{"test-proxy-reloader"},
+		Verbs:         []string{"patch"},
+	}}, role.Rules, "the sidecar can only report to its own status ConfigMap")
+	var status corev1.ConfigMap
+	require.NoError(t, r.Get(ctx, key, &status))
+	assert.True(t, metav1.IsControlledBy(&status, nginxProxy))
+	var binding rbacv1.RoleBinding
+	require.NoError(t, r.Get(ctx, key, &binding))
+	assert.Equal(t, "test-proxy", binding.Subjects[0].Name)
+
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{Name: "proxy-identity"}
+	_, err = r.reconcileReloaderRBAC(ctx, nginxProxy)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, key, &binding))
+	assert.Equal(t, "proxy-identity", binding.Subjects[0].Name)
+
+	nginxProxy.Spec.ReloadStrategy = ReloadStrategyNone
+	_, err = r.reconcileReloaderRBAC(ctx, nginxProxy)
+	require.NoError(t, err)
+	assert.True(t, errors.IsNotFound(r.Get(ctx, key, &role)))
+	assert.True(t, errors.IsNotFound(r.Get(ctx, key, &binding)))
+	assert.True(t, errors.IsNotFound(r.Get(ctx, key, &status)))
+}
+
+func TestReconcileReloaderRBACKeepsForeignObjects(t *testing.T) {
+	s := newTestScheme(t)
+	meta := metav1.ObjectMeta{Name: "test-proxy-reloader", Namespace: "default"}
+	r := &JaegerNginxProxyReconciler{
+		Client: fake.NewClientBuilder().WithScheme(s).
+			WithObjects(&rbacv1.Role{ObjectMeta: meta}, &rbacv1.RoleBinding{ObjectMeta: meta}, &corev1.ConfigMap{ObjectMeta: meta}).Build(),
+		Scheme: s,
+	}
+	ctx := context.Background()
+	nginxProxy := newTestProxy()
+
+	_, err := r.reconcileReloaderRBAC(ctx, nginxProxy)
+	require.NoError(t, err)
+	key := client.ObjectKey{Name: meta.Name, Namespace: meta.Namespace}
+	assert.NoError(t, r.Get(ctx, key, &rbacv1.Role{}), "objects the controller did not create are not deleted")
+	assert.NoError(t, r.Get(ctx, key, &rbacv1.RoleBinding{}))
+	assert.NoError(t, r.Get(ctx, key, &corev1.ConfigMap{}))
+
+	nginxProxy.Spec.ReloadStrategy = ReloadStrategyHotReload
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{Create: true}
+	_, err = r.reconcileReloaderRBAC(ctx, nginxProxy)
+	assert.ErrorContains(t, err, "not controlled by", "a foreign ConfigMap is not handed to the sidecar")
+}
+
+func TestPodConfigStatus(t *testing.T) {
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"app": "test-proxy"},
+		}}
+	}
+	other := pod("other")
+	other.Labels = map[string]string{"app": "other"}
+	report := func(pod, hash string, loadedAt time.Time) string {
+		patch, err := LoadedConfigReport(pod, hash, loadedAt)
+		require.NoError(t, err)
+		var p struct{ Data map[string]string }
+		require.NoError(t, json.Unmarshal(patch, &p))
+		return p.Data[pod]
+	}
+	loadedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	reports := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-proxy-reloader", Namespace: "default"},
+		Data: map[string]string{
+			"test-proxy-a":      report("test-proxy-a", "old", loadedAt),
+			"test-proxy-b":      report("test-proxy-b", "new", loadedAt),
+			"test-proxy-gone":   report("test-proxy-gone", "new", loadedAt),
+			"test-proxy-recent": report("test-proxy-recent", "new", time.Now()),
+		},
+	}
+	ctx := context.Background()
+
+	r := &JaegerNginxProxyReconciler{
+		Client: fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).
+			WithObjects(pod("test-proxy-b"), pod("test-proxy-a"), pod("test-proxy-c"), other, reports).Build(),
+	}
+	pods, allLoaded, err := r.podConfigStatus(ctx, newTestProxy(), "new")
+	require.NoError(t, err)
+	assert.False(t, allLoaded)
+	require.Len(t, pods, 3)
+	assert.Equal(t, "test-proxy-a", pods[0].Name)
+	assert.Equal(t, "old", pods[0].ConfigHash)
+	assert.Equal(t, 2026, pods[0].LoadedAt.Year())
+	assert.Empty(t, pods[2].ConfigHash, "test-proxy-c did not report yet")
+
+	var pruned corev1.ConfigMap
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(reports), &pruned))
+	assert.NotContains(t, pruned.Data, "test-proxy-gone", "old reports of gone pods are dropped")
+	assert.Contains(t, pruned.Data, "test-proxy-recent", "the pod of a recent report may not be listed yet")
+
+	r.Client = fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(pod("test-proxy-b"), reports).Build()
+	_, allLoaded, err = r.podConfigStatus(ctx, newTestProxy(), "new")
+	require.NoError(t, err)
+	assert.True(t, allLoaded)
+
+	r.Client = fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(pod("test-proxy-b")).Build()
+	_, allLoaded, err = r.podConfigStatus(ctx, newTestProxy(), "new")
+	require.NoError(t, err)
+	assert.False(t, allLoaded, "nothing is reported before the status ConfigMap exists")
+
+	// Pods are read from the API server, not the manager cache
+	r.Client = fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(reports).Build()
+	r.APIReader = fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(pod("test-proxy-a")).Build()
+	pods, allLoaded, err = r.podConfigStatus(ctx, newTestProxy(), "new")
+	require.NoError(t, err)
+	assert.False(t, allLoaded)
+	require.Len(t, pods, 1)
+	assert.Equal(t, "old", pods[0].ConfigHash)
+}
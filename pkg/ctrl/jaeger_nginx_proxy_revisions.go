@@ -0,0 +1,291 @@
+package ctrl
+
+import (
+	context "context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+const (
+	// RevisionAnnotation numbers the config revision ConfigMaps of a proxy
+	RevisionAnnotation = "jaeger-nginx-proxy.platform-engineer.stream/revision"
+	// RollbackAnnotation on a JaegerNginxProxy requests a rollback to the given revision, "0" means the previous one
+	RollbackAnnotation = "jaeger-nginx-proxy.platform-engineer.stream/rollback-to"
+
+	// SpecFileName is the key of the spec snapshot stored next to the config in a revision ConfigMap
+	SpecFileName = "spec.json"
+
+	DefaultRevisionHistoryLimit = 10
+)
+
+func revisionHistoryLimit(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) int {
+	if limit := nginxProxy.Spec.RevisionHistoryLimit; limit != nil && *limit > 0 {
+		return int(*limit)
+	}
+	return DefaultRevisionHistoryLimit
+}
+
+// revisionName returns the content addressed name of a config revision ConfigMap
+func revisionName(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, configHash string) string {
+	base := nginxProxy.Name
+	if maxLen := validation.DNS1123SubdomainMaxLength - len(configHash) - 1; len(base) > maxLen {
+		base = base[:maxLen]
+	}
+	return base + "-" + configHash
+}
+
+// revisionNumber returns the revision of a config revision ConfigMap, 0 for other ConfigMaps
+func revisionNumber(cm *corev1.ConfigMap) int64 {
+	revision, _ := strconv.ParseInt(cm.Annotations[RevisionAnnotation], 10, 64)
+	return revision
+}
+
+// withConfigMap points the config volume of the proxy Deployment at the given ConfigMap
+func withConfigMap(dep *appsv1.Deployment, name string) *appsv1.Deployment {
+	for i := range dep.Spec.Template.Spec.Volumes {
+		if volume := &dep.Spec.Template.Spec.Volumes[i]; volume.Name == ConfigVolumeName {
+			volume.ConfigMap.Name = name
+		}
+	}
+	return dep
+}
+
+// listRevisions returns the config revisions owned by the proxy, oldest first
+func (r *JaegerNginxProxyReconciler) listRevisions(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) ([]corev1.ConfigMap, error) {
+	var list corev1.ConfigMapList
+	if err := r.List(ctx, &list, client.InNamespace(nginxProxy.Namespace), client.MatchingLabels(selectorLabels(nginxProxy))); err != nil {
+		return nil, err
+	}
+	var revisions []corev1.ConfigMap
+	for _, cm := range list.Items {
+		if revisionNumber(&cm) > 0 && metav1.IsControlledBy(&cm, nginxProxy) {
+			revisions = append(revisions, cm)
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisionNumber(&revisions[i]) < revisionNumber(&revisions[j]) })
+	return revisions, nil
+}
+
+// reconcileRevision makes cm the current revision and returns the updated revisions, oldest first. A spec
+// that was served before reuses its ConfigMap, which is promoted to the next revision number like
+// Deployments do with their ReplicaSets.
+func (r *JaegerNginxProxyReconciler) reconcileRevision(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, cm *corev1.ConfigMap, revisions []corev1.ConfigMap) ([]corev1.ConfigMap, error) {
+	var latest int64
+	if len(revisions) > 0 {
+		latest = revisionNumber(&revisions[len(revisions)-1])
+	}
+
+	for i := range revisions {
+		if revisions[i].Name != cm.Name {
+			continue
+		}
+		if i == len(revisions)-1 {
+			log.Debug().Msgf("ConfigMap revision %d is up to date: %s %s", latest, cm.Name, cm.Namespace)
+			return revisions, nil
+		}
+		// Only the metadata of an immutable ConfigMap can change
+		existing := revisions[i]
+		log.Info().Msgf("Promoting ConfigMap revision %d to %d: %s %s", revisionNumber(&existing), latest+1, existing.Name, existing.Namespace)
+		existing.Annotations[RevisionAnnotation] = strconv.FormatInt(latest+1, 10)
+		if err := r.Update(ctx, &existing); err != nil {
+			return nil, err
+		}
+		r.eventf(nginxProxy, corev1.EventTypeNormal, ReasonUpdated, "Promoted config revision %d to %d", revisionNumber(&revisions[i]), latest+1)
+		recordUpdate("ConfigMap", UpdateCausePromotedRevision)
+		return append(append(revisions[:i:i], revisions[i+1:]...), existing), nil
+	}
+
+	cm.Annotations[RevisionAnnotation] = strconv.FormatInt(latest+1, 10)
+	if err := ctrl.SetControllerReference(nginxProxy, cm, r.Scheme); err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Creating ConfigMap revision %d for JaegerNginxProxy: %s %s", latest+1, cm.Name, cm.Namespace)
+	if err := r.createChild(ctx, nginxProxy, cm); err != nil {
+		return nil, err
+	}
+	recordUpdate("ConfigMap", UpdateCauseNewRevision)
+	return append(revisions, *cm), nil
+}
+
+// pruneRevisions deletes the oldest revisions beyond spec.revisionHistoryLimit, never the current one
+func (r *JaegerNginxProxyReconciler) pruneRevisions(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, revisions []corev1.ConfigMap, current string) ([]corev1.ConfigMap, error) {
+	limit := revisionHistoryLimit(nginxProxy)
+	var kept []corev1.ConfigMap
+	for i := range revisions {
+		if len(revisions)-i > limit && revisions[i].Name != current {
+			log.Info().Msgf("Pruning ConfigMap revision %d: %s %s", revisionNumber(&revisions[i]), revisions[i].Name, revisions[i].Namespace)
+			if err := r.deleteIfExists(ctx, &revisions[i]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		kept = append(kept, revisions[i])
+	}
+	return kept, nil
+}
+
+// revisionStatus lists the revisions newest first for the proxy status
+func revisionStatus(revisions []corev1.ConfigMap) []JaegerNginxProxyV1alpha0.ConfigRevision {
+	var statuses []JaegerNginxProxyV1alpha0.ConfigRevision
+	for i := len(revisions) - 1; i >= 0; i-- {
+		createdAt := revisions[i].CreationTimestamp
+		status := JaegerNginxProxyV1alpha0.ConfigRevision{
+			Revision:      revisionNumber(&revisions[i]),
+			ConfigMapName: revisions[i].Name,
+			ConfigHash:    ConfigHash(revisions[i].Data[ConfigFileName]),
+		}
+		if !createdAt.IsZero() {
+			status.CreatedAt = &createdAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// reconcileLiveConfigMap maintains the mutable ConfigMap named after the proxy that hot reloaded pods mount,
+// so config changes reach them without a rollout. Other reload strategies mount the revision directly, the
+// live ConfigMap is then removed by pruneLiveConfigMap.
+func (r *JaegerNginxProxyReconciler) reconcileLiveConfigMap(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, current *corev1.ConfigMap) (bool, error) {
+	if !hotReloadEnabled(nginxProxy) {
+		return false, nil
+	}
+	var existing corev1.ConfigMap
+	err := r.Get(ctx, client.ObjectKey{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace}, &existing)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	found := err == nil
+
+	live := &corev1.ConfigMap{
+		ObjectMeta: childObjectMeta(nginxProxy, nil),
+		Data:       map[string]string{ConfigFileName: current.Data[ConfigFileName]},
+	}
+	if err := ctrl.SetControllerReference(nginxProxy, live, r.Scheme); err != nil {
+		return false, err
+	}
+	if !found {
+		log.Info().Msgf("Creating live ConfigMap for JaegerNginxProxy: %s %s", live.Name, live.Namespace)
+		if err := r.createChild(ctx, nginxProxy, live); err != nil {
+			return false, err
+		}
+		recordUpdate("ConfigMap", UpdateCauseLiveConfig)
+		return false, nil
+	}
+
+	metadataChanged := convergeMetadata(&existing, live)
+	if !metadataChanged && existing.Data[ConfigFileName] == live.Data[ConfigFileName] && len(existing.Data) == 1 {
+		log.Debug().Msgf("Live ConfigMap is up to date: %s %s", live.Name, live.Namespace)
+		return false, nil
+	}
+	log.Info().Msgf("Live ConfigMap changed, updating: %s %s", live.Name, live.Namespace)
+	existing.Data = live.Data
+	requeue, err := r.updateChild(ctx, nginxProxy, &existing)
+	if err == nil && !requeue {
+		recordUpdate("ConfigMap", UpdateCauseLiveConfig)
+	}
+	return requeue, err
+}
+
+// pruneLiveConfigMap deletes the live ConfigMap once hot reload is disabled. Like old revisions it is only
+// called after the Deployment was updated to mount the current revision instead.
+func (r *JaegerNginxProxyReconciler) pruneLiveConfigMap(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) error {
+	if hotReloadEnabled(nginxProxy) {
+		return nil
+	}
+	var existing corev1.ConfigMap
+	if err := r.Get(ctx, client.ObjectKey{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace}, &existing); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if revisionNumber(&existing) != 0 || !metav1.IsControlledBy(&existing, nginxProxy) {
+		return nil
+	}
+	log.Info().Msgf("Deleting live ConfigMap no longer mounted by JaegerNginxProxy: %s %s", existing.Name, existing.Namespace)
+	return r.deleteIfExists(ctx, &existing)
+}
+
+// rollback restores the spec recorded with the revision requested by RollbackAnnotation. The replica count
+// is kept, scaling is not part of a revision. It returns false when no rollback was requested.
+func (r *JaegerNginxProxyReconciler) rollback(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (bool, error) {
+	value, ok := nginxProxy.Annotations[RollbackAnnotation]
+	if !ok {
+		return false, nil
+	}
+
+	spec, revision, err := r.revisionSpec(ctx, nginxProxy, value)
+	if err != nil {
+		if _, ok := err.(*rollbackError); !ok {
+			// Keep the request, the rollback is retried with the next reconcile
+			return true, fmt.Errorf("failed to load config revision %q: %w", value, err)
+		}
+		log.Error().Err(err).Msgf("Rollback of JaegerNginxProxy failed: %s %s", nginxProxy.Name, nginxProxy.Namespace)
+		r.event(nginxProxy, corev1.EventTypeWarning, ReasonRollbackFailed, err.Error())
+		// Drop the request, retrying cannot make an unknown revision appear
+		delete(nginxProxy.Annotations, RollbackAnnotation)
+		return true, r.Update(ctx, nginxProxy)
+	}
+
+	delete(nginxProxy.Annotations, RollbackAnnotation)
+
+	spec.ReplicaCount = nginxProxy.Spec.ReplicaCount
+	nginxProxy.Spec = *spec
+	log.Info().Msgf("Rolling back JaegerNginxProxy to revision %d: %s %s", revision, nginxProxy.Name, nginxProxy.Namespace)
+	if err := r.Update(ctx, nginxProxy); err != nil {
+		return true, err
+	}
+	r.event(nginxProxy, corev1.EventTypeNormal, ReasonRolledBack, fmt.Sprintf("rolled back to config revision %d", revision))
+	return true, nil
+}
+
+// rollbackError is a rollback request that can never succeed: an invalid or unknown revision
+type rollbackError struct {
+	msg string
+}
+
+func (e *rollbackError) Error() string { return e.msg }
+
+// revisionSpec loads the spec snapshot of the revision named by value. A *rollbackError is returned
+// when the revision is invalid or unknown, other errors are transient.
+func (r *JaegerNginxProxyReconciler) revisionSpec(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, value string) (*JaegerNginxProxyV1alpha0.JaegerNginxProxySpec, int64, error) {
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || revision < 0 {
+		return nil, 0, &rollbackError{msg: fmt.Sprintf("invalid rollback revision %q", value)}
+	}
+
+	revisions, err := r.listRevisions(ctx, nginxProxy)
+	if err != nil {
+		return nil, 0, err
+	}
+	if revision == 0 {
+		if len(revisions) < 2 {
+			return nil, 0, &rollbackError{msg: "no previous config revision to roll back to"}
+		}
+		revision = revisionNumber(&revisions[len(revisions)-2])
+	}
+
+	for i := range revisions {
+		if revisionNumber(&revisions[i]) != revision {
+			continue
+		}
+		spec := &JaegerNginxProxyV1alpha0.JaegerNginxProxySpec{}
+		if err := json.Unmarshal([]byte(revisions[i].Data[SpecFileName]), spec); err != nil {
+			return nil, 0, &rollbackError{msg: fmt.Sprintf("config revision %d has no usable spec snapshot: %v", revision, err)}
+		}
+		return spec, revision, nil
+	}
+	return nil, 0, &rollbackError{msg: fmt.Sprintf("config revision %d not found", revision)}
+}
@@ -0,0 +1,215 @@
+package ctrl
+
+import (
+	context "context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func newRevisionTestReconciler(t *testing.T, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (*JaegerNginxProxyReconciler, *record.FakeRecorder) {
+	t.Helper()
+	s := newTestScheme(t)
+	recorder := record.NewFakeRecorder(100)
+	c := fake.NewClientBuilder().WithScheme(s).
+		WithObjects(nginxProxy).
+		WithStatusSubresource(&JaegerNginxProxyV1alpha0.JaegerNginxProxy{}).
+		Build()
+	return &JaegerNginxProxyReconciler{Client: c, Scheme: s, Recorder: recorder}, recorder
+}
+
+// reconcileProxy runs Reconcile, applies change to the stored proxy when not nil and returns the proxy
+func reconcileProxy(t *testing.T, r *JaegerNginxProxyReconciler, key client.ObjectKey, change func(*JaegerNginxProxyV1alpha0.JaegerNginxProxy)) *JaegerNginxProxyV1alpha0.JaegerNginxProxy {
+	t.Helper()
+	ctx := context.Background()
+	if change != nil {
+		var current JaegerNginxProxyV1alpha0.JaegerNginxProxy
+		require.NoError(t, r.Get(ctx, key, &current))
+		change(&current)
+		require.NoError(t, r.Update(ctx, &current))
+	}
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+
+	var current JaegerNginxProxyV1alpha0.JaegerNginxProxy
+	require.NoError(t, r.Get(ctx, key, &current))
+	return &current
+}
+
+func deploymentConfigMap(t *testing.T, r *JaegerNginxProxyReconciler, key client.ObjectKey) string {
+	t.Helper()
+	var dep appsv1.Deployment
+	require.NoError(t, r.Get(context.Background(), key, &dep))
+	return dep.Spec.Template.Spec.Volumes[0].ConfigMap.Name
+}
+
+func TestReconcileConfigRevisions(t *testing.T) {
+	nginxProxy := newTestProxy()
+	r, _ := newRevisionTestReconciler(t, nginxProxy)
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(nginxProxy)
+
+	current := reconcileProxy(t, r, key, nil)
+	assert.Equal(t, int64(1), current.Status.CurrentRevision)
+	require.Len(t, current.Status.Revisions, 1)
+	first := current.Status.Revisions[0]
+	assert.Equal(t, int64(1), first.Revision)
+	assert.Equal(t, current.Status.ConfigHash, first.ConfigHash)
+	assert.Equal(t, first.ConfigMapName, deploymentConfigMap(t, r, key))
+
+	var cm corev1.ConfigMap
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Name: first.ConfigMapName, Namespace: key.Namespace}, &cm))
+	require.NotNil(t, cm.Immutable)
+	assert.True(t, *cm.Immutable)
+	assert.Contains(t, cm.Data[SpecFileName], `"collectorHost"`)
+	assert.True(t, errors.IsNotFound(r.Get(ctx, key, &cm)), "no mutable ConfigMap without hot reload")
+
+	// Scaling does not create a revision
+	current = reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) { p.Spec.ReplicaCount = 3 })
+	assert.Len(t, current.Status.Revisions, 1)
+
+	current = reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) { p.Spec.Ports[0].Path = "/v2/traces" })
+	assert.Equal(t, int64(2), current.Status.CurrentRevision)
+	require.Len(t, current.Status.Revisions, 2)
+	assert.Equal(t, int64(2), current.Status.Revisions[0].Revision, "newest first")
+	assert.Equal(t, current.Status.Revisions[0].ConfigMapName, deploymentConfigMap(t, r, key))
+
+	// Going back to a spec served before reuses its ConfigMap as the next revision
+	current = reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) { p.Spec.Ports[0].Path = "/api/traces" })
+	assert.Equal(t, int64(3), current.Status.CurrentRevision)
+	require.Len(t, current.Status.Revisions, 2)
+	assert.Equal(t, first.ConfigMapName, current.Status.Revisions[0].ConfigMapName)
+	assert.Equal(t, first.ConfigMapName, deploymentConfigMap(t, r, key))
+}
+
+func TestReconcilePrunesRevisions(t *testing.T) {
+	nginxProxy := newTestProxy()
+	limit := int32(2)
+	nginxProxy.Spec.RevisionHistoryLimit = &limit
+	r, _ := newRevisionTestReconciler(t, nginxProxy)
+	key := client.ObjectKeyFromObject(nginxProxy)
+
+	reconcileProxy(t, r, key, nil)
+	for _, path := range []string{"/v2/traces", "/v3/traces", "/v4/traces"} {
+		reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) { p.Spec.Ports[0].Path = path })
+	}
+
+	current := reconcileProxy(t, r, key, nil)
+	assert.Equal(t, int64(4), current.Status.CurrentRevision)
+	require.Len(t, current.Status.Revisions, 2)
+	assert.Equal(t, int64(4), current.Status.Revisions[0].Revision)
+	assert.Equal(t, int64(3), current.Status.Revisions[1].Revision)
+
+	var cms corev1.ConfigMapList
+	require.NoError(t, r.List(context.Background(), &cms))
+	assert.Len(t, cms.Items, 2)
+}
+
+func TestReconcileRollback(t *testing.T) {
+	nginxProxy := newTestProxy()
+	r, recorder := newRevisionTestReconciler(t, nginxProxy)
+	key := client.ObjectKeyFromObject(nginxProxy)
+
+	reconcileProxy(t, r, key, nil)
+	reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+		p.Spec.Ports[0].Path = "/v2/traces"
+		p.Spec.ReplicaCount = 3
+	})
+
+	current := reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+		p.Annotations = map[string]string{RollbackAnnotation: "0"}
+	})
+	assert.NotContains(t, current.Annotations, RollbackAnnotation)
+	assert.Equal(t, "/api/traces", current.Spec.Ports[0].Path)
+	assert.Equal(t, 3, current.Spec.ReplicaCount, "the replica count is kept")
+	assert.Contains(t, drainEvents(recorder), "Normal RolledBack rolled back to config revision 1")
+
+	current = reconcileProxy(t, r, key, nil)
+	assert.Equal(t, int64(3), current.Status.CurrentRevision)
+
+	current = reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) {
+		p.Annotations = map[string]string{RollbackAnnotation: "42"}
+	})
+	assert.NotContains(t, current.Annotations, RollbackAnnotation)
+	assert.Equal(t, "/api/traces", current.Spec.Ports[0].Path)
+	assert.Contains(t, drainEvents(recorder), "Warning RollbackFailed config revision 42 not found")
+}
+
+func TestRollbackKeepsRequestOnTransientError(t *testing.T) {
+	ctx := context.Background()
+	nginxProxy := newTestProxy()
+	nginxProxy.Annotations = map[string]string{RollbackAnnotation: "1"}
+	s := newTestScheme(t)
+	recorder := record.NewFakeRecorder(10)
+	c := interceptor.NewClient(fake.NewClientBuilder().WithScheme(s).WithObjects(nginxProxy).Build(), interceptor.Funcs{
+		List: func(_ context.Context, _ client.WithWatch, _ client.ObjectList, _ ...client.ListOption) error {
+			return errors.NewServiceUnavailable("etcd is unavailable")
+		},
+	})
+	r := &JaegerNginxProxyReconciler{Client: c, Scheme: s, Recorder: recorder}
+
+	rolledBack, err := r.rollback(ctx, nginxProxy)
+	assert.True(t, rolledBack)
+	assert.ErrorContains(t, err, "etcd is unavailable")
+	assert.Contains(t, nginxProxy.Annotations, RollbackAnnotation)
+
+	var stored JaegerNginxProxyV1alpha0.JaegerNginxProxy
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(nginxProxy), &stored))
+	assert.Equal(t, "1", stored.Annotations[RollbackAnnotation], "the request is retried")
+	assert.Empty(t, eventsWithReason(drainEvents(recorder), ReasonRollbackFailed))
+
+	nginxProxy.Annotations[RollbackAnnotation] = "latest"
+	_, err = r.rollback(ctx, nginxProxy)
+	require.NoError(t, err)
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(nginxProxy), &stored))
+	assert.NotContains(t, stored.Annotations, RollbackAnnotation, "an invalid revision is dropped")
+	assert.Equal(t, []string{`Warning RollbackFailed invalid rollback revision "latest"`}, eventsWithReason(drainEvents(recorder), ReasonRollbackFailed))
+}
+
+func TestReconcileLiveConfigMap(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.ReloadStrategy = ReloadStrategyHotReload
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{Create: true}
+	r, _ := newRevisionTestReconciler(t, nginxProxy)
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(nginxProxy)
+
+	reconcileProxy(t, r, key, nil)
+	assert.Equal(t, key.Name, deploymentConfigMap(t, r, key), "hot reloaded pods mount the live ConfigMap")
+
+	current := reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) { p.Spec.Ports[0].Path = "/v2/traces" })
+	var live corev1.ConfigMap
+	require.NoError(t, r.Get(ctx, key, &live))
+	assert.Equal(t, current.Status.ConfigHash, ConfigHash(live.Data[ConfigFileName]))
+	assert.Contains(t, live.Data[ConfigFileName], "location /v2/traces")
+
+	// The pods keep mounting the live ConfigMap until the Deployment update goes through
+	c := r.Client
+	r.Client = interceptor.NewClient(c.(client.WithWatch), interceptor.Funcs{
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if _, ok := obj.(*appsv1.Deployment); ok {
+				return errors.NewConflict(appsv1.Resource("deployments"), obj.GetName(), nil)
+			}
+			return c.Update(ctx, obj, opts...)
+		},
+	})
+	reconcileProxy(t, r, key, func(p *JaegerNginxProxyV1alpha0.JaegerNginxProxy) { p.Spec.ReloadStrategy = ReloadStrategyNone })
+	require.NoError(t, r.Get(ctx, key, &live))
+	assert.Equal(t, key.Name, deploymentConfigMap(t, r, key))
+
+	r.Client = c
+	current = reconcileProxy(t, r, key, nil)
+	assert.True(t, errors.IsNotFound(r.Get(ctx, key, &live)))
+	assert.Equal(t, current.Status.Revisions[0].ConfigMapName, deploymentConfigMap(t, r, key))
+}
@@ -0,0 +1,100 @@
+package ctrl
+
+import (
+	context "context"
+	"reflect"
+
+	"github.com/rs/zerolog/log"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+// serviceAccountName returns the ServiceAccount the proxy pods run as, "" means the namespace default
+func serviceAccountName(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) string {
+	sa := nginxProxy.Spec.ServiceAccount
+	if sa == nil {
+		return ""
+	}
+	if sa.Create {
+		return nginxProxy.Name
+	}
+	return sa.Name
+}
+
+// automountServiceAccountToken returns whether the API token is mounted into proxy pods, false unless requested
+func automountServiceAccountToken(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *bool {
+	automount := false
+	if sa := nginxProxy.Spec.ServiceAccount; sa != nil && sa.AutomountServiceAccountToken != nil {
+		automount = *sa.AutomountServiceAccountToken
+	}
+	return &automount
+}
+
+func imagePullSecrets(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) []corev1.LocalObjectReference {
+	var secrets []corev1.LocalObjectReference
+	for _, name := range nginxProxy.Spec.Image.PullSecrets {
+		secrets = append(secrets, corev1.LocalObjectReference{Name: name})
+	}
+	return secrets
+}
+
+func buildServiceAccount(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		ObjectMeta:                   childObjectMeta(nginxProxy, nginxProxy.Spec.ServiceAccount.Annotations),
+		AutomountServiceAccountToken: automountServiceAccountToken(nginxProxy),
+		ImagePullSecrets:             imagePullSecrets(nginxProxy),
+	}
+}
+
+// reconcileServiceAccount creates the dedicated ServiceAccount when requested and removes it once it is
+// no longer used. ServiceAccounts the controller did not create are never touched, with create requested
+// a same-named one is reported as error.
+func (r *JaegerNginxProxyReconciler) reconcileServiceAccount(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (bool, error) {
+	var existing corev1.ServiceAccount
+	err := r.Get(ctx, client.ObjectKey{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace}, &existing)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	found := err == nil
+
+	if nginxProxy.Spec.ServiceAccount == nil || !nginxProxy.Spec.ServiceAccount.Create {
+		if found && metav1.IsControlledBy(&existing, nginxProxy) {
+			log.Info().Msgf("Deleting ServiceAccount no longer used by JaegerNginxProxy: %s %s", existing.Name, existing.Namespace)
+			return false, r.deleteIfExists(ctx, &existing)
+		}
+		return false, nil
+	}
+
+	sa := buildServiceAccount(nginxProxy)
+	if err := ctrl.SetControllerReference(nginxProxy, sa, r.Scheme); err != nil {
+		return false, err
+	}
+
+	if !found {
+		log.Info().Msgf("Creating ServiceAccount for JaegerNginxProxy: %s %s", sa.Name, sa.Namespace)
+		return false, r.createChild(ctx, nginxProxy, sa)
+	}
+	if !metav1.IsControlledBy(&existing, nginxProxy) {
+		return false, r.notControlledError(nginxProxy, &existing)
+	}
+
+	metadataChanged := convergeMetadata(&existing, sa)
+	if !metadataChanged &&
+		reflect.DeepEqual(existing.AutomountServiceAccountToken, sa.AutomountServiceAccountToken) &&
+		reflect.DeepEqual(existing.ImagePullSecrets, sa.ImagePullSecrets) {
+		log.Debug().Msgf("ServiceAccount is up to date: %s %s", sa.Name, sa.Namespace)
+		return false, nil
+	}
+
+	log.Info().Msgf("ServiceAccount changed, updating: %s %s", sa.Name, sa.Namespace)
+	existing.AutomountServiceAccountToken = sa.AutomountServiceAccountToken
+	existing.ImagePullSecrets = sa.ImagePullSecrets
+	return r.updateChild(ctx, nginxProxy, &existing)
+}
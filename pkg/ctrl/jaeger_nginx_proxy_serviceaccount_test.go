@@ -0,0 +1,77 @@
+package ctrl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func TestBuildDeploymentPodIdentity(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Image.PullPolicy = "Always"
+	nginxProxy.Spec.Image.PullSecrets = []string{"registry-creds"}
+
+	dep := buildDeployment(nginxProxy)
+	podSpec := dep.Spec.Template.Spec
+
+	assert.Equal(t, corev1.PullAlways, podSpec.Containers[0].ImagePullPolicy)
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "registry-creds"}}, podSpec.ImagePullSecrets)
+	assert.Equal(t, "", podSpec.ServiceAccountName, "namespace default ServiceAccount without spec.serviceAccount")
+	require.NotNil(t, podSpec.AutomountServiceAccountToken)
+	assert.False(t, *podSpec.AutomountServiceAccountToken, "token is not mounted by default")
+
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{Name: "existing"}
+	assert.Equal(t, "existing", buildDeployment(nginxProxy).Spec.Template.Spec.ServiceAccountName)
+
+	automount := true
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{Create: true, AutomountServiceAccountToken: &automount}
+	podSpec = buildDeployment(nginxProxy).Spec.Template.Spec
+	assert.Equal(t, "test-proxy", podSpec.ServiceAccountName)
+	assert.True(t, *podSpec.AutomountServiceAccountToken)
+}
+
+func TestBuildServiceAccount(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Image.PullSecrets = []string{"registry-creds"}
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{
+		Create:      true,
+		Annotations: map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::123:role/proxy"},
+	}
+
+	sa := buildServiceAccount(nginxProxy)
+
+	assert.Equal(t, "test-proxy", sa.Name)
+	assert.Equal(t, "default", sa.Namespace)
+	assert.Equal(t, "arn:aws:iam::123:role/proxy", sa.Annotations["eks.amazonaws.com/role-arn"])
+	assert.False(t, *sa.AutomountServiceAccountToken)
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "registry-creds"}}, sa.ImagePullSecrets)
+}
+
+func TestReconcileServiceAccountRefusesUncontrolledAccount(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheme(t)
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{Create: true}
+	nginxProxy.Spec.Image.PullSecrets = []string{"registry-creds"}
+	userAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: nginxProxy.Name, Namespace: nginxProxy.Namespace}}
+	recorder := record.NewFakeRecorder(10)
+	r := &JaegerNginxProxyReconciler{Client: fake.NewClientBuilder().WithScheme(s).WithObjects(userAccount).Build(), Scheme: s, Recorder: recorder}
+
+	_, err := r.reconcileServiceAccount(ctx, nginxProxy)
+	assert.ErrorContains(t, err, "ServiceAccount test-proxy already exists and is not controlled by JaegerNginxProxy test-proxy")
+
+	var sa corev1.ServiceAccount
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(userAccount), &sa))
+	assert.Empty(t, sa.ImagePullSecrets, "the user's ServiceAccount is not overwritten")
+	assert.Empty(t, sa.OwnerReferences)
+	assert.Len(t, eventsWithReason(drainEvents(recorder), ReasonResourceExists), 1)
+}
@@ -0,0 +1,387 @@
+package ctrl
+
+import (
+	context "context"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/rs/zerolog/log"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+const (
+	DefaultConfigTemplateKey = "proxy.conf.tmpl"
+
+	configTemplateField = "spec.configTemplate"
+	serverSnippetField  = "spec.snippets.server"
+)
+
+// DefaultAllowedDirectives are the directives templates and snippets may use unless the controller is
+// configured otherwise. Directives reading files, running code or loading modules are left out.
+var DefaultAllowedDirectives = []string{
+	// Directives of the generated config
+	"log_format", "upstream", "server", "listen", "http2", "access_log", "error_log",
+	"proxy_connect_timeout", "proxy_send_timeout", "proxy_read_timeout", "send_timeout",
+	"client_max_body_size", "location", "return", "proxy_pass", "grpc_pass", "map", "default",
+	"set_real_ip_from", "real_ip_header", "real_ip_recursive", "allow", "deny", "split_clients",
+	"stub_status",
+	// Headers, buffering and timeouts
+	"proxy_set_header", "proxy_hide_header", "proxy_http_version", "proxy_buffering", "proxy_buffer_size",
+	"proxy_buffers", "proxy_request_buffering", "proxy_next_upstream", "proxy_next_upstream_tries",
+	"proxy_next_upstream_timeout", "grpc_set_header", "grpc_hide_header", "grpc_connect_timeout",
+	"grpc_send_timeout", "grpc_read_timeout", "add_header", "keepalive", "keepalive_timeout",
+	"keepalive_requests", "client_body_buffer_size", "client_body_timeout", "client_header_timeout",
+	"large_client_header_buffers", "server_name", "server_tokens", "gzip", "gzip_types",
+	// Access control and request handling
+	"limit_req", "limit_conn", "set", "if", "rewrite",
+}
+
+// ConfigTemplateData is passed to spec.configTemplate
+type ConfigTemplateData struct {
+	Name      string
+	Namespace string
+	Spec      JaegerNginxProxyV1alpha0.JaegerNginxProxySpec
+	// Generated is the config the controller generates without a template
+	Generated string
+}
+
+var configTemplateFuncs = template.FuncMap{
+	"protocol": PortProtocol,
+}
+
+// renderProxyConfig renders the config of the proxy from tmpl, or generates it when tmpl is empty, and
+// returns the spec field every line comes from
+func renderProxyConfig(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, tmpl string) (string, []string, error) {
+	generated, sources := renderNginxConfig(nginxProxy)
+	if tmpl == "" {
+		return generated, sources, nil
+	}
+
+	parsed, err := template.New(nginxProxy.Name).Funcs(configTemplateFuncs).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", nil, &ConfigError{Field: configTemplateField, Msg: fmt.Sprintf("invalid config template: %v", err)}
+	}
+	var config strings.Builder
+	if err := parsed.Execute(&config, ConfigTemplateData{
+		Name:      nginxProxy.Name,
+		Namespace: nginxProxy.Namespace,
+		Spec:      nginxProxy.Spec,
+		Generated: generated,
+	}); err != nil {
+		return "", nil, &ConfigError{Field: configTemplateField, Msg: fmt.Sprintf("failed to render config template: %v", err)}
+	}
+
+	// Every line of a rendered template is user supplied
+	sources = make([]string, strings.Count(config.String(), "\n"))
+	for i := range sources {
+		sources[i] = configTemplateField
+	}
+	return config.String(), sources, nil
+}
+
+// writeSnippet adds a snippet to the config, indented to the block it is inserted into
+func (b *configBuilder) writeSnippet(fieldPath, snippet, indent string) {
+	if strings.TrimSpace(snippet) == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(snippet, "\n"), "\n") {
+		b.Writef(fieldPath, "%s%s\n", indent, strings.TrimSpace(line))
+	}
+}
+
+func locationSnippetField(portName string) string {
+	return fmt.Sprintf("spec.snippets.location[%s]", portName)
+}
+
+// isUserSupplied reports whether config lines of source come from a template or snippet
+func isUserSupplied(source string) bool {
+	return source == configTemplateField || strings.HasPrefix(source, "spec.snippets.")
+}
+
+// FetchConfigTemplate returns the content of spec.configTemplate, "" when the proxy does not use a template
+func FetchConfigTemplate(ctx context.Context, c client.Reader, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (string, error) {
+	ref := nginxProxy.Spec.ConfigTemplate
+	if ref == nil {
+		return "", nil
+	}
+	key := ref.Key
+	if key == "" {
+		key = DefaultConfigTemplateKey
+	}
+
+	var cm corev1.ConfigMap
+	if err := c.Get(ctx, client.ObjectKey{Name: ref.ConfigMapName, Namespace: nginxProxy.Namespace}, &cm); err != nil {
+		if errors.IsNotFound(err) {
+			return "", &ConfigError{Field: configTemplateField + ".configMapName", Msg: fmt.Sprintf("config template ConfigMap %s not found", ref.ConfigMapName)}
+		}
+		return "", err
+	}
+	tmpl, ok := cm.Data[key]
+	if !ok {
+		return "", &ConfigError{Field: configTemplateField + ".key", Msg: fmt.Sprintf("config template ConfigMap %s has no key %s", ref.ConfigMapName, key)}
+	}
+	return tmpl, nil
+}
+
+// proxiesForConfigTemplate maps a ConfigMap to the proxies using it as config template
+func (r *JaegerNginxProxyReconciler) proxiesForConfigTemplate(ctx context.Context, obj client.Object) []reconcile.Request {
+	var proxies JaegerNginxProxyV1alpha0.JaegerNginxProxyList
+	if err := r.List(ctx, &proxies, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.Error().Err(err).Msgf("Failed to list JaegerNginxProxies for config template: %s %s", obj.GetName(), obj.GetNamespace())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, proxy := range proxies.Items {
+		if proxy.Spec.ConfigTemplate != nil && proxy.Spec.ConfigTemplate.ConfigMapName == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: proxy.Name, Namespace: proxy.Namespace}})
+		}
+	}
+	return requests
+}
+
+// DirectiveAllowlist rejects directives of templates and snippets that the controller does not allow,
+// and snippets closing the block they are inserted into
+type DirectiveAllowlist struct {
+	Allowed []string
+}
+
+func (v *DirectiveAllowlist) Name() string { return "allowlist" }
+
+// Validate checks every directive of config, which is treated as user supplied as a whole
+func (v *DirectiveAllowlist) Validate(config string) []JaegerNginxProxyV1alpha0.ConfigFinding {
+	return v.ValidateProxy(nil, config, nil)
+}
+
+// ValidateProxy only checks lines generated from a template or snippet
+func (v *DirectiveAllowlist) ValidateProxy(_ *JaegerNginxProxyV1alpha0.JaegerNginxProxy, config string, sources []string) []JaegerNginxProxyV1alpha0.ConfigFinding {
+	userSupplied := func(line int) bool {
+		return sources == nil || (line <= len(sources) && isUserSupplied(sources[line-1]))
+	}
+
+	var findings []JaegerNginxProxyV1alpha0.ConfigFinding
+	directives, braces, err := parseDirectives(config)
+	if syntaxErr, ok := err.(*syntaxError); ok {
+		findings = append(findings, JaegerNginxProxyV1alpha0.ConfigFinding{
+			Rule:     "syntax",
+			Severity: SeverityError,
+			Line:     syntaxErr.line,
+			Message:  syntaxErr.msg,
+		})
+	}
+	for _, directive := range directives {
+		if userSupplied(directive.line) && !slices.Contains(v.Allowed, directive.name) {
+			findings = append(findings, JaegerNginxProxyV1alpha0.ConfigFinding{
+				Rule:     "directive-not-allowed",
+				Severity: SeverityError,
+				Line:     directive.line,
+				Message:  fmt.Sprintf("directive %s is not allowed by the controller", directive.name),
+			})
+		}
+	}
+
+	// A snippet must leave the nesting as it found it
+	for start := 0; start < len(sources); {
+		end := start
+		for end+1 < len(sources) && sources[end+1] == sources[start] {
+			end++
+		}
+		if strings.HasPrefix(sources[start], "spec.snippets.") {
+			depth, escaped := 0, false
+			for _, b := range braces {
+				if b.line <= start || b.line > end+1 {
+					continue
+				}
+				if b.open {
+					depth++
+				} else {
+					depth--
+				}
+				escaped = escaped || depth < 0
+			}
+			if escaped || depth != 0 {
+				findings = append(findings, JaegerNginxProxyV1alpha0.ConfigFinding{
+					Rule:     "snippet-braces",
+					Severity: SeverityError,
+					Line:     start + 1,
+					Message:  "snippet braces must be balanced and cannot close the enclosing block",
+				})
+			}
+		}
+		start = end + 1
+	}
+	return findings
+}
+
+type directive struct {
+	name string
+	line int
+}
+
+// brace is a block opened or closed on line
+type brace struct {
+	line int
+	open bool
+}
+
+// valueBlocks hold key and value pairs instead of directives, only include reads a file there
+var valueBlocks = []string{"map", "split_clients", "geo", "types"}
+
+type syntaxError struct {
+	line int
+	msg  string
+}
+
+func (e *syntaxError) Error() string { return fmt.Sprintf("line %d: %s", e.line, e.msg) }
+
+// parseDirectives returns the directives of an nginx config with the line they start on and its braces. It
+// splits tokens like nginx does: quotes only start a string at the beginning of a token, a backslash escapes
+// the next character, "#" starts a comment at the beginning of a token and "${" is part of a variable.
+// The first token of a statement is the directive name, quoted or not, except for the entries of
+// valueBlocks. A *syntaxError is returned with the directives before it when the config is not valid nginx.
+func parseDirectives(config string) ([]directive, []brace, error) {
+	var directives []directive
+	var braces []brace
+	// blocks are the names of the enclosing blocks, innermost last
+	var blocks []string
+	// current is the statement being read, nil before its first token
+	var current *directive
+	var word strings.Builder
+	line, wordLine := 1, 1
+	inWord, quote, escaped, needSpace, comment := false, rune(0), false, false, false
+
+	startWord := func() {
+		inWord, wordLine = true, line
+	}
+	endWord := func() {
+		if current == nil {
+			current = &directive{name: word.String(), line: wordLine}
+		}
+		word.Reset()
+		inWord = false
+	}
+	// endStatement ends the current statement at ";", "{" or "}"
+	endStatement := func(c rune) error {
+		name := ""
+		if current != nil {
+			if c == '}' {
+				return &syntaxError{line: line, msg: fmt.Sprintf("unexpected \"}\" in directive %s", current.name)}
+			}
+			name = current.name
+			if len(blocks) == 0 || !slices.Contains(valueBlocks, blocks[len(blocks)-1]) || name == "include" {
+				directives = append(directives, *current)
+			}
+			current = nil
+		} else if c == ';' {
+			return &syntaxError{line: line, msg: "unexpected \";\""}
+		}
+		switch c {
+		case '{':
+			blocks = append(blocks, name)
+		case '}':
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+		}
+		if c != ';' {
+			braces = append(braces, brace{line: line, open: c == '{'})
+		}
+		return nil
+	}
+
+	for _, c := range config {
+		var err error
+		switch {
+		case comment:
+			comment = c != '\n'
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case quote != 0:
+			switch c {
+			case '\\':
+				escaped = true
+			case quote:
+				quote = 0
+				endWord()
+				needSpace = true
+			default:
+				word.WriteRune(c)
+			}
+		case needSpace:
+			switch c {
+			case ' ', '\t', '\r', '\n', ')':
+				needSpace = false
+			case ';', '{':
+				needSpace = false
+				err = endStatement(c)
+			default:
+				err = &syntaxError{line: line, msg: fmt.Sprintf("unexpected %q after a quoted string", c)}
+			}
+		case inWord:
+			switch c {
+			case ' ', '\t', '\r', '\n':
+				endWord()
+			case ';':
+				endWord()
+				err = endStatement(c)
+			case '{':
+				if strings.HasSuffix(word.String(), "$") {
+					word.WriteRune(c)
+					break
+				}
+				endWord()
+				err = endStatement(c)
+			case '\\':
+				escaped = true
+			default:
+				word.WriteRune(c)
+			}
+		default:
+			switch c {
+			case ' ', '\t', '\r', '\n':
+			case '#':
+				comment = true
+			case ';', '{', '}':
+				err = endStatement(c)
+			case '"', '\'':
+				startWord()
+				quote = c
+			case '\\':
+				startWord()
+				escaped = true
+			default:
+				startWord()
+				word.WriteRune(c)
+			}
+		}
+		if err != nil {
+			return directives, braces, err
+		}
+		if c == '\n' {
+			line++
+		}
+	}
+	if quote != 0 || escaped {
+		return directives, braces, &syntaxError{line: wordLine, msg: "unterminated quoted string or escape"}
+	}
+	if inWord {
+		endWord()
+	}
+	if current != nil {
+		blocks = nil
+		// Still checked, nginx rejects a missing ";" but the directive must not pass unnoticed
+		directives = append(directives, *current)
+		return directives, braces, &syntaxError{line: current.line, msg: fmt.Sprintf("directive %s is not terminated by \";\"", current.name)}
+	}
+	return directives, braces, nil
+}
@@ -0,0 +1,203 @@
+package ctrl
+
+import (
+	context "context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+func TestRenderProxyConfigTemplate(t *testing.T) {
+	nginxProxy := newTestProxy()
+	tmpl := "# {{ .Namespace }}/{{ .Name }}\n{{ .Generated }}" +
+		"{{ range .Spec.Ports }}# {{ .Name }} {{ protocol . }}\n{{ end }}"
+
+	config, sources, err := renderProxyConfig(nginxProxy, tmpl)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(config, "# default/test-proxy\nlog_format custom_format"))
+	assert.True(t, strings.HasSuffix(config, "# http http\n# grpc grpc\n"))
+	require.Len(t, sources, strings.Count(config, "\n"))
+	for _, source := range sources {
+		assert.Equal(t, configTemplateField, source)
+	}
+
+	_, _, err = renderProxyConfig(nginxProxy, "{{ .Generated")
+	assert.ErrorContains(t, err, "invalid config template")
+	assert.Equal(t, configTemplateField, ConfigErrorField(err))
+
+	_, _, err = renderProxyConfig(nginxProxy, "{{ .Spec.Missing }}")
+	assert.ErrorContains(t, err, "failed to render config template")
+
+	generated, _, err := renderProxyConfig(nginxProxy, "")
+	require.NoError(t, err)
+	assert.Equal(t, GenerateNginxConfig(nginxProxy), generated)
+}
+
+func TestRenderNginxConfigSnippets(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Snippets = &JaegerNginxProxyV1alpha0.Snippets{
+		Server:   "gzip on;\ngzip_types application/json;\n",
+		Location: map[string]string{"grpc": "grpc_read_timeout 30s;"},
+	}
+
+	config, sources := renderNginxConfig(nginxProxy)
+	assert.Contains(t, config, "  gzip on;\n  gzip_types application/json;\n")
+	assert.Contains(t, config, "     grpc_pass grpc://jaeger-collector-grpc;\n     grpc_read_timeout 30s;\n  }\n")
+
+	lines := strings.Split(config, "\n")
+	for i, line := range lines[:len(sources)] {
+		switch strings.TrimSpace(line) {
+		case "gzip on;", "gzip_types application/json;":
+			assert.Equal(t, serverSnippetField, sources[i])
+		case "grpc_read_timeout 30s;":
+			assert.Equal(t, "spec.snippets.location[grpc]", sources[i])
+		}
+	}
+
+	_, findings, err := DefaultConfigValidators().ValidateProxyConfig(nginxProxy, "")
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+}
+
+func TestDirectiveAllowlist(t *testing.T) {
+	validators := DefaultConfigValidators()
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Snippets = &JaegerNginxProxyV1alpha0.Snippets{
+		Location: map[string]string{"http": "add_header X-Proxy jaeger;\ninclude /etc/passwd;"},
+	}
+	_, findings, err := validators.ValidateProxyConfig(nginxProxy, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "directive include is not allowed")
+	assert.Equal(t, "spec.snippets.location[http]", ConfigErrorField(err))
+	require.Len(t, findings, 1)
+	assert.Equal(t, "allowlist", findings[0].Validator)
+	assert.Equal(t, "directive-not-allowed", findings[0].Rule)
+
+	// A snippet escaping its block would add directives outside of the allowlist's reach
+	nginxProxy.Spec.Snippets = &JaegerNginxProxyV1alpha0.Snippets{Server: "}\nserver {"}
+	_, findings, err = validators.ValidateProxyConfig(nginxProxy, "")
+	require.Error(t, err)
+	assert.Equal(t, serverSnippetField, ConfigErrorField(err))
+	assert.Equal(t, "snippet-braces", findings[0].Rule)
+
+	// Templates are checked as a whole, generated lines are trusted
+	_, _, err = validators.ValidateProxyConfig(newTestProxy(), "load_module modules/evil.so;\n{{ .Generated }}")
+	assert.ErrorContains(t, err, "directive load_module is not allowed")
+	// Quotes and escapes cannot hide directives from the allowlist
+	for _, snippet := range []string{
+		`set $a x"; include /etc/passwd; set $b y";`,
+		`"include" /etc/passwd;`,
+		`set $a \"; include /etc/passwd; set $b \";`,
+	} {
+		nginxProxy.Spec.Snippets = &JaegerNginxProxyV1alpha0.Snippets{Location: map[string]string{"http": snippet}}
+		_, _, err = validators.ValidateProxyConfig(nginxProxy, "")
+		assert.ErrorContains(t, err, "directive include is not allowed", snippet)
+	}
+	nginxProxy.Spec.Snippets = &JaegerNginxProxyV1alpha0.Snippets{Location: map[string]string{"http": `add_header X-Proxy "{"; }`}}
+	_, findings, err = validators.ValidateProxyConfig(nginxProxy, "")
+	require.Error(t, err)
+	assert.Equal(t, "snippet-braces", findings[0].Rule)
+
+	config, sources := renderNginxConfig(newTestProxy())
+	assert.Empty(t, (&DirectiveAllowlist{}).ValidateProxy(newTestProxy(), config, sources))
+	assert.NotEmpty(t, (&DirectiveAllowlist{}).Validate(config), "without sources every line is checked")
+}
+
+func TestParseDirectives(t *testing.T) {
+	config := `log_format main '$remote_addr "$request"'
+                 '$status';
+# include /etc/nginx/secret.conf;
+server {
+  set $x "a;b {c}"; return 200;
+  location / { proxy_pass http://upstream; }
+}
+`
+	directives, braces, err := parseDirectives(config)
+	require.NoError(t, err)
+	var names []string
+	var lines []int
+	for _, d := range directives {
+		names = append(names, d.name)
+		lines = append(lines, d.line)
+	}
+	assert.Equal(t, []string{"log_format", "server", "set", "return", "location", "proxy_pass"}, names)
+	assert.Equal(t, []int{1, 4, 5, 5, 6, 6}, lines)
+	assert.Equal(t, []brace{{4, true}, {6, true}, {6, false}, {7, false}}, braces)
+
+	// Tokens are split like nginx does, not by the first quote
+	for config, want := range map[string][]string{
+		`set $a x"; include /etc/passwd; set $b y";`:                             {"set", "include", "set"},
+		`"load_module" /tmp/evil.so;`:                                            {"load_module"},
+		`set $a \"; include /etc/passwd; set $b \";`:                             {"set", "include", "set"},
+		`set $a "x\"; include /etc/passwd; #";`:                                  {"set"},
+		`set $a ${host}x; # not a block`:                                         {"set"},
+		"add_header X-A a#b;\n'add_header' X-B 'b' ;":                            {"add_header", "add_header"},
+		`map $uri $x { "load_module" 1; include /etc/passwd; } return 200 "\\";`: {"map", "include", "return"},
+	} {
+		directives, _, err := parseDirectives(config)
+		require.NoError(t, err, config)
+		names = nil
+		for _, d := range directives {
+			names = append(names, d.name)
+		}
+		assert.Equal(t, want, names, config)
+	}
+
+	for config, line := range map[string]int{
+		`set $a "x"y;`:              1,
+		"set $a \"x;\n":             1,
+		"return 200;\nload_module":  2,
+		"location / { return 200 }": 1,
+		"\n;":                       2,
+	} {
+		_, _, err := parseDirectives(config)
+		var syntaxErr *syntaxError
+		require.ErrorAs(t, err, &syntaxErr, config)
+		assert.Equal(t, line, syntaxErr.line, config)
+	}
+}
+
+func TestReconcileConfigTemplate(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.ConfigTemplate = &JaegerNginxProxyV1alpha0.ConfigTemplate{ConfigMapName: "proxy-template"}
+	r, _ := newRevisionTestReconciler(t, nginxProxy)
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(nginxProxy)
+
+	// The template does not exist yet
+	current := reconcileProxy(t, r, key, nil)
+	assert.True(t, meta.IsStatusConditionFalse(current.Status.Conditions, ConditionConfigValid))
+	assert.Contains(t, meta.FindStatusCondition(current.Status.Conditions, ConditionConfigValid).Message, "spec.configTemplate.configMapName")
+	assert.Empty(t, current.Status.Revisions)
+
+	tmpl := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "proxy-template", Namespace: key.Namespace},
+		Data:       map[string]string{DefaultConfigTemplateKey: "# custom\n{{ .Generated }}"},
+	}
+	require.NoError(t, r.Create(ctx, tmpl))
+	assert.Equal(t, key, r.proxiesForConfigTemplate(ctx, tmpl)[0].NamespacedName)
+	assert.Empty(t, r.proxiesForConfigTemplate(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: key.Namespace}}))
+
+	current = reconcileProxy(t, r, key, nil)
+	assert.True(t, meta.IsStatusConditionTrue(current.Status.Conditions, ConditionConfigValid))
+	require.Len(t, current.Status.Revisions, 1)
+	var cm corev1.ConfigMap
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Name: current.Status.Revisions[0].ConfigMapName, Namespace: key.Namespace}, &cm))
+	assert.Equal(t, "# custom\n"+GenerateNginxConfig(nginxProxy), cm.Data[ConfigFileName])
+
+	// An invalid template keeps the last valid revision
+	tmpl.Data[DefaultConfigTemplateKey] = "include /etc/nginx/*.conf;\n{{ .Generated }}"
+	require.NoError(t, r.Update(ctx, tmpl))
+	current = reconcileProxy(t, r, key, nil)
+	assert.True(t, meta.IsStatusConditionFalse(current.Status.Conditions, ConditionConfigValid))
+	assert.Len(t, current.Status.Revisions, 1)
+	assert.Equal(t, cm.Name, deploymentConfigMap(t, r, key))
+}
@@ -1,12 +1,18 @@
 package ctrl
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+	"github.com/dolv/k8s-controller-tutorial/pkg/testutil"
+	"github.com/dolv/k8s-controller-tutorial/pkg/tracing"
 )
 
 func TestStatusLogicWithZeroReplicas(t *testing.T) {
@@ -119,3 +125,103 @@ func TestBuildDeploymentWithZeroReplicas(t *testing.T) {
 	assert.Equal(t, "test-proxy", deployment.Name)
 	assert.Equal(t, "default", deployment.Namespace)
 }
+
+// newTestProxy returns a minimal valid JaegerNginxProxy for builder tests
+func newTestProxy() *JaegerNginxProxyV1alpha0.JaegerNginxProxy {
+	return &JaegerNginxProxyV1alpha0.JaegerNginxProxy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-proxy",
+			Namespace: "default",
+		},
+		Spec: JaegerNginxProxyV1alpha0.JaegerNginxProxySpec{
+			ReplicaCount:  1,
+			ContainerPort: 8080,
+			Image: JaegerNginxProxyV1alpha0.Image{
+				Repository: "nginx",
+				Tag:        "1.28.0",
+				PullPolicy: "IfNotPresent",
+			},
+			Upstream: JaegerNginxProxyV1alpha0.Upstream{
+				CollectorHost: "jaeger-collector.tracing.svc.cluster.local",
+			},
+			Ports: []JaegerNginxProxyV1alpha0.Port{
+				{Name: "http", Port: 14268, Path: "/api/traces"},
+				{Name: "grpc", Port: 14250, Path: "/jaeger.api.v2.CollectorService/PostSpans"},
+			},
+			Service: JaegerNginxProxyV1alpha0.Service{Type: "ClusterIP"},
+			Resources: JaegerNginxProxyV1alpha0.Resources{
+				Limits:   JaegerNginxProxyV1alpha0.Resource{CPU: "500m", Memory: "512Mi"},
+				Requests: JaegerNginxProxyV1alpha0.Resource{CPU: "100m", Memory: "128Mi"},
+			},
+		},
+	}
+}
+
+func TestBuildService(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Service.Type = "NodePort"
+
+	svc := buildService(nginxProxy)
+
+	assert.Equal(t, "test-proxy", svc.Name)
+	assert.Equal(t, corev1.ServiceTypeNodePort, svc.Spec.Type)
+	assert.Equal(t, map[string]string{"app": "test-proxy"}, svc.Spec.Selector)
+	assert.Len(t, svc.Spec.Ports, 1)
+	assert.Equal(t, int32(8080), svc.Spec.Ports[0].Port)
+	assert.Equal(t, "http", svc.Spec.Ports[0].Name)
+}
+
+func TestBuildDeploymentWithExtras(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Env = []corev1.EnvVar{{Name: "NGINX_ENTRYPOINT_QUIET_LOGS", Value: "1"}}
+	nginxProxy.Spec.ExtraVolumes = []corev1.Volume{{Name: "error-pages"}}
+	nginxProxy.Spec.ExtraVolumeMounts = []corev1.VolumeMount{{Name: "error-pages", MountPath: "/usr/share/nginx/errors"}}
+	nginxProxy.Spec.ExtraContainers = []corev1.Container{{Name: "log-shipper", Image: "fluent/fluent-bit:3.2"}}
+
+	podSpec := buildDeployment(nginxProxy).Spec.Template.Spec
+
+	assert.Len(t, podSpec.Containers, 2)
+	assert.Equal(t, NginxContainerName, podSpec.Containers[0].Name)
+	assert.Equal(t, "log-shipper", podSpec.Containers[1].Name)
+	assert.Equal(t, nginxProxy.Spec.Env, podSpec.Containers[0].Env)
+	assert.Equal(t, []corev1.VolumeMount{
+		{Name: ConfigVolumeName, MountPath: ConfigMountPath},
+		{Name: "error-pages", MountPath: "/usr/share/nginx/errors"},
+	}, podSpec.Containers[0].VolumeMounts)
+	assert.Len(t, podSpec.Volumes, 2)
+	assert.Equal(t, ConfigVolumeName, podSpec.Volumes[0].Name)
+	assert.Equal(t, "error-pages", podSpec.Volumes[1].Name)
+}
+
+func TestListDerivative(t *testing.T) {
+	mode := int32(0644)
+	desired := []corev1.Volume{{Name: "extra", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}}}}
+	defaulted := []corev1.Volume{{Name: "extra", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{DefaultMode: &mode}}}}
+
+	assert.True(t, listDerivative(desired, defaulted), "server defaults are ignored")
+	assert.False(t, listDerivative(defaulted, desired))
+	assert.False(t, listDerivative(nil, defaulted), "removed entries are detected")
+	assert.True(t, listDerivative[corev1.Volume](nil, nil))
+}
+
+func TestReconcileTracing(t *testing.T) {
+	ctx := context.Background()
+	receiver := testutil.StartOTLPReceiver(t)
+	shutdown, err := tracing.Setup(ctx, tracing.Options{Endpoint: receiver.URL, Sampler: tracing.SamplerAlwaysOn})
+	require.NoError(t, err)
+
+	nginxProxy := newTestProxy()
+	r, _ := newRevisionTestReconciler(t, nginxProxy)
+	reconcileProxy(t, r, client.ObjectKeyFromObject(nginxProxy), nil)
+	require.NoError(t, shutdown(ctx))
+
+	root := receiver.WaitForSpan(t, "Reconcile JaegerNginxProxy")
+	assert.Equal(t, "test-proxy", testutil.SpanAttribute(root, "k8s.jaegernginxproxy.name"))
+	assert.Equal(t, "default", testutil.SpanAttribute(root, "k8s.namespace.name"))
+	for _, name := range []string{"BuildConfig", "ValidateConfig", "Create ConfigMap", "Create Deployment", "Create Service", "UpdateStatus"} {
+		span := receiver.WaitForSpan(t, name)
+		assert.Equal(t, root.TraceId, span.TraceId, name)
+		assert.Equal(t, root.SpanId, span.ParentSpanId, "%s is a step of the reconcile", name)
+	}
+	assert.Equal(t, "test-proxy", testutil.SpanAttribute(receiver.WaitForSpan(t, "Create Deployment"), "k8s.resource.name"))
+}
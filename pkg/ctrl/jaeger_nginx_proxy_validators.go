@@ -0,0 +1,449 @@
+package ctrl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+	"github.com/dolv/k8s-controller-tutorial/pkg/tracing"
+)
+
+const (
+	SeverityError   = "Error"
+	SeverityWarning = "Warning"
+
+	// NginxTestOff, NginxTestWarn and NginxTestStrict select whether a failing nginx -t is ignored,
+	// reported as a warning or rejects the config
+	NginxTestOff    = "off"
+	NginxTestWarn   = "warn"
+	NginxTestStrict = "strict"
+
+	LintRuleProxyHostHeader    = "proxy-host-header"
+	LintRuleClientMaxBodySize  = "client-max-body-size"
+	LintRuleServerNameCatchAll = "server-name-catch-all"
+)
+
+// LintRules lists the rules of the built-in lint validator
+var LintRules = []string{LintRuleProxyHostHeader, LintRuleClientMaxBodySize, LintRuleServerNameCatchAll}
+
+// ConfigValidator checks a generated nginx config
+type ConfigValidator interface {
+	// Name identifies the validator in findings
+	Name() string
+	// Validate returns the findings for config, lines are 1-based
+	Validate(config string) []JaegerNginxProxyV1alpha0.ConfigFinding
+}
+
+// ProxyConfigValidator is a ConfigValidator that also needs the proxy and the spec field every line of
+// the config was generated from, "" for static lines
+type ProxyConfigValidator interface {
+	ConfigValidator
+	ValidateProxy(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, config string, sources []string) []JaegerNginxProxyV1alpha0.ConfigFinding
+}
+
+// ConfigValidators runs several validators in order
+type ConfigValidators []ConfigValidator
+
+// ValidationOptions selects the config validators, see NewConfigValidators
+type ValidationOptions struct {
+	// NginxTest is the nginx -t mode: off, warn or strict
+	NginxTest string
+	// NginxBinary is the nginx executable used by nginx -t, looked up in PATH by default
+	NginxBinary string
+	// Lint enables the built-in lint validator
+	Lint bool
+	// LintDisabledRules are lint rules that are not checked
+	LintDisabledRules []string
+	// AllowedDirectives may be used by config templates and snippets, DefaultAllowedDirectives when nil
+	AllowedDirectives []string
+}
+
+// DefaultConfigValidators returns the validators used when none are configured
+func DefaultConfigValidators() ConfigValidators {
+	return ConfigValidators{StructuralValidator{}, &DirectiveAllowlist{Allowed: DefaultAllowedDirectives}}
+}
+
+// NewConfigValidators returns the structural validator and directive allowlist followed by the validators
+// enabled in opts. Strict nginx -t fails right away when nginx is not installed, instead of rejecting
+// every config later.
+func NewConfigValidators(opts ValidationOptions) (ConfigValidators, error) {
+	validators := DefaultConfigValidators()
+	if opts.AllowedDirectives != nil {
+		validators[1] = &DirectiveAllowlist{Allowed: opts.AllowedDirectives}
+	}
+
+	switch opts.NginxTest {
+	case "", NginxTestOff:
+	case NginxTestWarn, NginxTestStrict:
+		nginx := &NginxValidator{Mode: opts.NginxTest, Binary: opts.NginxBinary}
+		if opts.NginxTest == NginxTestStrict {
+			if _, err := exec.LookPath(nginx.binary()); err != nil {
+				return nil, fmt.Errorf("nginx -t is strict but %s is not available: %w", nginx.binary(), err)
+			}
+		}
+		validators = append(validators, nginx)
+	default:
+		return nil, fmt.Errorf("unsupported nginx -t mode %q, must be one of %s, %s or %s", opts.NginxTest, NginxTestOff, NginxTestWarn, NginxTestStrict)
+	}
+
+	if opts.Lint {
+		lint := &LintValidator{}
+		for _, rule := range opts.LintDisabledRules {
+			if !slices.Contains(LintRules, rule) {
+				return nil, fmt.Errorf("unknown lint rule %q, must be one of %s", rule, strings.Join(LintRules, ", "))
+			}
+		}
+		lint.Disabled = opts.LintDisabledRules
+		validators = append(validators, lint)
+	}
+	return validators, nil
+}
+
+// Validate runs every validator and returns all findings. The returned error is a *ConfigError for the
+// first finding with severity Error.
+func (vs ConfigValidators) Validate(config string) ([]JaegerNginxProxyV1alpha0.ConfigFinding, error) {
+	findings := vs.run(nil, config, nil)
+	return findings, firstConfigError(findings)
+}
+
+// ValidateProxyConfig renders the config of nginxProxy from tmpl, see renderProxyConfig, validates it,
+// including compatibility with the nginx version of the proxy image, and attributes every finding to
+// the spec field its line was generated from
+func (vs ConfigValidators) ValidateProxyConfig(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, tmpl string) (string, []JaegerNginxProxyV1alpha0.ConfigFinding, error) {
+	return vs.validateProxyConfig(context.Background(), nginxProxy, tmpl)
+}
+
+// validateProxyConfig is ValidateProxyConfig tracing rendering and validation as spans of ctx
+func (vs ConfigValidators) validateProxyConfig(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, tmpl string) (string, []JaegerNginxProxyV1alpha0.ConfigFinding, error) {
+	_, span := tracing.Start(ctx, "BuildConfig")
+	config, sources, err := renderProxyConfig(nginxProxy, tmpl)
+	tracing.End(span, err)
+	if err != nil {
+		finding := JaegerNginxProxyV1alpha0.ConfigFinding{Validator: "template", Rule: "render", Severity: SeverityError, Message: err.Error()}
+		var configErr *ConfigError
+		if errors.As(err, &configErr) {
+			finding.Field = configErr.Field
+		}
+		return "", []JaegerNginxProxyV1alpha0.ConfigFinding{finding}, err
+	}
+
+	_, span = tracing.Start(ctx, "ValidateConfig")
+	findings := append(vs.run(nginxProxy, config, sources), ConfigValidators{&VersionValidator{Tag: nginxProxy.Spec.Image.Tag}}.run(nginxProxy, config, sources)...)
+	// Validators may name a more specific field than the one the line was generated from
+	for i := range findings {
+		if line := findings[i].Line; findings[i].Field == "" && line > 0 && line <= len(sources) {
+			findings[i].Field = sources[line-1]
+		}
+	}
+	err = firstConfigError(findings)
+	span.SetAttributes(attribute.Int("findings", len(findings)))
+	tracing.End(span, err)
+	return config, findings, err
+}
+
+func (vs ConfigValidators) run(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, config string, sources []string) []JaegerNginxProxyV1alpha0.ConfigFinding {
+	if len(vs) == 0 {
+		vs = DefaultConfigValidators()
+	}
+	var findings []JaegerNginxProxyV1alpha0.ConfigFinding
+	for _, validator := range vs {
+		var validatorFindings []JaegerNginxProxyV1alpha0.ConfigFinding
+		if proxyValidator, ok := validator.(ProxyConfigValidator); ok && nginxProxy != nil {
+			validatorFindings = proxyValidator.ValidateProxy(nginxProxy, config, sources)
+		} else {
+			validatorFindings = validator.Validate(config)
+		}
+		for _, finding := range validatorFindings {
+			finding.Validator = validator.Name()
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// firstConfigError returns a *ConfigError for the first finding with severity Error
+func firstConfigError(findings []JaegerNginxProxyV1alpha0.ConfigFinding) error {
+	for _, finding := range findings {
+		if finding.Severity == SeverityError {
+			return &ConfigError{Line: finding.Line, Field: finding.Field, Msg: finding.Message}
+		}
+	}
+	return nil
+}
+
+// StructuralValidator checks braces and required directives, see ValidateNginxConfig
+type StructuralValidator struct{}
+
+func (StructuralValidator) Name() string { return "structural" }
+
+func (StructuralValidator) Validate(config string) []JaegerNginxProxyV1alpha0.ConfigFinding {
+	err := ValidateNginxConfig(config)
+	if err == nil {
+		return nil
+	}
+	finding := JaegerNginxProxyV1alpha0.ConfigFinding{Rule: "syntax", Severity: SeverityError, Message: err.Error()}
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		finding.Line = configErr.Line
+	}
+	return []JaegerNginxProxyV1alpha0.ConfigFinding{finding}
+}
+
+// NginxValidator runs nginx -t against the config wrapped into a minimal nginx.conf
+type NginxValidator struct {
+	// Mode is NginxTestWarn or NginxTestStrict
+	Mode   string
+	Binary string
+}
+
+// nginxTestLine matches the location nginx -t reports, e.g. "in /tmp/nginx-config-1.conf:12"
+var nginxTestLine = regexp.MustCompile(`in \S+:(\d+)`)
+
+const nginxTestHeader = `# Suppress default error log behavior
+error_log /dev/null;
+
+events {
+    worker_connections 1024;
+}
+
+http {
+`
+
+func (v *NginxValidator) Name() string { return "nginx" }
+
+func (v *NginxValidator) binary() string {
+	if v.Binary != "" {
+		return v.Binary
+	}
+	return "nginx"
+}
+
+func (v *NginxValidator) Validate(config string) []JaegerNginxProxyV1alpha0.ConfigFinding {
+	severity := SeverityWarning
+	if v.Mode == NginxTestStrict {
+		severity = SeverityError
+	}
+
+	binary, err := exec.LookPath(v.binary())
+	if err != nil {
+		if v.Mode != NginxTestStrict {
+			log.Warn().Msgf("%s binary not found in PATH: skipping nginx -t config validation", v.binary())
+			return nil
+		}
+		return []JaegerNginxProxyV1alpha0.ConfigFinding{{Rule: "nginx-t", Severity: severity, Message: err.Error()}}
+	}
+
+	stderr, err := runNginxTest(binary, config)
+	if err == nil {
+		return nil
+	}
+	// An upstream that cannot be resolved from the controller is expected during validation
+	if strings.Contains(stderr, "host not found in upstream") {
+		log.Debug().Msg("nginx validation passed (upstream host not found is expected during validation)")
+		return nil
+	}
+
+	finding := JaegerNginxProxyV1alpha0.ConfigFinding{
+		Rule:     "nginx-t",
+		Severity: severity,
+		Message:  fmt.Sprintf("nginx validation failed: %s", strings.TrimSpace(stderr)),
+	}
+	if match := nginxTestLine.FindStringSubmatch(stderr); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		if line -= strings.Count(nginxTestHeader, "\n"); line > 0 {
+			finding.Line = line
+		}
+	}
+	return []JaegerNginxProxyV1alpha0.ConfigFinding{finding}
+}
+
+// runNginxTest writes config into a temporary nginx.conf and returns the stderr of nginx -t
+func runNginxTest(binary, config string) (string, error) {
+	tmpFile, err := os.CreateTemp("", "nginx-config-*.conf")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	// The generated config is included verbatim so that nginx reports its line numbers after the header
+	if _, err := tmpFile.WriteString(nginxTestHeader + config + "\n}\n"); err != nil {
+		tmpFile.Close()
+		return "", fmt.Errorf("failed to write config to temp file: %w", err)
+	}
+	tmpFile.Close()
+
+	cmd := exec.Command(binary, "-t", "-c", tmpFile.Name(), "-e", "/dev/null")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	return stderr.String(), err
+}
+
+// lintMaxBodySize is the largest client_max_body_size the lint validator accepts without a warning
+const lintMaxBodySize = 1 << 30
+
+// parseNginxSize parses an nginx size like 512, 16k or 100m into bytes
+func parseNginxSize(value string) (int64, bool) {
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	if size > math.MaxInt64/multiplier {
+		return math.MaxInt64, true
+	}
+	return size * multiplier, true
+}
+
+// LintValidator flags valid but risky configs. Its findings are warnings only.
+type LintValidator struct {
+	// Disabled lists rules that are not checked
+	Disabled []string
+}
+
+func (v *LintValidator) Name() string { return "lint" }
+
+func (v *LintValidator) enabled(rule string) bool {
+	return !slices.Contains(v.Disabled, rule)
+}
+
+func (v *LintValidator) Validate(config string) []JaegerNginxProxyV1alpha0.ConfigFinding {
+	var findings []JaegerNginxProxyV1alpha0.ConfigFinding
+	warn := func(rule string, line int, format string, args ...interface{}) {
+		if v.enabled(rule) {
+			findings = append(findings, JaegerNginxProxyV1alpha0.ConfigFinding{
+				Rule:     rule,
+				Severity: SeverityWarning,
+				Line:     line,
+				Message:  fmt.Sprintf(format, args...),
+			})
+		}
+	}
+
+	// nginx merges proxy_set_header into a block only when the block sets no proxy_set_header of its
+	// own, and client_max_body_size into every block that does not set it. Blocks are checked once
+	// the whole config is read, directives after a nested block still apply to it.
+	type block struct {
+		name      string
+		line      int
+		parent    *block
+		headers   bool
+		host      bool
+		bodySize  bool
+		proxyPass bool
+		upstream  bool
+	}
+	var stack, blocks []*block
+	current := func() *block {
+		if len(stack) == 0 {
+			return nil
+		}
+		return stack[len(stack)-1]
+	}
+
+	for i, line := range strings.Split(config, "\n") {
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(strings.TrimSpace(line))
+		if len(fields) == 0 {
+			continue
+		}
+		directive := strings.TrimSuffix(fields[0], ";")
+		args := strings.TrimSuffix(strings.Join(fields[1:], " "), ";")
+
+		switch b := current(); directive {
+		case "proxy_set_header":
+			if b != nil {
+				b.headers = true
+				b.host = b.host || len(fields) > 1 && strings.EqualFold(fields[1], "Host")
+			}
+		case "proxy_pass", "grpc_pass":
+			if b != nil {
+				b.proxyPass = b.proxyPass || directive == "proxy_pass"
+				b.upstream = true
+			}
+		case "client_max_body_size":
+			if b != nil {
+				b.bodySize = true
+			}
+			if size, ok := parseNginxSize(args); ok && size == 0 {
+				warn(LintRuleClientMaxBodySize, i+1, "client_max_body_size 0 disables the request body limit")
+			} else if ok && size > lintMaxBodySize {
+				warn(LintRuleClientMaxBodySize, i+1, "client_max_body_size %s allows request bodies over 1g", args)
+			}
+		case "server_name":
+			for _, name := range fields[1:] {
+				if strings.TrimSuffix(name, ";") == "_" {
+					warn(LintRuleServerNameCatchAll, i+1, "server_name _ accepts requests for any host")
+				}
+			}
+		}
+
+		for opened := strings.Count(line, "{"); opened > 0; opened-- {
+			b := &block{name: directive, line: i + 1, parent: current()}
+			stack = append(stack, b)
+			blocks = append(blocks, b)
+		}
+		for closed := strings.Count(line, "}"); closed > 0 && len(stack) > 0; closed-- {
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	// inherited returns the value of the nearest block, starting with b, that sets the directive
+	inherited := func(b *block, sets func(*block) bool, value func(*block) bool) bool {
+		for ; b != nil; b = b.parent {
+			if sets(b) {
+				return value(b)
+			}
+		}
+		return false
+	}
+	proxiedServers := map[*block]bool{}
+	for _, b := range blocks {
+		if b.name != "location" || !b.upstream {
+			continue
+		}
+		server := b.parent
+		for server != nil && server.name != "server" {
+			server = server.parent
+		}
+		proxiedServers[server] = true
+		if b.proxyPass && !inherited(b, func(b *block) bool { return b.headers }, func(b *block) bool { return b.host }) {
+			warn(LintRuleProxyHostHeader, b.line, "proxy_pass without proxy_set_header Host sends the upstream name as Host header")
+		}
+	}
+	for _, b := range blocks {
+		if b.name == "server" && proxiedServers[b] && !inherited(b, func(b *block) bool { return b.bodySize }, func(b *block) bool { return true }) {
+			warn(LintRuleClientMaxBodySize, b.line, "client_max_body_size is not set, nginx rejects request bodies over its 1m default")
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Line < findings[j].Line })
+	return findings
+}
@@ -0,0 +1,210 @@
+package ctrl
+
+import (
+	context "context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+// fakeNginx writes an nginx stand-in that fails nginx -t with stderr
+func fakeNginx(t *testing.T, stderr string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nginx")
+	script := "#!/bin/sh\necho '" + stderr + "' >&2\nexit 1\n"
+	require.NoError(t, os.WriteFile(path, []byte(script), 0o755))
+	return path
+}
+
+func TestNewConfigValidators(t *testing.T) {
+	validators, err := NewConfigValidators(ValidationOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultConfigValidators(), validators)
+
+	validators, err = NewConfigValidators(ValidationOptions{NginxTest: NginxTestWarn, Lint: true, LintDisabledRules: []string{LintRuleServerNameCatchAll}})
+	require.NoError(t, err)
+	require.Len(t, validators, 4)
+	assert.Equal(t, "allowlist", validators[1].Name())
+	assert.Equal(t, "nginx", validators[2].Name())
+	assert.Equal(t, "lint", validators[3].Name())
+
+	validators, err = NewConfigValidators(ValidationOptions{AllowedDirectives: []string{"add_header"}})
+	require.NoError(t, err)
+	assert.Equal(t, &DirectiveAllowlist{Allowed: []string{"add_header"}}, validators[1])
+
+	_, err = NewConfigValidators(ValidationOptions{NginxTest: "sometimes"})
+	assert.ErrorContains(t, err, "unsupported nginx -t mode")
+
+	_, err = NewConfigValidators(ValidationOptions{Lint: true, LintDisabledRules: []string{"tabs"}})
+	assert.ErrorContains(t, err, "unknown lint rule")
+
+	_, err = NewConfigValidators(ValidationOptions{NginxTest: NginxTestStrict, NginxBinary: filepath.Join(t.TempDir(), "nginx")})
+	assert.Error(t, err, "strict mode fails at startup without nginx")
+}
+
+func TestNginxValidator(t *testing.T) {
+	config := GenerateNginxConfig(newTestProxy())
+	binary := fakeNginx(t, `nginx: [emerg] unknown directive "foo" in /tmp/nginx-config-1.conf:`+
+		strconv.Itoa(strings.Count(nginxTestHeader, "\n")+3))
+
+	findings := (&NginxValidator{Mode: NginxTestWarn, Binary: binary}).Validate(config)
+	require.Len(t, findings, 1)
+	assert.Equal(t, SeverityWarning, findings[0].Severity)
+	assert.Equal(t, 3, findings[0].Line, "lines are relative to the generated config")
+	assert.Contains(t, findings[0].Message, `unknown directive "foo"`)
+
+	findings = (&NginxValidator{Mode: NginxTestStrict, Binary: binary}).Validate(config)
+	require.Len(t, findings, 1)
+	assert.Equal(t, SeverityError, findings[0].Severity)
+
+	binary = fakeNginx(t, `nginx: [emerg] host not found in upstream "jaeger-collector:14268"`)
+	assert.Empty(t, (&NginxValidator{Mode: NginxTestStrict, Binary: binary}).Validate(config))
+
+	missing := filepath.Join(t.TempDir(), "nginx")
+	assert.Empty(t, (&NginxValidator{Mode: NginxTestWarn, Binary: missing}).Validate(config))
+	assert.Len(t, (&NginxValidator{Mode: NginxTestStrict, Binary: missing}).Validate(config), 1)
+}
+
+func TestLintValidator(t *testing.T) {
+	lint := &LintValidator{}
+	findings := lint.Validate(GenerateNginxConfig(newTestProxy()))
+	require.Len(t, findings, 1, "only the http location is proxied with proxy_pass")
+	assert.Equal(t, LintRuleProxyHostHeader, findings[0].Rule)
+	assert.Equal(t, SeverityWarning, findings[0].Severity)
+
+	config := `server {
+  listen 8080;
+  server_name _;
+  client_max_body_size 0;
+  proxy_set_header Host $host;
+
+  location / {
+    proxy_pass http://upstream;
+  }
+}
+`
+	findings = lint.Validate(config)
+	require.Len(t, findings, 2, "the Host header is inherited from the server block")
+	assert.Equal(t, LintRuleServerNameCatchAll, findings[0].Rule)
+	assert.Equal(t, 3, findings[0].Line)
+	assert.Equal(t, LintRuleClientMaxBodySize, findings[1].Rule)
+	assert.Equal(t, 4, findings[1].Line)
+
+	lint.Disabled = []string{LintRuleServerNameCatchAll, LintRuleClientMaxBodySize}
+	assert.Empty(t, lint.Validate(config))
+}
+
+func TestLintValidatorRules(t *testing.T) {
+	server := func(body string) string {
+		return "server {\n  listen 8080;\n" + body + "}\n"
+	}
+	location := "  location / {\n    proxy_pass http://upstream;\n  }\n"
+	tests := []struct {
+		name   string
+		config string
+		// findings are "<rule>:<line>"
+		findings []string
+	}{
+		{
+			name:   "Host header inherited from the server",
+			config: server("  client_max_body_size 10m;\n  proxy_set_header Host $host;\n" + location),
+		},
+		{
+			name:   "Host header inherited from http",
+			config: "http {\n  proxy_set_header Host $host;\n" + server("  client_max_body_size 10m;\n"+location) + "}\n",
+		},
+		{
+			name:   "Host header set after the location",
+			config: server("  client_max_body_size 10m;\n" + location + "  proxy_set_header Host $host;\n"),
+		},
+		{
+			name: "location headers replace the server headers",
+			config: server("  client_max_body_size 10m;\n  proxy_set_header Host $host;\n" +
+				"  location / {\n    proxy_set_header X-Tenant a;\n    proxy_pass http://upstream;\n  }\n"),
+			findings: []string{LintRuleProxyHostHeader + ":5"},
+		},
+		{
+			name: "location sets its own Host header",
+			config: server("  client_max_body_size 10m;\n  proxy_set_header X-Tenant a;\n" +
+				"  location / {\n    proxy_set_header Host $host;\n    proxy_pass http://upstream;\n  }\n"),
+		},
+		{
+			name:     "unlimited body size",
+			config:   server("  client_max_body_size 0;\n  proxy_set_header Host $host;\n" + location),
+			findings: []string{LintRuleClientMaxBodySize + ":3"},
+		},
+		{
+			name:     "very large body size",
+			config:   server("  client_max_body_size 2G;\n  proxy_set_header Host $host;\n" + location),
+			findings: []string{LintRuleClientMaxBodySize + ":3"},
+		},
+		{
+			name:     "missing body size",
+			config:   server("  proxy_set_header Host $host;\n" + location),
+			findings: []string{LintRuleClientMaxBodySize + ":1"},
+		},
+		{
+			name:   "body size inherited from http",
+			config: "http {\n  client_max_body_size 1g;\n" + server("  proxy_set_header Host $host;\n"+location) + "}\n",
+		},
+		{
+			name:   "server without proxied locations",
+			config: server("  location = /status {\n    stub_status;\n  }\n"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var findings []string
+			for _, finding := range (&LintValidator{}).Validate(tt.config) {
+				findings = append(findings, finding.Rule+":"+strconv.Itoa(finding.Line))
+			}
+			assert.Equal(t, tt.findings, findings)
+		})
+	}
+}
+
+func TestValidateProxyConfig(t *testing.T) {
+	validators := ConfigValidators{StructuralValidator{}, &LintValidator{}}
+	nginxProxy := newTestProxy()
+
+	_, findings, err := validators.ValidateProxyConfig(nginxProxy, "")
+	require.NoError(t, err, "lint findings do not reject the config")
+	require.Len(t, findings, 1)
+	assert.Equal(t, "lint", findings[0].Validator)
+	assert.Equal(t, "spec.ports[0].path", findings[0].Field)
+
+	nginxProxy.Spec.Ports[1].Path = "/broken {"
+	_, findings, err = validators.ValidateProxyConfig(nginxProxy, "")
+	require.Error(t, err)
+	assert.Equal(t, "spec.ports[1].path", ConfigErrorField(err))
+	assert.Equal(t, "structural", findings[0].Validator)
+	assert.Equal(t, SeverityError, findings[0].Severity)
+	assert.Equal(t, "spec.ports[1].path", findings[0].Field)
+}
+
+func TestReconcileReportsConfigFindings(t *testing.T) {
+	nginxProxy := newTestProxy()
+	r, _ := newRevisionTestReconciler(t, nginxProxy)
+	r.Validators = ConfigValidators{StructuralValidator{}, &LintValidator{}}
+	key := client.ObjectKeyFromObject(nginxProxy)
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+
+	var current JaegerNginxProxyV1alpha0.JaegerNginxProxy
+	require.NoError(t, r.Get(context.Background(), key, &current))
+	assert.True(t, meta.IsStatusConditionTrue(current.Status.Conditions, ConditionConfigValid))
+	require.Len(t, current.Status.ConfigFindings, 1)
+	assert.Equal(t, LintRuleProxyHostHeader, current.Status.ConfigFindings[0].Rule)
+	assert.Equal(t, "spec.ports[0].path", current.Status.ConfigFindings[0].Field)
+}
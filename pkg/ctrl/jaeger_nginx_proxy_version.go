@@ -0,0 +1,145 @@
+package ctrl
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+// NginxVersion is the version of nginx a proxy runs, derived from spec.image.tag
+type NginxVersion struct {
+	Major, Minor, Patch int
+}
+
+var (
+	// AssumedNginxVersion is used for tags that do not name a version, e.g. "stable" or "latest"
+	AssumedNginxVersion = NginxVersion{1, 28, 0}
+
+	// nginxGRPCVersion added grpc_pass
+	nginxGRPCVersion = NginxVersion{1, 13, 10}
+	// nginxHTTP2DirectiveVersion replaced the http2 listen parameter by the http2 directive and serves
+	// HTTP/1.1 and cleartext HTTP/2 on the same port
+	nginxHTTP2DirectiveVersion = NginxVersion{1, 25, 1}
+
+	// nginxTagVersion matches tags such as "1.21", "1.25.3" or "1.27-alpine-slim"
+	nginxTagVersion = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?(?:-.*)?$`)
+)
+
+// directiveVersions lists the first nginx version supporting directives the generator and validators know
+var directiveVersions = map[string]NginxVersion{
+	"grpc_pass":         nginxGRPCVersion,
+	"grpc_set_header":   nginxGRPCVersion,
+	"grpc_hide_header":  nginxGRPCVersion,
+	"grpc_read_timeout": nginxGRPCVersion,
+	"http2":             nginxHTTP2DirectiveVersion,
+}
+
+func (v NginxVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast reports whether v is other or newer
+func (v NginxVersion) AtLeast(other NginxVersion) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor > other.Minor
+	}
+	return v.Patch >= other.Patch
+}
+
+// ParseNginxVersion returns the nginx version named by an image tag. ok is false for tags without a
+// version, which are assumed to be AssumedNginxVersion.
+func ParseNginxVersion(tag string) (version NginxVersion, ok bool) {
+	match := nginxTagVersion.FindStringSubmatch(tag)
+	if match == nil {
+		return AssumedNginxVersion, false
+	}
+	version.Major, _ = strconv.Atoi(match[1])
+	version.Minor, _ = strconv.Atoi(match[2])
+	if match[3] != "" {
+		version.Patch, _ = strconv.Atoi(match[3])
+	}
+	return version, true
+}
+
+// proxyNginxVersion returns the nginx version the proxy pods will run
+func proxyNginxVersion(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) NginxVersion {
+	version, _ := ParseNginxVersion(nginxProxy.Spec.Image.Tag)
+	return version
+}
+
+// VersionValidator checks that a config only uses directives and parameters supported by the nginx
+// version of the proxy image, so incompatible configs are rejected instead of crash looping the pods
+type VersionValidator struct {
+	Tag string
+}
+
+func (v *VersionValidator) Name() string { return "version" }
+
+func (v *VersionValidator) Validate(config string) []JaegerNginxProxyV1alpha0.ConfigFinding {
+	version, known := ParseNginxVersion(v.Tag)
+	var findings []JaegerNginxProxyV1alpha0.ConfigFinding
+	report := func(rule, severity string, line int, format string, args ...interface{}) {
+		findings = append(findings, JaegerNginxProxyV1alpha0.ConfigFinding{
+			Rule:     rule,
+			Severity: severity,
+			Field:    "spec.image.tag",
+			Line:     line,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+	if !known {
+		report("unknown-version", SeverityWarning, 0, "cannot determine the nginx version of image tag %q, assuming nginx %s", v.Tag, version)
+	}
+
+	http2Listen, proxyPass := 0, false
+	for i, line := range strings.Split(config, "\n") {
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(line), ";"))
+		if len(fields) == 0 {
+			continue
+		}
+
+		if since, ok := directiveVersions[fields[0]]; ok && !version.AtLeast(since) {
+			report("unsupported-directive", SeverityError, i+1, "%s requires nginx %s or newer, image tag %q runs nginx %s", fields[0], since, v.Tag, version)
+		}
+		switch fields[0] {
+		case "listen":
+			for _, param := range fields[1:] {
+				if param != "http2" {
+					continue
+				}
+				if version.AtLeast(nginxHTTP2DirectiveVersion) {
+					report("deprecated-parameter", SeverityWarning, i+1, "the http2 listen parameter is deprecated since nginx %s, use the http2 directive", nginxHTTP2DirectiveVersion)
+				} else if http2Listen == 0 {
+					http2Listen = i + 1
+				}
+			}
+		case "proxy_pass":
+			proxyPass = true
+		}
+	}
+
+	// Before the http2 directive a cleartext http2 listener only speaks HTTP/2
+	if http2Listen > 0 && proxyPass {
+		report("http2-cleartext", SeverityError, http2Listen, "serving http and grpc ports on one listener requires nginx %s or newer, image tag %q runs nginx %s", nginxHTTP2DirectiveVersion, v.Tag, version)
+	}
+	return findings
+}
+
+// hasGRPCPorts reports whether any port of the proxy is served over gRPC
+func hasGRPCPorts(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) bool {
+	for _, port := range nginxProxy.Spec.Ports {
+		if PortProtocol(port) == PortProtocolGRPC {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package ctrl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNginxVersion(t *testing.T) {
+	tests := []struct {
+		tag     string
+		version NginxVersion
+		ok      bool
+	}{
+		{"1.21", NginxVersion{1, 21, 0}, true},
+		{"1.25.3", NginxVersion{1, 25, 3}, true},
+		{"1.27-alpine-slim", NginxVersion{1, 27, 0}, true},
+		{"1.28.0-alpine", NginxVersion{1, 28, 0}, true},
+		{"stable", AssumedNginxVersion, false},
+		{"latest", AssumedNginxVersion, false},
+		{"", AssumedNginxVersion, false},
+	}
+	for _, tt := range tests {
+		version, ok := ParseNginxVersion(tt.tag)
+		assert.Equal(t, tt.version, version, tt.tag)
+		assert.Equal(t, tt.ok, ok, tt.tag)
+	}
+
+	assert.True(t, NginxVersion{1, 25, 1}.AtLeast(nginxHTTP2DirectiveVersion))
+	assert.False(t, NginxVersion{1, 24, 9}.AtLeast(nginxHTTP2DirectiveVersion))
+	assert.True(t, NginxVersion{2, 0, 0}.AtLeast(nginxHTTP2DirectiveVersion))
+}
+
+func TestRenderNginxConfigForVersion(t *testing.T) {
+	nginxProxy := newTestProxy()
+	config := GenerateNginxConfig(nginxProxy)
+	assert.Contains(t, config, "  listen 8080 default_server;\n  http2 on;\n")
+	assert.Contains(t, config, "grpc_pass grpc://jaeger-collector-grpc;")
+	assert.Contains(t, config, "proxy_pass http://jaeger-collector-http;")
+
+	nginxProxy.Spec.Image.Tag = "1.21"
+	nginxProxy.Spec.Ports = nginxProxy.Spec.Ports[1:]
+	config = GenerateNginxConfig(nginxProxy)
+	assert.Contains(t, config, "  listen 8080 default_server http2;\n")
+	assert.NotContains(t, config, "http2 on")
+	_, _, err := ConfigValidators{}.ValidateProxyConfig(nginxProxy, "")
+	assert.NoError(t, err, "gRPC only proxies work with the listen parameter")
+
+	nginxProxy = newTestProxy()
+	nginxProxy.Spec.Ports = nginxProxy.Spec.Ports[:1]
+	assert.NotContains(t, GenerateNginxConfig(nginxProxy), "http2")
+}
+
+func TestVersionValidator(t *testing.T) {
+	nginxProxy := newTestProxy()
+	nginxProxy.Spec.Image.Tag = "1.21"
+	_, findings, err := ConfigValidators{}.ValidateProxyConfig(nginxProxy, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "serving http and grpc ports on one listener requires nginx 1.25.1")
+	assert.Equal(t, "spec.image.tag", ConfigErrorField(err))
+	require.Len(t, findings, 1)
+	assert.Equal(t, "http2-cleartext", findings[0].Rule)
+
+	nginxProxy.Spec.Image.Tag = "1.12.2"
+	nginxProxy.Spec.Ports = nginxProxy.Spec.Ports[1:]
+	_, findings, err = ConfigValidators{}.ValidateProxyConfig(nginxProxy, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "grpc_pass requires nginx 1.13.10 or newer")
+	assert.Equal(t, "unsupported-directive", findings[0].Rule)
+
+	nginxProxy = newTestProxy()
+	nginxProxy.Spec.Image.Tag = "mainline"
+	_, findings, err = ConfigValidators{}.ValidateProxyConfig(nginxProxy, "")
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+	assert.Equal(t, SeverityWarning, findings[0].Severity)
+	assert.Equal(t, "unknown-version", findings[0].Rule)
+
+	findings = (&VersionValidator{Tag: "1.27"}).Validate("server {\n  listen 8080 http2;\n}\n")
+	require.Len(t, findings, 1)
+	assert.Equal(t, "deprecated-parameter", findings[0].Rule)
+	assert.Equal(t, 2, findings[0].Line)
+}
@@ -0,0 +1,140 @@
+package ctrl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+)
+
+// Permission is an API access the controller needs in every namespace it watches
+type Permission struct {
+	Group       string
+	Resource    string
+	Subresource string
+	Verbs       []string
+}
+
+func (p Permission) resource() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+	return resource
+}
+
+var childVerbs = []string{"get", "list", "watch", "create", "update", "delete"}
+
+// RequiredPermissions are the permissions of the manager cache and the reconciler. The optional Gateway
+// API routes and ServiceMonitors are left out, the controller works without them.
+var RequiredPermissions = []Permission{
+	{Group: JaegerNginxProxyV1alpha0.SchemeGroupVersion.Group, Resource: "jaegernginxproxies", Verbs: []string{"list", "watch", "update"}},
+	{Group: JaegerNginxProxyV1alpha0.SchemeGroupVersion.Group, Resource: "jaegernginxproxies", Subresource: "status", Verbs: []string{"update"}},
+	{Group: "apps", Resource: "deployments", Verbs: childVerbs},
+	// patch drops the reports of gone pods from the reloader status ConfigMaps
+	{Resource: "configmaps", Verbs: append([]string{"patch"}, childVerbs...)},
+	{Resource: "services", Verbs: childVerbs},
+	{Resource: "serviceaccounts", Verbs: childVerbs},
+	{Group: "networking.k8s.io", Resource: "ingresses", Verbs: childVerbs},
+	{Group: "networking.k8s.io", Resource: "networkpolicies", Verbs: childVerbs},
+	{Group: "rbac.authorization.k8s.io", Resource: "roles", Verbs: childVerbs},
+	{Group: "rbac.authorization.k8s.io", Resource: "rolebindings", Verbs: childVerbs},
+	{Resource: "pods", Verbs: []string{"list", "watch"}},
+	{Resource: "events", Verbs: []string{"create"}},
+}
+
+// CollectorPermissions are needed in the namespaces of collector Services outside the watched namespaces,
+// spec.networkPolicy reads their pod selector
+var CollectorPermissions = []Permission{{Resource: "services", Verbs: []string{"get"}}}
+
+// PermissionError lists the permissions the controller lacks
+type PermissionError struct {
+	// Missing holds "<verb> <resource> in namespace <namespace>" entries
+	Missing []string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("missing RBAC permissions, grant them with a Role per watched namespace or a ClusterRole: %s", strings.Join(e.Missing, "; "))
+}
+
+// CheckPermissions asks the API server with SelfSubjectAccessReviews whether the controller has the
+// RequiredPermissions in namespaces, cluster-wide when namespaces is empty, and the CollectorPermissions in
+// collectorNamespaces. It returns a PermissionError listing everything that is missing, so a misconfigured
+// namespace fails the start with a clear message instead of a cache that never syncs.
+func CheckPermissions(ctx context.Context, reviews authorizationv1client.SelfSubjectAccessReviewInterface, namespaces, collectorNamespaces []string) error {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var missing []string
+	for _, namespace := range namespaces {
+		m, err := checkPermissions(ctx, reviews, namespace, RequiredPermissions)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, m...)
+	}
+	for _, namespace := range collectorNamespaces {
+		m, err := checkPermissions(ctx, reviews, namespace, CollectorPermissions)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, m...)
+	}
+	if len(missing) > 0 {
+		return &PermissionError{Missing: missing}
+	}
+	return nil
+}
+
+// checkPermissions returns the permissions the controller lacks in namespace
+func checkPermissions(ctx context.Context, reviews authorizationv1client.SelfSubjectAccessReviewInterface, namespace string, permissions []Permission) ([]string, error) {
+	var missing []string
+	for _, permission := range permissions {
+		for _, verb := range permission.Verbs {
+			review, err := reviews.Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace:   namespace,
+						Verb:        verb,
+						Group:       permission.Group,
+						Resource:    permission.Resource,
+						Subresource: permission.Subresource,
+					},
+				},
+			}, metav1.CreateOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("reviewing access to %s: %w", permission.resource(), err)
+			}
+			if !review.Status.Allowed {
+				scope := "in namespace " + namespace
+				if namespace == metav1.NamespaceAll {
+					scope = "cluster-wide"
+				}
+				missing = append(missing, fmt.Sprintf("%s %s %s", verb, permission.resource(), scope))
+			}
+		}
+	}
+	return missing, nil
+}
+
+// WatchErrorHandler is the manager cache's watch error handler. It reports permissions revoked while the
+// controller runs as errors naming the watched type, other errors are handled like client-go does.
+func WatchErrorHandler(ctx context.Context, r *toolscache.Reflector, err error) {
+	if apierrors.IsForbidden(err) {
+		log.Error().Err(err).Str("type", r.TypeDescription()).
+			Msgf("Missing RBAC permission to list and watch %s, the controller cache cannot sync until it is granted", r.TypeDescription())
+		return
+	}
+	toolscache.DefaultWatchErrorHandler(ctx, r, err)
+}
@@ -0,0 +1,67 @@
+package ctrl
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// accessReviewClient answers SelfSubjectAccessReviews with allowed
+func accessReviewClient(allowed func(*authorizationv1.ResourceAttributes) bool) *fake.Clientset {
+	clientset := fake.NewClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview).DeepCopy()
+		review.Status.Allowed = allowed(review.Spec.ResourceAttributes)
+		return true, review, nil
+	})
+	return clientset
+}
+
+func TestCheckPermissions(t *testing.T) {
+	ctx := context.Background()
+	var reviewed []string
+	clientset := accessReviewClient(func(attrs *authorizationv1.ResourceAttributes) bool {
+		reviewed = append(reviewed, attrs.Namespace)
+		return !(attrs.Namespace == "tenant-b" && (attrs.Resource == "deployments" && attrs.Verb == "delete" ||
+			attrs.Resource == "jaegernginxproxies" && attrs.Subresource == "status"))
+	})
+	reviews := clientset.AuthorizationV1().SelfSubjectAccessReviews()
+
+	require.NoError(t, CheckPermissions(ctx, reviews, []string{"tenant-a"}, nil))
+	assert.NotContains(t, reviewed, "", "only the watched namespaces are reviewed")
+
+	err := CheckPermissions(ctx, reviews, []string{"tenant-a", "tenant-b"}, nil)
+	var permissionErr *PermissionError
+	require.True(t, errors.As(err, &permissionErr))
+	assert.Equal(t, []string{
+		"update jaegernginxproxies.jaeger-nginx-proxy.platform-engineer.stream/status in namespace tenant-b",
+		"delete deployments.apps in namespace tenant-b",
+	}, permissionErr.Missing)
+
+	reviewed = nil
+	require.NoError(t, CheckPermissions(ctx, reviews, nil, nil))
+	assert.Equal(t, []string{""}, reviewed[:1], "no namespaces means cluster-wide")
+
+	err = CheckPermissions(ctx, accessReviewClient(func(*authorizationv1.ResourceAttributes) bool { return false }).AuthorizationV1().SelfSubjectAccessReviews(), nil, nil)
+	assert.ErrorContains(t, err, "list jaegernginxproxies.jaeger-nginx-proxy.platform-engineer.stream cluster-wide")
+
+	// Only reading Services is needed in the namespaces of collectors
+	var collectorReviews []authorizationv1.ResourceAttributes
+	err = CheckPermissions(ctx, accessReviewClient(func(attrs *authorizationv1.ResourceAttributes) bool {
+		if attrs.Namespace == "tracing" {
+			collectorReviews = append(collectorReviews, *attrs)
+			return false
+		}
+		return true
+	}).AuthorizationV1().SelfSubjectAccessReviews(), []string{"tenant-a"}, []string{"tracing"})
+	require.True(t, errors.As(err, &permissionErr))
+	assert.Equal(t, []string{"get services in namespace tracing"}, permissionErr.Missing)
+	assert.Equal(t, []authorizationv1.ResourceAttributes{{Namespace: "tracing", Verb: "get", Resource: "services"}}, collectorReviews)
+}
@@ -0,0 +1,279 @@
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/ctrl"
+)
+
+// StubStatus is the connection and request counters reported by the nginx stub_status module
+type StubStatus struct {
+	Active   int64
+	Accepted int64
+	Handled  int64
+	Requests int64
+	Reading  int64
+	Writing  int64
+	Waiting  int64
+}
+
+// Exporter serves nginx stub_status and the per-port counters of the metrics log as Prometheus metrics.
+// It runs as a sidecar next to nginx; nginx sends the metrics log over syslog.
+type Exporter struct {
+	// StatusURL is the stub_status location, scraped on every collection
+	StatusURL string
+	// HTTPClient fetches StatusURL, it defaults to a client with a short timeout
+	HTTPClient *http.Client
+
+	registry       *prometheus.Registry
+	requests       *prometheus.CounterVec
+	upstreamErrors *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	upstreamTime   *prometheus.HistogramVec
+	receivedBytes  *prometheus.CounterVec
+	sentBytes      *prometheus.CounterVec
+	parseErrors    prometheus.Counter
+}
+
+// New returns an Exporter scraping the stub_status at statusURL
+func New(statusURL string) *Exporter {
+	e := &Exporter{
+		StatusURL: statusURL,
+		registry:  prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jaeger_proxy_requests_total",
+			Help: "Requests handled by the proxy by port and status code.",
+		}, []string{"port", "code"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jaeger_proxy_upstream_errors_total",
+			Help: "Collector responses with a 5xx status code or failed collector connections by port.",
+		}, []string{"port"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "jaeger_proxy_request_duration_seconds",
+			Help:    "Time from the first byte of the request until the response was sent, by port.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"port"}),
+		upstreamTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "jaeger_proxy_upstream_response_duration_seconds",
+			Help:    "Time spent waiting for the collector by port, summed over all tried collectors.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"port"}),
+		receivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jaeger_proxy_received_bytes_total",
+			Help: "Bytes received from clients by port, including headers.",
+		}, []string{"port"}),
+		sentBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jaeger_proxy_sent_bytes_total",
+			Help: "Bytes sent to clients by port, including headers.",
+		}, []string{"port"}),
+		parseErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "jaeger_proxy_log_parse_errors_total",
+			Help: "Metrics log messages that could not be parsed.",
+		}),
+	}
+	e.registry.MustRegister(e.requests, e.upstreamErrors, e.duration, e.upstreamTime, e.receivedBytes, e.sentBytes, e.parseErrors)
+	e.registry.MustRegister(&stubStatusCollector{exporter: e})
+	return e
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (e *Exporter) Handler() http.Handler {
+	return promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{})
+}
+
+// Registry returns the registry of the exporter metrics
+func (e *Exporter) Registry() *prometheus.Registry {
+	return e.registry
+}
+
+// ServeSyslog reads metrics log messages from conn until ctx is cancelled
+func (e *Exporter) ServeSyslog(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to read syslog message: %w", err)
+		}
+		if err := e.Observe(string(buf[:n])); err != nil {
+			e.parseErrors.Inc()
+			log.Debug().Err(err).Msg("Skipping metrics log message")
+		}
+	}
+}
+
+// Observe counts the request of a metrics log message, with or without its syslog header
+func (e *Exporter) Observe(message string) error {
+	if _, body, ok := strings.Cut(message, ctrl.MetricsSyslogTag+": "); ok {
+		message = body
+	}
+	fields := strings.Split(strings.TrimSpace(message), ctrl.MetricsLogSeparator)
+	if len(fields) != len(ctrl.MetricsLogFields) {
+		return fmt.Errorf("expected %d fields, got %d: %q", len(ctrl.MetricsLogFields), len(fields), message)
+	}
+	port, status, requestTime, requestLength, bytesSent, upstreamStatus, upstreamTime :=
+		fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+
+	if _, err := strconv.Atoi(status); err != nil {
+		return fmt.Errorf("invalid status %q", status)
+	}
+	duration, err := strconv.ParseFloat(requestTime, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request time %q", requestTime)
+	}
+	received, err := strconv.ParseFloat(requestLength, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request length %q", requestLength)
+	}
+	sent, err := strconv.ParseFloat(bytesSent, 64)
+	if err != nil {
+		return fmt.Errorf("invalid bytes sent %q", bytesSent)
+	}
+
+	e.requests.WithLabelValues(port, status).Inc()
+	e.duration.WithLabelValues(port).Observe(duration)
+	e.receivedBytes.WithLabelValues(port).Add(received)
+	e.sentBytes.WithLabelValues(port).Add(sent)
+
+	// nginx reports 502 for collectors it could not connect to
+	for _, value := range upstreamValues(upstreamStatus) {
+		if code, err := strconv.Atoi(value); err == nil && code >= 500 {
+			e.upstreamErrors.WithLabelValues(port).Inc()
+		}
+	}
+	if values := upstreamValues(upstreamTime); len(values) > 0 {
+		total := 0.0
+		for _, value := range values {
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid upstream response time %q", upstreamTime)
+			}
+			total += seconds
+		}
+		e.upstreamTime.WithLabelValues(port).Observe(total)
+	}
+	return nil
+}
+
+// upstreamValues splits an upstream variable into one value per tried upstream. Values are separated by
+// ", " and by " : " after internal redirects, "-" means no upstream was tried.
+func upstreamValues(value string) []string {
+	var values []string
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ':' || r == ' ' }) {
+		if field != "-" {
+			values = append(values, field)
+		}
+	}
+	return values
+}
+
+// FetchStubStatus scrapes the stub_status page
+func (e *Exporter) FetchStubStatus(ctx context.Context) (*StubStatus, error) {
+	client := e.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.StatusURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stub_status: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch stub_status: %s", resp.Status)
+	}
+	return ParseStubStatus(resp.Body)
+}
+
+// ParseStubStatus parses the stub_status page:
+//
+//	Active connections: 2
+//	server accepts handled requests
+//	 10 10 20
+//	Reading: 0 Writing: 1 Waiting: 1
+func ParseStubStatus(r io.Reader) (*StubStatus, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) < 4 {
+		return nil, fmt.Errorf("invalid stub_status: expected 4 lines, got %d", len(lines))
+	}
+
+	var status StubStatus
+	if _, err := fmt.Sscanf(lines[0], "Active connections: %d", &status.Active); err != nil {
+		return nil, fmt.Errorf("invalid stub_status active connections %q: %w", lines[0], err)
+	}
+	if _, err := fmt.Sscanf(lines[2], "%d %d %d", &status.Accepted, &status.Handled, &status.Requests); err != nil {
+		return nil, fmt.Errorf("invalid stub_status counters %q: %w", lines[2], err)
+	}
+	if _, err := fmt.Sscanf(lines[3], "Reading: %d Writing: %d Waiting: %d", &status.Reading, &status.Writing, &status.Waiting); err != nil {
+		return nil, fmt.Errorf("invalid stub_status connection states %q: %w", lines[3], err)
+	}
+	return &status, nil
+}
+
+var (
+	upDesc          = prometheus.NewDesc("nginx_up", "Whether the last stub_status scrape succeeded.", nil, nil)
+	connectionsDesc = prometheus.NewDesc("nginx_connections", "Client connections by state.", []string{"state"}, nil)
+	acceptedDesc    = prometheus.NewDesc("nginx_connections_accepted_total", "Accepted client connections.", nil, nil)
+	handledDesc     = prometheus.NewDesc("nginx_connections_handled_total", "Handled client connections.", nil, nil)
+	httpDesc        = prometheus.NewDesc("nginx_http_requests_total", "Client requests, including health checks.", nil, nil)
+)
+
+// stubStatusCollector scrapes stub_status when Prometheus scrapes the exporter, so the metrics are never stale
+type stubStatusCollector struct {
+	exporter *Exporter
+}
+
+func (c *stubStatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upDesc
+	ch <- connectionsDesc
+	ch <- acceptedDesc
+	ch <- handledDesc
+	ch <- httpDesc
+}
+
+func (c *stubStatusCollector) Collect(ch chan<- prometheus.Metric) {
+	status, err := c.exporter.FetchStubStatus(context.Background())
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to scrape nginx")
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(status.Active), "active")
+	ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(status.Reading), "reading")
+	ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(status.Writing), "writing")
+	ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(status.Waiting), "waiting")
+	ch <- prometheus.MustNewConstMetric(acceptedDesc, prometheus.CounterValue, float64(status.Accepted))
+	ch <- prometheus.MustNewConstMetric(handledDesc, prometheus.CounterValue, float64(status.Handled))
+	ch <- prometheus.MustNewConstMetric(httpDesc, prometheus.CounterValue, float64(status.Requests))
+}
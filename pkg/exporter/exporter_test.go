@@ -0,0 +1,97 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stubStatus = "Active connections: 3 \nserver accepts handled requests\n 10 9 42 \nReading: 0 Writing: 1 Waiting: 2 \n"
+
+func TestParseStubStatus(t *testing.T) {
+	status, err := ParseStubStatus(strings.NewReader(stubStatus))
+	require.NoError(t, err)
+	assert.Equal(t, StubStatus{Active: 3, Accepted: 10, Handled: 9, Requests: 42, Reading: 0, Writing: 1, Waiting: 2}, *status)
+
+	_, err = ParseStubStatus(strings.NewReader("<html>Not Found</html>\n"))
+	assert.Error(t, err)
+}
+
+func TestObserve(t *testing.T) {
+	e := New("")
+	require.NoError(t, e.Observe("<190>Oct 19 08:43:07 jaeger_metrics: http|200|0.012|345|120|200|0.010"))
+	require.NoError(t, e.Observe("http|502|3.000|100|150|502, 502|1.500, 1.400"))
+	require.NoError(t, e.Observe("grpc|403|0.000|80|150|-|-"))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(e.requests.WithLabelValues("http", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(e.requests.WithLabelValues("http", "502")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(e.requests.WithLabelValues("grpc", "403")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(e.upstreamErrors.WithLabelValues("http")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(e.upstreamErrors.WithLabelValues("grpc")))
+	assert.Equal(t, 445.0, testutil.ToFloat64(e.receivedBytes.WithLabelValues("http")))
+	assert.Equal(t, 270.0, testutil.ToFloat64(e.sentBytes.WithLabelValues("http")))
+
+	// Requests denied before reaching the collector are not timed
+	assert.Equal(t, 1, testutil.CollectAndCount(e.upstreamTime, "jaeger_proxy_upstream_response_duration_seconds"))
+
+	assert.Error(t, e.Observe("http|200|0.012"))
+	assert.Error(t, e.Observe("http|ok|0.012|345|120|200|0.010"))
+}
+
+func TestServeSyslog(t *testing.T) {
+	e := New("")
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e.ServeSyslog(ctx, conn) }()
+
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer sender.Close()
+	_, err = sender.Write([]byte("<190>jaeger_metrics: http|204|0.001|10|20|204|0.001"))
+	require.NoError(t, err)
+	_, err = sender.Write([]byte("garbage"))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(e.parseErrors) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1.0, testutil.ToFloat64(e.requests.WithLabelValues("http", "204")))
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestHandler(t *testing.T) {
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, stubStatus)
+	}))
+	defer nginx.Close()
+
+	e := New(nginx.URL + "/stub_status")
+	require.NoError(t, e.Observe("http|200|0.012|345|120|200|0.010"))
+
+	rec := httptest.NewRecorder()
+	e.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	assert.Contains(t, body, "nginx_up 1\n")
+	assert.Contains(t, body, "nginx_connections{state=\"active\"} 3\n")
+	assert.Contains(t, body, "nginx_http_requests_total 42\n")
+	assert.Contains(t, body, "jaeger_proxy_requests_total{code=\"200\",port=\"http\"} 1\n")
+
+	nginx.Close()
+	rec = httptest.NewRecorder()
+	e.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), "nginx_up 0\n")
+	assert.NotContains(t, rec.Body.String(), "nginx_connections{")
+}
@@ -0,0 +1,64 @@
+package health
+
+import (
+	"context"
+	"net/http"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// Ping passes as long as the process serves the probe
+func Ping(context.Context) Result {
+	return OK("")
+}
+
+// CacheSynced checks that the informers of a controller-runtime cache are started and synced
+func CacheSynced(c cache.Cache) CheckFunc {
+	return func(ctx context.Context) Result {
+		if !c.WaitForCacheSync(ctx) {
+			return Failing("cache not synced")
+		}
+		return OK("synced")
+	}
+}
+
+// Synced checks a client-go informer
+func Synced(hasSynced func() bool) CheckFunc {
+	return func(context.Context) Result {
+		if !hasSynced() {
+			return Failing("cache not synced")
+		}
+		return OK("synced")
+	}
+}
+
+// LeaderElection reports whether the manager holds the leader lease. It never fails: a standby replica
+// still serves the API and takes over when the leader goes away.
+func LeaderElection(enabled bool, elected <-chan struct{}) CheckFunc {
+	return func(context.Context) Result {
+		if !enabled {
+			return Disabled("leader election disabled, controllers run on every replica")
+		}
+		select {
+		case <-elected:
+			return OK("leader")
+		default:
+			return OK("standby, waiting for the leader lease")
+		}
+	}
+}
+
+// Checker adapts a controller-runtime health checker, e.g. the webhook server's StartedChecker
+func Checker(check healthz.Checker) CheckFunc {
+	return func(ctx context.Context) Result {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		if err != nil {
+			return Failing(err.Error())
+		}
+		if err := check(req); err != nil {
+			return Failing(err.Error())
+		}
+		return OK("")
+	}
+}
@@ -0,0 +1,213 @@
+// Package health serves the liveness and readiness endpoints of the server with the state of every
+// component as JSON.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Status of a component or of a whole probe
+type Status string
+
+const (
+	StatusOK      Status = "ok"
+	StatusFailing Status = "failing"
+	// StatusDisabled is reported by components that are not configured, it does not fail a probe
+	StatusDisabled Status = "disabled"
+)
+
+// DefaultTimeout bounds each check when Registry.Timeout is not set
+const DefaultTimeout = 2 * time.Second
+
+// Result is the state of a component
+type Result struct {
+	Status  Status `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// OK returns a passing result
+func OK(message string) Result { return Result{Status: StatusOK, Message: message} }
+
+// Failing returns a failing result
+func Failing(message string) Result { return Result{Status: StatusFailing, Message: message} }
+
+// Disabled returns the result of a component that is not configured
+func Disabled(message string) Result { return Result{Status: StatusDisabled, Message: message} }
+
+// CheckFunc reports the state of a component. It must return when ctx is done.
+type CheckFunc func(ctx context.Context) Result
+
+// Response is the body of the probe endpoints
+type Response struct {
+	Status     Status            `json:"status"`
+	Components map[string]Result `json:"components"`
+}
+
+type namedCheck struct {
+	name  string
+	check CheckFunc
+}
+
+// Registry holds the liveness and readiness checks. Liveness checks fail only for states the process
+// does not recover from, readiness checks for everything that keeps the server from doing its work.
+type Registry struct {
+	// Timeout bounds each check, DefaultTimeout when zero. A check that does not return in time fails.
+	Timeout time.Duration
+
+	mu        sync.RWMutex
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+// AddLivenessCheck adds a check reported by /healthz
+func (r *Registry) AddLivenessCheck(name string, check CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.liveness = append(r.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadinessCheck adds a check reported by /readyz
+func (r *Registry) AddReadinessCheck(name string, check CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readiness = append(r.readiness, namedCheck{name: name, check: check})
+}
+
+// Liveness runs the liveness checks
+func (r *Registry) Liveness(ctx context.Context) Response {
+	r.mu.RLock()
+	checks := r.liveness
+	r.mu.RUnlock()
+	return r.run(ctx, checks)
+}
+
+// Readiness runs the readiness checks
+func (r *Registry) Readiness(ctx context.Context) Response {
+	r.mu.RLock()
+	checks := r.readiness
+	r.mu.RUnlock()
+	return r.run(ctx, checks)
+}
+
+// run runs checks concurrently, a single slow component does not delay the others
+func (r *Registry) run(ctx context.Context, checks []namedCheck) Response {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results := make([]chan Result, len(checks))
+	for i, c := range checks {
+		results[i] = make(chan Result, 1)
+		go func(check CheckFunc, result chan<- Result) {
+			result <- check(ctx)
+		}(c.check, results[i])
+	}
+
+	response := Response{Status: StatusOK, Components: make(map[string]Result, len(checks))}
+	for i, c := range checks {
+		var result Result
+		select {
+		case result = <-results[i]:
+		case <-ctx.Done():
+			// Results that arrived in time win over the expired context
+			select {
+			case result = <-results[i]:
+			default:
+				result = Failing("check timed out after " + timeout.String())
+			}
+		}
+		response.Components[c.name] = result
+		if result.Status == StatusFailing {
+			response.Status = StatusFailing
+		}
+	}
+	return response
+}
+
+// LivenessHandler serves the liveness checks, with status 503 when one of them fails
+func (r *Registry) LivenessHandler() http.Handler {
+	return probeHandler(r.Liveness)
+}
+
+// ReadinessHandler serves the readiness checks, with status 503 when one of them fails
+func (r *Registry) ReadinessHandler() http.Handler {
+	return probeHandler(r.Readiness)
+}
+
+func probeHandler(probe func(context.Context) Response) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		response := probe(req.Context())
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if response.Status != StatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	})
+}
+
+// Mux serves /healthz and /readyz
+func (r *Registry) Mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", r.LivenessHandler())
+	mux.Handle("/readyz", r.ReadinessHandler())
+	return mux
+}
+
+// Component is the state of a component started in the background that reports it itself, e.g. a server
+// that is ready once it listens. It starts out failing with "starting".
+type Component struct {
+	mu     sync.RWMutex
+	result Result
+	set    bool
+}
+
+// Ready marks the component ready
+func (c *Component) Ready(message string) {
+	c.update(OK(message))
+}
+
+// Fail marks the component failed with err
+func (c *Component) Fail(err error) {
+	c.update(Failing(err.Error()))
+}
+
+// Disable marks the component as not configured
+func (c *Component) Disable(message string) {
+	c.update(Disabled(message))
+}
+
+func (c *Component) update(result Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.result = result
+	c.set = true
+}
+
+// Check reports the last state of the component
+func (c *Component) Check(context.Context) Result {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.set {
+		return Failing("starting")
+	}
+	return c.result
+}
+
+// Alive reports only failures of the component, for liveness checks of components that may still be
+// starting
+func (c *Component) Alive(context.Context) Result {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.set {
+		return OK("starting")
+	}
+	return c.result
+}
@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+)
+
+func get(t *testing.T, handler http.Handler, path string) (int, Response) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var response Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	return rec.Code, response
+}
+
+func TestRegistry(t *testing.T) {
+	registry := &Registry{}
+	component := &Component{}
+	registry.AddLivenessCheck("ping", Ping)
+	registry.AddLivenessCheck("server", component.Alive)
+	registry.AddReadinessCheck("server", component.Check)
+	registry.AddReadinessCheck("webhook", func(context.Context) Result { return Disabled("not configured") })
+	mux := registry.Mux()
+
+	code, response := get(t, mux, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Response{Status: StatusOK, Components: map[string]Result{
+		"ping":   {Status: StatusOK},
+		"server": {Status: StatusOK, Message: "starting"},
+	}}, response)
+
+	code, response = get(t, mux, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, Response{Status: StatusFailing, Components: map[string]Result{
+		"server":  {Status: StatusFailing, Message: "starting"},
+		"webhook": {Status: StatusDisabled, Message: "not configured"},
+	}}, response)
+
+	component.Ready("listening")
+	code, response = get(t, mux, "/readyz")
+	assert.Equal(t, http.StatusOK, code, "disabled components do not fail the probe")
+	assert.Equal(t, OK("listening"), response.Components["server"])
+
+	component.Fail(errors.New("address in use"))
+	code, response = get(t, mux, "/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, Failing("address in use"), response.Components["server"])
+}
+
+func TestRegistryTimeout(t *testing.T) {
+	registry := &Registry{Timeout: 50 * time.Millisecond}
+	registry.AddReadinessCheck("stuck", func(context.Context) Result {
+		time.Sleep(time.Second)
+		return OK("")
+	})
+	registry.AddReadinessCheck("fast", Ping)
+
+	start := time.Now()
+	response := registry.Readiness(context.Background())
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, StatusFailing, response.Status)
+	assert.Equal(t, Failing("check timed out after 50ms"), response.Components["stuck"])
+	assert.Equal(t, OK(""), response.Components["fast"])
+}
+
+func TestChecks(t *testing.T) {
+	ctx := context.Background()
+	synced := false
+	informers := &informertest.FakeInformers{Synced: &synced}
+	assert.Equal(t, Failing("cache not synced"), CacheSynced(informers)(ctx))
+	assert.Equal(t, Failing("cache not synced"), Synced(func() bool { return synced })(ctx))
+	synced = true
+	assert.Equal(t, OK("synced"), CacheSynced(informers)(ctx))
+	assert.Equal(t, OK("synced"), Synced(func() bool { return synced })(ctx))
+
+	elected := make(chan struct{})
+	assert.Equal(t, StatusDisabled, LeaderElection(false, elected)(ctx).Status)
+	assert.Equal(t, OK("standby, waiting for the leader lease"), LeaderElection(true, elected)(ctx))
+	close(elected)
+	assert.Equal(t, OK("leader"), LeaderElection(true, elected)(ctx))
+
+	assert.Equal(t, Failing("not started"), Checker(func(*http.Request) error { return errors.New("not started") })(ctx))
+	assert.Equal(t, OK(""), Checker(func(*http.Request) error { return nil })(ctx))
+}
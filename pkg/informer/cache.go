@@ -0,0 +1,466 @@
+package informer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DefaultResyncPeriod is the resync period when WithResyncPeriod is not given
+	DefaultResyncPeriod = 30 * time.Second
+
+	ownerIndex = "owner"
+	labelIndex = "label"
+)
+
+// Option configures a DeploymentCache
+type Option func(*DeploymentCache)
+
+// WithNamespaces restricts the cache to namespaces, every namespace is watched without it
+func WithNamespaces(namespaces ...string) Option {
+	return func(c *DeploymentCache) {
+		c.namespaces = namespaces
+	}
+}
+
+// WithLabelSelector restricts the cache to Deployments matching selector, e.g. "app=nginx,tier!=test"
+func WithLabelSelector(selector string) Option {
+	return func(c *DeploymentCache) {
+		c.labelSelector = selector
+	}
+}
+
+// WithResyncPeriod sets how often the handlers see every cached Deployment again
+func WithResyncPeriod(period time.Duration) Option {
+	return func(c *DeploymentCache) {
+		c.resyncPeriod = period
+	}
+}
+
+// WithTransform replaces StripDeployment as the function that changes Deployments before they are
+// stored. It receives *metav1.PartialObjectMetadata in metadata-only mode.
+func WithTransform(transform cache.TransformFunc) Option {
+	return func(c *DeploymentCache) {
+		c.transform = transform
+	}
+}
+
+// WithMetadataOnly caches only the metadata of Deployments, listed and watched with client. Names,
+// namespaces, labels, annotations and owners are available, the Deployments returned by the cache have
+// no spec and status.
+func WithMetadataOnly(client metadata.Interface) Option {
+	return func(c *DeploymentCache) {
+		c.metadataClient = client
+	}
+}
+
+// StripDeployment is the default transform of a DeploymentCache. It drops the managed fields and the
+// last applied configuration, which often are the biggest part of an object, the pod template except
+// for its labels and container names and images, and the status conditions.
+func StripDeployment(obj interface{}) (interface{}, error) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		stripMetadata(&o.ObjectMeta)
+		containers := make([]corev1.Container, len(o.Spec.Template.Spec.Containers))
+		for i, container := range o.Spec.Template.Spec.Containers {
+			containers[i] = corev1.Container{Name: container.Name, Image: container.Image}
+		}
+		o.Spec.Template = corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: o.Spec.Template.Labels},
+			Spec:       corev1.PodSpec{Containers: containers},
+		}
+		o.Status.Conditions = nil
+	case *metav1.PartialObjectMetadata:
+		stripMetadata(&o.ObjectMeta)
+	}
+	return obj, nil
+}
+
+func stripMetadata(meta *metav1.ObjectMeta) {
+	meta.ManagedFields = nil
+	if _, ok := meta.Annotations[corev1.LastAppliedConfigAnnotation]; ok {
+		annotations := make(map[string]string, len(meta.Annotations)-1)
+		for key, value := range meta.Annotations {
+			if key != corev1.LastAppliedConfigAnnotation {
+				annotations[key] = value
+			}
+		}
+		meta.Annotations = annotations
+	}
+}
+
+// WithEventHistory sets the number of events kept to resume subscriptions, DefaultEventHistory by default
+func WithEventHistory(size int) Option {
+	return func(c *DeploymentCache) {
+		c.eventHistory = size
+	}
+}
+
+// informerFactory is the part of the typed and the metadata informer factories a DeploymentCache uses
+type informerFactory interface {
+	Start(stopCh <-chan struct{})
+	Shutdown()
+}
+
+// DeploymentCache is an informer cache of Deployments with lookups by owner and by label. It runs one
+// namespaced informer per watched namespace, so it needs list and watch permissions only in them and
+// holds only their Deployments.
+type DeploymentCache struct {
+	namespaces     []string
+	labelSelector  string
+	resyncPeriod   time.Duration
+	transform      cache.TransformFunc
+	metadataClient metadata.Interface
+	eventHistory   int
+
+	factories     []informerFactory
+	informers     []cache.SharedIndexInformer
+	registrations []cache.ResourceEventHandlerRegistration
+	store         *store
+	events        *broadcaster
+}
+
+// store merges the indexers of the per-namespace informers
+type store struct {
+	// byNamespace holds the indexer of each watched namespace, the one of all namespaces under ""
+	byNamespace map[string]cache.Indexer
+}
+
+func (s *store) List() []interface{} {
+	var objs []interface{}
+	for _, indexer := range s.byNamespace {
+		objs = append(objs, indexer.List()...)
+	}
+	return objs
+}
+
+func (s *store) Len() int {
+	var n int
+	for _, indexer := range s.byNamespace {
+		n += len(indexer.ListKeys())
+	}
+	return n
+}
+
+func (s *store) GetByKey(namespace, name string) (interface{}, bool) {
+	indexer, ok := s.byNamespace[namespace]
+	if !ok {
+		indexer, ok = s.byNamespace[metav1.NamespaceAll]
+	}
+	if !ok {
+		return nil, false
+	}
+	obj, exists, err := indexer.GetByKey(namespace + "/" + name)
+	return obj, err == nil && exists
+}
+
+func (s *store) ByIndex(name, value string) []interface{} {
+	var objs []interface{}
+	for _, indexer := range s.byNamespace {
+		matches, err := indexer.ByIndex(name, value)
+		if err == nil {
+			objs = append(objs, matches...)
+		}
+	}
+	return objs
+}
+
+// NewDeploymentCache creates a cache of the Deployments visible to clientset. Run starts it.
+func NewDeploymentCache(clientset kubernetes.Interface, opts ...Option) (*DeploymentCache, error) {
+	c := &DeploymentCache{resyncPeriod: DefaultResyncPeriod, transform: StripDeployment, eventHistory: DefaultEventHistory}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if _, err := labels.Parse(c.labelSelector); err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %w", c.labelSelector, err)
+	}
+
+	namespaces := c.namespaces
+	if len(namespaces) == 0 {
+		log.Info().Msg("Starting deployment informer for ALL namespaces")
+		namespaces = []string{metav1.NamespaceAll}
+	} else {
+		log.Info().Msgf("Starting deployment informers for namespaces: %v", namespaces)
+	}
+	c.store = &store{byNamespace: make(map[string]cache.Indexer, len(namespaces))}
+	c.events = newBroadcaster(c.eventHistory, c.List)
+	for _, ns := range namespaces {
+		if _, ok := c.store.byNamespace[ns]; ok {
+			continue
+		}
+		log.Debug().Msgf("Creating Informer instance for namespace %q", ns)
+		factory, informer := c.newInformer(clientset, ns)
+		if err := informer.AddIndexers(cache.Indexers{
+			ownerIndex: indexByOwner,
+			labelIndex: indexByLabel,
+		}); err != nil {
+			return nil, err
+		}
+		if c.transform != nil {
+			if err := informer.SetTransform(c.transform); err != nil {
+				return nil, err
+			}
+		}
+		for _, handler := range []cache.ResourceEventHandler{c.logEvents(), c.events.handler()} {
+			registration, err := informer.AddEventHandler(handler)
+			if err != nil {
+				return nil, err
+			}
+			c.registrations = append(c.registrations, registration)
+		}
+		c.factories = append(c.factories, factory)
+		c.informers = append(c.informers, informer)
+		c.store.byNamespace[ns] = informer.GetIndexer()
+	}
+	return c, nil
+}
+
+func (c *DeploymentCache) newInformer(clientset kubernetes.Interface, namespace string) (informerFactory, cache.SharedIndexInformer) {
+	tweak := func(options *metav1.ListOptions) {
+		options.LabelSelector = c.labelSelector
+	}
+	if c.metadataClient != nil {
+		factory := metadatainformer.NewFilteredSharedInformerFactory(c.metadataClient, c.resyncPeriod, namespace, tweak)
+		return factory, factory.ForResource(appsv1.SchemeGroupVersion.WithResource("deployments")).Informer()
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, c.resyncPeriod,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(tweak),
+	)
+	return factory, factory.Apps().V1().Deployments().Informer()
+}
+
+func indexByOwner(obj interface{}) ([]string, error) {
+	d, ok := obj.(metav1.Object)
+	if !ok {
+		return nil, nil
+	}
+	var uids []string
+	for _, ref := range d.GetOwnerReferences() {
+		uids = append(uids, string(ref.UID))
+	}
+	return uids, nil
+}
+
+func indexByLabel(obj interface{}) ([]string, error) {
+	d, ok := obj.(metav1.Object)
+	if !ok {
+		return nil, nil
+	}
+	var keys []string
+	for key, value := range d.GetLabels() {
+		keys = append(keys, labelIndexKey(key, value))
+	}
+	return keys, nil
+}
+
+func labelIndexKey(key, value string) string {
+	return key + "=" + value
+}
+
+// Run starts the informer and blocks until ctx is cancelled. It returns an error when the cache did
+// not sync before. Subscriptions end with ErrStopped when Run returns.
+func (c *DeploymentCache) Run(ctx context.Context) error {
+	log.Info().Msg("Starting deployment informer...")
+	defer c.events.stop()
+	for _, factory := range c.factories {
+		factory.Start(ctx.Done())
+		// Returns once the informer goroutines have stopped after ctx is cancelled
+		defer factory.Shutdown()
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), c.HasSynced) && !c.HasSynced() {
+		return fmt.Errorf("deployment informer did not sync: %w", context.Cause(ctx))
+	}
+	log.Info().Msg("Deployment informer cache synced. Watching for events...")
+	<-ctx.Done()
+	return nil
+}
+
+// HasSynced reports whether the initial list of Deployments of every watched namespace is cached and
+// has been handled
+func (c *DeploymentCache) HasSynced() bool {
+	for _, informer := range c.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	for _, registration := range c.registrations {
+		if !registration.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// asDeployment returns a cached object as Deployment, the metadata of metadata-only caches is wrapped
+// in one without spec and status
+func asDeployment(obj interface{}) (*appsv1.Deployment, bool) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return o, true
+	case *metav1.PartialObjectMetadata:
+		return &appsv1.Deployment{TypeMeta: o.TypeMeta, ObjectMeta: o.ObjectMeta}, true
+	}
+	return nil, false
+}
+
+// deployments returns the Deployments among objs sorted by namespace and name
+func deployments(objs []interface{}) []*appsv1.Deployment {
+	var deployments []*appsv1.Deployment
+	for _, obj := range objs {
+		if d, ok := asDeployment(obj); ok {
+			deployments = append(deployments, d)
+		}
+	}
+	sort.Slice(deployments, func(i, j int) bool {
+		if deployments[i].Namespace != deployments[j].Namespace {
+			return deployments[i].Namespace < deployments[j].Namespace
+		}
+		return deployments[i].Name < deployments[j].Name
+	})
+	return deployments
+}
+
+// List returns the cached Deployments sorted by namespace and name. They are shared with the cache and
+// must not be modified.
+func (c *DeploymentCache) List() []*appsv1.Deployment {
+	return deployments(c.store.List())
+}
+
+// Get returns a cached Deployment
+func (c *DeploymentCache) Get(namespace, name string) (*appsv1.Deployment, bool) {
+	obj, exists := c.store.GetByKey(namespace, name)
+	if !exists {
+		return nil, false
+	}
+	return asDeployment(obj)
+}
+
+// Subscribe returns a subscription to the Add, Update and Delete events of the cached Deployments.
+// The subscriber must Close it when done.
+func (c *DeploymentCache) Subscribe(opts SubscribeOptions) (*Subscription, error) {
+	return c.events.subscribe(opts)
+}
+
+// ByOwner returns the cached Deployments with an owner reference to uid
+func (c *DeploymentCache) ByOwner(uid types.UID) []*appsv1.Deployment {
+	return deployments(c.store.ByIndex(ownerIndex, string(uid)))
+}
+
+// ByLabel returns the cached Deployments with the label key=value
+func (c *DeploymentCache) ByLabel(key, value string) []*appsv1.Deployment {
+	return deployments(c.store.ByIndex(labelIndex, labelIndexKey(key, value)))
+}
+
+// logEvents logs the changes of cached Deployments
+func (c *DeploymentCache) logEvents() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			name := getDeploymentName(obj)
+			log.Info().Msgf("[INFORMER][Add] Deployment added: %s", name)
+			log.Debug().Msgf("[INFORMER][Add] Cache now contains %d deployments", c.store.Len())
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldDep, oldOk := oldObj.(*appsv1.Deployment)
+			newDep, newOk := newObj.(*appsv1.Deployment)
+
+			if !oldOk || !newOk {
+				// Metadata-only caches hold no spec and status to compare
+				log.Info().Msgf("[INFORMER][Update] Deployment updated: %s", getDeploymentName(newObj))
+				return
+			}
+
+			name := getDeploymentName(newObj)
+			if changes := specChanges(oldDep, newDep); len(changes) > 0 {
+				log.Info().Msgf("[INFORMER][Update] Deployment updated: %s - Changes: %s",
+					name, strings.Join(changes, ", "))
+			} else if changes := statusChanges(oldDep, newDep); len(changes) > 0 {
+				log.Info().Msgf("[INFORMER][Update] Deployment status updated: %s - Changes: %s",
+					name, strings.Join(changes, ", "))
+			} else {
+				log.Info().Msgf("[INFORMER][Update] Deployment status updated: %s (generation: %d -> %d)",
+					name, oldDep.Generation, newDep.Generation)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			name := getDeploymentName(obj)
+			log.Info().Msgf("[INFORMER][Delete] Deployment deleted: %s", name)
+			log.Debug().Msgf("[INFORMER][Delete] Cache now contains %d deployments", c.store.Len())
+		},
+	}
+}
+
+// specChanges lists the changed replicas, image, labels and annotations of a Deployment
+func specChanges(oldDep, newDep *appsv1.Deployment) []string {
+	changes := []string{}
+
+	// Compare replicas
+	if oldDep.Spec.Replicas != nil && newDep.Spec.Replicas != nil {
+		if *oldDep.Spec.Replicas != *newDep.Spec.Replicas {
+			changes = append(changes, fmt.Sprintf("replicas: %d -> %d", *oldDep.Spec.Replicas, *newDep.Spec.Replicas))
+		}
+	}
+
+	// Compare image
+	if len(oldDep.Spec.Template.Spec.Containers) > 0 && len(newDep.Spec.Template.Spec.Containers) > 0 {
+		if oldDep.Spec.Template.Spec.Containers[0].Image != newDep.Spec.Template.Spec.Containers[0].Image {
+			changes = append(changes, fmt.Sprintf("image: %s -> %s",
+				oldDep.Spec.Template.Spec.Containers[0].Image,
+				newDep.Spec.Template.Spec.Containers[0].Image))
+		}
+	}
+
+	// Compare labels
+	if !reflect.DeepEqual(oldDep.Labels, newDep.Labels) {
+		changes = append(changes, "labels changed")
+	}
+
+	// Compare annotations
+	if !reflect.DeepEqual(oldDep.Annotations, newDep.Annotations) {
+		changes = append(changes, "annotations changed")
+	}
+	return changes
+}
+
+// statusChanges lists the changed replica counts of a Deployment's status
+func statusChanges(oldDep, newDep *appsv1.Deployment) []string {
+	changes := []string{}
+	if oldDep.Status.Replicas != newDep.Status.Replicas {
+		changes = append(changes,
+			fmt.Sprintf("status.replicas: %d -> %d", oldDep.Status.Replicas, newDep.Status.Replicas))
+	}
+	if oldDep.Status.AvailableReplicas != newDep.Status.AvailableReplicas {
+		changes = append(changes,
+			fmt.Sprintf("status.availableReplicas: %d -> %d", oldDep.Status.AvailableReplicas, newDep.Status.AvailableReplicas))
+	}
+	if oldDep.Status.UpdatedReplicas != newDep.Status.UpdatedReplicas {
+		changes = append(changes,
+			fmt.Sprintf("status.updatedReplicas: %d -> %d", oldDep.Status.UpdatedReplicas, newDep.Status.UpdatedReplicas))
+	}
+	if oldDep.Status.ReadyReplicas != newDep.Status.ReadyReplicas {
+		changes = append(changes,
+			fmt.Sprintf("status.readyReplicas: %d -> %d", oldDep.Status.ReadyReplicas, newDep.Status.ReadyReplicas))
+	}
+	if oldDep.Status.UnavailableReplicas != newDep.Status.UnavailableReplicas {
+		changes = append(changes,
+			fmt.Sprintf("status.unavailableReplicas: %d -> %d", oldDep.Status.UnavailableReplicas, newDep.Status.UnavailableReplicas))
+	}
+	// Add more status fields as needed
+	return changes
+}
@@ -0,0 +1,325 @@
+package informer
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+func testDeployment(namespace, name string, labels map[string]string, owner types.UID) *appsv1.Deployment {
+	d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+	if owner != "" {
+		d.OwnerReferences = []metav1.OwnerReference{{APIVersion: "v1", Kind: "Owner", Name: "owner", UID: owner}}
+	}
+	return d
+}
+
+// runCache starts c and waits for its initial sync
+func runCache(t *testing.T, c *DeploymentCache) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	require.Eventually(t, c.HasSynced, 5*time.Second, 10*time.Millisecond)
+}
+
+func names(deployments []*appsv1.Deployment) []string {
+	var result []string
+	for _, d := range deployments {
+		result = append(result, d.Namespace+"/"+d.Name)
+	}
+	return result
+}
+
+// listedNamespaces returns the namespaces of the Deployment lists sent to clientset
+func listedNamespaces(clientset *fake.Clientset) []string {
+	var namespaces []string
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "deployments" {
+			namespaces = append(namespaces, action.GetNamespace())
+		}
+	}
+	return namespaces
+}
+
+// listedSelectors returns the label selectors of the Deployment lists sent to clientset
+func listedSelectors(clientset *fake.Clientset) []string {
+	var selectors []string
+	for _, action := range clientset.Actions() {
+		if list, ok := action.(k8stesting.ListAction); ok && action.GetResource().Resource == "deployments" {
+			selectors = append(selectors, list.GetListRestrictions().Labels.String())
+		}
+	}
+	return selectors
+}
+
+func TestDeploymentCache(t *testing.T) {
+	clientset := fake.NewClientset(
+		testDeployment("tenant-a", "web", map[string]string{"app": "web"}, "uid-1"),
+		testDeployment("tenant-a", "api", map[string]string{"app": "api"}, "uid-1"),
+		testDeployment("tenant-b", "web", map[string]string{"app": "web"}, "uid-2"),
+		testDeployment("other", "web", map[string]string{"app": "web"}, ""),
+	)
+	c, err := NewDeploymentCache(clientset, WithNamespaces("tenant-a", "tenant-b"))
+	require.NoError(t, err)
+	assert.False(t, c.HasSynced())
+	runCache(t, c)
+
+	assert.Equal(t, []string{"tenant-a/api", "tenant-a/web", "tenant-b/web"}, names(c.List()))
+	assert.ElementsMatch(t, []string{"tenant-a", "tenant-b"}, listedNamespaces(clientset), "one namespaced informer per namespace, no cluster-wide list")
+
+	d, ok := c.Get("tenant-a", "web")
+	require.True(t, ok)
+	assert.Equal(t, "web", d.Name)
+	_, ok = c.Get("other", "web")
+	assert.False(t, ok, "unwatched namespaces are not visible")
+	_, ok = c.Get("tenant-a", "missing")
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"tenant-a/api", "tenant-a/web"}, names(c.ByOwner("uid-1")))
+	assert.Empty(t, c.ByOwner("uid-3"))
+	assert.Equal(t, []string{"tenant-a/web", "tenant-b/web"}, names(c.ByLabel("app", "web")))
+	assert.Empty(t, c.ByLabel("app", "db"))
+
+	// Later changes are indexed too
+	_, err = clientset.AppsV1().Deployments("tenant-b").Create(context.Background(),
+		testDeployment("tenant-b", "db", map[string]string{"app": "db"}, "uid-2"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(c.ByLabel("app", "db")) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"tenant-b/db", "tenant-b/web"}, names(c.ByOwner("uid-2")))
+}
+
+func TestDeploymentCacheOptions(t *testing.T) {
+	clientset := fake.NewClientset(
+		testDeployment("default", "web", map[string]string{"app": "web"}, ""),
+		testDeployment("default", "api", map[string]string{"app": "api"}, ""),
+		testDeployment("other", "web", map[string]string{"app": "web"}, ""),
+	)
+	c, err := NewDeploymentCache(clientset,
+		WithNamespaces("default"),
+		WithLabelSelector("app=web"),
+		WithResyncPeriod(time.Minute),
+		WithTransform(func(obj interface{}) (interface{}, error) {
+			if d, ok := obj.(*appsv1.Deployment); ok {
+				d.Labels["transformed"] = "true"
+			}
+			return obj, nil
+		}),
+	)
+	require.NoError(t, err)
+	runCache(t, c)
+
+	assert.Equal(t, []string{"default/web"}, names(c.List()))
+	assert.Equal(t, []string{"app=web"}, listedSelectors(clientset))
+	assert.Equal(t, []string{"default/web"}, names(c.ByLabel("transformed", "true")))
+
+	_, err = NewDeploymentCache(clientset, WithLabelSelector("app in (web"))
+	assert.ErrorContains(t, err, "invalid label selector")
+}
+
+func TestStripDeployment(t *testing.T) {
+	d := testDeployment("default", "web", map[string]string{"app": "web"}, "uid-1")
+	d.Annotations = map[string]string{corev1.LastAppliedConfigAnnotation: "{}", "team": "platform"}
+	d.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationApply}}
+	d.Spec.Replicas = new(int32)
+	d.Spec.Template.Labels = map[string]string{"app": "web"}
+	d.Spec.Template.Annotations = map[string]string{"checksum": "abc"}
+	d.Spec.Template.Spec.Containers = []corev1.Container{{
+		Name:  "web",
+		Image: "nginx:1.27",
+		Env:   []corev1.EnvVar{{Name: "MODE", Value: "production"}},
+	}}
+	d.Status.Replicas = 1
+	d.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentAvailable}}
+
+	obj, err := StripDeployment(d)
+	require.NoError(t, err)
+	stripped := obj.(*appsv1.Deployment)
+	assert.Empty(t, stripped.ManagedFields)
+	assert.Equal(t, map[string]string{"team": "platform"}, stripped.Annotations)
+	assert.Equal(t, map[string]string{"app": "web"}, stripped.Labels)
+	assert.Equal(t, []metav1.OwnerReference{{APIVersion: "v1", Kind: "Owner", Name: "owner", UID: "uid-1"}}, stripped.OwnerReferences)
+	assert.NotNil(t, stripped.Spec.Replicas)
+	assert.Equal(t, corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:1.27"}}},
+	}, stripped.Spec.Template)
+	assert.Equal(t, int32(1), stripped.Status.Replicas)
+	assert.Empty(t, stripped.Status.Conditions)
+
+	meta := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{
+		Name:          "web",
+		Annotations:   map[string]string{corev1.LastAppliedConfigAnnotation: "{}"},
+		ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl"}},
+	}}
+	obj, err = StripDeployment(meta)
+	require.NoError(t, err)
+	assert.Empty(t, obj.(*metav1.PartialObjectMetadata).ManagedFields)
+	assert.Empty(t, obj.(*metav1.PartialObjectMetadata).Annotations)
+
+	tombstone := toolscache.DeletedFinalStateUnknown{Key: "default/web"}
+	obj, err = StripDeployment(tombstone)
+	require.NoError(t, err)
+	assert.Equal(t, tombstone, obj, "other objects are passed through")
+}
+
+func testDeploymentMetadata(namespace, name string, labels map[string]string, owner types.UID) *metav1.PartialObjectMetadata {
+	d := testDeployment(namespace, name, labels, owner)
+	return &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: d.ObjectMeta,
+	}
+}
+
+func newMetadataClient(objs ...k8sruntime.Object) *metadatafake.FakeMetadataClient {
+	scheme := metadatafake.NewTestScheme()
+	_ = metav1.AddMetaToScheme(scheme)
+	return metadatafake.NewSimpleMetadataClient(scheme, objs...)
+}
+
+func TestDeploymentCacheMetadataOnly(t *testing.T) {
+	client := newMetadataClient(
+		testDeploymentMetadata("tenant-a", "web", map[string]string{"app": "web"}, "uid-1"),
+		testDeploymentMetadata("tenant-a", "api", map[string]string{"app": "api"}, "uid-1"),
+		testDeploymentMetadata("other", "web", map[string]string{"app": "web"}, ""),
+	)
+	c, err := NewDeploymentCache(nil, WithNamespaces("tenant-a"), WithMetadataOnly(client))
+	require.NoError(t, err)
+	runCache(t, c)
+
+	assert.Equal(t, []string{"tenant-a/api", "tenant-a/web"}, names(c.List()))
+	d, ok := c.Get("tenant-a", "web")
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"app": "web"}, d.Labels)
+	assert.Nil(t, d.Spec.Replicas, "metadata-only caches hold no spec")
+	assert.Equal(t, []string{"tenant-a/api", "tenant-a/web"}, names(c.ByOwner("uid-1")))
+	assert.Equal(t, []string{"tenant-a/web"}, names(c.ByLabel("app", "web")))
+}
+
+func TestStartDeploymentInformerWrappers(t *testing.T) {
+	defaultCache.Store(nil)
+	assert.False(t, HasSynced())
+	assert.Empty(t, GetDeploymentNames())
+
+	c, err := NewDeploymentCache(fake.NewClientset(testDeployment("default", "web", nil, "")))
+	require.NoError(t, err)
+	defaultCache.Store(c)
+	t.Cleanup(func() { defaultCache.Store(nil) })
+	runCache(t, c)
+
+	assert.True(t, HasSynced())
+	assert.Equal(t, []string{"web"}, GetDeploymentNames())
+	assert.Equal(t, []map[string]string{{"name": "web", "namespace": "default"}}, GetDeploymentNamesWithNamespace())
+}
+
+// benchmarkDeployment is a Deployment of the size kubectl apply leaves behind: managed fields, the last
+// applied configuration and a pod template with environment and probes
+func benchmarkDeployment(i int) *appsv1.Deployment {
+	name := fmt.Sprintf("app-%d", i)
+	d := testDeployment("default", name, map[string]string{"app": name}, "")
+	d.Annotations = map[string]string{corev1.LastAppliedConfigAnnotation: strings.Repeat(`{"apiVersion":"apps/v1"}`, 100)}
+	d.ManagedFields = []metav1.ManagedFieldsEntry{{
+		Manager:    "kubectl-client-side-apply",
+		Operation:  metav1.ManagedFieldsOperationUpdate,
+		APIVersion: "apps/v1",
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: []byte(strings.Repeat(`{"f:spec":{}}`, 200))},
+	}}
+	container := corev1.Container{
+		Name:           "app",
+		Image:          "nginx:1.27",
+		LivenessProbe:  &corev1.Probe{PeriodSeconds: 10},
+		ReadinessProbe: &corev1.Probe{PeriodSeconds: 10},
+	}
+	for j := 0; j < 20; j++ {
+		container.Env = append(container.Env, corev1.EnvVar{Name: fmt.Sprintf("VAR_%d", j), Value: strings.Repeat("x", 32)})
+	}
+	d.Spec.Template.Labels = map[string]string{"app": name}
+	d.Spec.Template.Spec.Containers = []corev1.Container{container}
+	return d
+}
+
+// BenchmarkDeploymentCacheMemory reports the heap a synced cache of 1000 Deployments holds per
+// Deployment, unmodified, stripped by the default transform and in metadata-only mode
+func BenchmarkDeploymentCacheMemory(b *testing.B) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	b.Cleanup(func() { zerolog.SetGlobalLevel(zerolog.TraceLevel) })
+
+	const count = 1000
+	var objs, metaObjs []k8sruntime.Object
+	for i := 0; i < count; i++ {
+		d := benchmarkDeployment(i)
+		objs = append(objs, d)
+		metaObjs = append(metaObjs, &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: d.ObjectMeta,
+		})
+	}
+
+	// newClients returns the clients of the cache, objects held by the fake clients are not measured
+	for _, bc := range []struct {
+		name       string
+		newClients func() (kubernetes.Interface, []Option)
+	}{
+		{"full", func() (kubernetes.Interface, []Option) {
+			return fake.NewClientset(objs...), []Option{WithTransform(nil)}
+		}},
+		{"stripped", func() (kubernetes.Interface, []Option) {
+			return fake.NewClientset(objs...), nil
+		}},
+		{"metadata", func() (kubernetes.Interface, []Option) {
+			return nil, []Option{WithMetadataOnly(newMetadataClient(metaObjs...))}
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var total uint64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				clientset, opts := bc.newClients()
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				b.StartTimer()
+
+				c, err := NewDeploymentCache(clientset, opts...)
+				require.NoError(b, err)
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() { done <- c.Run(ctx) }()
+				require.Eventually(b, c.HasSynced, 10*time.Second, time.Millisecond)
+
+				b.StopTimer()
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				require.Len(b, c.List(), count)
+				cancel()
+				require.NoError(b, <-done)
+				if after.HeapAlloc > before.HeapAlloc {
+					total += after.HeapAlloc - before.HeapAlloc
+				}
+			}
+			b.ReportMetric(float64(total)/float64(b.N)/count, "heap-bytes/deployment")
+		})
+	}
+}
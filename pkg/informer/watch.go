@@ -0,0 +1,361 @@
+package informer
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// EventType is the kind of a DeploymentEvent
+type EventType string
+
+const (
+	EventAdd    EventType = "Add"
+	EventUpdate EventType = "Update"
+	EventDelete EventType = "Delete"
+	// EventBookmark carries only the resourceVersion a subscription can be resumed from
+	EventBookmark EventType = "Bookmark"
+)
+
+const (
+	// DefaultEventHistory is the number of events kept to resume subscriptions from a resourceVersion
+	DefaultEventHistory = 1000
+	// DefaultSubscriptionBuffer is the number of events a subscriber may fall behind before it is dropped
+	DefaultSubscriptionBuffer = 100
+)
+
+var (
+	// ErrSlowConsumer ends a subscription whose buffer is full, the subscriber can resume from the
+	// resourceVersion of the last event it received
+	ErrSlowConsumer = errors.New("subscriber did not keep up with the deployment events")
+	// ErrResourceVersionTooOld is returned when the events after a resourceVersion are no longer kept,
+	// the subscriber has to start over without a resourceVersion
+	ErrResourceVersionTooOld = errors.New("resource version is too old")
+	// ErrStopped ends the subscriptions of a cache that stopped running
+	ErrStopped = errors.New("deployment cache stopped")
+	// ErrNotStarted is returned by Subscribe before StartDeploymentInformer created the cache
+	ErrNotStarted = errors.New("deployment informer is not started")
+)
+
+// DeploymentEvent is a change of a cached Deployment
+type DeploymentEvent struct {
+	Type            EventType `json:"type"`
+	Namespace       string    `json:"namespace,omitempty"`
+	Name            string    `json:"name,omitempty"`
+	ResourceVersion string    `json:"resourceVersion"`
+	// Changes lists what an update changed, e.g. "replicas: 1 -> 3"
+	Changes []string `json:"changes,omitempty"`
+	// Deployment is the cached object, shared with the cache and read-only. Deletes carry its last state.
+	// Events replayed to resume a subscription carry only its name, namespace, labels and version.
+	Deployment *appsv1.Deployment `json:"deployment,omitempty"`
+
+	// old is the state before an update, subscriptions filter on both states
+	old *appsv1.Deployment
+}
+
+// SubscribeOptions select the events of a Subscription
+type SubscribeOptions struct {
+	// Namespace limits the events to one namespace, all watched namespaces when empty
+	Namespace string
+	// LabelSelector limits the events to matching Deployments, all when nil. Updates that make a
+	// Deployment match are sent as Add, updates that make it stop matching as Delete.
+	LabelSelector labels.Selector
+	// ResourceVersion resumes a subscription after the event with this resourceVersion. When empty,
+	// the subscription starts with an Add event for every cached Deployment.
+	ResourceVersion string
+	// BufferSize is the number of events the subscriber may fall behind, DefaultSubscriptionBuffer when 0
+	BufferSize int
+}
+
+// Subscription receives the events of a DeploymentCache
+type Subscription struct {
+	b         *broadcaster
+	namespace string
+	selector  labels.Selector
+	events    chan DeploymentEvent
+	err       error
+	// pending holds the Deployments whose initial state came from the cache before its events were
+	// published, keyed by namespace/name. Their events are skipped up to the one with that
+	// resourceVersion, "" for a Deployment that is already gone from the cache.
+	pending map[string]string
+}
+
+// Events returns the events of the subscription. The channel is closed when the subscription ends,
+// Err then tells why.
+func (s *Subscription) Events() <-chan DeploymentEvent {
+	return s.events
+}
+
+// Err returns ErrSlowConsumer or ErrStopped once the subscription was ended by the cache, nil otherwise
+func (s *Subscription) Err() error {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.b.remove(s, nil)
+}
+
+// Bookmark queues a Bookmark event with the resourceVersion of the latest event, which the subscriber
+// can resume from once it has received the events before it. Subscribers use it to keep idle streams
+// open, it is skipped when the buffer is full.
+func (s *Subscription) Bookmark() {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	if _, ok := s.b.subscribers[s]; !ok {
+		return
+	}
+	bookmark := DeploymentEvent{Type: EventBookmark}
+	if len(s.b.history) > 0 {
+		bookmark.ResourceVersion = s.b.history[len(s.b.history)-1].ResourceVersion
+	}
+	select {
+	case s.events <- bookmark:
+	default:
+	}
+}
+
+func (s *Subscription) matches(d *appsv1.Deployment) bool {
+	return d != nil && (s.namespace == "" || d.Namespace == s.namespace) && s.selector.Matches(labels.Set(d.Labels))
+}
+
+// skip reports whether e is already covered by the initial state of the subscription
+func (s *Subscription) skip(key string, e DeploymentEvent) bool {
+	resourceVersion, ok := s.pending[key]
+	if !ok {
+		return false
+	}
+	if e.Type == EventDelete && resourceVersion == "" || e.Type != EventDelete && e.ResourceVersion == resourceVersion {
+		delete(s.pending, key)
+	}
+	return true
+}
+
+// filter returns e as the subscriber sees it, false when it is not selected
+func (s *Subscription) filter(e DeploymentEvent) (DeploymentEvent, bool) {
+	switch {
+	case !s.matches(e.Deployment) && (e.Type != EventUpdate || !s.matches(e.old)):
+		return e, false
+	case e.Type == EventUpdate && !s.matches(e.old):
+		e.Type = EventAdd
+	case e.Type == EventUpdate && !s.matches(e.Deployment):
+		e.Type = EventDelete
+	}
+	return e, true
+}
+
+// broadcaster fans the events of a DeploymentCache out to its subscriptions. New subscriptions start
+// from the Deployments listed by the cache, the broadcaster only keeps the resourceVersion it published
+// last for each of them, so the events that follow continue from the same state. The latest events are
+// kept with trimmed Deployments to resume subscriptions.
+type broadcaster struct {
+	historySize int
+	// list returns the cached Deployments sorted by namespace and name, it may be ahead of the
+	// published events
+	list func() []*appsv1.Deployment
+
+	mu          sync.Mutex
+	versions    map[string]string
+	history     []DeploymentEvent
+	subscribers map[*Subscription]struct{}
+	stopped     bool
+}
+
+func newBroadcaster(historySize int, list func() []*appsv1.Deployment) *broadcaster {
+	return &broadcaster{
+		historySize: max(historySize, 0),
+		list:        list,
+		versions:    make(map[string]string),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+func (b *broadcaster) subscribe(opts SubscribeOptions) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return nil, ErrStopped
+	}
+
+	var initial []DeploymentEvent
+	pending := map[string]string{}
+	if opts.ResourceVersion == "" && b.list != nil {
+		cached := map[string]bool{}
+		for _, d := range b.list() {
+			key := d.Namespace + "/" + d.Name
+			cached[key] = true
+			if b.versions[key] != d.ResourceVersion {
+				pending[key] = d.ResourceVersion
+			}
+			initial = append(initial, newEvent(EventAdd, d))
+		}
+		for key := range b.versions {
+			if !cached[key] {
+				pending[key] = ""
+			}
+		}
+	} else if opts.ResourceVersion != "" {
+		// Deletes carry the resourceVersion of the last state, the latest event with it is the position
+		i := len(b.history) - 1
+		for i >= 0 && b.history[i].ResourceVersion != opts.ResourceVersion {
+			i--
+		}
+		if i < 0 {
+			return nil, ErrResourceVersionTooOld
+		}
+		initial = b.history[i+1:]
+	}
+
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultSubscriptionBuffer
+	}
+	s := &Subscription{
+		b:         b,
+		namespace: opts.Namespace,
+		selector:  opts.LabelSelector,
+		events:    make(chan DeploymentEvent, len(initial)+bufferSize),
+		pending:   pending,
+	}
+	if s.selector == nil {
+		s.selector = labels.Everything()
+	}
+	for _, e := range initial {
+		if e, ok := s.filter(e); ok {
+			s.events <- e
+		}
+	}
+	b.subscribers[s] = struct{}{}
+	return s, nil
+}
+
+func (b *broadcaster) publish(e DeploymentEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := e.Namespace + "/" + e.Name
+	if e.Type == EventDelete {
+		delete(b.versions, key)
+	} else {
+		b.versions[key] = e.ResourceVersion
+	}
+	if b.historySize > 0 {
+		if len(b.history) == b.historySize {
+			// Evicted events are cleared, so the backing array does not retain them
+			b.history[0] = DeploymentEvent{}
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, trimmed(e))
+	}
+
+	for s := range b.subscribers {
+		if s.skip(key, e) {
+			continue
+		}
+		e, ok := s.filter(e)
+		if !ok {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			log.Warn().Str("deployment", key).Msg("Dropping a deployment event subscriber that does not keep up")
+			b.remove(s, ErrSlowConsumer)
+		}
+	}
+}
+
+// stop ends all subscriptions and refuses new ones
+func (b *broadcaster) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	for s := range b.subscribers {
+		b.remove(s, ErrStopped)
+	}
+}
+
+// remove ends the subscription s with err, the caller must hold b.mu
+func (b *broadcaster) remove(s *Subscription, err error) {
+	if _, ok := b.subscribers[s]; !ok {
+		return
+	}
+	delete(b.subscribers, s)
+	s.err = err
+	close(s.events)
+}
+
+// handler publishes the changes of the cached Deployments
+func (b *broadcaster) handler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if d, ok := asDeployment(obj); ok {
+				b.publish(newEvent(EventAdd, d))
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldDep, oldOk := asDeployment(oldObj)
+			newDep, newOk := asDeployment(newObj)
+			// Resyncs deliver unchanged objects
+			if !oldOk || !newOk || oldDep.ResourceVersion == newDep.ResourceVersion {
+				return
+			}
+			e := newEvent(EventUpdate, newDep)
+			e.old = oldDep
+			if e.Changes = specChanges(oldDep, newDep); len(e.Changes) == 0 {
+				e.Changes = statusChanges(oldDep, newDep)
+			}
+			b.publish(e)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if d, ok := asDeployment(obj); ok {
+				b.publish(newEvent(EventDelete, d))
+			}
+		},
+	}
+}
+
+// trimmed returns e with the metadata of its Deployments only, which is all subscriptions filter on. The
+// history does not retain older versions of the cached objects.
+func trimmed(e DeploymentEvent) DeploymentEvent {
+	e.Deployment = metadataOnly(e.Deployment)
+	e.old = metadataOnly(e.old)
+	return e
+}
+
+func metadataOnly(d *appsv1.Deployment) *appsv1.Deployment {
+	if d == nil {
+		return nil
+	}
+	return &appsv1.Deployment{
+		TypeMeta: d.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       d.Namespace,
+			Name:            d.Name,
+			UID:             d.UID,
+			ResourceVersion: d.ResourceVersion,
+			Labels:          d.Labels,
+		},
+	}
+}
+
+func newEvent(eventType EventType, d *appsv1.Deployment) DeploymentEvent {
+	return DeploymentEvent{
+		Type:            eventType,
+		Namespace:       d.Namespace,
+		Name:            d.Name,
+		ResourceVersion: d.ResourceVersion,
+		Deployment:      d,
+	}
+}
@@ -0,0 +1,197 @@
+package informer
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func versioned(d *appsv1.Deployment, resourceVersion string) *appsv1.Deployment {
+	d.ResourceVersion = resourceVersion
+	return d
+}
+
+// nextEvent returns the next event of s, the zero event when the subscription ended
+func nextEvent(t *testing.T, s *Subscription) DeploymentEvent {
+	t.Helper()
+	select {
+	case e := <-s.Events():
+		return e
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no deployment event")
+		return DeploymentEvent{}
+	}
+}
+
+// summary is an event without its objects, for comparisons
+func summary(e DeploymentEvent) DeploymentEvent {
+	return DeploymentEvent{Type: e.Type, Namespace: e.Namespace, Name: e.Name, ResourceVersion: e.ResourceVersion, Changes: e.Changes}
+}
+
+func TestDeploymentCacheSubscribe(t *testing.T) {
+	replicas := func(n int32) *int32 { return &n }
+	web := versioned(testDeployment("default", "web", map[string]string{"app": "web"}, ""), "1")
+	web.Spec.Replicas = replicas(1)
+	clientset := fake.NewClientset(web, versioned(testDeployment("default", "api", nil, ""), "2"))
+	c, err := NewDeploymentCache(clientset)
+	require.NoError(t, err)
+	runCache(t, c)
+
+	s, err := c.Subscribe(SubscribeOptions{})
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, DeploymentEvent{Type: EventAdd, Namespace: "default", Name: "api", ResourceVersion: "2"}, summary(nextEvent(t, s)))
+	assert.Equal(t, DeploymentEvent{Type: EventAdd, Namespace: "default", Name: "web", ResourceVersion: "1"}, summary(nextEvent(t, s)))
+
+	updated := versioned(web.DeepCopy(), "3")
+	updated.Spec.Replicas = replicas(3)
+	_, err = clientset.AppsV1().Deployments("default").Update(context.Background(), updated, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	e := nextEvent(t, s)
+	assert.Equal(t, DeploymentEvent{Type: EventUpdate, Namespace: "default", Name: "web", ResourceVersion: "3", Changes: []string{"replicas: 1 -> 3"}}, summary(e))
+	assert.Equal(t, int32(3), *e.Deployment.Spec.Replicas)
+
+	require.NoError(t, clientset.AppsV1().Deployments("default").Delete(context.Background(), "api", metav1.DeleteOptions{}))
+	assert.Equal(t, DeploymentEvent{Type: EventDelete, Namespace: "default", Name: "api", ResourceVersion: "2"}, summary(nextEvent(t, s)))
+
+	// Resuming replays the events after the resourceVersion
+	resumed, err := c.Subscribe(SubscribeOptions{ResourceVersion: "3"})
+	require.NoError(t, err)
+	defer resumed.Close()
+	assert.Equal(t, DeploymentEvent{Type: EventDelete, Namespace: "default", Name: "api", ResourceVersion: "2"}, summary(nextEvent(t, resumed)))
+}
+
+func TestSubscriptionFilter(t *testing.T) {
+	b := newBroadcaster(DefaultEventHistory, nil)
+	s, err := b.subscribe(SubscribeOptions{Namespace: "default", LabelSelector: labels.SelectorFromSet(labels.Set{"app": "web"})})
+	require.NoError(t, err)
+
+	web := versioned(testDeployment("default", "web", map[string]string{"app": "web"}, ""), "1")
+	b.publish(newEvent(EventAdd, web))
+	b.publish(newEvent(EventAdd, versioned(testDeployment("other", "web", map[string]string{"app": "web"}, ""), "2")))
+	b.publish(newEvent(EventAdd, versioned(testDeployment("default", "api", map[string]string{"app": "api"}, ""), "3")))
+	assert.Equal(t, "1", nextEvent(t, s).ResourceVersion, "other namespaces and labels are filtered")
+
+	relabeled := versioned(testDeployment("default", "web", map[string]string{"app": "proxy"}, ""), "4")
+	update := newEvent(EventUpdate, relabeled)
+	update.old = web
+	b.publish(update)
+	assert.Equal(t, EventDelete, nextEvent(t, s).Type, "leaving the selector is a delete")
+
+	update = newEvent(EventUpdate, web)
+	update.old = relabeled
+	b.publish(update)
+	assert.Equal(t, EventAdd, nextEvent(t, s).Type, "entering the selector is an add")
+
+	s.Close()
+	_, ok := <-s.Events()
+	assert.False(t, ok)
+	assert.NoError(t, s.Err())
+}
+
+func TestSubscriptionResume(t *testing.T) {
+	var cached []*appsv1.Deployment
+	b := newBroadcaster(2, func() []*appsv1.Deployment { return cached })
+	for i, name := range []string{"a", "b", "c"} {
+		d := versioned(testDeployment("default", name, nil, ""), strconv.Itoa(i+1))
+		cached = append(cached, d)
+		b.publish(newEvent(EventAdd, d))
+	}
+
+	s, err := b.subscribe(SubscribeOptions{ResourceVersion: "2"})
+	require.NoError(t, err)
+	assert.Equal(t, "c", nextEvent(t, s).Name)
+	s.Bookmark()
+	assert.Equal(t, DeploymentEvent{Type: EventBookmark, ResourceVersion: "3"}, nextEvent(t, s))
+
+	_, err = b.subscribe(SubscribeOptions{ResourceVersion: "1"})
+	assert.ErrorIs(t, err, ErrResourceVersionTooOld, "only the latest events are kept")
+
+	s, err = b.subscribe(SubscribeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, []string{nextEvent(t, s).Name, nextEvent(t, s).Name, nextEvent(t, s).Name}, "without a resourceVersion the current state is sent")
+}
+
+func TestSubscriptionSlowConsumer(t *testing.T) {
+	b := newBroadcaster(DefaultEventHistory, nil)
+	slow, err := b.subscribe(SubscribeOptions{BufferSize: 1})
+	require.NoError(t, err)
+	fast, err := b.subscribe(SubscribeOptions{})
+	require.NoError(t, err)
+
+	b.publish(newEvent(EventAdd, versioned(testDeployment("default", "a", nil, ""), "1")))
+	b.publish(newEvent(EventAdd, versioned(testDeployment("default", "b", nil, ""), "2")))
+
+	assert.Equal(t, "a", nextEvent(t, slow).Name, "buffered events are delivered")
+	_, ok := <-slow.Events()
+	assert.False(t, ok)
+	assert.ErrorIs(t, slow.Err(), ErrSlowConsumer)
+	assert.Equal(t, "a", nextEvent(t, fast).Name, "other subscribers are not affected")
+	assert.Equal(t, "b", nextEvent(t, fast).Name)
+
+	b.stop()
+	_, ok = <-fast.Events()
+	assert.False(t, ok)
+	assert.ErrorIs(t, fast.Err(), ErrStopped)
+	_, err = b.subscribe(SubscribeOptions{})
+	assert.ErrorIs(t, err, ErrStopped)
+}
+
+func TestEventHistoryEviction(t *testing.T) {
+	b := newBroadcaster(3, nil)
+	for i := 1; i <= 5; i++ {
+		d := versioned(testDeployment("default", "web", map[string]string{"app": "web"}, ""), strconv.Itoa(i))
+		d.Spec.Template.Spec.Containers = []corev1.Container{{Name: "nginx", Image: "nginx:1." + strconv.Itoa(i)}}
+		b.publish(newEvent(EventUpdate, d))
+	}
+
+	require.Len(t, b.history, 3, "the history is bounded")
+	assert.Equal(t, "3", b.history[0].ResourceVersion, "the oldest events are evicted")
+	for _, e := range b.history {
+		assert.Empty(t, e.Deployment.Spec.Template.Spec.Containers, "the history keeps only the metadata")
+		assert.Equal(t, map[string]string{"app": "web"}, e.Deployment.Labels)
+	}
+
+	_, err := b.subscribe(SubscribeOptions{ResourceVersion: "2"})
+	assert.ErrorIs(t, err, ErrResourceVersionTooOld)
+	s, err := b.subscribe(SubscribeOptions{ResourceVersion: "3", LabelSelector: labels.SelectorFromSet(labels.Set{"app": "web"})})
+	require.NoError(t, err)
+	assert.Equal(t, "4", nextEvent(t, s).ResourceVersion, "trimmed events are still filtered by labels")
+	assert.Equal(t, "5", nextEvent(t, s).ResourceVersion)
+
+	b = newBroadcaster(0, nil)
+	b.publish(newEvent(EventAdd, versioned(testDeployment("default", "web", nil, ""), "1")))
+	assert.Empty(t, b.history, "no history is kept with a size of 0")
+}
+
+func TestSubscriptionStartsFromCache(t *testing.T) {
+	web := versioned(testDeployment("default", "web", nil, ""), "1")
+	api := versioned(testDeployment("default", "api", nil, ""), "2")
+	cached := []*appsv1.Deployment{api, web}
+	b := newBroadcaster(DefaultEventHistory, func() []*appsv1.Deployment { return cached })
+	b.publish(newEvent(EventAdd, web))
+	b.publish(newEvent(EventAdd, api))
+
+	// The cache is ahead of the published events: web was updated and api deleted
+	updated := versioned(testDeployment("default", "web", nil, ""), "3")
+	cached = []*appsv1.Deployment{updated}
+	s, err := b.subscribe(SubscribeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, DeploymentEvent{Type: EventAdd, Namespace: "default", Name: "web", ResourceVersion: "3"}, summary(nextEvent(t, s)))
+
+	// The pending events are not sent again
+	b.publish(newEvent(EventUpdate, updated))
+	b.publish(newEvent(EventDelete, api))
+	b.publish(newEvent(EventAdd, versioned(testDeployment("default", "api", nil, ""), "4")))
+	assert.Equal(t, DeploymentEvent{Type: EventAdd, Namespace: "default", Name: "api", ResourceVersion: "4"}, summary(nextEvent(t, s)))
+	assert.Empty(t, s.pending)
+}
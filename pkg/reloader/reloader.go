@@ -0,0 +1,219 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/ctrl"
+)
+
+// DefaultReloadTimeout is how long nginx gets to start workers with a new config after SIGHUP
+const DefaultReloadTimeout = 10 * time.Second
+
+// workerPollInterval is how often the nginx workers are listed while waiting for a reload
+var workerPollInterval = 100 * time.Millisecond
+
+// Reloader watches the nginx config mounted from the proxy ConfigMap and reloads nginx in place when
+// it changes. It runs as a sidecar in a pod with a shared process namespace.
+type Reloader struct {
+	// ConfigPath is the mounted proxy.conf
+	ConfigPath string
+	// ProcDir is where the nginx master process is looked up, normally /proc
+	ProcDir string
+	// Interval between two checks of ConfigPath; kubelet swaps ConfigMap files atomically, so polling is
+	// more reliable than file system notifications here
+	Interval time.Duration
+	// Validate rejects configs that must not be loaded
+	Validate func(config string) error
+	// Report publishes the hash of the config nginx is running with, it is optional
+	Report func(ctx context.Context, hash string) error
+	// Signal delivers a signal to a process, it defaults to os.Process.Signal
+	Signal func(pid int, sig os.Signal) error
+	// ReloadTimeout bounds the wait for new nginx workers after SIGHUP, it defaults to DefaultReloadTimeout
+	ReloadTimeout time.Duration
+
+	loadedHash   string
+	reportedHash string
+	rejectedHash string
+}
+
+// Run checks the config every Interval until ctx is cancelled
+func (r *Reloader) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Sync(ctx); err != nil {
+			log.Error().Err(err).Msg("Config reload failed")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync reloads nginx when the config on disk differs from the loaded one and reports the loaded hash.
+// The first config seen is the one nginx started with, so it is reported without a reload. nginx keeps
+// its workers when it fails to apply a config, so a reload only counts once a new worker is running.
+func (r *Reloader) Sync(ctx context.Context) error {
+	data, err := os.ReadFile(r.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	config := string(data)
+	hash := ctrl.ConfigHash(config)
+
+	if hash != r.loadedHash {
+		if err := r.Validate(config); err != nil {
+			if hash == r.rejectedHash {
+				return nil
+			}
+			r.rejectedHash = hash
+			return fmt.Errorf("config %s failed validation, keeping %s: %w", hash, r.loadedHash, err)
+		}
+
+		if r.loadedHash != "" {
+			pid, err := FindNginxMaster(r.ProcDir)
+			if err != nil {
+				return err
+			}
+			workers := NginxWorkers(r.ProcDir, pid)
+			if err := r.signal(pid, syscall.SIGHUP); err != nil {
+				return fmt.Errorf("failed to signal nginx master %d: %w", pid, err)
+			}
+			if err := r.waitForNewWorker(ctx, pid, workers); err != nil {
+				return fmt.Errorf("nginx master %d did not load config %s, keeping %s: %w", pid, hash, r.loadedHash, err)
+			}
+			log.Info().Str("from", r.loadedHash).Str("to", hash).Int("pid", pid).Msg("Reloaded nginx config")
+		}
+		r.loadedHash = hash
+	}
+
+	if r.Report != nil && r.reportedHash != r.loadedHash {
+		if err := r.Report(ctx, r.loadedHash); err != nil {
+			return fmt.Errorf("failed to report config %s: %w", r.loadedHash, err)
+		}
+		r.reportedHash = r.loadedHash
+	}
+	return nil
+}
+
+// LoadedHash returns the hash of the config nginx is running with
+func (r *Reloader) LoadedHash() string {
+	return r.loadedHash
+}
+
+func (r *Reloader) signal(pid int, sig os.Signal) error {
+	if r.Signal != nil {
+		return r.Signal(pid, sig)
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(sig)
+}
+
+// waitForNewWorker waits until master runs a worker that is not in workers
+func (r *Reloader) waitForNewWorker(ctx context.Context, master int, workers []int) error {
+	timeout := r.ReloadTimeout
+	if timeout == 0 {
+		timeout = DefaultReloadTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	old := make(map[int]bool, len(workers))
+	for _, pid := range workers {
+		old[pid] = true
+	}
+	ticker := time.NewTicker(workerPollInterval)
+	defer ticker.Stop()
+	for {
+		for _, pid := range NginxWorkers(r.ProcDir, master) {
+			if !old[pid] {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no new worker process within %s, check the nginx error log", timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// FindNginxMaster returns the pid of the nginx master process by scanning procDir
+func FindNginxMaster(procDir string) (int, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list processes: %w", err)
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join(procDir, entry.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		// nginx rewrites its title to "nginx: master process <command line>"
+		if strings.HasPrefix(string(cmdline), "nginx: master process") {
+			return pid, nil
+		}
+	}
+	return 0, fmt.Errorf("nginx master process not found in %s, is shareProcessNamespace enabled?", procDir)
+}
+
+// NginxWorkers returns the pids of the worker processes of the nginx master by scanning procDir
+func NginxWorkers(procDir string, master int) []int {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil
+	}
+	var workers []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join(procDir, entry.Name(), "cmdline"))
+		if err != nil || !strings.HasPrefix(string(cmdline), "nginx: worker process") {
+			continue
+		}
+		if parentPID(procDir, entry.Name()) == master {
+			workers = append(workers, pid)
+		}
+	}
+	return workers
+}
+
+// parentPID reads the parent pid from /proc/<pid>/stat, it is 0 when unknown
+func parentPID(procDir, pid string) int {
+	stat, err := os.ReadFile(filepath.Join(procDir, pid, "stat"))
+	if err != nil {
+		return 0
+	}
+	// The command name in parentheses may contain spaces, the state and parent pid follow it
+	end := strings.LastIndexByte(string(stat), ')')
+	if end < 0 {
+		return 0
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
@@ -0,0 +1,142 @@
+package reloader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/ctrl"
+)
+
+func writeProcess(t *testing.T, procDir, pid, cmdline string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Join(procDir, pid), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(procDir, pid, "cmdline"), []byte(cmdline), 0o644))
+}
+
+func writeWorker(t *testing.T, procDir, pid, master string) {
+	t.Helper()
+	writeProcess(t, procDir, pid, "nginx: worker process\x00")
+	stat := pid + " (nginx) S " + master + " 6 6 0 -1 4194624"
+	require.NoError(t, os.WriteFile(filepath.Join(procDir, pid, "stat"), []byte(stat), 0o644))
+}
+
+func TestFindNginxMaster(t *testing.T) {
+	procDir := t.TempDir()
+	writeProcess(t, procDir, "1", "/pause\x00")
+	writeProcess(t, procDir, "7", "nginx: worker process\x00")
+
+	_, err := FindNginxMaster(procDir)
+	assert.Error(t, err)
+
+	writeProcess(t, procDir, "6", "nginx: master process nginx -g daemon off;\x00")
+	require.NoError(t, os.MkdirAll(filepath.Join(procDir, "self"), 0o755))
+
+	pid, err := FindNginxMaster(procDir)
+	require.NoError(t, err)
+	assert.Equal(t, 6, pid)
+}
+
+func TestNginxWorkers(t *testing.T) {
+	procDir := t.TempDir()
+	writeProcess(t, procDir, "6", "nginx: master process nginx -g daemon off;\x00")
+	writeWorker(t, procDir, "7", "6")
+	writeWorker(t, procDir, "8", "6")
+	writeWorker(t, procDir, "21", "20")
+	writeProcess(t, procDir, "9", "nginx: worker process\x00")
+
+	assert.Equal(t, []int{7, 8}, NginxWorkers(procDir, 6))
+	assert.Empty(t, NginxWorkers(procDir, 5))
+}
+
+func TestReloaderSync(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "proxy.conf")
+	procDir := filepath.Join(dir, "proc")
+	writeProcess(t, procDir, "6", "nginx: master process nginx -g daemon off;\x00")
+	writeWorker(t, procDir, "7", "6")
+
+	var signalled []int
+	var reported []string
+	r := &Reloader{
+		ConfigPath: configPath,
+		ProcDir:    procDir,
+		Validate: func(config string) error {
+			if config == "broken" {
+				return errors.New("broken config")
+			}
+			return nil
+		},
+		Report: func(ctx context.Context, hash string) error {
+			reported = append(reported, hash)
+			return nil
+		},
+		Signal: func(pid int, sig os.Signal) error {
+			assert.Equal(t, syscall.SIGHUP, sig)
+			signalled = append(signalled, pid)
+			// nginx starts new workers with the new config and shuts the old ones down
+			writeWorker(t, procDir, strconv.Itoa(7+len(signalled)), "6")
+			return nil
+		},
+	}
+	ctx := context.Background()
+
+	require.NoError(t, os.WriteFile(configPath, []byte("v1"), 0o644))
+	require.NoError(t, r.Sync(ctx))
+	assert.Empty(t, signalled, "the initial config is loaded by nginx on start")
+	assert.Equal(t, []string{ctrl.ConfigHash("v1")}, reported)
+
+	require.NoError(t, r.Sync(ctx))
+	assert.Empty(t, signalled)
+	assert.Len(t, reported, 1, "unchanged config is reported once")
+
+	require.NoError(t, os.WriteFile(configPath, []byte("broken"), 0o644))
+	assert.Error(t, r.Sync(ctx))
+	assert.NoError(t, r.Sync(ctx), "a rejected config is only logged once")
+	assert.Empty(t, signalled)
+	assert.Equal(t, ctrl.ConfigHash("v1"), r.LoadedHash())
+
+	require.NoError(t, os.WriteFile(configPath, []byte("v2"), 0o644))
+	require.NoError(t, r.Sync(ctx))
+	assert.Equal(t, []int{6}, signalled)
+	assert.Equal(t, []string{ctrl.ConfigHash("v1"), ctrl.ConfigHash("v2")}, reported)
+}
+
+func TestReloaderSyncUnconfirmedReload(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "proxy.conf")
+	procDir := filepath.Join(dir, "proc")
+	writeProcess(t, procDir, "6", "nginx: master process nginx -g daemon off;\x00")
+	writeWorker(t, procDir, "7", "6")
+
+	var reported []string
+	r := &Reloader{
+		ConfigPath: configPath,
+		ProcDir:    procDir,
+		Validate:   func(string) error { return nil },
+		Report: func(ctx context.Context, hash string) error {
+			reported = append(reported, hash)
+			return nil
+		},
+		// nginx rejected the config and kept its workers
+		Signal:        func(int, os.Signal) error { return nil },
+		ReloadTimeout: 50 * time.Millisecond,
+	}
+	ctx := context.Background()
+
+	require.NoError(t, os.WriteFile(configPath, []byte("v1"), 0o644))
+	require.NoError(t, r.Sync(ctx))
+
+	require.NoError(t, os.WriteFile(configPath, []byte("v2"), 0o644))
+	assert.ErrorContains(t, r.Sync(ctx), "did not load config")
+	assert.Equal(t, ctrl.ConfigHash("v1"), r.LoadedHash())
+	assert.Equal(t, []string{ctrl.ConfigHash("v1")}, reported, "an unconfirmed reload is not reported")
+}
@@ -0,0 +1,105 @@
+package testutil
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	collectortracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLPReceiver is an in-process OTLP/HTTP trace receiver collecting every exported span
+type OTLPReceiver struct {
+	// URL is the base URL to export to, the receiver serves /v1/traces below it
+	URL string
+
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+// StartOTLPReceiver starts a receiver that is stopped when the test ends
+func StartOTLPReceiver(t *testing.T) *OTLPReceiver {
+	t.Helper()
+	receiver := &OTLPReceiver{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/traces", receiver.export)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	receiver.URL = server.URL
+	return receiver
+}
+
+func (r *OTLPReceiver) export(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var export collectortracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	for _, resourceSpans := range export.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			r.spans = append(r.spans, scopeSpans.Spans...)
+		}
+	}
+	r.mu.Unlock()
+
+	response, _ := proto.Marshal(&collectortracepb.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(response)
+}
+
+// Spans returns the spans received so far
+func (r *OTLPReceiver) Spans() []*tracepb.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*tracepb.Span(nil), r.spans...)
+}
+
+// WaitForSpan waits until a span named name was received and returns it
+func (r *OTLPReceiver) WaitForSpan(t *testing.T, name string) *tracepb.Span {
+	t.Helper()
+	var found *tracepb.Span
+	require.Eventually(t, func() bool {
+		for _, span := range r.Spans() {
+			if span.Name == name {
+				found = span
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 20*time.Millisecond, "span %s not received", name)
+	return found
+}
+
+// SpanAttribute returns the string form of the attribute key of span, "" when it is not set
+func SpanAttribute(span *tracepb.Span, key string) string {
+	for _, attr := range span.Attributes {
+		if attr.Key != key {
+			continue
+		}
+		switch value := attr.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			return value.StringValue
+		case *commonpb.AnyValue_IntValue:
+			return strconv.FormatInt(value.IntValue, 10)
+		case *commonpb.AnyValue_BoolValue:
+			return strconv.FormatBool(value.BoolValue)
+		}
+		return attr.Value.String()
+	}
+	return ""
+}
@@ -0,0 +1,160 @@
+// Package tracing exports OpenTelemetry traces of the controller, the REST API and the MCP server over
+// OTLP/HTTP and adds the trace context to zerolog output.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Samplers accepted by Options.Sampler, named like the OTEL_TRACES_SAMPLER values
+const (
+	SamplerAlwaysOn                = "always_on"
+	SamplerAlwaysOff               = "always_off"
+	SamplerTraceIDRatio            = "traceidratio"
+	SamplerParentBasedAlwaysOn     = "parentbased_always_on"
+	SamplerParentBasedAlwaysOff    = "parentbased_always_off"
+	SamplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+
+	// ServiceName is the default service.name resource attribute
+	ServiceName = "jaeger-nginx-proxy-controller"
+
+	// Log fields holding the trace context
+	TraceIDField = "trace_id"
+	SpanIDField  = "span_id"
+
+	instrumentationName = "github.com/dolv/k8s-controller-tutorial"
+	tracesPath          = "/v1/traces"
+)
+
+// Samplers lists the supported sampler names
+var Samplers = []string{
+	SamplerAlwaysOn, SamplerAlwaysOff, SamplerTraceIDRatio,
+	SamplerParentBasedAlwaysOn, SamplerParentBasedAlwaysOff, SamplerParentBasedTraceIDRatio,
+}
+
+// Options configures trace export
+type Options struct {
+	// Endpoint is the OTLP/HTTP base URL, e.g. http://jaeger-collector:4318, or the URL of its /v1/traces
+	// path. Tracing is disabled when empty.
+	Endpoint string
+	// Sampler is one of Samplers, SamplerParentBasedAlwaysOn when empty
+	Sampler string
+	// SamplerArg is the ratio of the trace ID ratio samplers
+	SamplerArg float64
+	// ServiceName overrides ServiceName
+	ServiceName    string
+	ServiceVersion string
+	// ResourceAttributes are added to every exported span
+	ResourceAttributes map[string]string
+}
+
+func newSampler(name string, arg float64) (sdktrace.Sampler, error) {
+	switch name {
+	case SamplerAlwaysOn:
+		return sdktrace.AlwaysSample(), nil
+	case SamplerAlwaysOff:
+		return sdktrace.NeverSample(), nil
+	case SamplerTraceIDRatio:
+		return sdktrace.TraceIDRatioBased(arg), nil
+	case "", SamplerParentBasedAlwaysOn:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case SamplerParentBasedAlwaysOff:
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case SamplerParentBasedTraceIDRatio:
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(arg)), nil
+	}
+	return nil, fmt.Errorf("unknown trace sampler %q, expected one of %s", name, strings.Join(Samplers, ", "))
+}
+
+func newResource(opts Options) (*resource.Resource, error) {
+	serviceName := opts.ServiceName
+	if serviceName == "" {
+		serviceName = ServiceName
+	}
+	attrs := []attribute.KeyValue{semconv.ServiceName(serviceName)}
+	if opts.ServiceVersion != "" {
+		attrs = append(attrs, semconv.ServiceVersion(opts.ServiceVersion))
+	}
+	keys := make([]string, 0, len(opts.ResourceAttributes))
+	for key := range opts.ResourceAttributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		attrs = append(attrs, attribute.String(key, opts.ResourceAttributes[key]))
+	}
+	return resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, attrs...))
+}
+
+// Setup installs the global tracer provider and W3C trace context propagation. The returned function
+// flushes pending spans and stops the export, it is a no-op when tracing is disabled.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	sampler, err := newSampler(opts.Sampler, opts.SamplerArg)
+	if err != nil {
+		return nil, err
+	}
+	res, err := newResource(opts)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := strings.TrimSuffix(opts.Endpoint, "/")
+	if !strings.HasSuffix(endpoint, tracesPath) {
+		endpoint += tracesPath
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(5*time.Second)),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start starts a span with the tracer of the global provider
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End records err on span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// LogHook adds the trace and span ID to events of loggers or events with a context carrying a span, e.g.
+// log.Info().Ctx(ctx) or log.With().Ctx(ctx).Logger()
+type LogHook struct{}
+
+func (LogHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	spanCtx := trace.SpanContextFromContext(e.GetCtx())
+	if spanCtx.IsValid() {
+		e.Str(TraceIDField, spanCtx.TraceID().String()).Str(SpanIDField, spanCtx.SpanID().String())
+	}
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+
+	"github.com/dolv/k8s-controller-tutorial/pkg/testutil"
+)
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+	receiver := testutil.StartOTLPReceiver(t)
+	shutdown, err := Setup(ctx, Options{
+		Endpoint:           receiver.URL,
+		Sampler:            SamplerAlwaysOn,
+		ResourceAttributes: map[string]string{"deployment.environment": "test"},
+	})
+	require.NoError(t, err)
+
+	parentCtx, parent := Start(ctx, "parent")
+	_, child := Start(parentCtx, "child")
+	End(child, errors.New("boom"))
+	End(parent, nil)
+	require.NoError(t, shutdown(ctx))
+
+	received := receiver.WaitForSpan(t, "child")
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, received.Status.Code)
+	assert.Equal(t, "boom", received.Status.Message)
+	assert.Equal(t, parent.SpanContext().TraceID().String(), hex.EncodeToString(received.TraceId))
+	assert.Equal(t, parent.SpanContext().SpanID().String(), hex.EncodeToString(received.ParentSpanId))
+	assert.Equal(t, tracepb.Status_STATUS_CODE_UNSET, receiver.WaitForSpan(t, "parent").Status.Code)
+}
+
+func TestSetupDisabled(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Options{Sampler: "bogus"})
+	require.NoError(t, err, "options are not checked while tracing is disabled")
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup(context.Background(), Options{Endpoint: "http://127.0.0.1:4318", Sampler: "bogus"})
+	assert.ErrorContains(t, err, `unknown trace sampler "bogus"`)
+}
+
+func TestNewSampler(t *testing.T) {
+	for _, name := range append(Samplers, "") {
+		sampler, err := newSampler(name, 0.5)
+		require.NoError(t, err, name)
+		assert.NotNil(t, sampler)
+	}
+}
+
+func TestLogHook(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf).Hook(LogHook{})
+
+	logger.Info().Msg("no span")
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.NotContains(t, entry, TraceIDField)
+
+	receiver := testutil.StartOTLPReceiver(t)
+	shutdown, err := Setup(context.Background(), Options{Endpoint: receiver.URL, Sampler: SamplerAlwaysOn})
+	require.NoError(t, err)
+	defer func() { _ = shutdown(context.Background()) }()
+	ctx, span := Start(context.Background(), "logged")
+	defer span.End()
+
+	for _, log := range []func(){
+		func() { logger.Info().Ctx(ctx).Msg("event context") },
+		func() { l := logger.With().Ctx(ctx).Logger(); l.Info().Msg("logger context") },
+	} {
+		buf.Reset()
+		log()
+		entry = map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, span.SpanContext().TraceID().String(), entry[TraceIDField])
+		assert.Equal(t, span.SpanContext().SpanID().String(), entry[SpanIDField])
+	}
+}
@@ -2,10 +2,21 @@ package webhook
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/prometheus/common/model"
 	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -17,8 +28,13 @@ import (
 
 // JaegerNginxProxyValidator validates JaegerNginxProxy resources
 type JaegerNginxProxyValidator struct {
-	Client  client.Client
-	decoder *admission.Decoder
+	Client client.Client
+	// APIReader reads config templates uncached, the webhook may be asked about namespaces the manager
+	// cache does not hold. The Client is used when it is nil.
+	APIReader client.Reader
+	// ConfigValidators check the generated nginx config, ctrl.DefaultConfigValidators when empty
+	ConfigValidators ctrl.ConfigValidators
+	decoder          *admission.Decoder
 }
 
 //+kubebuilder:webhook:path=/validate-jaeger-nginx-proxy-platform-engineer-stream-v1alpha0-jaegernginxproxy,mutating=false,failurePolicy=fail,sideEffects=None,admissionReviewVersions=v1;v1beta1,groups=jaeger-nginx-proxy.platform-engineer.stream,resources=jaegernginxproxies,verbs=create;update,versions=v1alpha0,name=vjaegernginxproxy.kb.io
@@ -30,8 +46,7 @@ func (v *JaegerNginxProxyValidator) ValidateCreate(ctx context.Context, obj runt
 	nginxProxy := obj.(*JaegerNginxProxyV1alpha0.JaegerNginxProxy)
 	log.Info().Msgf("Validating creation of JaegerNginxProxy: %s/%s", nginxProxy.Namespace, nginxProxy.Name)
 
-	err := v.validateJaegerNginxProxy(nginxProxy)
-	return nil, err
+	return v.validateJaegerNginxProxy(ctx, nginxProxy)
 }
 
 // ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
@@ -40,8 +55,7 @@ func (v *JaegerNginxProxyValidator) ValidateUpdate(ctx context.Context, oldObj,
 
 	log.Info().Msgf("Validating update of JaegerNginxProxy: %s/%s", newNginxProxy.Namespace, newNginxProxy.Name)
 
-	err := v.validateJaegerNginxProxy(newNginxProxy)
-	return nil, err
+	return v.validateJaegerNginxProxy(ctx, newNginxProxy)
 }
 
 // ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
@@ -53,9 +67,11 @@ func (v *JaegerNginxProxyValidator) ValidateDelete(ctx context.Context, obj runt
 	return nil, nil
 }
 
-// validateJaegerNginxProxy performs comprehensive validation of the JaegerNginxProxy resource
-func (v *JaegerNginxProxyValidator) validateJaegerNginxProxy(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) error {
+// validateJaegerNginxProxy performs comprehensive validation of the JaegerNginxProxy resource. Config
+// validator findings that do not reject the config are returned as warnings.
+func (v *JaegerNginxProxyValidator) validateJaegerNginxProxy(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) (admission.Warnings, error) {
 	var allErrs field.ErrorList
+	var warnings admission.Warnings
 
 	// Validate basic fields
 	if nginxProxy.Spec.ReplicaCount <= 0 {
@@ -120,8 +136,35 @@ func (v *JaegerNginxProxyValidator) validateJaegerNginxProxy(nginxProxy *JaegerN
 				"port path is required",
 			))
 		}
+
+		if port.Protocol != "" && port.Protocol != ctrl.PortProtocolHTTP && port.Protocol != ctrl.PortProtocolGRPC {
+			allErrs = append(allErrs, field.NotSupported(
+				field.NewPath("spec", "ports").Index(i).Child("protocol"),
+				port.Protocol,
+				[]string{ctrl.PortProtocolHTTP, ctrl.PortProtocolGRPC},
+			))
+		}
+
+		allErrs = append(allErrs, validatePortHeaders(port, field.NewPath("spec", "ports").Index(i))...)
+		allErrs = append(allErrs, validateAccessAddresses(port.Allow, field.NewPath("spec", "ports").Index(i).Child("allow"))...)
+		allErrs = append(allErrs, validateAccessAddresses(port.Deny, field.NewPath("spec", "ports").Index(i).Child("deny"))...)
 	}
 
+	// Validate ingress
+	allErrs = append(allErrs, validateIngress(nginxProxy.Spec.Ingress, field.NewPath("spec", "ingress"))...)
+
+	// Validate network policy
+	allErrs = append(allErrs, validateNetworkPolicy(nginxProxy, field.NewPath("spec", "networkPolicy"))...)
+
+	// Validate access control
+	allErrs = append(allErrs, validateAccess(nginxProxy, field.NewPath("spec", "access"))...)
+
+	// Validate logging
+	allErrs = append(allErrs, validateLogging(nginxProxy.Spec.Logging, field.NewPath("spec", "logging"))...)
+
+	// Validate metrics
+	allErrs = append(allErrs, validateMetrics(nginxProxy, field.NewPath("spec", "metrics"))...)
+
 	// Validate image
 	if nginxProxy.Spec.Image.Repository == "" {
 		allErrs = append(allErrs, field.Required(
@@ -137,6 +180,99 @@ func (v *JaegerNginxProxyValidator) validateJaegerNginxProxy(nginxProxy *JaegerN
 		))
 	}
 
+	switch nginxProxy.Spec.Image.PullPolicy {
+	case "", string(corev1.PullAlways), string(corev1.PullIfNotPresent), string(corev1.PullNever):
+	default:
+		allErrs = append(allErrs, field.NotSupported(
+			field.NewPath("spec", "image", "pullPolicy"),
+			nginxProxy.Spec.Image.PullPolicy,
+			[]string{string(corev1.PullAlways), string(corev1.PullIfNotPresent), string(corev1.PullNever)},
+		))
+	}
+
+	for i, secret := range nginxProxy.Spec.Image.PullSecrets {
+		for _, msg := range validation.IsDNS1123Subdomain(secret) {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "image", "pullSecrets").Index(i), secret, msg))
+		}
+	}
+
+	// Validate service account
+	if sa := nginxProxy.Spec.ServiceAccount; sa != nil {
+		if sa.Create && sa.Name != "" && sa.Name != nginxProxy.Name {
+			allErrs = append(allErrs, field.Invalid(
+				field.NewPath("spec", "serviceAccount", "name"),
+				sa.Name,
+				"a created ServiceAccount is always named after the proxy, leave name empty",
+			))
+		}
+		if sa.Name != "" {
+			for _, msg := range validation.IsDNS1123Subdomain(sa.Name) {
+				allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "serviceAccount", "name"), sa.Name, msg))
+			}
+		}
+	}
+
+	// Validate reload strategy
+	switch nginxProxy.Spec.ReloadStrategy {
+	case "", ctrl.ReloadStrategyNone:
+	case ctrl.ReloadStrategyHotReload:
+		// The reloader sidecar is granted pod access, which must not end up on the namespace default ServiceAccount
+		if sa := nginxProxy.Spec.ServiceAccount; sa == nil || (!sa.Create && sa.Name == "") {
+			allErrs = append(allErrs, field.Required(
+				field.NewPath("spec", "serviceAccount"),
+				"a dedicated ServiceAccount is required when reloadStrategy is hotReload",
+			))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(
+			field.NewPath("spec", "reloadStrategy"),
+			nginxProxy.Spec.ReloadStrategy,
+			[]string{ctrl.ReloadStrategyNone, ctrl.ReloadStrategyHotReload},
+		))
+	}
+
+	// Validate revision history and rollback requests
+	if limit := nginxProxy.Spec.RevisionHistoryLimit; limit != nil && *limit < 1 {
+		allErrs = append(allErrs, field.Invalid(
+			field.NewPath("spec", "revisionHistoryLimit"),
+			*limit,
+			"must keep at least the current revision",
+		))
+	}
+	if value, ok := nginxProxy.Annotations[ctrl.RollbackAnnotation]; ok {
+		if revision, err := strconv.ParseInt(value, 10, 64); err != nil || revision < 0 {
+			allErrs = append(allErrs, field.Invalid(
+				field.NewPath("metadata", "annotations").Key(ctrl.RollbackAnnotation),
+				value,
+				"must be a revision number, or 0 for the previous revision",
+			))
+		}
+	}
+
+	// Validate config template and snippets, their directives are checked with the rendered config
+	if tmpl := nginxProxy.Spec.ConfigTemplate; tmpl != nil && tmpl.ConfigMapName == "" {
+		allErrs = append(allErrs, field.Required(
+			field.NewPath("spec", "configTemplate", "configMapName"),
+			"configMapName is required",
+		))
+	}
+	if snippets := nginxProxy.Spec.Snippets; snippets != nil {
+		for portName := range snippets.Location {
+			if !portNames[portName] {
+				allErrs = append(allErrs, field.NotFound(field.NewPath("spec", "snippets", "location").Key(portName), portName))
+			}
+		}
+	}
+
+	// Validate labels and annotations propagated to child resources
+	allErrs = append(allErrs, validateLabels(nginxProxy.Spec.CommonLabels, field.NewPath("spec", "commonLabels"))...)
+	allErrs = append(allErrs, validateLabels(nginxProxy.Spec.PodLabels, field.NewPath("spec", "podLabels"))...)
+	allErrs = append(allErrs, apivalidation.ValidateAnnotations(nginxProxy.Spec.CommonAnnotations, field.NewPath("spec", "commonAnnotations"))...)
+	allErrs = append(allErrs, apivalidation.ValidateAnnotations(nginxProxy.Spec.PodAnnotations, field.NewPath("spec", "podAnnotations"))...)
+
+	// Validate extra containers, env and volumes of the proxy pod
+	allErrs = append(allErrs, validatePodExtras(nginxProxy, field.NewPath("spec"))...)
+
 	// Validate resources
 	if nginxProxy.Spec.Resources.Limits.CPU == "" {
 		allErrs = append(allErrs, field.Required(
@@ -168,29 +304,423 @@ func (v *JaegerNginxProxyValidator) validateJaegerNginxProxy(nginxProxy *JaegerN
 
 	// Validate nginx configuration generation
 	if len(allErrs) == 0 {
-		if err := v.validateNginxConfigGeneration(nginxProxy); err != nil {
+		findings, err := v.validateNginxConfigGeneration(ctx, nginxProxy)
+		if err != nil {
 			allErrs = append(allErrs, field.Invalid(
-				field.NewPath("spec"),
+				field.NewPath(ctrl.ConfigErrorField(err)),
 				nginxProxy.Spec,
 				fmt.Sprintf("nginx configuration validation failed: %v", err),
 			))
 		}
+		for _, finding := range findings {
+			if finding.Severity == ctrl.SeverityWarning {
+				warnings = append(warnings, configFindingWarning(finding))
+			}
+		}
 	}
 
 	if len(allErrs) > 0 {
-		return fmt.Errorf("validation failed: %v", allErrs)
+		return warnings, fmt.Errorf("validation failed: %v", allErrs)
 	}
 
-	return nil
+	return warnings, nil
+}
+
+// configFindingWarning formats a config finding as admission warning, e.g.
+// "spec.ports[0].path: lint/proxy-host-header: proxy_pass without proxy_set_header Host ..."
+func configFindingWarning(finding JaegerNginxProxyV1alpha0.ConfigFinding) string {
+	fieldPath := finding.Field
+	if fieldPath == "" {
+		fieldPath = "spec"
+	}
+	return fmt.Sprintf("%s: %s/%s: %s", fieldPath, finding.Validator, finding.Rule, finding.Message)
+}
+
+// validateIngress validates spec.ingress when exposure is enabled
+func validateIngress(ingress *JaegerNginxProxyV1alpha0.Ingress, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if ingress == nil || !ingress.Enabled {
+		return allErrs
+	}
+
+	if ingress.Host == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("host"), "host is required when ingress is enabled"))
+	} else {
+		host := ingress.Host
+		if strings.HasPrefix(host, "*.") {
+			host = host[2:]
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(host) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("host"), ingress.Host, msg))
+		}
+	}
+
+	switch ingress.Kind {
+	case "", ctrl.IngressKindIngress:
+		if ingress.Gateway != nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("gateway"), "gateway can only be set when kind is Gateway"))
+		}
+	case ctrl.IngressKindGateway:
+		if ingress.Gateway == nil || ingress.Gateway.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("gateway", "name"), "gateway name is required when kind is Gateway"))
+		}
+		if ingress.TLSSecretName != "" {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("tlsSecretName"), "TLS is terminated by the Gateway listener when kind is Gateway"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("kind"), ingress.Kind, []string{ctrl.IngressKindIngress, ctrl.IngressKindGateway}))
+	}
+
+	return allErrs
+}
+
+// validateNetworkPolicy validates spec.networkPolicy and makes sure the collector can be resolved
+func validateNetworkPolicy(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	np := nginxProxy.Spec.NetworkPolicy
+	if np == nil || !np.Enabled {
+		return allErrs
+	}
+
+	for i, cidr := range np.CollectorCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("collectorCIDRs").Index(i), cidr, "must be a valid CIDR"))
+		}
+	}
+
+	if len(np.CollectorCIDRs) == 0 {
+		if _, _, ok := ctrl.ParseCollectorServiceHost(nginxProxy.Spec.Upstream.CollectorHost, nginxProxy.Namespace); !ok {
+			allErrs = append(allErrs, field.Required(fldPath.Child("collectorCIDRs"),
+				"collectorCIDRs are required when upstream.collectorHost is not a cluster Service"))
+		}
+	}
+
+	for i, peer := range np.From {
+		if peer.NamespaceSelector == nil && peer.PodSelector == nil {
+			allErrs = append(allErrs, field.Required(fldPath.Child("from").Index(i),
+				"at least one of namespaceSelector or podSelector is required"))
+		}
+	}
+
+	return allErrs
+}
+
+// validatePodExtras validates spec.env, spec.extraContainers, spec.extraVolumes and spec.extraVolumeMounts
+// and keeps them from clashing with the controller-managed nginx container and config volume
+func validatePodExtras(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	spec := nginxProxy.Spec
+
+	allErrs = append(allErrs, validateEnv(spec.Env, fldPath.Child("env"))...)
+
+	volumes := map[string]bool{ctrl.ConfigVolumeName: true}
+	for i, volume := range spec.ExtraVolumes {
+		idxPath := fldPath.Child("extraVolumes").Index(i)
+		switch {
+		case volume.Name == ctrl.ConfigVolumeName || volume.Name == ctrl.ReloaderTokenVolumeName:
+			allErrs = append(allErrs, field.Forbidden(idxPath.Child("name"), "volume name is reserved by the controller"))
+		case volumes[volume.Name]:
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), volume.Name))
+		default:
+			for _, msg := range validation.IsDNS1123Label(volume.Name) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("name"), volume.Name, msg))
+			}
+			volumes[volume.Name] = true
+		}
+	}
+
+	allErrs = append(allErrs, validateVolumeMounts(spec.ExtraVolumeMounts, volumes, true, fldPath.Child("extraVolumeMounts"))...)
+
+	containers := map[string]bool{ctrl.NginxContainerName: true}
+	for i, container := range spec.ExtraContainers {
+		idxPath := fldPath.Child("extraContainers").Index(i)
+		switch {
+		case container.Name == ctrl.NginxContainerName || container.Name == ctrl.ReloaderContainerName || container.Name == ctrl.ExporterContainerName:
+			allErrs = append(allErrs, field.Forbidden(idxPath.Child("name"), "container name is reserved by the controller"))
+		case containers[container.Name]:
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), container.Name))
+		default:
+			for _, msg := range validation.IsDNS1123Label(container.Name) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("name"), container.Name, msg))
+			}
+			containers[container.Name] = true
+		}
+		if container.Image == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("image"), "image is required"))
+		}
+		allErrs = append(allErrs, validateEnv(container.Env, idxPath.Child("env"))...)
+		// Sidecars may read the generated config, only the nginx container mount is reserved
+		allErrs = append(allErrs, validateVolumeMounts(container.VolumeMounts, volumes, false, idxPath.Child("volumeMounts"))...)
+	}
+
+	return allErrs
+}
+
+func validateEnv(env []corev1.EnvVar, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, envVar := range env {
+		if envVar.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Index(i).Child("name"), "env var name is required"))
+			continue
+		}
+		for _, msg := range validation.IsEnvVarName(envVar.Name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i).Child("name"), envVar.Name, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateVolumeMounts checks that mounts reference known volumes. For the nginx container the config
+// volume and everything at or below the config mount path are reserved.
+func validateVolumeMounts(mounts []corev1.VolumeMount, volumes map[string]bool, nginx bool, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	mountPaths := map[string]bool{}
+	for i, mount := range mounts {
+		idxPath := fldPath.Index(i)
+		if nginx && mount.Name == ctrl.ConfigVolumeName {
+			allErrs = append(allErrs, field.Forbidden(idxPath.Child("name"), "volume is already mounted by the controller"))
+		} else if !volumes[mount.Name] {
+			allErrs = append(allErrs, field.NotFound(idxPath.Child("name"), mount.Name))
+		}
+
+		mountPath := path.Clean(mount.MountPath)
+		switch {
+		case mount.MountPath == "":
+			allErrs = append(allErrs, field.Required(idxPath.Child("mountPath"), "mountPath is required"))
+		case nginx && (mountPath == ctrl.ConfigMountPath || strings.HasPrefix(mountPath, ctrl.ConfigMountPath+"/")):
+			allErrs = append(allErrs, field.Forbidden(idxPath.Child("mountPath"), fmt.Sprintf("%s is reserved for the generated nginx config", ctrl.ConfigMountPath)))
+		case mountPaths[mountPath]:
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("mountPath"), mount.MountPath))
+		}
+		mountPaths[mountPath] = true
+	}
+	return allErrs
+}
+
+// validatePortHeaders validates the header manipulation and CORS settings of a port. Header names and
+// values end up in the nginx config, so only header names and single line values are accepted.
+func validatePortHeaders(port JaegerNginxProxyV1alpha0.Port, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	headerNames := func(names []string, fldPath *field.Path) {
+		for i, name := range names {
+			if !ctrl.IsHeaderName(name) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Index(i), name, "must be a valid HTTP header name"))
+			}
+		}
+	}
+
+	for name, value := range port.SetHeaders {
+		if !ctrl.IsHeaderName(name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("setHeaders").Key(name), name, "must be a valid HTTP header name"))
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("setHeaders").Key(name), value, "must be a single line"))
+		}
+	}
+	headerNames(port.RemoveHeaders, fldPath.Child("removeHeaders"))
+	headerNames(port.HideResponseHeaders, fldPath.Child("hideResponseHeaders"))
+
+	cors := port.CORS
+	if cors == nil {
+		return allErrs
+	}
+	corsPath := fldPath.Child("cors")
+	if ctrl.PortProtocol(port) == ctrl.PortProtocolGRPC {
+		allErrs = append(allErrs, field.Forbidden(corsPath, "browsers cannot call gRPC ports, use an http port"))
+	}
+	if len(cors.AllowedOrigins) == 0 {
+		allErrs = append(allErrs, field.Required(corsPath.Child("allowedOrigins"), "at least one origin is required"))
+	}
+	for i, origin := range cors.AllowedOrigins {
+		if err := ctrl.ValidateCORSOrigin(origin); err != nil {
+			allErrs = append(allErrs, field.Invalid(corsPath.Child("allowedOrigins").Index(i), origin, err.Error()))
+		} else if origin == "*" && cors.AllowCredentials {
+			allErrs = append(allErrs, field.Invalid(corsPath.Child("allowedOrigins").Index(i), origin, "browsers reject credentials for any origin, list the allowed origins"))
+		}
+	}
+	for i, method := range cors.AllowedMethods {
+		if !ctrl.IsHeaderName(method) || strings.ToUpper(method) != method {
+			allErrs = append(allErrs, field.Invalid(corsPath.Child("allowedMethods").Index(i), method, "must be an upper case HTTP method"))
+		}
+	}
+	headerNames(cors.AllowedHeaders, corsPath.Child("allowedHeaders"))
+	headerNames(cors.ExposedHeaders, corsPath.Child("exposedHeaders"))
+	if cors.MaxAge != nil && *cors.MaxAge < 0 {
+		allErrs = append(allErrs, field.Invalid(corsPath.Child("maxAge"), *cors.MaxAge, "must not be negative"))
+	}
+	switch cors.Preflight {
+	case "", ctrl.CORSPreflightRespond, ctrl.CORSPreflightForward:
+	default:
+		allErrs = append(allErrs, field.NotSupported(corsPath.Child("preflight"), cors.Preflight, []string{ctrl.CORSPreflightRespond, ctrl.CORSPreflightForward}))
+	}
+	return allErrs
+}
+
+// validateAccess validates spec.access. Client addresses are only replaced for trusted proxies, and the
+// PROXY protocol is only sent by load balancers.
+func validateAccess(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	access := nginxProxy.Spec.Access
+	if access == nil {
+		return allErrs
+	}
+
+	allErrs = append(allErrs, validateAccessAddresses(access.Allow, fldPath.Child("allow"))...)
+	allErrs = append(allErrs, validateAccessAddresses(access.Deny, fldPath.Child("deny"))...)
+	allErrs = append(allErrs, validateAccessAddresses(access.TrustedProxies, fldPath.Child("trustedProxies"))...)
+
+	if access.RealIPHeader != "" || access.RealIPRecursive {
+		if len(access.TrustedProxies) == 0 {
+			allErrs = append(allErrs, field.Required(fldPath.Child("trustedProxies"), "the client address is only taken from trusted proxies"))
+		}
+	}
+	switch header := access.RealIPHeader; {
+	case header == ctrl.RealIPHeaderProxyProtocol:
+		if !access.ProxyProtocol {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("realIPHeader"), header, "requires proxyProtocol"))
+		}
+	case header != "" && !ctrl.IsHeaderName(header):
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("realIPHeader"), header, "must be a valid HTTP header name or proxy_protocol"))
+	}
+
+	if access.ProxyProtocol && nginxProxy.Spec.Service.Type != string(corev1.ServiceTypeLoadBalancer) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("proxyProtocol"), access.ProxyProtocol,
+			"requires service type LoadBalancer with a load balancer sending the PROXY protocol"))
+	}
+	return allErrs
+}
+
+// validateLogging validates spec.logging
+func validateLogging(logging *JaegerNginxProxyV1alpha0.Logging, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if logging == nil {
+		return allErrs
+	}
+
+	switch logging.Format {
+	case "", ctrl.LogFormatCustom, ctrl.LogFormatJSON:
+	case ctrl.LogFormatCombined:
+		if len(logging.Fields) > 0 {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("fields"), "the combined format has fixed fields"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("format"), logging.Format,
+			[]string{ctrl.LogFormatCustom, ctrl.LogFormatJSON, ctrl.LogFormatCombined}))
+	}
+
+	fields := map[string]bool{}
+	for i, name := range logging.Fields {
+		switch {
+		case !slices.Contains(ctrl.LogFields, name):
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("fields").Index(i), name, ctrl.LogFields))
+		case fields[name]:
+			allErrs = append(allErrs, field.Duplicate(fldPath.Child("fields").Index(i), name))
+		}
+		fields[name] = true
+	}
+	if fields[ctrl.LogFieldTenant] && logging.TenantHeader == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("tenantHeader"), "the tenant field logs this header"))
+	}
+	if logging.TenantHeader != "" && !ctrl.IsHeaderName(logging.TenantHeader) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("tenantHeader"), logging.TenantHeader, "must be a valid HTTP header name"))
+	}
+
+	if percent := logging.SuccessSamplePercent; percent != nil && (*percent < 0 || *percent > 100) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("successSamplePercent"), *percent, "must be between 0 and 100"))
+	}
+	return allErrs
+}
+
+// validateMetrics validates spec.metrics. The exporter and the stub_status server share the pod network
+// with nginx, so their ports must differ from each other and from the container port.
+func validateMetrics(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	metrics := nginxProxy.Spec.Metrics
+	if metrics == nil || !metrics.Enabled {
+		return allErrs
+	}
+
+	port, statusPort := ctrl.MetricsPorts(metrics)
+	for _, p := range []struct {
+		path  *field.Path
+		value int
+	}{{fldPath.Child("port"), port}, {fldPath.Child("statusPort"), statusPort}} {
+		switch {
+		case p.value < 1 || p.value > 65535:
+			allErrs = append(allErrs, field.Invalid(p.path, p.value, "must be between 1 and 65535"))
+		case p.value == nginxProxy.Spec.ContainerPort:
+			allErrs = append(allErrs, field.Invalid(p.path, p.value, "must differ from spec.containerPort"))
+		}
+	}
+	if port == statusPort {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("statusPort"), statusPort, "must differ from spec.metrics.port"))
+	}
+
+	if monitor := metrics.ServiceMonitor; monitor != nil {
+		if monitor.Interval != "" {
+			if _, err := model.ParseDuration(monitor.Interval); err != nil {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("serviceMonitor", "interval"), monitor.Interval, "must be a Prometheus duration such as 30s or 1m"))
+			}
+		}
+		allErrs = append(allErrs, validateLabels(monitor.Labels, fldPath.Child("serviceMonitor", "labels"))...)
+	}
+	return allErrs
 }
 
-// validateNginxConfigGeneration validates that the nginx configuration can be generated successfully
-func (v *JaegerNginxProxyValidator) validateNginxConfigGeneration(nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) error {
-	// Generate the nginx configuration
-	config := ctrl.GenerateNginxConfig(nginxProxy)
+// validateAccessAddresses validates a list of IP addresses and CIDRs
+func validateAccessAddresses(addresses []string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, address := range addresses {
+		if err := ctrl.ValidateAccessAddress(address); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), address, err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// validateLabels validates user supplied labels and rejects the keys the controller manages itself
+func validateLabels(labels map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := metav1validation.ValidateLabels(labels, fldPath)
+	for key := range labels {
+		if key == "app" || strings.HasPrefix(key, "app.kubernetes.io/") {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Key(key), "label is managed by the controller"))
+		}
+	}
+	return allErrs
+}
+
+// validateNginxConfigGeneration validates that the nginx configuration can be generated successfully.
+// A config template that does not exist yet is only a warning, it may be applied after the proxy.
+func (v *JaegerNginxProxyValidator) validateNginxConfigGeneration(ctx context.Context, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) ([]JaegerNginxProxyV1alpha0.ConfigFinding, error) {
+	var tmpl string
+	if nginxProxy.Spec.ConfigTemplate != nil {
+		var reader client.Reader = v.Client
+		if v.APIReader != nil {
+			reader = v.APIReader
+		}
+		if reader == nil {
+			// The template cannot be checked, the controller reports it in the status instead
+			return nil, nil
+		}
+		var err error
+		if tmpl, err = ctrl.FetchConfigTemplate(ctx, reader, nginxProxy); err != nil {
+			var configErr *ctrl.ConfigError
+			if !errors.As(err, &configErr) {
+				return nil, fmt.Errorf("failed to get config template: %w", err)
+			}
+			return []JaegerNginxProxyV1alpha0.ConfigFinding{{
+				Validator: "template",
+				Rule:      "missing-template",
+				Severity:  ctrl.SeverityWarning,
+				Field:     configErr.Field,
+				Message:   configErr.Msg,
+			}}, nil
+		}
+	}
 
-	// Validate the generated configuration
-	return ctrl.ValidateNginxConfig(config)
+	// Generate and validate the nginx configuration
+	_, findings, err := v.ConfigValidators.ValidateProxyConfig(nginxProxy, tmpl)
+	return findings, err
 }
 
 // InjectDecoder injects the decoder.
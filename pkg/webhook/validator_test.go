@@ -0,0 +1,459 @@
+package webhook
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	JaegerNginxProxyV1alpha0 "github.com/dolv/k8s-controller-tutorial/pkg/apis/jaeger-nginx-proxy/v1alpha0"
+	ctrl "github.com/dolv/k8s-controller-tutorial/pkg/ctrl"
+)
+
+func TestValidateIngress(t *testing.T) {
+	fldPath := field.NewPath("spec", "ingress")
+
+	assert.Empty(t, validateIngress(nil, fldPath))
+	assert.Empty(t, validateIngress(&JaegerNginxProxyV1alpha0.Ingress{Enabled: false}, fldPath), "disabled ingress is not validated")
+
+	assert.Empty(t, validateIngress(&JaegerNginxProxyV1alpha0.Ingress{Enabled: true, Host: "traces.example.com"}, fldPath))
+	assert.Empty(t, validateIngress(&JaegerNginxProxyV1alpha0.Ingress{Enabled: true, Host: "*.example.com"}, fldPath))
+
+	errs := validateIngress(&JaegerNginxProxyV1alpha0.Ingress{Enabled: true}, fldPath)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "spec.ingress.host", errs[0].Field)
+
+	errs = validateIngress(&JaegerNginxProxyV1alpha0.Ingress{Enabled: true, Host: "Not_A_Host"}, fldPath)
+	assert.NotEmpty(t, errs)
+
+	errs = validateIngress(&JaegerNginxProxyV1alpha0.Ingress{Enabled: true, Kind: "Gateway", Host: "traces.example.com"}, fldPath)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "spec.ingress.gateway.name", errs[0].Field)
+
+	errs = validateIngress(&JaegerNginxProxyV1alpha0.Ingress{
+		Enabled:       true,
+		Kind:          "Gateway",
+		Host:          "traces.example.com",
+		TLSSecretName: "tls",
+		Gateway:       &JaegerNginxProxyV1alpha0.GatewayRef{Name: "public"},
+	}, fldPath)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "spec.ingress.tlsSecretName", errs[0].Field)
+
+	errs = validateIngress(&JaegerNginxProxyV1alpha0.Ingress{Enabled: true, Kind: "LoadBalancer", Host: "traces.example.com"}, fldPath)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, field.ErrorTypeNotSupported, errs[0].Type)
+}
+
+func TestValidateNetworkPolicy(t *testing.T) {
+	fldPath := field.NewPath("spec", "networkPolicy")
+	nginxProxy := &JaegerNginxProxyV1alpha0.JaegerNginxProxy{}
+	nginxProxy.Namespace = "default"
+	nginxProxy.Spec.Upstream.CollectorHost = "jaeger-collector.tracing.svc.cluster.local"
+
+	assert.Empty(t, validateNetworkPolicy(nginxProxy, fldPath))
+
+	nginxProxy.Spec.NetworkPolicy = &JaegerNginxProxyV1alpha0.NetworkPolicy{Enabled: true}
+	assert.Empty(t, validateNetworkPolicy(nginxProxy, fldPath), "collector Service host resolves without CIDRs")
+
+	nginxProxy.Spec.Upstream.CollectorHost = "collector.example.com"
+	errs := validateNetworkPolicy(nginxProxy, fldPath)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "spec.networkPolicy.collectorCIDRs", errs[0].Field)
+
+	nginxProxy.Spec.NetworkPolicy.CollectorCIDRs = []string{"10.0.0.0/24", "10.0.0.1"}
+	errs = validateNetworkPolicy(nginxProxy, fldPath)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "spec.networkPolicy.collectorCIDRs[1]", errs[0].Field)
+
+	nginxProxy.Spec.NetworkPolicy.CollectorCIDRs = []string{"10.0.0.0/24"}
+	nginxProxy.Spec.NetworkPolicy.From = []JaegerNginxProxyV1alpha0.NetworkPolicyPeer{{}}
+	errs = validateNetworkPolicy(nginxProxy, fldPath)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "spec.networkPolicy.from[0]", errs[0].Field)
+}
+
+func TestValidateJaegerNginxProxyPodIdentity(t *testing.T) {
+	v := &JaegerNginxProxyValidator{}
+	nginxProxy := newValidProxy()
+	assert.NoError(t, validationErr(v, nginxProxy))
+
+	nginxProxy.Spec.Image.PullPolicy = "Sometimes"
+	assert.ErrorContains(t, validationErr(v, nginxProxy), "spec.image.pullPolicy")
+
+	nginxProxy = newValidProxy()
+	nginxProxy.Spec.Image.PullSecrets = []string{"Registry_Creds"}
+	assert.ErrorContains(t, validationErr(v, nginxProxy), "spec.image.pullSecrets[0]")
+
+	nginxProxy = newValidProxy()
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{Create: true, Name: "other"}
+	assert.ErrorContains(t, validationErr(v, nginxProxy), "spec.serviceAccount.name")
+}
+
+func TestValidateJaegerNginxProxyReloadStrategy(t *testing.T) {
+	v := &JaegerNginxProxyValidator{}
+	nginxProxy := newValidProxy()
+
+	nginxProxy.Spec.ReloadStrategy = "restart"
+	assert.ErrorContains(t, validationErr(v, nginxProxy), "spec.reloadStrategy")
+
+	nginxProxy.Spec.ReloadStrategy = "hotReload"
+	assert.ErrorContains(t, validationErr(v, nginxProxy), "spec.serviceAccount")
+
+	nginxProxy.Spec.ServiceAccount = &JaegerNginxProxyV1alpha0.ServiceAccount{Create: true}
+	assert.NoError(t, validationErr(v, nginxProxy))
+
+	nginxProxy.Spec.ExtraContainers = []corev1.Container{{Name: "reloader", Image: "busybox"}}
+	assert.ErrorContains(t, validationErr(v, nginxProxy), "spec.extraContainers[0].name")
+}
+
+func TestValidateJaegerNginxProxyRevisions(t *testing.T) {
+	v := &JaegerNginxProxyValidator{}
+	nginxProxy := newValidProxy()
+
+	limit := int32(0)
+	nginxProxy.Spec.RevisionHistoryLimit = &limit
+	assert.ErrorContains(t, validationErr(v, nginxProxy), "spec.revisionHistoryLimit")
+	limit = 3
+	assert.NoError(t, validationErr(v, nginxProxy))
+
+	nginxProxy.Annotations = map[string]string{ctrl.RollbackAnnotation: "previous"}
+	assert.ErrorContains(t, validationErr(v, nginxProxy), ctrl.RollbackAnnotation)
+	nginxProxy.Annotations[ctrl.RollbackAnnotation] = "0"
+	assert.NoError(t, validationErr(v, nginxProxy))
+}
+
+// validationErr returns the error of validateJaegerNginxProxy, ignoring warnings
+func validationErr(v *JaegerNginxProxyValidator, nginxProxy *JaegerNginxProxyV1alpha0.JaegerNginxProxy) error {
+	_, err := v.validateJaegerNginxProxy(context.Background(), nginxProxy)
+	return err
+}
+
+// newValidProxy returns a JaegerNginxProxy that passes validation
+func newValidProxy() *JaegerNginxProxyV1alpha0.JaegerNginxProxy {
+	nginxProxy := &JaegerNginxProxyV1alpha0.JaegerNginxProxy{}
+	nginxProxy.Name = "test-proxy"
+	nginxProxy.Namespace = "default"
+	nginxProxy.Spec = JaegerNginxProxyV1alpha0.JaegerNginxProxySpec{
+		ReplicaCount:  1,
+		ContainerPort: 8080,
+		Image:         JaegerNginxProxyV1alpha0.Image{Repository: "nginx", Tag: "1.28.0", PullPolicy: "IfNotPresent"},
+		Upstream:      JaegerNginxProxyV1alpha0.Upstream{CollectorHost: "jaeger-collector.tracing.svc.cluster.local"},
+		Ports:         []JaegerNginxProxyV1alpha0.Port{{Name: "http", Port: 14268, Path: "/api/traces"}},
+		Service:       JaegerNginxProxyV1alpha0.Service{Type: "ClusterIP"},
+		Resources: JaegerNginxProxyV1alpha0.Resources{
+			Limits:   JaegerNginxProxyV1alpha0.Resource{CPU: "500m", Memory: "512Mi"},
+			Requests: JaegerNginxProxyV1alpha0.Resource{CPU: "100m", Memory: "128Mi"},
+		},
+	}
+	return nginxProxy
+}
+
+func TestValidateLabels(t *testing.T) {
+	fldPath := field.NewPath("spec", "commonLabels")
+
+	assert.Empty(t, validateLabels(map[string]string{"team": "observability", "example.com/cost-center": "42"}, fldPath))
+
+	errs := validateLabels(map[string]string{"app": "other"}, fldPath)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "spec.commonLabels[app]", errs[0].Field)
+
+	errs = validateLabels(map[string]string{"app.kubernetes.io/version": "2"}, fldPath)
+	assert.Len(t, errs, 1)
+
+	errs = validateLabels(map[string]string{"team": "not a valid value"}, fldPath)
+	assert.NotEmpty(t, errs)
+}
+
+func TestValidatePodExtras(t *testing.T) {
+	fldPath := field.NewPath("spec")
+	nginxProxy := newValidProxy()
+	nginxProxy.Spec.Env = []corev1.EnvVar{{Name: "NGINX_ENTRYPOINT_QUIET_LOGS", Value: "1"}}
+	nginxProxy.Spec.ExtraVolumes = []corev1.Volume{{Name: "error-pages"}}
+	nginxProxy.Spec.ExtraVolumeMounts = []corev1.VolumeMount{{Name: "error-pages", MountPath: "/usr/share/nginx/errors"}}
+	nginxProxy.Spec.ExtraContainers = []corev1.Container{{
+		Name:         "log-shipper",
+		Image:        "fluent/fluent-bit:3.2",
+		VolumeMounts: []corev1.VolumeMount{{Name: "contents", MountPath: "/etc/nginx/conf.d", ReadOnly: true}},
+	}}
+	assert.Empty(t, validatePodExtras(nginxProxy, fldPath), "sidecars may mount the config volume")
+
+	nginxProxy.Spec.ExtraVolumes = append(nginxProxy.Spec.ExtraVolumes, corev1.Volume{Name: "contents"})
+	errs := validatePodExtras(nginxProxy, fldPath)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "spec.extraVolumes[1].name", errs[0].Field)
+
+	nginxProxy = newValidProxy()
+	nginxProxy.Spec.ExtraVolumes = []corev1.Volume{{Name: "extra"}}
+	nginxProxy.Spec.ExtraVolumeMounts = []corev1.VolumeMount{
+		{Name: "contents", MountPath: "/srv"},
+		{Name: "extra", MountPath: "/etc/nginx/conf.d/extra/"},
+		{Name: "missing", MountPath: "/data"},
+	}
+	errs = validatePodExtras(nginxProxy, fldPath)
+	assert.Len(t, errs, 3)
+	assert.Equal(t, "spec.extraVolumeMounts[0].name", errs[0].Field)
+	assert.Equal(t, "spec.extraVolumeMounts[1].mountPath", errs[1].Field)
+	assert.Equal(t, field.ErrorTypeNotFound, errs[2].Type)
+
+	nginxProxy = newValidProxy()
+	nginxProxy.Spec.Env = []corev1.EnvVar{{Name: "1INVALID"}}
+	nginxProxy.Spec.ExtraContainers = []corev1.Container{{Name: "nginx", Image: "busybox"}, {Name: "shipper"}}
+	errs = validatePodExtras(nginxProxy, fldPath)
+	assert.Len(t, errs, 3)
+	assert.Equal(t, "spec.env[0].name", errs[0].Field)
+	assert.Equal(t, "spec.extraContainers[0].name", errs[1].Field)
+	assert.Equal(t, "spec.extraContainers[1].image", errs[2].Field)
+}
+
+func TestValidateJaegerNginxProxyConfigFieldPath(t *testing.T) {
+	v := &JaegerNginxProxyValidator{}
+	nginxProxy := newValidProxy()
+	nginxProxy.Spec.Ports[0].Path = "/api/traces {"
+
+	err := validationErr(v, nginxProxy)
+	assert.ErrorContains(t, err, "spec.ports[0].path")
+	assert.ErrorContains(t, err, "unmatched opening braces")
+}
+
+func TestValidateJaegerNginxProxyConfigWarnings(t *testing.T) {
+	v := &JaegerNginxProxyValidator{}
+	warnings, err := v.validateJaegerNginxProxy(context.Background(), newValidProxy())
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	v.ConfigValidators = ctrl.ConfigValidators{ctrl.StructuralValidator{}, &ctrl.LintValidator{}}
+	warnings, err = v.validateJaegerNginxProxy(context.Background(), newValidProxy())
+	require.NoError(t, err, "lint findings are warnings only")
+	require.Len(t, warnings, 1)
+	assert.True(t, strings.HasPrefix(warnings[0], "spec.ports[0].path: lint/proxy-host-header: "), warnings[0])
+}
+
+func TestValidateJaegerNginxProxyNginxVersion(t *testing.T) {
+	v := &JaegerNginxProxyValidator{}
+	nginxProxy := newValidProxy()
+	nginxProxy.Spec.Ports = append(nginxProxy.Spec.Ports, JaegerNginxProxyV1alpha0.Port{Name: "grpc", Port: 14250, Path: "/jaeger.api.v2.CollectorService/PostSpans"})
+
+	nginxProxy.Spec.Image.Tag = "1.21"
+	_, err := v.validateJaegerNginxProxy(context.Background(), nginxProxy)
+	assert.ErrorContains(t, err, "spec.image.tag")
+	assert.ErrorContains(t, err, "requires nginx 1.25.1 or newer")
+
+	nginxProxy.Spec.Image.Tag = "stable"
+	warnings, err := v.validateJaegerNginxProxy(context.Background(), nginxProxy)
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "spec.image.tag: version/unknown-version")
+}
+
+func TestValidateJaegerNginxProxySnippets(t *testing.T) {
+	v := &JaegerNginxProxyValidator{}
+	nginxProxy := newValidProxy()
+	nginxProxy.Spec.Snippets = &JaegerNginxProxyV1alpha0.Snippets{
+		Server:   "gzip on;",
+		Location: map[string]string{"http": "add_header X-Proxy jaeger;"},
+	}
+	assert.NoError(t, validationErr(v, nginxProxy))
+
+	nginxProxy.Spec.Snippets.Location["grpc"] = "grpc_read_timeout 30s;"
+	assert.ErrorContains(t, validationErr(v, nginxProxy), "spec.snippets.location[grpc]: Not found")
+
+	nginxProxy = newValidProxy()
+	nginxProxy.Spec.Snippets = &JaegerNginxProxyV1alpha0.Snippets{Server: "include /etc/nginx/secrets.conf;"}
+	err := validationErr(v, nginxProxy)
+	assert.ErrorContains(t, err, "spec.snippets.server")
+	assert.ErrorContains(t, err, "directive include is not allowed")
+}
+
+func TestValidateJaegerNginxProxyConfigTemplate(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(s))
+	c := fake.NewClientBuilder().WithScheme(s).Build()
+	v := &JaegerNginxProxyValidator{Client: c}
+	nginxProxy := newValidProxy()
+	nginxProxy.Spec.ConfigTemplate = &JaegerNginxProxyV1alpha0.ConfigTemplate{ConfigMapName: "proxy-template"}
+
+	warnings, err := v.validateJaegerNginxProxy(context.Background(), nginxProxy)
+	require.NoError(t, err, "the template may be applied after the proxy")
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "spec.configTemplate.configMapName: template/missing-template")
+
+	tmpl := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "proxy-template", Namespace: nginxProxy.Namespace},
+		Data:       map[string]string{ctrl.DefaultConfigTemplateKey: "load_module modules/evil.so;\n{{ .Generated }}"},
+	}
+	require.NoError(t, c.Create(context.Background(), tmpl))
+	err = validationErr(v, nginxProxy)
+	assert.ErrorContains(t, err, "spec.configTemplate")
+	assert.ErrorContains(t, err, "directive load_module is not allowed")
+
+	// The template is read from the API server, not from the namespaced manager cache
+	v = &JaegerNginxProxyValidator{Client: fake.NewClientBuilder().WithScheme(s).Build(), APIReader: c}
+	err = validationErr(v, nginxProxy)
+	assert.ErrorContains(t, err, "directive load_module is not allowed")
+
+	nginxProxy.Spec.ConfigTemplate.ConfigMapName = ""
+	assert.ErrorContains(t, validationErr(v, nginxProxy), "spec.configTemplate.configMapName: Required value")
+}
+
+func TestValidatePortHeaders(t *testing.T) {
+	fldPath := field.NewPath("spec", "ports").Index(0)
+	port := JaegerNginxProxyV1alpha0.Port{
+		Name:                "http",
+		SetHeaders:          map[string]string{"X-Scope-OrgID": "tenant-a"},
+		RemoveHeaders:       []string{"Cookie"},
+		HideResponseHeaders: []string{"Server"},
+		CORS:                &JaegerNginxProxyV1alpha0.CORS{AllowedOrigins: []string{"https://*.example.com"}, AllowedMethods: []string{"POST"}},
+	}
+	assert.Empty(t, validatePortHeaders(port, fldPath))
+
+	port.SetHeaders = map[string]string{"X Tenant": "a", "X-Auth": "a\nb"}
+	port.RemoveHeaders = []string{"Cookie;"}
+	port.CORS = &JaegerNginxProxyV1alpha0.CORS{
+		AllowedOrigins:   []string{"*", "https://app.example.com/path"},
+		AllowedMethods:   []string{"post"},
+		AllowCredentials: true,
+		Preflight:        "sometimes",
+	}
+	errs := validatePortHeaders(port, fldPath)
+	fields := make([]string, 0, len(errs))
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	assert.ElementsMatch(t, []string{
+		"spec.ports[0].setHeaders[X Tenant]",
+		"spec.ports[0].setHeaders[X-Auth]",
+		"spec.ports[0].removeHeaders[0]",
+		"spec.ports[0].cors.allowedOrigins[0]",
+		"spec.ports[0].cors.allowedOrigins[1]",
+		"spec.ports[0].cors.allowedMethods[0]",
+		"spec.ports[0].cors.preflight",
+	}, fields)
+
+	grpc := JaegerNginxProxyV1alpha0.Port{Name: "grpc", CORS: &JaegerNginxProxyV1alpha0.CORS{}}
+	errs = validatePortHeaders(grpc, fldPath)
+	require.Len(t, errs, 2)
+	assert.Equal(t, field.ErrorTypeForbidden, errs[0].Type)
+	assert.Equal(t, field.ErrorTypeRequired, errs[1].Type)
+}
+
+func TestValidateJaegerNginxProxyAccess(t *testing.T) {
+	v := &JaegerNginxProxyValidator{}
+	nginxProxy := newValidProxy()
+	nginxProxy.Spec.Access = &JaegerNginxProxyV1alpha0.Access{
+		Allow:          []string{"10.0.0.0/8"},
+		Deny:           []string{"10.0.13.7"},
+		TrustedProxies: []string{"192.168.0.0/16"},
+		RealIPHeader:   "X-Real-IP",
+	}
+	nginxProxy.Spec.Ports[0].Allow = []string{"fd00::/8"}
+	assert.NoError(t, validationErr(v, nginxProxy))
+
+	nginxProxy.Spec.Ports[0].Deny = []string{"10.0.0.0/33"}
+	assert.ErrorContains(t, validationErr(v, nginxProxy), "spec.ports[0].deny[0]")
+
+	nginxProxy = newValidProxy()
+	nginxProxy.Spec.Access = &JaegerNginxProxyV1alpha0.Access{Allow: []string{"all"}, RealIPHeader: "X Real IP"}
+	err := validationErr(v, nginxProxy)
+	assert.ErrorContains(t, err, "spec.access.allow[0]")
+	assert.ErrorContains(t, err, "spec.access.trustedProxies: Required value")
+	assert.ErrorContains(t, err, "spec.access.realIPHeader")
+
+	nginxProxy = newValidProxy()
+	nginxProxy.Spec.Access = &JaegerNginxProxyV1alpha0.Access{
+		TrustedProxies: []string{"10.0.0.0/8"},
+		RealIPHeader:   ctrl.RealIPHeaderProxyProtocol,
+	}
+	err = validationErr(v, nginxProxy)
+	assert.ErrorContains(t, err, "spec.access.realIPHeader")
+	assert.ErrorContains(t, err, "requires proxyProtocol")
+
+	nginxProxy.Spec.Access.ProxyProtocol = true
+	assert.ErrorContains(t, validationErr(v, nginxProxy), "requires service type LoadBalancer")
+	nginxProxy.Spec.Service.Type = "LoadBalancer"
+	assert.NoError(t, validationErr(v, nginxProxy))
+}
+
+func TestValidateLogging(t *testing.T) {
+	fldPath := field.NewPath("spec", "logging")
+	assert.Empty(t, validateLogging(nil, fldPath))
+	assert.Empty(t, validateLogging(&JaegerNginxProxyV1alpha0.Logging{
+		Format:       ctrl.LogFormatJSON,
+		Fields:       []string{"time", "upstream_response_time", ctrl.LogFieldTenant},
+		TenantHeader: "X-Scope-OrgID",
+	}, fldPath))
+
+	percent := int32(101)
+	errs := validateLogging(&JaegerNginxProxyV1alpha0.Logging{
+		Format:               "logfmt",
+		Fields:               []string{"time", "time", "cookie", ctrl.LogFieldTenant},
+		SuccessSamplePercent: &percent,
+	}, fldPath)
+	fields := make([]string, 0, len(errs))
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	assert.ElementsMatch(t, []string{
+		"spec.logging.format",
+		"spec.logging.fields[1]",
+		"spec.logging.fields[2]",
+		"spec.logging.tenantHeader",
+		"spec.logging.successSamplePercent",
+	}, fields)
+
+	errs = validateLogging(&JaegerNginxProxyV1alpha0.Logging{Format: ctrl.LogFormatCombined, Fields: []string{"time"}}, fldPath)
+	require.Len(t, errs, 1)
+	assert.Equal(t, field.ErrorTypeForbidden, errs[0].Type)
+}
+
+func TestValidateMetrics(t *testing.T) {
+	fldPath := field.NewPath("spec", "metrics")
+	p := newValidProxy()
+	assert.Empty(t, validateMetrics(p, fldPath))
+
+	p.Spec.Metrics = &JaegerNginxProxyV1alpha0.Metrics{Enabled: true, ServiceMonitor: &JaegerNginxProxyV1alpha0.ServiceMonitor{
+		Enabled: true, Interval: "1m30s", Labels: map[string]string{"release": "prometheus"},
+	}}
+	assert.Empty(t, validateMetrics(p, fldPath))
+
+	p.Spec.Metrics = &JaegerNginxProxyV1alpha0.Metrics{
+		Enabled:    true,
+		Port:       p.Spec.ContainerPort,
+		StatusPort: 70000,
+		ServiceMonitor: &JaegerNginxProxyV1alpha0.ServiceMonitor{
+			Interval: "soon",
+			Labels:   map[string]string{"app.kubernetes.io/name": "x"},
+		},
+	}
+	errs := validateMetrics(p, fldPath)
+	fields := make([]string, 0, len(errs))
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	assert.ElementsMatch(t, []string{
+		"spec.metrics.port",
+		"spec.metrics.statusPort",
+		"spec.metrics.serviceMonitor.interval",
+		"spec.metrics.serviceMonitor.labels[app.kubernetes.io/name]",
+	}, fields)
+
+	p.Spec.Metrics = &JaegerNginxProxyV1alpha0.Metrics{Enabled: true, Port: 9000, StatusPort: 9000}
+	errs = validateMetrics(p, fldPath)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "spec.metrics.statusPort", errs[0].Field)
+
+	// Disabled metrics are not validated
+	p.Spec.Metrics.Enabled = false
+	assert.Empty(t, validateMetrics(p, fldPath))
+
+	p.Spec.ExtraContainers = []corev1.Container{{Name: ctrl.ExporterContainerName, Image: "busybox"}}
+	assert.Error(t, validationErr(&JaegerNginxProxyValidator{}, p))
+}